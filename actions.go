@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Kinds of corporate actions. The value of a dividend is the cash paid per
+// share in PKR, of a bonus issue the new shares per 100 held, of a split the
+// shares each share becomes.
+const (
+	actionDividend = "dividend"
+	actionBonus    = "bonus"
+	actionSplit    = "split"
+)
+
+// corporateAction is a row of the corporate_actions table. Actions are stored
+// in the shard of their ex-date, like the prices they affect.
+type corporateAction struct {
+	Symbol      string  `json:"symbol"`
+	ExDate      string  `json:"ex_date"`
+	Type        string  `json:"type"`
+	Value       float64 `json:"value"`
+	Description string  `json:"description"`
+}
+
+// validate normalises the symbol and checks the action makes sense
+func (a *corporateAction) validate() error {
+	a.Symbol = strings.ToUpper(strings.TrimSpace(a.Symbol))
+	a.Type = strings.ToLower(strings.TrimSpace(a.Type))
+	if !isSymbol(a.Symbol) {
+		return fmt.Errorf("invalid symbol %q", a.Symbol)
+	}
+	if _, err := time.Parse("2006-01-02", a.ExDate); err != nil {
+		return fmt.Errorf("invalid ex-date %q, expected YYYY-MM-DD", a.ExDate)
+	}
+	switch a.Type {
+	case actionDividend, actionBonus, actionSplit:
+	default:
+		return fmt.Errorf("unknown action type %q, expected dividend, bonus or split", a.Type)
+	}
+	if a.Value <= 0 {
+		return fmt.Errorf("the value of a %s must be positive", a.Type)
+	}
+	return nil
+}
+
+// shareFactor is how many shares a share held before the ex-date becomes
+func (a corporateAction) shareFactor() float64 {
+	switch a.Type {
+	case actionBonus:
+		return 1 + a.Value/100
+	case actionSplit:
+		return a.Value
+	}
+	return 1
+}
+
+// runActionsCommand dispatches the "actions" subcommands
+func runActionsCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing actions command, expected add, import, list or delete")
+	}
+
+	switch args[0] {
+	case "add":
+		return addActionCommand(dbPath, args[1:])
+	case "import":
+		return importActionsCommand(dbPath, args[1:])
+	case "list":
+		return listActionsCommand(dbPath, args[1:])
+	case "delete":
+		return deleteActionCommand(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown actions command %q, expected add, import, list or delete", args[0])
+	}
+}
+
+// addActionCommand records a single corporate action
+func addActionCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("actions add", flag.ContinueOnError)
+	var a corporateAction
+	fs.StringVar(&a.Symbol, "symbol", "", "Symbol of the company")
+	fs.StringVar(&a.ExDate, "ex-date", "", "First day the shares trade without it (YYYY-MM-DD)")
+	fs.StringVar(&a.Type, "type", "", "dividend, bonus or split")
+	fs.Float64Var(&a.Value, "value", 0, "PKR per share for a dividend, shares per 100 for a bonus, shares per share for a split")
+	fs.StringVar(&a.Description, "description", "", "What the action is, e.g. the announcement")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := a.validate(); err != nil {
+		return err
+	}
+	if _, err := saveActions(dbPath, []corporateAction{a}); err != nil {
+		return err
+	}
+	slog.Info("Added corporate action", "symbol", a.Symbol, "exDate", a.ExDate, "type", a.Type, "value", a.Value)
+	return nil
+}
+
+// importActionsCommand loads corporate actions from a CSV file with
+// symbol,ex_date,type,value[,description] rows
+func importActionsCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("actions import", flag.ContinueOnError)
+	file := fs.String("file", "", "CSV file with symbol,ex_date,type,value[,description] rows")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("missing -file to import")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open actions file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	var list []corporateAction
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read actions file: %w", err)
+		}
+		if len(record) < 4 {
+			return fmt.Errorf("line %d: expected symbol,ex_date,type,value", line)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[3]), 64)
+		if err != nil {
+			// Tolerate a header row
+			if line == 1 {
+				continue
+			}
+			return fmt.Errorf("line %d: invalid value %q", line, record[3])
+		}
+		a := corporateAction{Symbol: record[0], ExDate: strings.TrimSpace(record[1]), Type: record[2], Value: value}
+		if len(record) > 4 {
+			a.Description = strings.TrimSpace(record[4])
+		}
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		list = append(list, a)
+	}
+
+	saved, err := saveActions(dbPath, list)
+	if err != nil {
+		return err
+	}
+	slog.Info("Imported corporate actions", "file", *file, "read", len(list), "saved", saved)
+	return nil
+}
+
+// listActionsCommand prints the stored corporate actions
+func listActionsCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("actions list", flag.ContinueOnError)
+	symbols := fs.String("symbols", "", "Comma separated symbols, all when empty")
+	from := fs.String("from", "0000-01-01", "First ex-date (YYYY-MM-DD)")
+	to := fs.String("to", "9999-12-31", "Last ex-date (YYYY-MM-DD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	actions, err := loadActions(db, parseSymbolList(*symbols), *from, *to)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "symbol\tex_date\ttype\tvalue\tdescription")
+	for _, a := range actions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%g\t%s\n", a.Symbol, a.ExDate, a.Type, a.Value, a.Description)
+	}
+	return tw.Flush()
+}
+
+// deleteActionCommand removes a corporate action entered by mistake
+func deleteActionCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("actions delete", flag.ContinueOnError)
+	symbol := fs.String("symbol", "", "Symbol of the company")
+	exDate := fs.String("ex-date", "", "Ex-date of the action (YYYY-MM-DD)")
+	kind := fs.String("type", "", "dividend, bonus or split")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	date, err := time.Parse("2006-01-02", *exDate)
+	if err != nil {
+		return fmt.Errorf("invalid -ex-date: %w", err)
+	}
+
+	db, err := openDatabase(marketDBPath(dbPath, date))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	result, err := db.Exec("DELETE FROM corporate_actions WHERE symbol = ? AND ex_date = ? AND type = ?",
+		strings.ToUpper(*symbol), *exDate, strings.ToLower(*kind))
+	if err != nil {
+		return fmt.Errorf("failed to delete corporate action: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("no %s of %s on %s", *kind, *symbol, *exDate)
+	}
+	slog.Info("Deleted corporate action", "symbol", *symbol, "exDate", *exDate, "type", *kind)
+	return nil
+}
+
+// saveActions stores actions in the shards of their ex-dates, replacing the
+// value of an action recorded before, and returns how many changed
+func saveActions(dbPath string, list []corporateAction) (int, error) {
+	byFile := make(map[string][]corporateAction)
+	for _, a := range list {
+		date, err := time.Parse("2006-01-02", a.ExDate)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ex-date %q: %w", a.ExDate, err)
+		}
+		path := marketDBPath(dbPath, date)
+		byFile[path] = append(byFile[path], a)
+	}
+
+	paths := make([]string, 0, len(byFile))
+	for path := range byFile {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	saved := 0
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, path := range paths {
+		db, err := openDatabase(path)
+		if err != nil {
+			return saved, err
+		}
+		for _, a := range byFile[path] {
+			res, err := db.Exec(`INSERT INTO corporate_actions (symbol, ex_date, type, value, description, added_at)
+				VALUES (?, ?, ?, ?, ?, ?)
+				ON CONFLICT(symbol, ex_date, type) DO UPDATE SET value = excluded.value,
+					description = excluded.description, added_at = excluded.added_at
+				WHERE (value, description) IS NOT (excluded.value, excluded.description)`,
+				a.Symbol, a.ExDate, a.Type, a.Value, a.Description, now)
+			if err != nil {
+				db.Close()
+				return saved, fmt.Errorf("failed to save %s of %s on %s: %w", a.Type, a.Symbol, a.ExDate, err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				saved++
+			}
+		}
+		db.Close()
+	}
+	return saved, nil
+}
+
+// loadActions returns the actions of the symbols, or of all when none are
+// given, with ex-dates from from to to, ordered by symbol and ex-date
+func loadActions(db *sql.DB, symbols []string, from, to string) ([]corporateAction, error) {
+	query := "SELECT symbol, ex_date, type, value, description FROM corporate_actions WHERE ex_date >= ? AND ex_date <= ?"
+	args := []any{from, to}
+	if len(symbols) > 0 {
+		query += " AND symbol IN (?" + strings.Repeat(", ?", len(symbols)-1) + ")"
+		args = append(args, toArgs(symbols)...)
+	}
+	rows, err := db.Query(query+" ORDER BY symbol, ex_date, type", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query corporate actions: %w", err)
+	}
+	defer rows.Close()
+
+	actions := []corporateAction{}
+	for rows.Next() {
+		var a corporateAction
+		if err := rows.Scan(&a.Symbol, &a.ExDate, &a.Type, &a.Value, &a.Description); err != nil {
+			return nil, fmt.Errorf("failed to read corporate actions: %w", err)
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+)
+
+// adjustPrices back-adjusts rows for the bonus issues and splits that went ex
+// after them, so a series reads continuously across them. Prices are divided
+// and volumes multiplied by the shares a share became since. The previous
+// close of a row is that of the day before it, so an action going ex on the
+// row's date adjusts it too.
+func adjustPrices(db *sql.DB, prices []priceRow) error {
+	if len(prices) == 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	var symbols []string
+	first := prices[0].Date
+	for _, p := range prices {
+		if !seen[p.Symbol] {
+			seen[p.Symbol] = true
+			symbols = append(symbols, p.Symbol)
+		}
+		first = min(first, p.Date)
+	}
+	actions, err := loadActions(db, symbols, first, "9999-12-31")
+	if err != nil {
+		return err
+	}
+	bySymbol := map[string][]corporateAction{}
+	for _, a := range actions {
+		if a.Type != actionDividend {
+			bySymbol[a.Symbol] = append(bySymbol[a.Symbol], a)
+		}
+	}
+
+	for i, p := range prices {
+		factor, previousFactor := 1.0, 1.0
+		for _, a := range bySymbol[p.Symbol] {
+			if a.ExDate > p.Date {
+				factor *= a.shareFactor()
+			}
+			if a.ExDate >= p.Date {
+				previousFactor *= a.shareFactor()
+			}
+		}
+		if previousFactor == 1 {
+			continue
+		}
+		p.Open, p.High, p.Low, p.Close = adjustPrice(p.Open, factor), adjustPrice(p.High, factor),
+			adjustPrice(p.Low, factor), adjustPrice(p.Close, factor)
+		p.PreviousClose = adjustPrice(p.PreviousClose, previousFactor)
+		p.Volume = int64(math.Round(float64(p.Volume) * factor))
+		prices[i] = p
+	}
+	return nil
+}
+
+func adjustPrice(price, factor float64) float64 {
+	return math.Round(price/factor*10000) / 10000
+}
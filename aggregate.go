@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// barPeriod describes one of the aggregation tables derived from the daily rows
+type barPeriod struct {
+	table string
+	// bounds returns the first and last day of the period containing date
+	bounds func(date time.Time) (time.Time, time.Time)
+}
+
+var barPeriods = []barPeriod{
+	{"weekly_bars", func(date time.Time) (time.Time, time.Time) {
+		// Weeks start on Monday
+		start := date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 6)
+	}},
+	{"monthly_bars", func(date time.Time) (time.Time, time.Time) {
+		start := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, -1)
+	}},
+}
+
+// bar is an aggregated OHLCV row of one symbol over a period
+type bar struct {
+	symbol              string
+	firstDate, lastDate string
+	open, high, low     float64
+	close               float64
+	volume              int64
+	days                int
+}
+
+// updateAggregates recomputes the weekly and monthly bars of the periods
+// containing date, called after every ingest so the bars stay current
+func updateAggregates(dbPath string, date time.Time) error {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	for _, p := range barPeriods {
+		start, end := p.bounds(day)
+		if err := rebuildBars(dbPath, p.table, start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildBars replaces the bars of one period. The daily rows are read across
+// shards as a week can span two years, the bars live in the shard of the
+// period's first day.
+func rebuildBars(dbPath, table string, start, end time.Time) error {
+	src, err := sharedQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	bars, err := computeBars(src, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	db, err := sharedDatabase(marketDBPath(dbPath, start))
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := writeBars(tx, table, start.Format("2006-01-02"), end.Format("2006-01-02"), bars); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit %s: %w", table, err)
+	}
+	return nil
+}
+
+// writeBars replaces the bars of the period starting at periodStart
+func writeBars(tx *sql.Tx, table, periodStart, periodEnd string, bars []bar) error {
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE period_start = ?", table), periodStart); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", table, err)
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO %s
+		(symbol, period_start, period_end, first_date, last_date, open, high, low, close, volume, days)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, table))
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s insert: %w", table, err)
+	}
+	defer stmt.Close()
+	for _, b := range bars {
+		_, err := stmt.Exec(b.symbol, periodStart, periodEnd, b.firstDate, b.lastDate, b.open, b.high, b.low, b.close, b.volume, b.days)
+		if err != nil {
+			return fmt.Errorf("failed to insert into %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// computeBars folds the daily rows between from and to into one bar per
+// symbol. Rows without any prices are untraded and left out.
+func computeBars(q querier, from, to string) ([]bar, error) {
+	rows, err := q.Query(`SELECT symbol, date, open, high, low, close, volume FROM market_data
+		WHERE date >= ? AND date <= ? AND NOT (open = 0 AND high = 0 AND low = 0)
+		ORDER BY symbol, date`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query daily rows: %w", err)
+	}
+	defer rows.Close()
+
+	var bars []bar
+	for rows.Next() {
+		var symbol, date string
+		var open, high, low, close sql.NullFloat64
+		var volume sql.NullInt64
+		if err := rows.Scan(&symbol, &date, &open, &high, &low, &close, &volume); err != nil {
+			return nil, fmt.Errorf("failed to read daily row: %w", err)
+		}
+
+		if len(bars) == 0 || bars[len(bars)-1].symbol != symbol {
+			bars = append(bars, bar{symbol: symbol, firstDate: date, open: open.Float64,
+				high: high.Float64, low: low.Float64})
+		}
+		b := &bars[len(bars)-1]
+		b.high = max(b.high, high.Float64)
+		b.low = min(b.low, low.Float64)
+		b.close = close.Float64
+		b.lastDate = date
+		b.volume += volume.Int64
+		b.days++
+	}
+	return bars, rows.Err()
+}
+
+// aggregateDatabase rebuilds the weekly and monthly bars of every period
+// and the daily returns between -from and -to, by default the whole stored
+// range, and the summary of every symbol
+func aggregateDatabase(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("db aggregate", flag.ContinueOnError)
+	from := fs.String("from", "", "First date to aggregate (YYYY-MM-DD), defaults to the earliest stored date")
+	to := fs.String("to", "", "Last date to aggregate (YYYY-MM-DD), defaults to the latest stored date")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *from == "" || *to == "" {
+		db, err := openQueryDatabase(dbPath)
+		if err != nil {
+			return err
+		}
+		var first, last sql.NullString
+		err = db.QueryRow("SELECT MIN(date), MAX(date) FROM market_data").Scan(&first, &last)
+		db.Close()
+		if err != nil {
+			return fmt.Errorf("failed to query stored date range: %w", err)
+		}
+		if !first.Valid {
+			return fmt.Errorf("no data to aggregate")
+		}
+		if *from == "" {
+			*from = first.String
+		}
+		if *to == "" {
+			*to = last.String
+		}
+	}
+	startDate, err := time.Parse("2006-01-02", *from)
+	if err != nil {
+		return fmt.Errorf("invalid -from date: %w", err)
+	}
+	endDate, err := time.Parse("2006-01-02", *to)
+	if err != nil {
+		return fmt.Errorf("invalid -to date: %w", err)
+	}
+
+	for _, p := range barPeriods {
+		periods := 0
+		for start, _ := p.bounds(startDate); !start.After(endDate); periods++ {
+			_, end := p.bounds(start)
+			if err := rebuildBars(dbPath, p.table, start, end); err != nil {
+				return err
+			}
+			start = end.AddDate(0, 0, 1)
+		}
+		slog.Info("Rebuilt bars", "table", p.table, "periods", periods)
+	}
+	days, err := rebuildDailyReturns(dbPath, *from, *to)
+	if err != nil {
+		return err
+	}
+	slog.Info("Rebuilt daily returns", "days", days)
+	if err := rebuildSymbolStats(dbPath); err != nil {
+		return err
+	}
+	slog.Info("Rebuilt symbol summary")
+	return nil
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// anomalyConfig is the anomalies entry of the config file. Zero fields keep
+// their defaults.
+type anomalyConfig struct {
+	// MaxPercent is the largest move from the previous close, in percent,
+	// that is not flagged
+	MaxPercent float64 `json:"max_percent"`
+	// MaxZScore is the largest move, in standard deviations of the daily
+	// returns of the window before it, that is not flagged
+	MaxZScore float64 `json:"max_zscore"`
+	// Window is how many stored days the z-score is measured against
+	Window int `json:"window"`
+	// Alert raises an alert for the anomalies of every ingested day
+	Alert bool `json:"alert"`
+}
+
+// minAnomalyHistory is how many daily returns a symbol needs before its
+// moves get a z-score
+const minAnomalyHistory = 20
+
+var anomalySettings = anomalyConfig{MaxPercent: 10, MaxZScore: 5, Window: 60}
+
+// applyAnomalies checks the anomalies entry of the config file
+func applyAnomalies(cfg *anomalyConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.MaxPercent < 0 || cfg.MaxZScore < 0 || cfg.Window < 0 {
+		return errors.New("max_percent, max_zscore and window must not be negative")
+	}
+	if cfg.Window > 0 && cfg.Window < minAnomalyHistory {
+		return fmt.Errorf("invalid window %d, expected at least %d days", cfg.Window, minAnomalyHistory)
+	}
+	if cfg.MaxPercent > 0 {
+		anomalySettings.MaxPercent = cfg.MaxPercent
+	}
+	if cfg.MaxZScore > 0 {
+		anomalySettings.MaxZScore = cfg.MaxZScore
+	}
+	if cfg.Window > 0 {
+		anomalySettings.Window = cfg.Window
+	}
+	anomalySettings.Alert = cfg.Alert
+	return nil
+}
+
+// anomaly is a row of the anomalies table, a move of a symbol beyond the
+// configured thresholds that no corporate action explains. ZScore is nil
+// without enough history.
+type anomaly struct {
+	Date          string   `json:"date"`
+	Symbol        string   `json:"symbol"`
+	Close         float64  `json:"close"`
+	PreviousClose float64  `json:"previous_close"`
+	ChangePercent float64  `json:"change_percent"`
+	ZScore        *float64 `json:"zscore"`
+	// Reason lists the thresholds crossed, change and/or zscore
+	Reason     string `json:"reason"`
+	DetectedAt string `json:"detected_at"`
+}
+
+// anomalyBar is a stored day of a symbol as the detection sees it
+type anomalyBar struct {
+	date                 string
+	close, previousClose float64
+}
+
+// expectedClose is the previous close of b moved by the actions going ex on
+// its date: less the dividend, spread over the shares of a bonus issue or
+// split
+func (b anomalyBar) expectedClose(actions []corporateAction) float64 {
+	expected := b.previousClose
+	factor := 1.0
+	for _, a := range actions {
+		if a.ExDate != b.date {
+			continue
+		}
+		if a.Type == actionDividend {
+			expected -= a.Value
+		} else {
+			factor *= a.shareFactor()
+		}
+	}
+	return expected / factor
+}
+
+// detectAnomalies flags the moves of the symbols stored for date and
+// replaces the anomalies stored for it
+func detectAnomalies(dbPath string, date time.Time) ([]anomaly, error) {
+	day := date.Format("2006-01-02")
+	src, err := sharedQueryDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := src.Query("SELECT DISTINCT date FROM market_data WHERE date <= ? ORDER BY date DESC LIMIT ?", day, anomalySettings.Window+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dates: %w", err)
+	}
+	var dates []string
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read dates: %w", err)
+		}
+		dates = append(dates, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dates: %w", err)
+	}
+	if len(dates) == 0 || dates[0] != day {
+		return nil, nil
+	}
+	first := dates[len(dates)-1]
+
+	actions, err := loadActions(src, nil, first, day)
+	if err != nil {
+		return nil, err
+	}
+	bySymbol := map[string][]corporateAction{}
+	for _, a := range actions {
+		bySymbol[a.Symbol] = append(bySymbol[a.Symbol], a)
+	}
+
+	rows, err = src.Query(`SELECT symbol, date, close, previous_close FROM market_data
+		WHERE date >= ? AND date <= ? AND close > 0 AND previous_close > 0
+			AND symbol IN (SELECT symbol FROM market_data WHERE date = ?)
+		ORDER BY symbol, date`, first, day, day)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prices: %w", err)
+	}
+	history := map[string][]anomalyBar{}
+	var symbols []string
+	for rows.Next() {
+		var symbol string
+		var b anomalyBar
+		if err := rows.Scan(&symbol, &b.date, &b.close, &b.previousClose); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read prices: %w", err)
+		}
+		if _, ok := history[symbol]; !ok {
+			symbols = append(symbols, symbol)
+		}
+		history[symbol] = append(history[symbol], b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read prices: %w", err)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	found := []anomaly{}
+	for _, symbol := range symbols {
+		if a, ok := checkMove(symbol, day, history[symbol], bySymbol[symbol]); ok {
+			a.DetectedAt = now
+			found = append(found, a)
+		}
+	}
+	return found, storeAnomalies(dbPath, date, found)
+}
+
+// checkMove compares the last of bars, on day, with the expected close and
+// with the returns of the bars before it
+func checkMove(symbol, day string, bars []anomalyBar, actions []corporateAction) (anomaly, bool) {
+	last := bars[len(bars)-1]
+	expected := last.expectedClose(actions)
+	if last.date != day || expected <= 0 {
+		return anomaly{}, false
+	}
+	a := anomaly{
+		Date:          day,
+		Symbol:        symbol,
+		Close:         last.close,
+		PreviousClose: last.previousClose,
+		ChangePercent: math.Round((last.close/expected-1)*100*10000) / 10000,
+	}
+
+	var reasons []string
+	if math.Abs(a.ChangePercent) > anomalySettings.MaxPercent {
+		reasons = append(reasons, "change")
+	}
+	if returns := adjustedReturns(bars[:len(bars)-1], actions); len(returns) >= minAnomalyHistory {
+		var mean float64
+		for _, r := range returns {
+			mean += r
+		}
+		mean /= float64(len(returns))
+		var squares float64
+		for _, r := range returns {
+			squares += (r - mean) * (r - mean)
+		}
+		if sd := math.Sqrt(squares / float64(len(returns)-1)); sd > 0 {
+			z := math.Round((math.Log(last.close/expected)-mean)/sd*10000) / 10000
+			a.ZScore = &z
+			if math.Abs(z) > anomalySettings.MaxZScore {
+				reasons = append(reasons, "zscore")
+			}
+		}
+	}
+	a.Reason = strings.Join(reasons, ",")
+	return a, len(reasons) > 0
+}
+
+// adjustedReturns are the daily log returns of bars net of their corporate
+// actions
+func adjustedReturns(bars []anomalyBar, actions []corporateAction) []float64 {
+	returns := make([]float64, 0, len(bars))
+	for _, b := range bars {
+		if expected := b.expectedClose(actions); expected > 0 {
+			returns = append(returns, math.Log(b.close/expected))
+		}
+	}
+	return returns
+}
+
+// storeAnomalies replaces the anomalies of date in its shard
+func storeAnomalies(dbPath string, date time.Time, found []anomaly) error {
+	db, err := sharedDatabase(marketDBPath(dbPath, date))
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM anomalies WHERE date = ?", date.Format("2006-01-02")); err != nil {
+		return fmt.Errorf("failed to clear anomalies: %w", err)
+	}
+	for _, a := range found {
+		if _, err := tx.Exec(`INSERT INTO anomalies (date, symbol, close, previous_close, change_percent, zscore, reason, detected_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, a.Date, a.Symbol, a.Close, a.PreviousClose, a.ChangePercent, a.ZScore, a.Reason, a.DetectedAt); err != nil {
+			return fmt.Errorf("failed to store anomaly of %s: %w", a.Symbol, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit anomalies: %w", err)
+	}
+	return nil
+}
+
+// updateAnomalies detects the anomalies of an ingested day, raising an
+// alert for them when configured to
+func updateAnomalies(dbPath string, date time.Time) error {
+	found, err := detectAnomalies(dbPath, date)
+	if err != nil || len(found) == 0 {
+		return err
+	}
+	slog.Info("Detected price anomalies", "date", date.Format("2006-01-02"), "count", len(found))
+	if anomalySettings.Alert {
+		moves := make([]string, len(found))
+		for i, a := range found {
+			moves[i] = fmt.Sprintf("%s %+.2f%%", a.Symbol, a.ChangePercent)
+		}
+		raiseAlert(alert{
+			Level:   "warning",
+			Title:   "Price anomalies",
+			Message: fmt.Sprintf("%d moves without a corporate action: %s", len(found), strings.Join(moves, ", ")),
+			Date:    date.Format("2006-01-02"),
+		})
+	}
+	return nil
+}
+
+// runAnomaliesCommand dispatches the "anomalies" subcommands
+func runAnomaliesCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing anomalies command, expected list or detect")
+	}
+
+	switch args[0] {
+	case "list":
+		return listAnomaliesCommand(dbPath, args[1:])
+	case "detect":
+		return detectAnomaliesCommand(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown anomalies command %q, expected list or detect", args[0])
+	}
+}
+
+// detectAnomaliesCommand runs the detection again for the stored days
+// between -from and -to, e.g. after changing the thresholds or adding
+// corporate actions
+func detectAnomaliesCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("anomalies detect", flag.ContinueOnError)
+	from := fs.String("from", "0000-01-01", "First date to check (YYYY-MM-DD)")
+	to := fs.String("to", "9999-12-31", "Last date to check (YYYY-MM-DD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT DISTINCT date FROM market_data WHERE date >= ? AND date <= ? ORDER BY date", *from, *to)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to query dates: %w", err)
+	}
+	var dates []time.Time
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			rows.Close()
+			db.Close()
+			return fmt.Errorf("failed to read dates: %w", err)
+		}
+		if day, err := time.Parse("2006-01-02", d); err == nil {
+			dates = append(dates, day)
+		}
+	}
+	rows.Close()
+	db.Close()
+
+	total := 0
+	for _, date := range dates {
+		found, err := detectAnomalies(dbPath, date)
+		if err != nil {
+			return err
+		}
+		total += len(found)
+	}
+	slog.Info("Detected price anomalies", "days", len(dates), "count", total)
+	return nil
+}
+
+// listAnomaliesCommand prints the stored anomalies
+func listAnomaliesCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("anomalies list", flag.ContinueOnError)
+	symbols := fs.String("symbols", "", "Comma separated symbols, all when empty")
+	from := fs.String("from", "0000-01-01", "First date (YYYY-MM-DD)")
+	to := fs.String("to", "9999-12-31", "Last date (YYYY-MM-DD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	list, err := loadAnomalies(db, parseSymbolList(*symbols), *from, *to)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "date\tsymbol\tprevious_close\tclose\tchange_percent\tzscore\treason")
+	for _, a := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%g\t%g\t%.2f\t%s\t%s\n", a.Date, a.Symbol, a.PreviousClose, a.Close,
+			a.ChangePercent, formatOptional(a.ZScore), a.Reason)
+	}
+	return tw.Flush()
+}
+
+// loadAnomalies returns the anomalies of the symbols, or of all when none are
+// given, from from to to, ordered by date and symbol
+func loadAnomalies(db *sql.DB, symbols []string, from, to string) ([]anomaly, error) {
+	query := `SELECT date, symbol, close, previous_close, change_percent, zscore, reason, detected_at
+		FROM anomalies WHERE date >= ? AND date <= ?`
+	args := []any{from, to}
+	if len(symbols) > 0 {
+		query += " AND symbol IN (?" + strings.Repeat(", ?", len(symbols)-1) + ")"
+		args = append(args, toArgs(symbols)...)
+	}
+	rows, err := db.Query(query+" ORDER BY date, symbol", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query anomalies: %w", err)
+	}
+	defer rows.Close()
+
+	list := []anomaly{}
+	for rows.Next() {
+		var a anomaly
+		var z sql.NullFloat64
+		if err := rows.Scan(&a.Date, &a.Symbol, &a.Close, &a.PreviousClose, &a.ChangePercent, &z, &a.Reason, &a.DetectedAt); err != nil {
+			return nil, fmt.Errorf("failed to read anomalies: %w", err)
+		}
+		if z.Valid {
+			a.ZScore = &z.Float64
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// serveAnomalies answers GET /anomalies?symbols=OGDC&from=2024-01-01 with the
+// stored anomalies
+func serveAnomalies(w http.ResponseWriter, r *http.Request, dbPath string) {
+	q := r.URL.Query()
+	from, to, err := queryDateRange(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer db.Close()
+	list, err := loadAnomalies(db, parseSymbolList(q.Get("symbols")), from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, list)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// defaultServeAddr is where the serve command listens without -http-addr
+const defaultServeAddr = ":8080"
+
+// runServeCommand serves the HTTP endpoints in the foreground without running
+// the scheduler, for sharing a database that another instance ingests into
+func runServeCommand(dbPath, addr string, maxStaleness time.Duration) error {
+	if addr == "" {
+		addr = defaultServeAddr
+	}
+	return listenAndServe(newHTTPServer(addr, dbPath, maxStaleness), dbPath)
+}
+
+// serveDownload streams a zip with one file per stored day of the requested
+// range: GET /download?from=2024-01-01&to=2024-01-31&format=csv. symbols
+// optionally limits the rows to a comma separated list, currency converts
+// the prices at the stored exchange rates.
+func serveDownload(w http.ResponseWriter, r *http.Request, dbPath string) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	export, ok := exporters[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown format %q, expected one of %s", format, exportFormats()), http.StatusBadRequest)
+		return
+	}
+
+	from, to := q.Get("from"), q.Get("to")
+	for name, value := range map[string]string{"from": from, "to": to} {
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid or missing %s date, expected YYYY-MM-DD", name), http.StatusBadRequest)
+			return
+		}
+	}
+	if to < from {
+		http.Error(w, "to is before from", http.StatusBadRequest)
+		return
+	}
+	symbols := parseSymbolList(q.Get("symbols"))
+	currency, err := parseCurrency(q.Get("currency"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer db.Close()
+
+	// The rates are loaded up front, a day without one can then fail the
+	// request before the body started
+	var converter *currencyConverter
+	if currency != "" {
+		if converter, err = loadConverter(db, currency); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	rows, err := db.QueryContext(r.Context(), "SELECT DISTINCT date FROM market_data WHERE date BETWEEN ? AND ? ORDER BY date", from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var dates []string
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		dates = append(dates, date)
+	}
+	rows.Close()
+	if len(dates) == 0 {
+		http.Error(w, "no data stored between "+from+" and "+to, http.StatusNotFound)
+		return
+	}
+	if converter != nil {
+		for _, date := range dates {
+			if _, err := converter.rate(date, true); err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="psx_%s_%s_%s.zip"`, from, to, format))
+
+	// Rows are loaded a day at a time so large ranges stream without being
+	// held in memory. Once the body started errors can only cut it short,
+	// which leaves a zip without its directory that clients reject.
+	zw := zip.NewWriter(w)
+	for _, date := range dates {
+		opts := exportOptions{from: date, to: date, symbols: symbols, by: "symbol", currency: currency}
+		records, err := loadExportRows(db, opts)
+		if err != nil {
+			slog.Error("Download failed", "date", date, "error", err)
+			return
+		}
+		if len(records) == 0 {
+			continue
+		}
+		if converter != nil {
+			if err := convertExportRows(converter, records); err != nil {
+				slog.Error("Download failed", "date", date, "error", err)
+				return
+			}
+		}
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: date + "." + format, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			slog.Error("Download failed", "date", date, "error", err)
+			return
+		}
+		if err := export.write(f, records, opts); err != nil {
+			slog.Error("Download failed", "date", date, "error", err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		slog.Error("Download failed", "error", err)
+		return
+	}
+	slog.Info("Download served", "from", from, "to", to, "format", format, "days", len(dates), "remote", r.RemoteAddr)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// apiKeyPrefix starts every generated key so leaked keys are easy to spot
+const apiKeyPrefix = "psx_"
+
+// requireAPIKey protects every endpoint except the health probes
+var requireAPIKey bool
+
+// runAPIKeyCommand dispatches the "apikey" subcommands. Keys live in the -db
+// file itself, also when the data is sharded by year.
+func runAPIKeyCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing apikey command, expected create, list or revoke")
+	}
+
+	switch args[0] {
+	case "create":
+		return createAPIKey(dbPath, args[1:])
+	case "list":
+		return listAPIKeys(dbPath)
+	case "revoke":
+		return revokeAPIKey(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown apikey command %q, expected create, list or revoke", args[0])
+	}
+}
+
+// createAPIKey generates a key and prints it, only its hash is stored
+func createAPIKey(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+	name := fs.String("name", "", "Name identifying who the key is for")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("missing -name for the key")
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO api_keys (name, key_hash, created_at) VALUES (?, ?, ?)",
+		*name, hashAPIKey(key), time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return fmt.Errorf("a key named %q already exists, revoke it first", *name)
+		}
+		return fmt.Errorf("failed to store key: %w", err)
+	}
+
+	fmt.Fprintln(os.Stderr, "Store this key now, it cannot be shown again:")
+	fmt.Println(key)
+	return nil
+}
+
+// listAPIKeys prints the key names, never the keys
+func listAPIKeys(dbPath string) error {
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT name, created_at FROM api_keys ORDER BY name")
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+	defer rows.Close()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "name\tcreated_at")
+	for rows.Next() {
+		var name, created string
+		if err := rows.Scan(&name, &created); err != nil {
+			return fmt.Errorf("failed to list keys: %w", err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", name, created)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+	return tw.Flush()
+}
+
+// revokeAPIKey deletes a key, requests using it fail right away
+func revokeAPIKey(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("apikey revoke", flag.ContinueOnError)
+	name := fs.String("name", "", "Name of the key to revoke")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("missing -name of the key to revoke")
+	}
+
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := db.Exec("DELETE FROM api_keys WHERE name = ?", *name)
+	if err != nil {
+		return fmt.Errorf("failed to revoke key: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("no key named %q", *name)
+	}
+	slog.Info("Revoked API key", "name", *name)
+	return nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestAPIKey extracts the key from a bearer token, the X-API-Key header or
+// the password of basic auth, where the user name is ignored
+func requestAPIKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// apiKeyName returns the name of a valid key, or "" when it is unknown
+func apiKeyName(dbPath, key string) (string, error) {
+	db, err := openReadOnlyDatabase(dbPath)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var name string
+	err = db.QueryRow("SELECT name FROM api_keys WHERE key_hash = ?", hashAPIKey(key)).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up key: %w", err)
+	}
+	return name, nil
+}
+
+// withAPIKey rejects requests without a valid key when -require-api-key is
+// set. Keys are looked up on every request so revocations apply at once.
+func withAPIKey(dbPath string, next http.Handler) http.Handler {
+	if !requireAPIKey {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Probes come from the orchestrator and carry no secrets, the API
+		// description is public so clients can be generated before a key is
+		// issued
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/openapi.json", "/docs":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := requestAPIKey(r)
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="psx-data-downloader"`)
+			http.Error(w, "missing API key", http.StatusUnauthorized)
+			return
+		}
+		name, err := apiKeyName(dbPath, key)
+		if err != nil {
+			slog.Error("API key lookup failed", "error", err)
+			http.Error(w, "failed to check API key", http.StatusServiceUnavailable)
+			return
+		}
+		if name == "" {
+			validatedKeys.Delete(hashAPIKey(key))
+			slog.Warn("Rejected invalid API key", "path", r.URL.Path, "remote", r.RemoteAddr)
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		validatedKeys.Store(hashAPIKey(key), struct{}{})
+		slog.Debug("Authenticated request", "key", name, "path", r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
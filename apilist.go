@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes of the list endpoints
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// priceRow is one stored row as returned by /prices
+type priceRow struct {
+	Date          string  `json:"date"`
+	Symbol        string  `json:"symbol"`
+	Code          string  `json:"code"`
+	CompanyName   string  `json:"company_name"`
+	Open          float64 `json:"open"`
+	High          float64 `json:"high"`
+	Low           float64 `json:"low"`
+	Close         float64 `json:"close"`
+	Volume        int64   `json:"volume"`
+	PreviousClose float64 `json:"previous_close"`
+}
+
+// symbolRow is one listed company as returned by /symbols
+type symbolRow struct {
+	Symbol      string `json:"symbol"`
+	Code        string `json:"code"`
+	CompanyName string `json:"company_name"`
+	FirstSeen   string `json:"first_seen"`
+	LastSeen    string `json:"last_seen"`
+	// Days, High52w and Low52w come from symbol_stats and are empty for
+	// symbols not summarised yet
+	Days    int      `json:"days"`
+	High52w *float64 `json:"high_52w"`
+	Low52w  *float64 `json:"low_52w"`
+}
+
+// page is the envelope of the list endpoints. Next is the URL of the
+// following page and is left out on the last one.
+type page struct {
+	Data   any    `json:"data"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+	Next   string `json:"next,omitempty"`
+}
+
+// listQuery collects the WHERE, ORDER BY and paging of a list request
+type listQuery struct {
+	where  []string
+	args   []any
+	order  string
+	limit  int
+	offset int
+}
+
+// parseListQuery reads limit, offset and sort. sort is a comma separated list
+// of columns from sortable, each optionally prefixed with - for descending.
+// The tiebreak columns are appended so pages never overlap.
+func parseListQuery(q url.Values, sortable []string, defaultSort, tiebreak string) (listQuery, error) {
+	lq := listQuery{limit: defaultPageLimit}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return lq, fmt.Errorf("invalid limit %q, expected 1 to %d", v, maxPageLimit)
+		}
+		lq.limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return lq, fmt.Errorf("invalid offset %q", v)
+		}
+		lq.offset = n
+	}
+
+	sortBy := q.Get("sort")
+	if sortBy == "" {
+		sortBy = defaultSort
+	}
+	var order []string
+	for _, key := range strings.Split(sortBy, ",") {
+		key = strings.TrimSpace(key)
+		column, desc := strings.CutPrefix(key, "-")
+		if !slices.Contains(sortable, column) {
+			return lq, fmt.Errorf("cannot sort by %q, expected one of %s", column, strings.Join(sortable, ", "))
+		}
+		if desc {
+			column += " DESC"
+		}
+		order = append(order, column)
+	}
+	lq.order = strings.Join(append(order, tiebreak), ", ")
+	return lq, nil
+}
+
+// filter adds a condition with its arguments
+func (lq *listQuery) filter(cond string, args ...any) {
+	lq.where = append(lq.where, cond)
+	lq.args = append(lq.args, args...)
+}
+
+// dateRange adds from and to filters on column
+func (lq *listQuery) dateRange(q url.Values, fromColumn, toColumn string) error {
+	for _, name := range []string{"from", "to"} {
+		value := q.Get(name)
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return fmt.Errorf("invalid %s date, expected YYYY-MM-DD", name)
+		}
+		if name == "from" {
+			lq.filter(toColumn+" >= ?", value)
+		} else {
+			lq.filter(fromColumn+" <= ?", value)
+		}
+	}
+	return nil
+}
+
+func (lq *listQuery) whereClause() string {
+	if len(lq.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(lq.where, " AND ")
+}
+
+// run counts the matching rows and selects the requested page of them
+func (lq *listQuery) run(db *sql.DB, r *http.Request, columns, from string) (int, *sql.Rows, error) {
+	var total int
+	if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM "+from+lq.whereClause(), lq.args...).Scan(&total); err != nil {
+		return 0, nil, err
+	}
+	query := "SELECT " + columns + " FROM " + from + lq.whereClause() + " ORDER BY " + lq.order + " LIMIT ? OFFSET ?"
+	rows, err := db.QueryContext(r.Context(), query, append(lq.args, lq.limit, lq.offset)...)
+	return total, rows, err
+}
+
+// nextPage returns the URL of the page after the current one
+func nextPage(r *http.Request, lq listQuery, total int) string {
+	if lq.offset+lq.limit >= total {
+		return ""
+	}
+	q := r.URL.Query()
+	q.Set("offset", strconv.Itoa(lq.offset+lq.limit))
+	q.Set("limit", strconv.Itoa(lq.limit))
+	return externalPath(r.URL.Path) + "?" + q.Encode()
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+// servePrices lists stored rows: GET /prices?symbols=OGDC,HBL&from=2024-01-01
+// &to=2024-01-31&sectors=0807&min_volume=100000&sort=-volume&limit=50&offset=0,
+// sectors keeping the rows of symbols in one of them on their day. With
+// adjusted=true the rows are back-adjusted for bonus issues and splits and
+// currency=USD converts them at the stored exchange rates, the filters and
+// sort still apply to the stored values.
+func servePrices(w http.ResponseWriter, r *http.Request, dbPath string) {
+	q := r.URL.Query()
+	sortable := []string{"date", "symbol", "open", "high", "low", "close", "volume", "previous_close"}
+	lq, err := parseListQuery(q, sortable, "date,symbol", "date, symbol")
+	if err == nil {
+		err = lq.dateRange(q, "date", "date")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if symbols := parseSymbolList(q.Get("symbols")); len(symbols) > 0 {
+		lq.filter("symbol IN (?"+strings.Repeat(", ?", len(symbols)-1)+")", toArgs(symbols)...)
+	}
+	if sectors := parseSymbolList(q.Get("sectors")); len(sectors) > 0 {
+		lq.filter(sectorCondition("market_data", "market_data.date", sectors), toArgs(sectors)...)
+	}
+	if v := q.Get("min_volume"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid min_volume %q", v), http.StatusBadRequest)
+			return
+		}
+		lq.filter("volume >= ?", n)
+	}
+	adjusted := false
+	if v := q.Get("adjusted"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid adjusted %q, expected true or false", v), http.StatusBadRequest)
+			return
+		}
+		adjusted = b
+	}
+	currency, err := parseCurrency(q.Get("currency"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer db.Close()
+
+	total, rows, err := lq.run(db, r, `date, symbol, COALESCE(code, ''), COALESCE(company_name, ''),
+		COALESCE(open, 0), COALESCE(high, 0), COALESCE(low, 0), COALESCE(close, 0),
+		COALESCE(volume, 0), COALESCE(previous_close, 0)`, "market_data")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	prices := []priceRow{}
+	for rows.Next() {
+		var p priceRow
+		if err := rows.Scan(&p.Date, &p.Symbol, &p.Code, &p.CompanyName, &p.Open, &p.High, &p.Low, &p.Close, &p.Volume, &p.PreviousClose); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		prices = append(prices, p)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if adjusted {
+		rows.Close()
+		if err := adjustPrices(db, prices); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if currency != "" {
+		rows.Close()
+		if err := convertPriceRows(db, prices, currency); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	writeJSON(w, page{Data: prices, Total: total, Limit: lq.limit, Offset: lq.offset, Next: nextPage(r, lq, total)})
+}
+
+// serveSymbols lists the companies seen in the daily files. from and to keep
+// those that traded at some point in the range, sectors those in one of them
+// on their last day.
+func serveSymbols(w http.ResponseWriter, r *http.Request, dbPath string) {
+	q := r.URL.Query()
+	sortable := []string{"symbol", "company_name", "first_seen", "last_seen", "days"}
+	lq, err := parseListQuery(q, sortable, "symbol", "symbol")
+	if err == nil {
+		err = lq.dateRange(q, "first_seen", "last_seen")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if symbols := parseSymbolList(q.Get("symbols")); len(symbols) > 0 {
+		lq.filter("symbol IN (?"+strings.Repeat(", ?", len(symbols)-1)+")", toArgs(symbols)...)
+	}
+	if sectors := parseSymbolList(q.Get("sectors")); len(sectors) > 0 {
+		lq.filter(sectorCondition("companies", "companies.last_seen", sectors), toArgs(sectors)...)
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer db.Close()
+
+	// Every shard keeps its own companies, merge them per symbol. The span,
+	// row count and 52-week range are read from symbol_stats, the companies
+	// only stand in for symbols it lacks.
+	from := `(SELECT c.symbol, COALESCE(MAX(c.code), '') AS code, COALESCE(MAX(c.company_name), '') AS company_name,
+		COALESCE(MAX(s.first_date), MIN(c.first_seen)) AS first_seen, COALESCE(MAX(s.last_date), MAX(c.last_seen)) AS last_seen,
+		COALESCE(MAX(s.days), 0) AS days, MAX(s.high_52w) AS high_52w, MAX(s.low_52w) AS low_52w
+		FROM companies c LEFT JOIN symbol_stats s ON s.symbol = c.symbol GROUP BY c.symbol) AS companies`
+	total, rows, err := lq.run(db, r, "symbol, code, company_name, first_seen, last_seen, days, high_52w, low_52w", from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	symbols := []symbolRow{}
+	for rows.Next() {
+		var s symbolRow
+		if err := rows.Scan(&s.Symbol, &s.Code, &s.CompanyName, &s.FirstSeen, &s.LastSeen, &s.Days, &s.High52w, &s.Low52w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		symbols = append(symbols, s)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, page{Data: symbols, Total: total, Limit: lq.limit, Offset: lq.offset, Next: nextPage(r, lq, total)})
+}
+
+// sectorCondition keeps the rows of table whose symbol was in one of sectors
+// on the day of dateExpr, by the sectors mapping or else their own code
+func sectorCondition(table, dateExpr string, sectors []string) string {
+	return fmt.Sprintf(`COALESCE((SELECT s.sector FROM symbol_sectors s WHERE s.symbol = %[1]s.symbol
+		AND s.start_date <= %[2]s AND (s.end_date IS NULL OR s.end_date > %[2]s)), %[1]s.code) IN (?`, table, dateExpr) +
+		strings.Repeat(", ?", len(sectors)-1) + ")"
+}
+
+func toArgs(values []string) []any {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// Arrow IPC constants from the Arrow columnar format specification
+const (
+	arrowMetadataV5     = 4
+	arrowHeaderSchema   = 1
+	arrowHeaderBatch    = 3
+	arrowTypeInt        = 2
+	arrowTypeFloat      = 3
+	arrowTypeUtf8       = 5
+	arrowTypeDate       = 8
+	arrowPrecisionFloat = 2
+	arrowDateUnitDay    = 0
+	// arrowBatchRows is how many rows go into each record batch
+	arrowBatchRows = 65536
+)
+
+// arrowMagic opens and closes the IPC file format
+var arrowMagic = []byte("ARROW1")
+
+func init() {
+	registerExporter("arrow", "application/vnd.apache.arrow.file", func(w io.Writer, rows []exportRow, opts exportOptions) error {
+		return writeArrow(w, rows, true)
+	})
+	registerExporter("arrow-stream", "application/vnd.apache.arrow.stream", func(w io.Writer, rows []exportRow, opts exportOptions) error {
+		return writeArrow(w, rows, false)
+	})
+}
+
+// arrowColumn describes one exported column and how to encode its values
+type arrowColumn struct {
+	name     string
+	typeID   uint8
+	typeDesc func() *fbTable
+	// values returns the value buffers of the column, without validity
+	values func(rows []exportRow) [][]byte
+}
+
+var arrowColumns = []arrowColumn{
+	{"date", arrowTypeDate, arrowDateType, func(rows []exportRow) [][]byte {
+		buf := make([]byte, 0, 4*len(rows))
+		for _, r := range rows {
+			var days int32
+			if t, err := time.Parse("2006-01-02", r.date); err == nil {
+				days = int32(t.Unix() / 86400)
+			}
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(days))
+		}
+		return [][]byte{buf}
+	}},
+	{"symbol", arrowTypeUtf8, arrowEmptyType, arrowStrings(func(r exportRow) string { return r.symbol })},
+	{"code", arrowTypeUtf8, arrowEmptyType, arrowStrings(func(r exportRow) string { return r.code })},
+	{"company_name", arrowTypeUtf8, arrowEmptyType, arrowStrings(func(r exportRow) string { return r.companyName })},
+	{"open", arrowTypeFloat, arrowDoubleType, arrowFloats(func(r exportRow) float64 { return r.open })},
+	{"high", arrowTypeFloat, arrowDoubleType, arrowFloats(func(r exportRow) float64 { return r.high })},
+	{"low", arrowTypeFloat, arrowDoubleType, arrowFloats(func(r exportRow) float64 { return r.low })},
+	{"close", arrowTypeFloat, arrowDoubleType, arrowFloats(func(r exportRow) float64 { return r.close })},
+	{"volume", arrowTypeInt, arrowInt64Type, func(rows []exportRow) [][]byte {
+		buf := make([]byte, 0, 8*len(rows))
+		for _, r := range rows {
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(r.volume))
+		}
+		return [][]byte{buf}
+	}},
+	{"previous_close", arrowTypeFloat, arrowDoubleType, arrowFloats(func(r exportRow) float64 { return r.previousClose })},
+}
+
+func arrowEmptyType() *fbTable { return &fbTable{} }
+
+func arrowDateType() *fbTable {
+	// The default unit is milliseconds, so the day unit must be written
+	t := &fbTable{}
+	t.addScalar(0, int16(arrowDateUnitDay))
+	return t
+}
+
+func arrowDoubleType() *fbTable {
+	t := &fbTable{}
+	t.addScalar(0, int16(arrowPrecisionFloat))
+	return t
+}
+
+func arrowInt64Type() *fbTable {
+	t := &fbTable{}
+	t.addScalar(0, int32(64))
+	t.addScalar(1, true)
+	return t
+}
+
+func arrowFloats(get func(exportRow) float64) func([]exportRow) [][]byte {
+	return func(rows []exportRow) [][]byte {
+		buf := make([]byte, 0, 8*len(rows))
+		for _, r := range rows {
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(get(r)))
+		}
+		return [][]byte{buf}
+	}
+}
+
+// arrowStrings encodes a utf8 column as int32 offsets followed by the data
+func arrowStrings(get func(exportRow) string) func([]exportRow) [][]byte {
+	return func(rows []exportRow) [][]byte {
+		offsets := make([]byte, 0, 4*(len(rows)+1))
+		var data []byte
+		offsets = binary.LittleEndian.AppendUint32(offsets, 0)
+		for _, r := range rows {
+			data = append(data, get(r)...)
+			offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+		}
+		return [][]byte{offsets, data}
+	}
+}
+
+// arrowSchema builds the Schema table shared by the schema message and the
+// file footer
+func arrowSchema() *fbTable {
+	fields := make(fbRefs, len(arrowColumns))
+	for i, c := range arrowColumns {
+		f := &fbTable{}
+		f.addRef(0, fbString(c.name))
+		f.addScalar(1, false)
+		f.addScalar(2, c.typeID)
+		f.addRef(3, c.typeDesc())
+		// Readers expect a children vector even for flat types
+		f.addRef(5, fbRefs{})
+		fields[i] = f
+	}
+	schema := &fbTable{}
+	schema.addRef(1, fields)
+	return schema
+}
+
+// arrowMessage wraps a message header in a Message table
+func arrowMessage(headerType uint8, header *fbTable, bodyLength int64) *fbTable {
+	m := &fbTable{}
+	m.addScalar(0, int16(arrowMetadataV5))
+	m.addScalar(1, headerType)
+	m.addRef(2, header)
+	m.addScalar(3, bodyLength)
+	return m
+}
+
+// arrowBlock locates a record batch in the file for the footer
+type arrowBlock struct {
+	offset, metadataLength, bodyLength int64
+}
+
+// countingWriter tracks the offset of everything written
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeArrowMessage writes an encapsulated message: continuation marker,
+// metadata length, metadata and body. It returns the metadata length
+// including the prefix as recorded in file footers.
+func writeArrowMessage(w io.Writer, metadata, body []byte) (int64, error) {
+	prefix := binary.LittleEndian.AppendUint32(nil, 0xFFFFFFFF)
+	prefix = binary.LittleEndian.AppendUint32(prefix, uint32(len(metadata)))
+	for _, part := range [][]byte{prefix, metadata, body} {
+		if _, err := w.Write(part); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(prefix) + len(metadata)), nil
+}
+
+// writeArrow writes the rows as an Arrow IPC file, which can be memory
+// mapped and is what Feather v2 files are, or as an IPC stream
+func writeArrow(out io.Writer, rows []exportRow, file bool) error {
+	w := &countingWriter{w: out}
+	if file {
+		if _, err := w.Write(append(append([]byte{}, arrowMagic...), 0, 0)); err != nil {
+			return err
+		}
+	}
+
+	schema := encodeFlatbuffer(arrowMessage(arrowHeaderSchema, arrowSchema(), 0))
+	if _, err := writeArrowMessage(w, schema, nil); err != nil {
+		return err
+	}
+
+	var blocks []arrowBlock
+	for start := 0; start < len(rows); start += arrowBatchRows {
+		batch := rows[start:min(start+arrowBatchRows, len(rows))]
+
+		var body, nodes, buffers []byte
+		addBuffer := func(data []byte) {
+			buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(body)))
+			buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(data)))
+			body = append(body, data...)
+			for len(body)%8 != 0 {
+				body = append(body, 0)
+			}
+		}
+		for _, c := range arrowColumns {
+			nodes = binary.LittleEndian.AppendUint64(nodes, uint64(len(batch)))
+			nodes = binary.LittleEndian.AppendUint64(nodes, 0)
+			// No nulls, so the validity bitmap is left empty
+			addBuffer(nil)
+			for _, data := range c.values(batch) {
+				addBuffer(data)
+			}
+		}
+
+		header := &fbTable{}
+		header.addScalar(0, int64(len(batch)))
+		header.addRef(1, fbStructs{data: nodes, count: len(arrowColumns), align: 8})
+		header.addRef(2, fbStructs{data: buffers, count: len(buffers) / 16, align: 8})
+		metadata := encodeFlatbuffer(arrowMessage(arrowHeaderBatch, header, int64(len(body))))
+
+		offset := w.n
+		metadataLength, err := writeArrowMessage(w, metadata, body)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, arrowBlock{offset, metadataLength, int64(len(body))})
+	}
+
+	// End of stream marker
+	if _, err := w.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0}); err != nil {
+		return err
+	}
+	if !file {
+		return nil
+	}
+
+	// Block is a struct of offset, metadata length (padded to 8) and body length
+	var blockData []byte
+	for _, b := range blocks {
+		blockData = binary.LittleEndian.AppendUint64(blockData, uint64(b.offset))
+		blockData = binary.LittleEndian.AppendUint32(blockData, uint32(b.metadataLength))
+		blockData = binary.LittleEndian.AppendUint32(blockData, 0)
+		blockData = binary.LittleEndian.AppendUint64(blockData, uint64(b.bodyLength))
+	}
+	footer := &fbTable{}
+	footer.addScalar(0, int16(arrowMetadataV5))
+	footer.addRef(1, arrowSchema())
+	footer.addRef(2, fbStructs{align: 8})
+	footer.addRef(3, fbStructs{data: blockData, count: len(blocks), align: 8})
+	footerData := encodeFlatbuffer(footer)
+
+	if _, err := w.Write(footerData); err != nil {
+		return err
+	}
+	if _, err := w.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(footerData)))); err != nil {
+		return err
+	}
+	_, err := w.Write(arrowMagic)
+	return err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+)
+
+// avroBlockRows is how many records go into each data block
+const avroBlockRows = 4096
+
+// avroSchema describes an exported row. Dates use the date logical type,
+// days since the Unix epoch, which Kafka Connect and most readers map to a
+// native date.
+var avroSchema = map[string]any{
+	"type":      "record",
+	"name":      "MarketData",
+	"namespace": "pk.com.psx",
+	"fields": []map[string]any{
+		{"name": "date", "type": map[string]string{"type": "int", "logicalType": "date"}},
+		{"name": "symbol", "type": "string"},
+		{"name": "code", "type": "string"},
+		{"name": "company_name", "type": "string"},
+		{"name": "open", "type": "double"},
+		{"name": "high", "type": "double"},
+		{"name": "low", "type": "double"},
+		{"name": "close", "type": "double"},
+		{"name": "volume", "type": "long"},
+		{"name": "previous_close", "type": "double"},
+	},
+}
+
+func init() {
+	registerExporter("avro", "application/avro", writeAvro)
+}
+
+// writeAvro writes an Avro object container file with the schema embedded in
+// the header and deflate compressed data blocks
+func writeAvro(w io.Writer, rows []exportRow, opts exportOptions) error {
+	schema, err := json.Marshal(avroSchema)
+	if err != nil {
+		return err
+	}
+	sync := make([]byte, 16)
+	if _, err := rand.Read(sync); err != nil {
+		return err
+	}
+
+	header := []byte("Obj\x01")
+	header = avroLong(header, 2)
+	header = avroBytes(header, []byte("avro.schema"))
+	header = avroBytes(header, schema)
+	header = avroBytes(header, []byte("avro.codec"))
+	header = avroBytes(header, []byte("deflate"))
+	header = avroLong(header, 0)
+	header = append(header, sync...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	var block, compressed bytes.Buffer
+	for start := 0; start < len(rows); start += avroBlockRows {
+		batch := rows[start:min(start+avroBlockRows, len(rows))]
+
+		var records []byte
+		for _, r := range batch {
+			records = avroRecord(records, r)
+		}
+
+		compressed.Reset()
+		fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(records); err != nil {
+			return err
+		}
+		if err := fw.Close(); err != nil {
+			return err
+		}
+
+		block.Reset()
+		block.Write(avroLong(nil, int64(len(batch))))
+		block.Write(avroLong(nil, int64(compressed.Len())))
+		block.Write(compressed.Bytes())
+		block.Write(sync)
+		if _, err := w.Write(block.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// avroRecord appends the binary encoding of a row in schema field order
+func avroRecord(buf []byte, r exportRow) []byte {
+	var days int64
+	if t, err := time.Parse("2006-01-02", r.date); err == nil {
+		days = t.Unix() / 86400
+	}
+	buf = avroLong(buf, days)
+	buf = avroBytes(buf, []byte(r.symbol))
+	buf = avroBytes(buf, []byte(r.code))
+	buf = avroBytes(buf, []byte(r.companyName))
+	for _, v := range []float64{r.open, r.high, r.low, r.close} {
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+	}
+	buf = avroLong(buf, r.volume)
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(r.previousClose))
+}
+
+// avroLong appends a zigzag varint, used for int, long and lengths
+func avroLong(buf []byte, v int64) []byte {
+	return binary.AppendUvarint(buf, uint64(v<<1)^uint64(v>>63))
+}
+
+// avroBytes appends a length prefixed byte string
+func avroBytes(buf, b []byte) []byte {
+	return append(avroLong(buf, int64(len(b))), b...)
+}
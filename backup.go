@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupPagesPerStep is how many pages are copied before the source database
+// is released again, letting the daemon keep writing during a backup
+const backupPagesPerStep = 1024
+
+// backupDatabase writes a consistent snapshot of the database to a new file
+func backupDatabase(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("db backup", flag.ContinueOnError)
+	out := fs.String("out", "", "Path of the snapshot file to create")
+	force := fs.Bool("force", false, "Overwrite the snapshot file if it already exists")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("missing -out path for the snapshot")
+	}
+
+	pairs, err := shardPairs(dbPath, *out, dbPath)
+	if err != nil {
+		return err
+	}
+	for _, dst := range pairs {
+		if _, err := os.Stat(dst); err == nil && !*force {
+			return fmt.Errorf("%s already exists, pass -force to overwrite it", dst)
+		}
+	}
+	for src, dst := range pairs {
+		if err := backupFile(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupFile snapshots a single database file
+func backupFile(path, out string) error {
+	src, err := openReadOnlyDatabase(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := sql.Open(sqliteDriverName, sqliteDSN(out, sqliteConfig, false))
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer dst.Close()
+
+	slog.Info("Starting backup", "db", path, "out", out)
+	start := time.Now()
+	if err := copyDatabase(src, dst); err != nil {
+		return err
+	}
+	slog.Info("Backup completed", "out", out, "size", fileSize(out), "elapsed", time.Since(start))
+	return nil
+}
+
+// shardPairs maps every file of the database rooted at from to the matching
+// file rooted at to. Without sharding that is the single file itself, with
+// sharding the existing shards of existing are used to find the years, next
+// to the base file holding the tables that are not sharded.
+func shardPairs(from, to, existing string) (map[string]string, error) {
+	if !shardByYear {
+		return map[string]string{from: to}, nil
+	}
+	years, _, err := shardFiles(existing)
+	if err != nil {
+		return nil, err
+	}
+	pairs := make(map[string]string, len(years)+1)
+	if _, err := os.Stat(existing); err == nil {
+		pairs[from] = to
+	}
+	for _, year := range years {
+		pairs[shardPath(from, year)] = shardPath(to, year)
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no database files of %s found", existing)
+	}
+	return pairs, nil
+}
+
+// restoreDatabase replaces the database with a backup and verifies the
+// restored rows against the ingest log
+func restoreDatabase(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("db restore", flag.ContinueOnError)
+	from := fs.String("from", "", "Backup file to restore")
+	force := fs.Bool("force", false, "Overwrite the database if it already exists")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return errors.New("missing -from path of the backup to restore")
+	}
+
+	pairs, err := shardPairs(*from, dbPath, *from)
+	if err != nil {
+		return err
+	}
+	for _, dst := range pairs {
+		if _, err := os.Stat(dst); err == nil && !*force {
+			return fmt.Errorf("%s already exists, pass -force to overwrite it", dst)
+		}
+	}
+
+	// Make sure no daemon is writing while the file is replaced
+	lock, err := acquireInstanceLock(dbPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	for src, dst := range pairs {
+		if err := restoreFile(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFile replaces a single database file with its backup
+func restoreFile(from, path string) error {
+	src, err := openReadOnlyDatabase(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	problems, err := integrityCheck(src)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("backup %s failed the integrity check: %s", from, problems[0])
+	}
+
+	dst, err := sql.Open(sqliteDriverName, sqliteDSN(path, sqliteConfig, false))
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer dst.Close()
+
+	slog.Info("Starting restore", "from", from, "db", path)
+	start := time.Now()
+	if err := copyDatabase(src, dst); err != nil {
+		return err
+	}
+	slog.Info("Restore completed", "db", path, "elapsed", time.Since(start))
+
+	return verifyRestoredDatabase(dst)
+}
+
+// verifyRestoredDatabase checks the restored file for corruption and compares
+// the stored rows with the counts and checksums recorded at ingest time
+func verifyRestoredDatabase(db *sql.DB) error {
+	problems, err := integrityCheck(db)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("restored database failed the integrity check: %s", problems[0])
+	}
+
+	checked, mismatches, err := verifyIngestLog(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range mismatches {
+		slog.Error("Restored day does not match the ingest log", "date", m.date,
+			"expectedRows", m.expectedRows, "actualRows", m.actualRows,
+			"expectedChecksum", m.expectedChecksum, "actualChecksum", m.actualChecksum)
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d of %d days do not match the ingest log", len(mismatches), checked)
+	}
+
+	slog.Info("Restored database verified", "daysChecked", checked)
+	return nil
+}
+
+// copyDatabase copies every page of src into dst using the SQLite online
+// backup API, logging progress as it goes
+func copyDatabase(src, dst *sql.DB) error {
+	ctx := context.Background()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get source connection: %w", err)
+	}
+	defer srcConn.Close()
+
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get destination connection: %w", err)
+	}
+	defer dstConn.Close()
+
+	return dstConn.Raw(func(dstDriver any) error {
+		return srcConn.Raw(func(srcDriver any) error {
+			dstSQLite, ok := dstDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("destination is not a SQLite connection")
+			}
+			srcSQLite, ok := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("source is not a SQLite connection")
+			}
+
+			backup, err := dstSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return fmt.Errorf("failed to start backup: %w", err)
+			}
+
+			lastLog := time.Now()
+			for {
+				done, err := backup.Step(backupPagesPerStep)
+				if err != nil {
+					backup.Finish()
+					return fmt.Errorf("backup step failed: %w", err)
+				}
+				if done {
+					break
+				}
+				if time.Since(lastLog) > 2*time.Second {
+					total := backup.PageCount()
+					slog.Info("Backup progress", "pagesCopied", total-backup.Remaining(), "pagesTotal", total)
+					lastLog = time.Now()
+				}
+				// Give writers a chance to grab the database between steps
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("failed to finish backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
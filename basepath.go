@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// basePath is the prefix the HTTP server is mounted under behind a reverse
+// proxy, such as /psx
+var basePath string
+
+// cleanBasePath turns psx, /psx/ and /psx into /psx, and / into nothing
+func cleanBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// externalPath returns the URL path clients see for path
+func externalPath(path string) string {
+	return cleanBasePath(basePath) + path
+}
+
+// withBasePath strips -base-path from request paths. Paths without it are
+// served as well, so proxies may pass the full path or strip the prefix
+// themselves, links in responses always carry it.
+func withBasePath(next http.Handler) http.Handler {
+	prefix := cleanBasePath(basePath)
+	if prefix == "" {
+		return next
+	}
+	stripped := http.StripPrefix(prefix, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == prefix:
+			http.Redirect(w, r, prefix+"/docs", http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, prefix+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
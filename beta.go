@@ -0,0 +1,37 @@
+package main
+
+import "math"
+
+func init() {
+	registerIndicator("beta", windowIndicator(indexBeta))
+}
+
+// indexBeta is the beta of the daily log returns of the bars against those
+// of the benchmark index, the covariance of both over the variance of the
+// index. Every bar needs both returns, so it stays empty until the indices
+// module has stored the index for the whole window.
+func indexBeta(bars []indicatorBar, window int) (float64, bool) {
+	var sumS, sumM float64
+	stock := make([]float64, 0, len(bars))
+	market := make([]float64, 0, len(bars))
+	for _, b := range bars {
+		if b.close <= 0 || b.previousClose <= 0 || b.indexClose <= 0 || b.indexPreviousClose <= 0 {
+			return 0, false
+		}
+		s, m := math.Log(b.close/b.previousClose), math.Log(b.indexClose/b.indexPreviousClose)
+		stock = append(stock, s)
+		market = append(market, m)
+		sumS += s
+		sumM += m
+	}
+	meanS, meanM := sumS/float64(window), sumM/float64(window)
+	var cov, variance float64
+	for i := range stock {
+		cov += (stock[i] - meanS) * (market[i] - meanM)
+		variance += (market[i] - meanM) * (market[i] - meanM)
+	}
+	if variance == 0 {
+		return 0, false
+	}
+	return cov / variance, true
+}
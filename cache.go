@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cacheEntries bounds the cached API responses, 0 disables caching
+var cacheEntries = 512
+
+// cacheMaxBody keeps single huge pages from pushing everything else out
+const cacheMaxBody = 4 << 20
+
+// cachedResponse is a stored 200 response
+type cachedResponse struct {
+	key         string
+	contentType string
+	body        []byte
+}
+
+// responseCache holds the most recently used responses of the query
+// endpoints. Every entry belongs to a version of the database files, a new
+// ingest, in this process or another one, changes the version and drops them
+// all.
+type responseCache struct {
+	dbPath string
+
+	mu      sync.Mutex
+	version string
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newResponseCache(dbPath string) *responseCache {
+	return &responseCache{dbPath: dbPath, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// databaseVersion identifies the current content of the database by the size
+// and modification time of its files and their write-ahead logs, which change
+// with every commit. Statting them is far cheaper than any query.
+func databaseVersion(dbPath string) string {
+	files, err := databaseFiles(dbPath)
+	if err != nil {
+		return ""
+	}
+	// The shards leave out the -db file, which holds the portfolios, sectors
+	// and the other tables not tied to a year
+	if shardByYear {
+		files = append(files, dbPath)
+	}
+	var version strings.Builder
+	for _, f := range files {
+		for _, name := range []string{f, f + "-wal"} {
+			if info, err := os.Stat(name); err == nil {
+				fmt.Fprintf(&version, "%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+			}
+		}
+	}
+	return version.String()
+}
+
+// sync drops every entry once the database changed
+func (c *responseCache) sync() {
+	version := databaseVersion(c.dbPath)
+	if version != c.version || version == "" {
+		c.version = version
+		c.order.Init()
+		clear(c.entries)
+	}
+}
+
+// reset drops every entry after a change made by this process. Responses
+// still being computed from before it are not stored.
+func (c *responseCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version = ""
+	c.order.Init()
+	clear(c.entries)
+}
+
+func (c *responseCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sync()
+	e, ok := c.entries[key]
+	if !ok {
+		return cachedResponse{}, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(cachedResponse), true
+}
+
+// put stores a response computed for version, unless the database changed
+// while it was computed
+func (c *responseCache) put(version string, resp cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version {
+		return
+	}
+	if e, ok := c.entries[resp.key]; ok {
+		c.order.Remove(e)
+	}
+	c.entries[resp.key] = c.order.PushFront(resp)
+	for c.order.Len() > cacheEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(cachedResponse).key)
+	}
+}
+
+func (c *responseCache) currentVersion() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// responseRecorder captures a response while passing it through
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.body.Len() <= cacheMaxBody {
+		r.body.Write(p)
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// cached answers repeated requests for the same URL from c. The query is
+// normalised so parameter order does not matter. Requests run after
+// authentication, so any client with access may share an entry.
+func (c *responseCache) cached(next http.HandlerFunc) http.HandlerFunc {
+	if cacheEntries <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path + "?" + r.URL.Query().Encode()
+		if resp, ok := c.get(key); ok {
+			w.Header().Set("Content-Type", resp.contentType)
+			w.Header().Set("Content-Length", strconv.Itoa(len(resp.body)))
+			w.Header().Set("X-Cache", "HIT")
+			w.Write(resp.body)
+			return
+		}
+
+		version := c.currentVersion()
+		w.Header().Set("X-Cache", "MISS")
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		if rec.status == http.StatusOK && rec.body.Len() <= cacheMaxBody {
+			c.put(version, cachedResponse{key: key, contentType: rec.Header().Get("Content-Type"), body: rec.body.Bytes()})
+		}
+	}
+}
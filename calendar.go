@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sources of holiday entries. Manual entries are never overwritten by imports.
+const (
+	holidaySourceManual = "manual"
+	holidaySourceFile   = "file"
+	holidaySourcePSX    = "psx"
+)
+
+// includeWeekends treats Saturdays and Sundays as trading days, for the rare
+// special sessions PSX holds on a weekend
+var includeWeekends bool
+
+var (
+	holidaysMu sync.RWMutex
+	// marketHolidays maps YYYY-MM-DD to the holiday description
+	marketHolidays = map[string]string{}
+)
+
+// holiday is a row of the holidays table
+type holiday struct {
+	date        string
+	description string
+	source      string
+}
+
+// isTradingDay reports whether PSX is expected to publish a market summary
+// for the date
+func isTradingDay(date time.Time) bool {
+	holidaysMu.RLock()
+	_, closed := marketHolidays[date.Format("2006-01-02")]
+	holidaysMu.RUnlock()
+	if closed {
+		return false
+	}
+
+	if includeWeekends {
+		return true
+	}
+	switch date.Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	return true
+}
+
+// mostRecentTradingDay returns date, or the closest trading day before it
+func mostRecentTradingDay(date time.Time) time.Time {
+	// Holidays rarely run beyond a couple of weeks, the bound only guards the loop
+	for i := 0; i < 31 && !isTradingDay(date); i++ {
+		date = date.AddDate(0, 0, -1)
+	}
+	return date
+}
+
+// loadHolidays replaces the in-memory calendar with the holidays table
+func loadHolidays(dbPath string) error {
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	list, err := listHolidays(db, "", "")
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]string, len(list))
+	for _, h := range list {
+		loaded[h.date] = h.description
+	}
+	holidaysMu.Lock()
+	marketHolidays = loaded
+	holidaysMu.Unlock()
+	return nil
+}
+
+// listHolidays returns the holidays between from and to inclusive, either
+// bound may be empty
+func listHolidays(db *sql.DB, from, to string) ([]holiday, error) {
+	if to == "" {
+		to = "9999-12-31"
+	}
+	rows, err := db.Query("SELECT date, description, source FROM holidays WHERE date >= ? AND date <= ? ORDER BY date", from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query holidays: %w", err)
+	}
+	defer rows.Close()
+
+	var list []holiday
+	for rows.Next() {
+		var h holiday
+		if err := rows.Scan(&h.date, &h.description, &h.source); err != nil {
+			return nil, fmt.Errorf("failed to read holiday: %w", err)
+		}
+		list = append(list, h)
+	}
+	return list, rows.Err()
+}
+
+// saveHolidays stores holidays in the database file of their year and adds
+// them to the in-memory calendar. Imported entries never replace manual ones.
+// It returns how many entries were stored.
+func saveHolidays(dbPath string, list []holiday) (int, error) {
+	byFile := make(map[string][]holiday)
+	for _, h := range list {
+		date, err := time.Parse("2006-01-02", h.date)
+		if err != nil {
+			return 0, fmt.Errorf("invalid holiday date %q: %w", h.date, err)
+		}
+		path := marketDBPath(dbPath, date)
+		byFile[path] = append(byFile[path], h)
+	}
+
+	paths := make([]string, 0, len(byFile))
+	for path := range byFile {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	saved := 0
+	now := time.Now().Format(time.RFC3339)
+	for _, path := range paths {
+		db, err := openDatabase(path)
+		if err != nil {
+			return saved, err
+		}
+		for _, h := range byFile[path] {
+			res, err := db.Exec(`INSERT INTO holidays (date, description, source, added_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT(date) DO UPDATE SET description = excluded.description, source = excluded.source, added_at = excluded.added_at
+				WHERE excluded.source = ? OR holidays.source <> ?`,
+				h.date, h.description, h.source, now, holidaySourceManual, holidaySourceManual)
+			if err != nil {
+				db.Close()
+				return saved, fmt.Errorf("failed to save holiday %s: %w", h.date, err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				saved++
+				holidaysMu.Lock()
+				marketHolidays[h.date] = h.description
+				holidaysMu.Unlock()
+			}
+		}
+		db.Close()
+	}
+	return saved, nil
+}
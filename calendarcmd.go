@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// psxHolidaysURL is the PSX page listing the market holidays of the year
+const psxHolidaysURL = "https://www.psx.com.pk/psx/exchange/general/calendar-holidays"
+
+func init() {
+	// Keeps the calendar current without anyone running calendar import
+	registerModule("holidays", func(date time.Time, dbPath string) error {
+		_, err := importPSXHolidays(dbPath, psxHolidaysURL)
+		return err
+	})
+}
+
+// runCalendarCommand dispatches the "calendar" subcommands
+func runCalendarCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing calendar command, expected add-holiday, list or import")
+	}
+
+	switch args[0] {
+	case "add-holiday":
+		return addHoliday(dbPath, args[1:])
+	case "list":
+		return printHolidays(dbPath, args[1:])
+	case "import":
+		return importHolidays(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown calendar command %q, expected add-holiday, list or import", args[0])
+	}
+}
+
+// addHoliday records a single market holiday
+func addHoliday(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("calendar add-holiday", flag.ContinueOnError)
+	date := fs.String("date", "", "Holiday date (YYYY-MM-DD)")
+	description := fs.String("description", "", "What the holiday is for")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *date == "" {
+		return errors.New("missing -date of the holiday")
+	}
+	if _, err := time.Parse("2006-01-02", *date); err != nil {
+		return fmt.Errorf("invalid -date: %w", err)
+	}
+
+	if _, err := saveHolidays(dbPath, []holiday{{date: *date, description: *description, source: holidaySourceManual}}); err != nil {
+		return err
+	}
+	slog.Info("Holiday added", "date", *date, "description", *description)
+	return nil
+}
+
+// printHolidays writes the holidays in the requested range to stdout
+func printHolidays(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("calendar list", flag.ContinueOnError)
+	from := fs.String("from", "", "First date to list (YYYY-MM-DD)")
+	to := fs.String("to", "", "Last date to list (YYYY-MM-DD)")
+	year := fs.Int("year", 0, "List only this year")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *year != 0 {
+		*from = fmt.Sprintf("%04d-01-01", *year)
+		*to = fmt.Sprintf("%04d-12-31", *year)
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	list, err := listHolidays(db, *from, *to)
+	if err != nil {
+		return err
+	}
+	for _, h := range list {
+		date, _ := time.Parse("2006-01-02", h.date)
+		fmt.Printf("%s\t%s\t%s\t%s\n", h.date, date.Weekday().String()[:3], h.source, h.description)
+	}
+	return nil
+}
+
+// importHolidays loads holidays from a CSV file of date,description rows or,
+// without -file, from the notices published by PSX
+func importHolidays(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("calendar import", flag.ContinueOnError)
+	file := fs.String("file", "", "CSV file with date,description rows, fetch the PSX holiday page when empty")
+	url := fs.String("url", psxHolidaysURL, "PSX holiday page to fetch")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *file == "" {
+		saved, err := importPSXHolidays(dbPath, *url)
+		if err != nil {
+			return err
+		}
+		slog.Info("Imported PSX holidays", "saved", saved)
+		return nil
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open holiday file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	var list []holiday
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read holiday file: %w", err)
+		}
+		date := strings.TrimSpace(record[0])
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			// Tolerate a header row
+			if line == 1 {
+				continue
+			}
+			return fmt.Errorf("line %d: invalid date %q", line, date)
+		}
+		h := holiday{date: date, source: holidaySourceFile}
+		if len(record) > 1 {
+			h.description = strings.TrimSpace(record[1])
+		}
+		list = append(list, h)
+	}
+
+	saved, err := saveHolidays(dbPath, list)
+	if err != nil {
+		return err
+	}
+	slog.Info("Imported holidays", "file", *file, "read", len(list), "saved", saved)
+	return nil
+}
+
+// importPSXHolidays fetches the PSX holiday page and stores the holidays it
+// lists. The page is meant for people, so parsing is best effort: any table
+// row with a recognisable date becomes a holiday.
+func importPSXHolidays(dbPath, url string) (int, error) {
+	client := httpClient()
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch holidays: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("fetching holidays failed with status: %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read holidays page: %w", err)
+	}
+
+	list := parseHolidayPage(string(body))
+	if len(list) == 0 {
+		return 0, errors.New("no holidays found on the PSX holiday page, its layout may have changed")
+	}
+	return saveHolidays(dbPath, list)
+}
+
+var (
+	tableRowPattern  = regexp.MustCompile(`(?is)<tr[^>]*>(.*?)</tr>`)
+	tableCellPattern = regexp.MustCompile(`(?is)<t[dh][^>]*>(.*?)</t[dh]>`)
+	tagPattern       = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// holidayDateLayouts are the date formats seen in PSX notices
+var holidayDateLayouts = []string{
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"Monday, January 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+	"02-Jan-2006",
+	"02-01-2006",
+	"2006-01-02",
+}
+
+// parseHolidayPage extracts holidays from the table rows of an HTML page. The
+// row's first cell that is neither a date nor a weekday is the description.
+func parseHolidayPage(page string) []holiday {
+	var list []holiday
+	for _, row := range tableRowPattern.FindAllStringSubmatch(page, -1) {
+		var date, description string
+		for _, cell := range tableCellPattern.FindAllStringSubmatch(row[1], -1) {
+			text := strings.Join(strings.Fields(html.UnescapeString(tagPattern.ReplaceAllString(cell[1], " "))), " ")
+			if text == "" {
+				continue
+			}
+			if d, ok := parseHolidayDate(text); ok {
+				if date == "" {
+					date = d
+				}
+				continue
+			}
+			if isWeekdayName(text) {
+				continue
+			}
+			if description == "" {
+				description = text
+			}
+		}
+		if date != "" {
+			list = append(list, holiday{date: date, description: description, source: holidaySourcePSX})
+		}
+	}
+	return list
+}
+
+// parseHolidayDate tries every known layout, returning YYYY-MM-DD on success
+func parseHolidayDate(text string) (string, bool) {
+	for _, layout := range holidayDateLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t.Format("2006-01-02"), true
+		}
+	}
+	return "", false
+}
+
+// isWeekdayName reports whether text is a day name such as "Monday" or "Mon"
+func isWeekdayName(text string) bool {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(text, d.String()) || strings.EqualFold(text, d.String()[:3]) {
+			return true
+		}
+	}
+	return false
+}
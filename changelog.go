@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// changelogPath is the JSONL file every inserted and updated row is appended
+// to, the changelog is off when it is empty
+var changelogPath string
+
+// changeEvent is one line of the changelog. Sequence numbers increase by one
+// per event across runs, consumers resume after the last one they processed.
+type changeEvent struct {
+	Seq           int64   `json:"seq"`
+	Op            string  `json:"op"`
+	Date          string  `json:"date"`
+	Symbol        string  `json:"symbol"`
+	Code          string  `json:"code"`
+	CompanyName   string  `json:"company_name"`
+	Open          float64 `json:"open"`
+	High          float64 `json:"high"`
+	Low           float64 `json:"low"`
+	Close         float64 `json:"close"`
+	Volume        int     `json:"volume"`
+	PreviousClose float64 `json:"previous_close"`
+	ExtraFields   string  `json:"extra_fields,omitempty"`
+	ChangedAt     string  `json:"changed_at"`
+}
+
+// newChangeEvent describes a stored row, the sequence number is assigned when
+// the event is appended
+func newChangeEvent(change rowChange, rec parsedRecord, changedAt time.Time) changeEvent {
+	op := "insert"
+	if change == rowUpdated {
+		op = "update"
+	}
+	return changeEvent{
+		Op:            op,
+		Date:          rec.date,
+		Symbol:        rec.symbol,
+		Code:          rec.row.code,
+		CompanyName:   rec.row.companyName,
+		Open:          rec.row.open,
+		High:          rec.row.high,
+		Low:           rec.row.low,
+		Close:         rec.row.close,
+		Volume:        rec.row.volume,
+		PreviousClose: rec.row.previousClose,
+		ExtraFields:   rec.row.extraFields,
+		ChangedAt:     changedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// changelogMu serialises appends so sequence numbers stay unique
+var changelogMu sync.Mutex
+
+// appendChangelog numbers the events and appends them to the changelog file,
+// synced to disk before returning. It is called once the ingest committed,
+// so rows rolled back with a failed commit or batch never show up in it.
+func appendChangelog(events []changeEvent) error {
+	if changelogPath == "" || len(events) == 0 {
+		return nil
+	}
+	changelogMu.Lock()
+	defer changelogMu.Unlock()
+
+	f, err := os.OpenFile(changelogPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open changelog: %w", err)
+	}
+	defer f.Close()
+
+	seq, err := lastSequence(f)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, e := range events {
+		seq++
+		e.Seq = seq
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write changelog: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync changelog: %w", err)
+	}
+	return nil
+}
+
+// lastSequence reads the sequence number of the last complete line. A torn
+// line left by a crash is cut off so new events start on a fresh line.
+func lastSequence(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read changelog: %w", err)
+	}
+	size := info.Size()
+	if size == 0 {
+		return 0, nil
+	}
+
+	// Events are a few hundred bytes, the tail always holds a whole one
+	tail := min(size, 64*1024)
+	buf := make([]byte, tail)
+	if _, err := f.ReadAt(buf, size-tail); err != nil && err != io.EOF {
+		return 0, fmt.Errorf("failed to read changelog: %w", err)
+	}
+	if buf[len(buf)-1] != '\n' {
+		end := bytes.LastIndexByte(buf, '\n') + 1
+		if err := f.Truncate(size - tail + int64(end)); err != nil {
+			return 0, fmt.Errorf("failed to repair changelog: %w", err)
+		}
+		buf = buf[:end]
+	}
+	lines := bytes.Split(bytes.TrimSuffix(buf, []byte("\n")), []byte("\n"))
+	last := lines[len(lines)-1]
+	if len(last) == 0 {
+		return 0, nil
+	}
+	return eventSequence(last)
+}
+
+// runChangesCommand prints the changelog events after a sequence number,
+// optionally following the file for new ones like tail -f
+func runChangesCommand(args []string) error {
+	fs := flag.NewFlagSet("changes", flag.ContinueOnError)
+	file := fs.String("file", changelogPath, "Changelog file, defaults to -changelog")
+	since := fs.Int64("since", 0, "Print events with a sequence number above this one")
+	follow := fs.Bool("follow", false, "Keep running and print new events as they are written")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("missing changelog file, pass -file or -changelog")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open changelog: %w", err)
+	}
+	defer f.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	reader := bufio.NewReader(f)
+	var partial []byte
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Keep an incomplete line until the writer finishes it
+			partial = append(partial, line...)
+			if !*follow {
+				return out.Flush()
+			}
+			if err := out.Flush(); err != nil {
+				return err
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read changelog: %w", err)
+		}
+		line = append(partial, line...)
+		partial = nil
+
+		seq, err := eventSequence(line)
+		if err != nil {
+			return err
+		}
+		if seq > *since {
+			if _, err := out.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// eventSequence extracts the sequence number of a changelog line
+func eventSequence(line []byte) (int64, error) {
+	var e struct {
+		Seq int64 `json:"seq"`
+	}
+	if err := json.Unmarshal(line, &e); err != nil {
+		return 0, fmt.Errorf("invalid changelog line: %w", err)
+	}
+	return e.Seq, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// checkIssue is a single invariant violation in the check report
+type checkIssue struct {
+	Check  string `json:"check"`
+	Date   string `json:"date"`
+	Symbol string `json:"symbol,omitempty"`
+	Detail string `json:"detail"`
+}
+
+// checkReport is the JSON document written by the check command
+type checkReport struct {
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	Days       int            `json:"days"`
+	MinSymbols int            `json:"minSymbols"`
+	MaxSymbols int            `json:"maxSymbols"`
+	Counts     map[string]int `json:"counts"`
+	Issues     []checkIssue   `json:"issues"`
+}
+
+// runCheckCommand validates per day invariants of the stored data and
+// writes a JSON report to stdout
+func runCheckCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	from := fs.String("from", "", "First date to check (YYYY-MM-DD), defaults to the earliest stored date")
+	to := fs.String("to", "", "Last date to check (YYYY-MM-DD), defaults to the latest stored date")
+	minSymbols := fs.Int("min-symbols", 0, "Fewest symbols expected per day, 0 uses half the median")
+	maxSymbols := fs.Int("max-symbols", 0, "Most symbols expected per day, 0 uses one and a half times the median")
+	out := fs.String("out", "-", "File or s3://, gs://, az://, sftp:// or ftp:// URL to write the report to, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := loadHolidays(dbPath); err != nil {
+		return err
+	}
+
+	var first, last sql.NullString
+	if err := db.QueryRow("SELECT MIN(date), MAX(date) FROM market_data").Scan(&first, &last); err != nil {
+		return fmt.Errorf("failed to query stored date range: %w", err)
+	}
+	if *from == "" {
+		*from = first.String
+	}
+	if *to == "" {
+		*to = last.String
+	}
+	if *from == "" || *to == "" {
+		return errors.New("no data to check")
+	}
+
+	report, err := checkDatabase(db, *from, *to, *minSymbols, *maxSymbols)
+	if err != nil {
+		return err
+	}
+
+	err = writeOutput(*out, "application/json", func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if len(report.Issues) > 0 {
+		return fmt.Errorf("found %d issues", len(report.Issues))
+	}
+	return nil
+}
+
+// checkDatabase runs every invariant over the dates from..to inclusive
+func checkDatabase(db *sql.DB, from, to string, minSymbols, maxSymbols int) (*checkReport, error) {
+	startDate, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -from date: %w", err)
+	}
+	endDate, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -to date: %w", err)
+	}
+
+	report := &checkReport{From: from, To: to, Counts: map[string]int{}, Issues: []checkIssue{}}
+	add := func(issue checkIssue) {
+		report.Issues = append(report.Issues, issue)
+		report.Counts[issue.Check]++
+	}
+
+	counts, err := symbolCounts(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+	report.Days = len(counts)
+
+	// Trading days and ingested days without any rows
+	for _, date := range backloadDates(startDate, endDate.AddDate(0, 0, 1)) {
+		day := date.Format("2006-01-02")
+		if counts[day] == 0 {
+			add(checkIssue{Check: "empty_day", Date: day, Detail: "trading day without rows"})
+		}
+	}
+
+	// Symbol counts outside the expected range
+	report.MinSymbols, report.MaxSymbols = minSymbols, maxSymbols
+	if median := medianCount(counts); median > 0 {
+		if report.MinSymbols == 0 {
+			report.MinSymbols = median / 2
+		}
+		if report.MaxSymbols == 0 {
+			report.MaxSymbols = median * 3 / 2
+		}
+	}
+	days := make([]string, 0, len(counts))
+	for day := range counts {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	for _, day := range days {
+		if n := counts[day]; n < report.MinSymbols || (report.MaxSymbols > 0 && n > report.MaxSymbols) {
+			add(checkIssue{Check: "symbol_count", Date: day,
+				Detail: fmt.Sprintf("%d symbols, expected %d-%d", n, report.MinSymbols, report.MaxSymbols)})
+		}
+	}
+
+	// Symbols stored twice for a day under whitespace or case variants, or in
+	// two shards
+	rows, err := db.Query(`SELECT date, UPPER(TRIM(symbol)), COUNT(*) FROM market_data
+		WHERE date >= ? AND date <= ? GROUP BY date, UPPER(TRIM(symbol)) HAVING COUNT(*) > 1
+		ORDER BY date, 2`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for duplicates: %w", err)
+	}
+	for rows.Next() {
+		var day, symbol string
+		var n int
+		if err := rows.Scan(&day, &symbol, &n); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read duplicates: %w", err)
+		}
+		add(checkIssue{Check: "duplicate", Date: day, Symbol: symbol, Detail: fmt.Sprintf("%d rows", n)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read duplicates: %w", err)
+	}
+
+	// OHLC consistency, rows without any prices are untraded and skipped
+	rows, err = db.Query(`SELECT date, symbol, open, high, low, close, volume FROM market_data
+		WHERE date >= ? AND date <= ? AND NOT (open = 0 AND high = 0 AND low = 0)
+		AND (low > high OR open > high OR close > high OR open < low OR close < low
+			OR low < 0 OR close < 0 OR volume < 0)
+		ORDER BY date, symbol`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check OHLC consistency: %w", err)
+	}
+	for rows.Next() {
+		var day, symbol string
+		var open, high, low, close sql.NullFloat64
+		var volume sql.NullInt64
+		if err := rows.Scan(&day, &symbol, &open, &high, &low, &close, &volume); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read OHLC rows: %w", err)
+		}
+		add(checkIssue{Check: "ohlc", Date: day, Symbol: symbol,
+			Detail: fmt.Sprintf("open %v high %v low %v close %v volume %d", open.Float64, high.Float64, low.Float64, close.Float64, volume.Int64)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read OHLC rows: %w", err)
+	}
+
+	return report, nil
+}
+
+// symbolCounts returns the number of rows stored for each day in the range
+func symbolCounts(db *sql.DB, from, to string) (map[string]int, error) {
+	rows, err := db.Query("SELECT date, COUNT(*) FROM market_data WHERE date >= ? AND date <= ? GROUP BY date", from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count symbols: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var day string
+		var n int
+		if err := rows.Scan(&day, &n); err != nil {
+			return nil, fmt.Errorf("failed to count symbols: %w", err)
+		}
+		counts[day] = n
+	}
+	return counts, rows.Err()
+}
+
+// medianCount returns the median of the per day counts
+func medianCount(counts map[string]int) int {
+	values := make([]int, 0, len(counts))
+	for _, n := range counts {
+		values = append(values, n)
+	}
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Ints(values)
+	return values[len(values)/2]
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// envPrefix is prepended to the environment variable name of every flag
+const envPrefix = "PSX_"
+
+// envName maps a flag name such as backloadFrom or log-format to the
+// environment variable PSX_BACKLOAD_FROM or PSX_LOG_FORMAT
+func envName(flagName string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	for i, r := range flagName {
+		switch {
+		case r == '-' || r == '.':
+			b.WriteByte('_')
+		case unicode.IsUpper(r) && i > 0:
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// applyEnv sets every flag that was not given on the command line from its
+// PSX_* environment variable, so command line flags take precedence
+func applyEnv(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, name, setErr)
+		}
+	})
+	return err
+}
+
+// envUsage prints the flag defaults along with the matching environment variables
+func envUsage(fs *flag.FlagSet) func() {
+	return func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(out, "\nEvery flag can also be set with an environment variable, e.g. -%s with %s.\n", "backloadFrom", envName("backloadFrom"))
+	}
+}
+
+// fileConfig is the optional JSON config file given with -config
+type fileConfig struct {
+	// Schedules maps a collector name (eod or an optional module) to its
+	// cron expression
+	Schedules map[string]string `json:"schedules"`
+	// Retention maps a table name to how long its rows are kept, e.g. "90d"
+	Retention map[string]string `json:"retention"`
+	// Exports are written by the export module for every ingested date
+	Exports []scheduledExport `json:"exports"`
+	// Notifiers are told about ingests and alerts, each entry has a type of
+	// webhook, slack or email and the settings of that type
+	Notifiers []json.RawMessage `json:"notifiers"`
+	// Hooks are commands run after every successful ingest
+	Hooks []postIngestHook `json:"hooks"`
+	// SummaryColumns maps market_data columns to field indices of the market
+	// summary lines, counted from 0, overriding the built-in layout
+	SummaryColumns map[string]int `json:"summary_columns"`
+	// SummaryVersions pin the layout version of date ranges of market
+	// summary files instead of detecting it
+	SummaryVersions []summaryVersion `json:"summary_versions"`
+	// Indicators maps an indicator name to the parameter sets, such as
+	// windows in days, it is kept for, replacing the defaults
+	Indicators map[string][]indicatorParams `json:"indicators"`
+	// Anomalies sets the thresholds moves are flagged as anomalies beyond
+	Anomalies *anomalyConfig `json:"anomalies"`
+	// Rates maps rate names such as kibor_3m to the SBP EasyData series the
+	// rates module reads them from
+	Rates map[string]string `json:"rates"`
+	// ListedFunds are the ETFs and REITs whose premium to NAV is tracked
+	ListedFunds []listedFund `json:"listed_funds"`
+}
+
+// loadConfig reads the config file, an empty path yields an empty config
+func loadConfig(path string) (*fileConfig, error) {
+	cfg := &fileConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return cfg, nil
+}
+
+// flagSet reports whether a flag was given on the command line or through
+// its environment variable
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
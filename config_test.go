@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		flag string
+		want string
+	}{
+		{"db", "PSX_DB"},
+		{"store", "PSX_STORE"},
+		{"log-format", "PSX_LOG_FORMAT"},
+		{"shard-by-year", "PSX_SHARD_BY_YEAR"},
+		{"sbp-api-key", "PSX_SBP_API_KEY"},
+		{"backloadFrom", "PSX_BACKLOAD_FROM"},
+		{"httpAddr", "PSX_HTTP_ADDR"},
+		{"Force", "PSX_FORCE"},
+		{"summary.columns", "PSX_SUMMARY_COLUMNS"},
+		{"http-tls-timeout", "PSX_HTTP_TLS_TIMEOUT"},
+		{"retry2", "PSX_RETRY2"},
+	}
+	for _, tt := range tests {
+		if got := envName(tt.flag); got != tt.want {
+			t.Errorf("envName(%q) = %q, want %q", tt.flag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// errStaleConstituents refuses lists older than the latest recorded change
+// of an index, the history is only ever extended forward
+var errStaleConstituents = errors.New("constituent list predates the latest recomposition")
+
+// symbolPattern matches the PSX symbols of constituent lists
+var symbolPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9.\-]*$`)
+
+func init() {
+	registerModule("constituents", func(date time.Time, dbPath string) error {
+		return collectConstituents(dbPath, date)
+	})
+}
+
+// indexPageURL is the PSX page listing the constituents of an index
+func indexPageURL(index string) string {
+	return "https://dps.psx.com.pk/indices/" + index
+}
+
+// runConstituentsCommand dispatches the "constituents" subcommands
+func runConstituentsCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing constituents command, expected import, list or changes")
+	}
+
+	switch args[0] {
+	case "import":
+		return importConstituents(dbPath, args[1:])
+	case "list":
+		return printConstituents(dbPath, args[1:])
+	case "changes":
+		return printRecompositions(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown constituents command %q, expected import, list or changes", args[0])
+	}
+}
+
+// collectConstituents records the current constituents of the tracked
+// indices as of date
+func collectConstituents(dbPath string, date time.Time) error {
+	for _, index := range strings.Split(trackedIndices, ",") {
+		index = strings.ToUpper(strings.TrimSpace(index))
+		if index == "" {
+			continue
+		}
+		symbols, err := fetchConstituents(index)
+		if err != nil {
+			return err
+		}
+		if err := recordConstituents(dbPath, index, date.Format("2006-01-02"), symbols, "psx"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchConstituents reads the symbols of the constituents table of the PSX
+// page of an index, the one with a symbol column
+func fetchConstituents(index string) ([]string, error) {
+	data, err := downloadFile(indexPageURL(index))
+	if err != nil {
+		return nil, err
+	}
+	var symbols []string
+	column := -1
+	for _, row := range tableRowPattern.FindAllStringSubmatch(string(data), -1) {
+		var cells []string
+		for _, cell := range tableCellPattern.FindAllStringSubmatch(row[1], -1) {
+			cells = append(cells, strings.Join(strings.Fields(html.UnescapeString(tagPattern.ReplaceAllString(cell[1], " "))), " "))
+		}
+		isHeader := false
+		for i, cell := range cells {
+			if strings.EqualFold(cell, "symbol") {
+				column, isHeader = i, true
+			}
+		}
+		if isHeader || column < 0 || column >= len(cells) {
+			continue
+		}
+		// Symbol cells may carry the company name after the symbol
+		if fields := strings.Fields(cells[column]); len(fields) > 0 && symbolPattern.MatchString(fields[0]) {
+			symbols = append(symbols, fields[0])
+		}
+	}
+	if len(symbols) == 0 {
+		return nil, fmt.Errorf("no constituents found on the PSX page of %s, its layout may have changed", index)
+	}
+	return symbols, nil
+}
+
+// importConstituents records a constituent list read from a file, one symbol
+// per line, as the membership of an index from a date on
+func importConstituents(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("constituents import", flag.ContinueOnError)
+	index := fs.String("index", benchmarkIndex, "Index the list is of")
+	date := fs.String("date", "", "Day the list took effect (YYYY-MM-DD)")
+	file := fs.String("file", "", "File with one symbol per line, fetch the current list from PSX when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	name := strings.ToUpper(strings.TrimSpace(*index))
+	if *date == "" {
+		*date = time.Now().Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", *date); err != nil {
+		return fmt.Errorf("invalid -date: %w", err)
+	}
+
+	if *file == "" {
+		symbols, err := fetchConstituents(name)
+		if err != nil {
+			return err
+		}
+		return recordConstituents(dbPath, name, *date, symbols, "psx")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open constituent file: %w", err)
+	}
+	defer f.Close()
+	var symbols []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		// Tolerate CSV files with the symbol first
+		symbol, _, _ := strings.Cut(scanner.Text(), ",")
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if symbol == "" || strings.HasPrefix(symbol, "#") || (line == 1 && symbol == "SYMBOL") {
+			continue
+		}
+		if !symbolPattern.MatchString(symbol) {
+			return fmt.Errorf("line %d: invalid symbol %q", line, symbol)
+		}
+		symbols = append(symbols, symbol)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read constituent file: %w", err)
+	}
+	if len(symbols) == 0 {
+		return errors.New("no symbols in the constituent file")
+	}
+	return recordConstituents(dbPath, name, *date, symbols, "file")
+}
+
+// recordConstituents makes symbols the members of index from date on. Those
+// that left get date as their end, those that joined an interval starting
+// on it. Constituents live in the -db file, their intervals span shards.
+func recordConstituents(dbPath, index, date string, symbols []string, source string) error {
+	symbols = slices.Compact(slices.Sorted(slices.Values(symbols)))
+	db, err := sharedDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var latest sql.NullString
+	if err := tx.QueryRow(`SELECT MAX(MAX(start_date), COALESCE(MAX(end_date), ''))
+		FROM index_constituents WHERE index_name = ?`, index).Scan(&latest); err != nil {
+		return fmt.Errorf("failed to query constituents of %s: %w", index, err)
+	}
+	if latest.Valid && date < latest.String {
+		return fmt.Errorf("%w: %s of %s is before %s", errStaleConstituents, date, index, latest.String)
+	}
+
+	current, err := queryConstituents(tx, index, date)
+	if err != nil {
+		return err
+	}
+	added, removed := subtractSymbols(symbols, current), subtractSymbols(current, symbols)
+	if len(added) == 0 && len(removed) == 0 {
+		slog.Debug("Constituents unchanged", "index", index, "date", date, "symbols", len(symbols))
+		return nil
+	}
+
+	for _, symbol := range removed {
+		if _, err := tx.Exec(`UPDATE index_constituents SET end_date = ?
+			WHERE index_name = ? AND symbol = ? AND end_date IS NULL`, date, index, symbol); err != nil {
+			return fmt.Errorf("failed to remove %s from %s: %w", symbol, index, err)
+		}
+	}
+	for _, symbol := range added {
+		// A symbol removed earlier on the same day just stays a member
+		res, err := tx.Exec(`UPDATE index_constituents SET end_date = NULL
+			WHERE index_name = ? AND symbol = ? AND end_date = ?`, index, symbol, date)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to %s: %w", symbol, index, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO index_constituents (index_name, symbol, start_date, end_date, source)
+			VALUES (?, ?, ?, NULL, ?)`, index, symbol, date, source); err != nil {
+			return fmt.Errorf("failed to add %s to %s: %w", symbol, index, err)
+		}
+	}
+	// Members that joined and left on the same day never were
+	if _, err := tx.Exec(`DELETE FROM index_constituents WHERE index_name = ? AND start_date = end_date`, index); err != nil {
+		return fmt.Errorf("failed to update constituents of %s: %w", index, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit constituents of %s: %w", index, err)
+	}
+	slog.Info("Index recomposed", "index", index, "date", date, "constituents", len(symbols), "added", added, "removed", removed)
+	return nil
+}
+
+// queryConstituents returns the members of index on date, sorted
+func queryConstituents(q querier, index, date string) ([]string, error) {
+	rows, err := q.Query(`SELECT symbol FROM index_constituents
+		WHERE index_name = ? AND start_date <= ? AND (end_date IS NULL OR end_date > ?) ORDER BY symbol`, index, date, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query constituents of %s: %w", index, err)
+	}
+	defer rows.Close()
+	var symbols []string
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			return nil, fmt.Errorf("failed to read constituents of %s: %w", index, err)
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols, rows.Err()
+}
+
+// printConstituents lists the members of an index on a day
+func printConstituents(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("constituents list", flag.ContinueOnError)
+	index := fs.String("index", benchmarkIndex, "Index to list")
+	date := fs.String("date", "", "Day of the membership (YYYY-MM-DD), defaults to today")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *date == "" {
+		*date = time.Now().Format("2006-01-02")
+	}
+	db, err := openReadOnlyDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	symbols, err := queryConstituents(db, strings.ToUpper(*index), *date)
+	if err != nil {
+		return err
+	}
+	for _, symbol := range symbols {
+		fmt.Println(symbol)
+	}
+	return nil
+}
+
+// printRecompositions lists the days the members of an index changed, with
+// the symbols that joined and left
+func printRecompositions(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("constituents changes", flag.ContinueOnError)
+	index := fs.String("index", benchmarkIndex, "Index to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	db, err := openReadOnlyDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT start_date, '+', symbol FROM index_constituents WHERE index_name = ?
+		UNION ALL SELECT end_date, '-', symbol FROM index_constituents WHERE index_name = ? AND end_date IS NOT NULL
+		ORDER BY 1, 2, 3`, strings.ToUpper(*index), strings.ToUpper(*index))
+	if err != nil {
+		return fmt.Errorf("failed to query constituents of %s: %w", *index, err)
+	}
+	defer rows.Close()
+
+	var day string
+	var joined, left []string
+	flush := func() {
+		if day != "" {
+			fmt.Printf("%s\t%d joined\t%d left\t%s\n", day, len(joined), len(left), strings.Join(append(prefixAll("+", joined), prefixAll("-", left)...), " "))
+		}
+	}
+	for rows.Next() {
+		var date, change, symbol string
+		if err := rows.Scan(&date, &change, &symbol); err != nil {
+			return fmt.Errorf("failed to read constituents of %s: %w", *index, err)
+		}
+		if date != day {
+			flush()
+			day, joined, left = date, nil, nil
+		}
+		if change == "+" {
+			joined = append(joined, symbol)
+		} else {
+			left = append(left, symbol)
+		}
+	}
+	flush()
+	return rows.Err()
+}
+
+func prefixAll(prefix string, values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = prefix + v
+	}
+	return out
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// minCorrelationDays is how many days with returns of both symbols a pair
+// needs to get a correlation
+const minCorrelationDays = 3
+
+// correlationMatrix holds the pairwise correlations of the daily log returns
+// of the symbols over the days from From to To, nil where a pair has too few
+// days in common
+type correlationMatrix struct {
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Days    int          `json:"days"`
+	Symbols []string     `json:"symbols"`
+	Matrix  [][]*float64 `json:"matrix"`
+}
+
+// correlationReport writes the correlation matrix of the returns of the
+// given symbols over the last -window trading days up to -to
+func correlationReport(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("report correlation", flag.ContinueOnError)
+	symbols := fs.String("symbols", "", "Comma separated symbols to correlate")
+	window := fs.String("window", "250d", "Trading days to correlate, such as 250d")
+	to := fs.String("to", "", "Last date of the window (YYYY-MM-DD), the latest stored when empty")
+	format := fs.String("format", "csv", "Output format: table, csv, json or png")
+	out := fs.String("out", "-", "File to write, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	list := parseSymbolList(*symbols)
+	if len(list) < 2 {
+		return errors.New("-symbols needs at least two symbols")
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(*window, "d"))
+	if err != nil || days < minCorrelationDays {
+		return fmt.Errorf("invalid window %q, expected trading days such as 250d", *window)
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	matrix, err := correlateReturns(db, list, days, *to)
+	if err != nil {
+		return err
+	}
+
+	var write func(w io.Writer, m correlationMatrix) error
+	switch *format {
+	case "table":
+		write = writeCorrelationTable
+	case "csv":
+		write = writeCorrelationCSV
+	case "json":
+		write = func(w io.Writer, m correlationMatrix) error {
+			return json.NewEncoder(w).Encode(m)
+		}
+	case "png":
+		write = writeCorrelationHeatmap
+	default:
+		return fmt.Errorf("unknown format %q, expected table, csv, json or png", *format)
+	}
+	return writeReport(*out, func(w io.Writer) error { return write(w, matrix) })
+}
+
+// correlateReturns computes the matrix over the last days trading days of
+// the market up to to. Each pair uses the days both symbols have a return.
+func correlateReturns(db *sql.DB, symbols []string, days int, to string) (correlationMatrix, error) {
+	m := correlationMatrix{Symbols: symbols}
+	if to == "" {
+		to = "9999-12-31"
+	}
+	err := db.QueryRow(`SELECT COALESCE(MIN(date), ''), COALESCE(MAX(date), ''), COUNT(*) FROM (
+		SELECT DISTINCT date FROM daily_returns WHERE date <= ? ORDER BY date DESC LIMIT ?
+	)`, to, days).Scan(&m.From, &m.To, &m.Days)
+	if err != nil {
+		return m, fmt.Errorf("failed to find the window: %w", err)
+	}
+	if m.Days == 0 {
+		return m, errors.New("no daily returns stored, run db aggregate first")
+	}
+
+	index := make(map[string]int, len(symbols))
+	for i, s := range symbols {
+		index[s] = i
+	}
+	rows, err := db.Query(`SELECT date, symbol, log_return FROM daily_returns
+		WHERE date BETWEEN ? AND ? AND symbol IN (?`+strings.Repeat(", ?", len(symbols)-1)+`)`,
+		append([]any{m.From, m.To}, toArgs(symbols)...)...)
+	if err != nil {
+		return m, fmt.Errorf("failed to query returns: %w", err)
+	}
+	defer rows.Close()
+	returns := map[string][]float64{}
+	for rows.Next() {
+		var date, symbol string
+		var r float64
+		if err := rows.Scan(&date, &symbol, &r); err != nil {
+			return m, fmt.Errorf("failed to read returns: %w", err)
+		}
+		day, ok := returns[date]
+		if !ok {
+			day = make([]float64, len(symbols))
+			for i := range day {
+				day[i] = math.NaN()
+			}
+			returns[date] = day
+		}
+		day[index[symbol]] = r
+	}
+	if err := rows.Err(); err != nil {
+		return m, fmt.Errorf("failed to read returns: %w", err)
+	}
+
+	m.Matrix = make([][]*float64, len(symbols))
+	for i := range symbols {
+		m.Matrix[i] = make([]*float64, len(symbols))
+	}
+	for i := range symbols {
+		for j := i; j < len(symbols); j++ {
+			if c, ok := correlation(returns, i, j); ok {
+				m.Matrix[i][j], m.Matrix[j][i] = &c, &c
+			}
+		}
+	}
+	return m, nil
+}
+
+// correlation is the Pearson correlation of the returns of symbols i and j on
+// the days both have one, rounded to 4 decimals
+func correlation(returns map[string][]float64, i, j int) (float64, bool) {
+	var n, sumX, sumY float64
+	for _, day := range returns {
+		if !math.IsNaN(day[i]) && !math.IsNaN(day[j]) {
+			n++
+			sumX += day[i]
+			sumY += day[j]
+		}
+	}
+	if n < minCorrelationDays {
+		return 0, false
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var cov, varX, varY float64
+	for _, day := range returns {
+		if !math.IsNaN(day[i]) && !math.IsNaN(day[j]) {
+			dx, dy := day[i]-meanX, day[j]-meanY
+			cov += dx * dy
+			varX += dx * dx
+			varY += dy * dy
+		}
+	}
+	if varX == 0 || varY == 0 {
+		return 0, false
+	}
+	return math.Round(cov/math.Sqrt(varX*varY)*10000) / 10000, true
+}
+
+// correlationCells renders a row of the matrix, missing pairs empty
+func correlationCells(row []*float64) []string {
+	cells := make([]string, len(row))
+	for i, c := range row {
+		if c != nil {
+			cells[i] = strconv.FormatFloat(*c, 'f', 4, 64)
+		}
+	}
+	return cells
+}
+
+func writeCorrelationTable(w io.Writer, m correlationMatrix) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\t"+strings.Join(m.Symbols, "\t")+"\t")
+	for i, row := range m.Matrix {
+		fmt.Fprintln(tw, m.Symbols[i]+"\t"+strings.Join(correlationCells(row), "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+func writeCorrelationCSV(w io.Writer, m correlationMatrix) error {
+	cw := csv.NewWriter(w)
+	cw.Write(append([]string{"symbol"}, m.Symbols...))
+	for i, row := range m.Matrix {
+		cw.Write(append([]string{m.Symbols[i]}, correlationCells(row)...))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeCorrelationHeatmap draws the matrix as a PNG, blue for positive and
+// red for negative correlations, grey for missing pairs
+func writeCorrelationHeatmap(w io.Writer, m correlationMatrix) error {
+	face := basicfont.Face7x13
+	const charWidth, lineHeight, padding = 7, 13, 6
+	longest := 0
+	for _, s := range m.Symbols {
+		longest = max(longest, len(s))
+	}
+	cell := max(44, longest*charWidth+padding)
+	left, top := longest*charWidth+2*padding, lineHeight+2*padding
+	img := image.NewRGBA(image.Rect(0, 0, left+cell*len(m.Symbols)+padding, top+cell*len(m.Symbols)+padding))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	text := func(s string, x, y int) {
+		d := font.Drawer{Dst: img, Src: image.Black, Face: face, Dot: fixed.P(x, y)}
+		d.DrawString(s)
+	}
+	centered := func(s string, x, y int) {
+		text(s, x+(cell-len(s)*charWidth)/2, y+(cell+lineHeight)/2-2)
+	}
+
+	for i, s := range m.Symbols {
+		text(s, left+i*cell+(cell-len(s)*charWidth)/2, top-padding)
+		text(s, padding, top+i*cell+(cell+lineHeight)/2-2)
+	}
+	for i, row := range m.Matrix {
+		for j, c := range row {
+			r := image.Rect(left+j*cell, top+i*cell, left+(j+1)*cell-1, top+(i+1)*cell-1)
+			draw.Draw(img, r, image.NewUniform(heatColor(c)), image.Point{}, draw.Src)
+			if c != nil {
+				centered(strconv.FormatFloat(*c, 'f', 2, 64), r.Min.X, r.Min.Y)
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// heatColor fades from white at 0 to blue at 1 and red at -1
+func heatColor(c *float64) color.Color {
+	if c == nil {
+		return color.RGBA{0xcc, 0xcc, 0xcc, 0xff}
+	}
+	fade := uint8(255 - math.Min(math.Abs(*c), 1)*160)
+	if *c < 0 {
+		return color.RGBA{0xff, fade, fade, 0xff}
+	}
+	return color.RGBA{fade, fade, 0xff, 0xff}
+}
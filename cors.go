@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsOptions lists the browser origins allowed to call the HTTP server and
+// the request headers they may send. No origins disables CORS.
+type corsOptions struct {
+	origins string
+	headers string
+}
+
+var corsConfig = corsOptions{headers: "Authorization, X-API-Key, Content-Type"}
+
+// corsMaxAge is how long browsers may cache a preflight answer, in seconds
+const corsMaxAge = "600"
+
+// withCORS adds the CORS headers for allowed origins and answers preflight
+// requests itself, before rate limiting and authentication, since browsers
+// never send credentials with them
+func withCORS(next http.Handler) http.Handler {
+	var origins []string
+	for _, o := range strings.Split(corsConfig.origins, ",") {
+		if o = strings.TrimSuffix(strings.TrimSpace(o), "/"); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	if len(origins) == 0 {
+		return next
+	}
+	anyOrigin := slices.Contains(origins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !(anyOrigin || slices.Contains(origins, origin)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", corsConfig.headers)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		// Lets scripts read the file name of downloads and when to retry
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition, Retry-After")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// cronBits builds the bit set a field of the listed values parses into
+func cronBits(values ...int) uint64 {
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+func cronRange(from, to int) uint64 {
+	var bits uint64
+	for v := from; v <= to; v++ {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr                          string
+		minute, hour, dom, month, dow uint64
+		location                      string
+	}{
+		{"0 23 * * *", cronBits(0), cronBits(23), cronRange(1, 31), cronRange(1, 12), cronRange(0, 6), "Asia/Karachi"},
+		{"*/15 9-17/4 1,15 * *", cronBits(0, 15, 30, 45), cronBits(9, 13, 17), cronBits(1, 15), cronRange(1, 12), cronRange(0, 6), "Asia/Karachi"},
+		{"30 6 * jan-mar mon-fri", cronBits(30), cronBits(6), cronRange(1, 31), cronBits(1, 2, 3), cronRange(1, 5), "Asia/Karachi"},
+		{"0 0 * * 7", cronBits(0), cronBits(0), cronRange(1, 31), cronRange(1, 12), cronBits(0), "Asia/Karachi"},
+		{"0 0 * * 5-7", cronBits(0), cronBits(0), cronRange(1, 31), cronRange(1, 12), cronBits(0, 5, 6), "Asia/Karachi"},
+		{"@daily", cronBits(0), cronBits(0), cronRange(1, 31), cronRange(1, 12), cronRange(0, 6), "Asia/Karachi"},
+		{"@weekly", cronBits(0), cronBits(0), cronRange(1, 31), cronRange(1, 12), cronBits(0), "Asia/Karachi"},
+		{"CRON_TZ=America/New_York 0 9 * * *", cronBits(0), cronBits(9), cronRange(1, 31), cronRange(1, 12), cronRange(0, 6), "America/New_York"},
+		{"TZ=UTC @hourly", cronBits(0), cronRange(0, 23), cronRange(1, 31), cronRange(1, 12), cronRange(0, 6), "UTC"},
+	}
+
+	karachi := mustLoadLocation(t, "Asia/Karachi")
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr, karachi)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if s.minute != tt.minute || s.hour != tt.hour || s.dom != tt.dom || s.month != tt.month || s.dow != tt.dow {
+			t.Errorf("parseCron(%q) = %x %x %x %x %x, want %x %x %x %x %x", tt.expr,
+				s.minute, s.hour, s.dom, s.month, s.dow, tt.minute, tt.hour, tt.dom, tt.month, tt.dow)
+		}
+		if s.location.String() != tt.location {
+			t.Errorf("parseCron(%q) location = %s, want %s", tt.expr, s.location, tt.location)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"0 23 * *",
+		"0 23 * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@never",
+		"CRON_TZ=Nowhere/Zone 0 0 * * *",
+	}
+	for _, expr := range tests {
+		if _, err := parseCron(expr, time.UTC); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	karachi := mustLoadLocation(t, "Asia/Karachi")
+	newYork := mustLoadLocation(t, "America/New_York")
+
+	tests := []struct {
+		name string
+		expr string
+		loc  *time.Location
+		from time.Time
+		want time.Time
+	}{
+		{"later today", "0 23 * * *", karachi,
+			time.Date(2024, 1, 1, 22, 0, 0, 0, karachi), time.Date(2024, 1, 1, 23, 0, 0, 0, karachi)},
+		{"strictly after", "0 23 * * *", karachi,
+			time.Date(2024, 1, 1, 23, 0, 0, 0, karachi), time.Date(2024, 1, 2, 23, 0, 0, 0, karachi)},
+		{"from another zone", "0 23 * * *", karachi,
+			time.Date(2024, 1, 1, 17, 59, 0, 0, time.UTC), time.Date(2024, 1, 1, 23, 0, 0, 0, karachi)},
+		{"weekdays skip the weekend", "0 9 * * 1-5", karachi,
+			time.Date(2024, 6, 1, 10, 0, 0, 0, karachi), time.Date(2024, 6, 3, 9, 0, 0, 0, karachi)},
+		{"restricted day fields combine with or", "0 0 13 * 5", karachi,
+			time.Date(2024, 9, 1, 0, 0, 0, 0, karachi), time.Date(2024, 9, 6, 0, 0, 0, 0, karachi)},
+		{"day of month alone", "0 0 13 * *", karachi,
+			time.Date(2024, 9, 1, 0, 0, 0, 0, karachi), time.Date(2024, 9, 13, 0, 0, 0, 0, karachi)},
+		{"29 February", "0 0 29 2 *", karachi,
+			time.Date(2024, 3, 1, 0, 0, 0, 0, karachi), time.Date(2028, 2, 29, 0, 0, 0, 0, karachi)},
+		{"hour kept across spring forward", "0 23 * * *", newYork,
+			time.Date(2024, 3, 9, 23, 0, 0, 0, newYork), time.Date(2024, 3, 10, 23, 0, 0, 0, newYork)},
+		{"hour kept across fall back", "0 23 * * *", newYork,
+			time.Date(2024, 11, 2, 23, 0, 0, 0, newYork), time.Date(2024, 11, 3, 23, 0, 0, 0, newYork)},
+		{"skipped time fires when clocks resume", "30 2 * * *", newYork,
+			time.Date(2024, 3, 9, 12, 0, 0, 0, newYork), time.Date(2024, 3, 10, 3, 0, 0, 0, newYork)},
+		{"skipped time the day after", "30 2 * * *", newYork,
+			time.Date(2024, 3, 10, 3, 0, 0, 0, newYork), time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)},
+		{"repeated time fires", "30 1 * * *", newYork,
+			time.Date(2024, 11, 3, 0, 0, 0, 0, newYork), time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC)},
+		{"repeated time fires once", "30 1 * * *", newYork,
+			time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), time.Date(2024, 11, 4, 1, 30, 0, 0, newYork)},
+		{"never", "0 0 31 2 *", karachi,
+			time.Date(2024, 1, 1, 0, 0, 0, 0, karachi), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr, tt.loc)
+			if err != nil {
+				t.Fatalf("parseCron(%q): %v", tt.expr, err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("failed to load time zone %s: %v", name, err)
+	}
+	return loc
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+)
+
+// verifyTimeseries compares the stored closes of symbols from from to to
+// with the timeseries PSX serves for them, which comes from another pipeline
+// than the market summary, so systematic parser errors show up as
+// differences. Without symbols a random sample of those stored is checked.
+func verifyTimeseries(db *sql.DB, from, to string, symbols []string, sample int, tolerance float64) error {
+	if len(symbols) == 0 {
+		stored, err := storedSymbols(db, from, to)
+		if err != nil {
+			return err
+		}
+		rand.Shuffle(len(stored), func(i, j int) { stored[i], stored[j] = stored[j], stored[i] })
+		symbols = stored[:min(sample, len(stored))]
+	}
+
+	var checked, unavailable, days, mismatches int
+	for _, symbol := range symbols {
+		closes, err := storedCloses(db, symbol, from, to)
+		if err != nil {
+			return err
+		}
+		points, err := fetchTimeseries(symbol)
+		if err != nil {
+			slog.Warn("Timeseries unavailable, skipping", "symbol", symbol, "error", err)
+			unavailable++
+			continue
+		}
+
+		checked++
+		compared := 0
+		for _, p := range points {
+			stored, ok := closes[p.date]
+			if !ok {
+				continue
+			}
+			compared++
+			if math.Abs(stored-p.close) > tolerance {
+				fmt.Printf("%s\t%s\tchanged\tclose: %v -> %v\n", p.date, symbol, stored, p.close)
+				mismatches++
+			}
+		}
+		days += compared
+		slog.Debug("Cross-checked symbol", "symbol", symbol, "storedDays", len(closes), "compared", compared)
+	}
+
+	slog.Info("Cross-check completed", "symbolsChecked", checked, "symbolsUnavailable", unavailable, "daysCompared", days, "mismatches", mismatches)
+	if mismatches > 0 {
+		return fmt.Errorf("found %d closes differing from the timeseries", mismatches)
+	}
+	return nil
+}
+
+// storedSymbols lists the symbols traded between from and to
+func storedSymbols(db *sql.DB, from, to string) ([]string, error) {
+	rows, err := db.Query(`SELECT DISTINCT symbol FROM market_data
+		WHERE date >= ? AND date <= ? AND volume > 0 AND symbol IS NOT NULL ORDER BY symbol`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list symbols: %w", err)
+	}
+	defer rows.Close()
+	var symbols []string
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			return nil, fmt.Errorf("failed to list symbols: %w", err)
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols, rows.Err()
+}
+
+// storedCloses maps the days a symbol traded between from and to to its
+// close. Untraded days repeat the previous close in the summary files and
+// are left out.
+func storedCloses(db *sql.DB, symbol, from, to string) (map[string]float64, error) {
+	rows, err := db.Query(`SELECT date, close FROM market_data
+		WHERE symbol = ? AND date >= ? AND date <= ? AND volume > 0 AND close IS NOT NULL`, symbol, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query closes of %s: %w", symbol, err)
+	}
+	defer rows.Close()
+	closes := map[string]float64{}
+	for rows.Next() {
+		var date string
+		var close float64
+		if err := rows.Scan(&date, &close); err != nil {
+			return nil, fmt.Errorf("failed to read closes of %s: %w", symbol, err)
+		}
+		closes[date] = close
+	}
+	return closes, rows.Err()
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schema holds the statements creating every table used by the tool
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS market_data (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT,
+		symbol TEXT,
+		code TEXT,
+		company_name TEXT,
+		open REAL,
+		high REAL,
+		low REAL,
+		close REAL,
+		volume INTEGER,
+		previous_close REAL,
+		UNIQUE(date, symbol)
+	);`,
+	`CREATE TABLE IF NOT EXISTS ingest_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		filename TEXT,
+		records INTEGER NOT NULL,
+		errors INTEGER NOT NULL,
+		inserted INTEGER NOT NULL DEFAULT 0,
+		updated INTEGER NOT NULL DEFAULT 0,
+		unchanged INTEGER NOT NULL DEFAULT 0,
+		row_count INTEGER NOT NULL,
+		checksum TEXT NOT NULL,
+		started_at TEXT NOT NULL,
+		finished_at TEXT NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS ingest_log_date ON ingest_log(date);`,
+	`CREATE TABLE IF NOT EXISTS run_metrics (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		started_at TEXT NOT NULL,
+		duration_ms INTEGER NOT NULL,
+		download_bytes INTEGER NOT NULL,
+		download_ms INTEGER NOT NULL,
+		parse_ms INTEGER NOT NULL,
+		store_ms INTEGER NOT NULL DEFAULT 0,
+		records INTEGER NOT NULL,
+		errors INTEGER NOT NULL,
+		rows_per_second REAL NOT NULL,
+		error_rate REAL NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT,
+		new_symbols TEXT NOT NULL DEFAULT '',
+		missing_symbols TEXT NOT NULL DEFAULT ''
+	);`,
+	`CREATE TABLE IF NOT EXISTS holidays (
+		date TEXT PRIMARY KEY,
+		description TEXT NOT NULL,
+		source TEXT NOT NULL,
+		added_at TEXT NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS corporate_actions (
+		symbol TEXT NOT NULL,
+		ex_date TEXT NOT NULL,
+		type TEXT NOT NULL,
+		value REAL NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		added_at TEXT NOT NULL,
+		PRIMARY KEY (symbol, ex_date, type)
+	);`,
+	`CREATE TABLE IF NOT EXISTS market_data_extra (
+		date TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		name TEXT NOT NULL,
+		value,
+		PRIMARY KEY (date, symbol, name)
+	);`,
+	`CREATE TABLE IF NOT EXISTS raw_rows (
+		date TEXT NOT NULL,
+		line INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		PRIMARY KEY (date, line)
+	);`,
+	`CREATE TABLE IF NOT EXISTS companies (
+		symbol TEXT PRIMARY KEY,
+		code TEXT,
+		company_name TEXT,
+		first_seen TEXT NOT NULL,
+		last_seen TEXT NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS weekly_bars (
+		symbol TEXT NOT NULL,
+		period_start TEXT NOT NULL,
+		period_end TEXT NOT NULL,
+		first_date TEXT NOT NULL,
+		last_date TEXT NOT NULL,
+		open REAL,
+		high REAL,
+		low REAL,
+		close REAL,
+		volume INTEGER,
+		days INTEGER NOT NULL,
+		PRIMARY KEY (symbol, period_start)
+	);`,
+	`CREATE TABLE IF NOT EXISTS monthly_bars (
+		symbol TEXT NOT NULL,
+		period_start TEXT NOT NULL,
+		period_end TEXT NOT NULL,
+		first_date TEXT NOT NULL,
+		last_date TEXT NOT NULL,
+		open REAL,
+		high REAL,
+		low REAL,
+		close REAL,
+		volume INTEGER,
+		days INTEGER NOT NULL,
+		PRIMARY KEY (symbol, period_start)
+	);`,
+	`CREATE TABLE IF NOT EXISTS latest_prices (
+		symbol TEXT PRIMARY KEY,
+		date TEXT NOT NULL,
+		code TEXT,
+		company_name TEXT,
+		open REAL,
+		high REAL,
+		low REAL,
+		close REAL,
+		volume INTEGER,
+		previous_close REAL,
+		change REAL,
+		change_percent REAL,
+		updated_at TEXT NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS daily_returns (
+		date TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		close REAL NOT NULL,
+		previous_close REAL NOT NULL,
+		pct_return REAL NOT NULL,
+		log_return REAL NOT NULL,
+		PRIMARY KEY (symbol, date)
+	);`,
+	`CREATE INDEX IF NOT EXISTS daily_returns_date ON daily_returns(date);`,
+	`CREATE TABLE IF NOT EXISTS index_data (
+		date TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		open REAL,
+		close REAL,
+		volume INTEGER,
+		previous_close REAL,
+		PRIMARY KEY (symbol, date)
+	);`,
+	`CREATE TABLE IF NOT EXISTS indicators (
+		date TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		name TEXT NOT NULL,
+		value REAL NOT NULL,
+		PRIMARY KEY (symbol, name, date)
+	);`,
+	`CREATE INDEX IF NOT EXISTS indicators_date ON indicators(date);`,
+	`CREATE TABLE IF NOT EXISTS anomalies (
+		date TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		close REAL NOT NULL,
+		previous_close REAL NOT NULL,
+		change_percent REAL NOT NULL,
+		zscore REAL,
+		reason TEXT NOT NULL,
+		detected_at TEXT NOT NULL,
+		PRIMARY KEY (date, symbol)
+	);`,
+	`CREATE TABLE IF NOT EXISTS exchange_rates (
+		date TEXT NOT NULL,
+		currency TEXT NOT NULL,
+		rate REAL NOT NULL,
+		source TEXT NOT NULL,
+		fetched_at TEXT NOT NULL,
+		PRIMARY KEY (currency, date)
+	);`,
+	`CREATE TABLE IF NOT EXISTS rates (
+		date TEXT NOT NULL,
+		name TEXT NOT NULL,
+		value REAL NOT NULL,
+		source TEXT NOT NULL,
+		fetched_at TEXT NOT NULL,
+		PRIMARY KEY (name, date)
+	);`,
+	`CREATE TABLE IF NOT EXISTS fund_navs (
+		date TEXT NOT NULL,
+		fund TEXT NOT NULL,
+		amc TEXT,
+		category TEXT,
+		nav REAL NOT NULL,
+		offer_price REAL,
+		repurchase_price REAL,
+		source TEXT NOT NULL,
+		fetched_at TEXT NOT NULL,
+		PRIMARY KEY (fund, date)
+	);`,
+	`CREATE TABLE IF NOT EXISTS fund_premiums (
+		date TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		type TEXT NOT NULL,
+		close REAL NOT NULL,
+		nav REAL NOT NULL,
+		nav_date TEXT NOT NULL,
+		premium_percent REAL NOT NULL,
+		PRIMARY KEY (symbol, date)
+	);`,
+	`CREATE TABLE IF NOT EXISTS symbol_stats (
+		symbol TEXT PRIMARY KEY,
+		first_date TEXT NOT NULL,
+		last_date TEXT NOT NULL,
+		days INTEGER NOT NULL,
+		high_52w REAL,
+		low_52w REAL,
+		updated_at TEXT NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS retry_queue (
+		date TEXT PRIMARY KEY,
+		attempts INTEGER NOT NULL,
+		last_error TEXT NOT NULL,
+		first_failed_at TEXT NOT NULL,
+		last_attempt_at TEXT NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		name TEXT PRIMARY KEY,
+		key_hash TEXT NOT NULL UNIQUE,
+		created_at TEXT NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS portfolios (
+		name TEXT PRIMARY KEY,
+		cash REAL NOT NULL DEFAULT 0,
+		created_at TEXT NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS portfolio_holdings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		portfolio TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		buy_date TEXT NOT NULL,
+		buy_price REAL NOT NULL,
+		quantity INTEGER NOT NULL,
+		created_at TEXT NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS portfolio_holdings_portfolio ON portfolio_holdings(portfolio);`,
+	`CREATE TABLE IF NOT EXISTS index_constituents (
+		index_name TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		start_date TEXT NOT NULL,
+		end_date TEXT,
+		source TEXT NOT NULL,
+		PRIMARY KEY (index_name, symbol, start_date)
+	);`,
+	`CREATE TABLE IF NOT EXISTS sectors (
+		code TEXT PRIMARY KEY,
+		name TEXT NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS symbol_sectors (
+		symbol TEXT NOT NULL,
+		sector TEXT NOT NULL,
+		start_date TEXT NOT NULL,
+		end_date TEXT,
+		source TEXT NOT NULL,
+		PRIMARY KEY (symbol, start_date)
+	);`,
+	`CREATE TABLE IF NOT EXISTS isins (
+		isin TEXT PRIMARY KEY,
+		symbol TEXT NOT NULL,
+		name TEXT,
+		source TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS isins_symbol ON isins(symbol);`,
+}
+
+// addedColumns lists columns added to existing tables after they were first
+// released, so databases created by older versions are upgraded in place
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{"ingest_log", "inserted", "INTEGER NOT NULL DEFAULT 0"},
+	{"ingest_log", "updated", "INTEGER NOT NULL DEFAULT 0"},
+	{"ingest_log", "unchanged", "INTEGER NOT NULL DEFAULT 0"},
+	{"market_data", "extra_fields", "TEXT"},
+	{"market_data", "created_at", "TEXT"},
+	{"market_data", "updated_at", "TEXT"},
+	{"run_metrics", "new_symbols", "TEXT NOT NULL DEFAULT ''"},
+	{"run_metrics", "missing_symbols", "TEXT NOT NULL DEFAULT ''"},
+	{"run_metrics", "store_ms", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// addMissingColumns upgrades tables created before addedColumns existed
+func addMissingColumns(ctx context.Context, db *sql.DB) error {
+	for _, c := range addedColumns {
+		var exists bool
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?", c.table, c.column).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", c.table, err)
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	return nil
+}
+
+// Connection retry policy used by openDatabase, configurable through flags
+var (
+	dbConnectAttempts = 5
+	dbConnectBackoff  = time.Second
+)
+
+// sqliteOptions are the connection options passed to SQLite in the DSN
+type sqliteOptions struct {
+	busyTimeout time.Duration
+	cacheSizeKB int
+	foreignKeys bool
+	journalMode string
+	immutable   bool
+}
+
+// sqliteConfig holds the options configured through flags
+var sqliteConfig = sqliteOptions{busyTimeout: 5 * time.Second}
+
+// sqliteDSN builds the go-sqlite3 connection string for dbPath. Read-only
+// connections additionally honour the immutable option.
+func sqliteDSN(dbPath string, opts sqliteOptions, readOnly bool) string {
+	params := url.Values{}
+	params.Set("_busy_timeout", strconv.FormatInt(opts.busyTimeout.Milliseconds(), 10))
+	if opts.cacheSizeKB > 0 {
+		// Negative values are interpreted by SQLite as KiB rather than pages
+		params.Set("_cache_size", strconv.Itoa(-opts.cacheSizeKB))
+	}
+	if opts.foreignKeys {
+		params.Set("_foreign_keys", "1")
+	}
+	if opts.journalMode != "" {
+		params.Set("_journal_mode", opts.journalMode)
+	}
+	if readOnly {
+		params.Set("mode", "ro")
+		if opts.immutable {
+			params.Set("immutable", "1")
+		}
+	}
+
+	path := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(dbPath)
+	return "file:" + path + "?" + params.Encode()
+}
+
+// dbMaxConnectBackoff caps the delay between connection attempts
+const dbMaxConnectBackoff = 30 * time.Second
+
+// openDatabase connects to the database, retrying with exponential backoff
+// when the connection cannot be verified, and makes sure the schema exists
+func openDatabase(dbPath string) (*sql.DB, error) {
+	backoff := dbConnectBackoff
+	for attempt := 1; ; attempt++ {
+		db, err := connectDatabase(dbPath)
+		if err == nil {
+			return db, nil
+		}
+		// Configuration problems won't fix themselves, don't wait on them
+		if errors.Is(err, errNoSQLCipher) || errors.Is(err, errWrongKey) {
+			return nil, err
+		}
+		if attempt >= dbConnectAttempts {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		slog.Warn("Database connection failed, retrying", "db", dbPath, "attempt", attempt, "retryIn", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, dbMaxConnectBackoff)
+	}
+}
+
+// connectDatabase makes a single attempt at opening and pinging the
+// database, then creates the schema
+func connectDatabase(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open(sqliteDriverName, sqliteDSN(dbPath, sqliteConfig, false))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// Create tables if they don't exist
+	for _, stmt := range schema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create table: %w", err)
+		}
+	}
+	if err := addMissingColumns(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := createIndexes(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := seedLatestPrices(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// openReadOnlyDatabase opens an existing database without write access or
+// schema changes, for processes that only query the data
+func openReadOnlyDatabase(dbPath string) (*sql.DB, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db, err := sql.Open(sqliteDriverName, sqliteDSN(dbPath, sqliteConfig, true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return db, nil
+}
+
+// latestIngestedDate returns the most recent date present in market_data
+func latestIngestedDate(db *sql.DB) (time.Time, error) {
+	var latest sql.NullString
+	if err := db.QueryRow("SELECT MAX(date) FROM market_data").Scan(&latest); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query latest date: %w", err)
+	}
+	if !latest.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", latest.String)
+}
+
+// ingestedDates returns the dates between from and to inclusive that have
+// rows in market_data
+func ingestedDates(db *sql.DB, from, to string) (map[string]bool, error) {
+	rows, err := db.Query("SELECT DISTINCT date FROM market_data WHERE date >= ? AND date <= ?", from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ingested dates: %w", err)
+	}
+	defer rows.Close()
+
+	dates := make(map[string]bool)
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			return nil, fmt.Errorf("failed to read ingested date: %w", err)
+		}
+		dates[date] = true
+	}
+	return dates, rows.Err()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// runDBCommand dispatches the "db" subcommands
+func runDBCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing db command, expected maintain, backup, restore, dedupe or aggregate")
+	}
+
+	switch args[0] {
+	case "maintain":
+		return maintainDatabase(dbPath, args[1:])
+	case "backup":
+		return backupDatabase(dbPath, args[1:])
+	case "restore":
+		return restoreDatabase(dbPath, args[1:])
+	case "dedupe":
+		return dedupeDatabase(dbPath, args[1:])
+	case "aggregate":
+		return aggregateDatabase(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown db command %q, expected maintain, backup, restore, dedupe or aggregate", args[0])
+	}
+}
+
+// maintainDatabase checks the database for corruption, refreshes the query
+// planner statistics and rebuilds the file to reclaim free pages
+func maintainDatabase(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("db maintain", flag.ContinueOnError)
+	skipVacuum := fs.Bool("skip-vacuum", false, "Skip VACUUM, which rewrites the whole file and needs as much free disk space")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	paths, err := databaseFiles(dbPath)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := maintainFile(path, *skipVacuum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maintainFile runs the maintenance steps on a single database file
+func maintainFile(dbPath string, skipVacuum bool) error {
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sizeBefore := fileSize(dbPath)
+
+	slog.Info("Running integrity check", "step", "1/3", "db", dbPath)
+	start := time.Now()
+	problems, err := integrityCheck(db)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			slog.Error("Integrity check problem", "problem", p)
+		}
+		return fmt.Errorf("integrity check found %d problems, restore from a backup before maintaining", len(problems))
+	}
+	slog.Info("Integrity check passed", "step", "1/3", "elapsed", time.Since(start))
+
+	slog.Info("Running ANALYZE", "step", "2/3")
+	start = time.Now()
+	if _, err := db.Exec("ANALYZE"); err != nil {
+		return fmt.Errorf("failed to analyze database: %w", err)
+	}
+	slog.Info("ANALYZE completed", "step", "2/3", "elapsed", time.Since(start))
+
+	if skipVacuum {
+		slog.Info("Skipping VACUUM", "step", "3/3")
+	} else {
+		slog.Info("Running VACUUM, this rewrites the whole database", "step", "3/3", "size", sizeBefore)
+		start = time.Now()
+		if _, err := db.Exec("VACUUM"); err != nil {
+			return fmt.Errorf("failed to vacuum database: %w", err)
+		}
+		slog.Info("VACUUM completed", "step", "3/3", "elapsed", time.Since(start), "sizeBefore", sizeBefore, "sizeAfter", fileSize(dbPath))
+	}
+
+	slog.Info("Database maintenance completed", "db", dbPath)
+	return nil
+}
+
+// integrityCheck runs PRAGMA integrity_check and returns the problems it reports
+func integrityCheck(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return nil, fmt.Errorf("failed to read integrity check result: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read integrity check result: %w", err)
+	}
+	return problems, nil
+}
+
+// fileSize returns the size of a file in bytes, or 0 if it cannot be read
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
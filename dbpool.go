@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// dbHealthInterval is how long a shared handle is handed out before it is
+// checked again
+const dbHealthInterval = time.Minute
+
+// sharedDB is a database handle kept open for the life of the process
+type sharedDB struct {
+	db      *sql.DB
+	file    os.FileInfo
+	checked time.Time
+}
+
+// sharedQuery is the union handle of a sharded database, with the files it
+// attached
+type sharedQuery struct {
+	db      *sql.DB
+	files   map[string]os.FileInfo
+	checked time.Time
+}
+
+var (
+	sharedDBsMu   sync.Mutex
+	sharedDBs     = map[string]*sharedDB{}
+	sharedQueries = map[string]*sharedQuery{}
+)
+
+// sharedDatabase returns the process wide handle of a database file, opening
+// it on first use. Callers must not close it. A handle that fails its health
+// check, or whose file was replaced as by a restore, is reopened.
+func sharedDatabase(path string) (*sql.DB, error) {
+	sharedDBsMu.Lock()
+	defer sharedDBsMu.Unlock()
+
+	if h, ok := sharedDBs[path]; ok {
+		if time.Since(h.checked) < dbHealthInterval {
+			return h.db, nil
+		}
+		err := h.check(path)
+		if err == nil {
+			h.checked = time.Now()
+			return h.db, nil
+		}
+		slog.Warn("Reopening database", "db", path, "error", err)
+		h.db.Close()
+		delete(sharedDBs, path)
+	}
+
+	db, err := openDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Stat(path)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to inspect database: %w", err)
+	}
+	sharedDBs[path] = &sharedDB{db: db, file: file, checked: time.Now()}
+	return db, nil
+}
+
+// check pings the database and makes sure the path still names the file the
+// handle has open
+func (h *sharedDB) check(path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.db.PingContext(ctx); err != nil {
+		return err
+	}
+	file, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(file, h.file) {
+		return errors.New("database file was replaced")
+	}
+	return nil
+}
+
+// sharedQueryDatabase returns the process wide handle for queries across the
+// database, the shared handle of the file itself or, when sharding, the union
+// of openQueryDatabase. Callers must not close it. The union is rebuilt once
+// shards are added or replaced, or its connection fails.
+func sharedQueryDatabase(dbPath string) (*sql.DB, error) {
+	if !shardByYear {
+		return sharedDatabase(dbPath)
+	}
+	files, err := queryFiles(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedDBsMu.Lock()
+	defer sharedDBsMu.Unlock()
+	if q, ok := sharedQueries[dbPath]; ok {
+		if sameFiles(q.files, files) {
+			if time.Since(q.checked) < dbHealthInterval {
+				return q.db, nil
+			}
+			if err := q.db.Ping(); err == nil {
+				q.checked = time.Now()
+				return q.db, nil
+			}
+		}
+		q.db.Close()
+		delete(sharedQueries, dbPath)
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	sharedQueries[dbPath] = &sharedQuery{db: db, files: files, checked: time.Now()}
+	return db, nil
+}
+
+// queryFiles stats the files openQueryDatabase attaches
+func queryFiles(dbPath string) (map[string]os.FileInfo, error) {
+	paths, err := databaseFiles(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]os.FileInfo, len(paths)+1)
+	for _, path := range append(paths, dbPath) {
+		file, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) && path == dbPath {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect database: %w", err)
+		}
+		files[path] = file
+	}
+	return files, nil
+}
+
+func sameFiles(a, b map[string]os.FileInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, file := range a {
+		if other, ok := b[path]; !ok || !os.SameFile(file, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// closeSharedDatabases closes every shared handle at exit
+func closeSharedDatabases() {
+	sharedDBsMu.Lock()
+	defer sharedDBsMu.Unlock()
+	for path, h := range sharedDBs {
+		if err := h.db.Close(); err != nil {
+			slog.Warn("Failed to close database", "db", path, "error", err)
+		}
+		delete(sharedDBs, path)
+	}
+	for path, q := range sharedQueries {
+		q.db.Close()
+		delete(sharedQueries, path)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// dedupeDatabase resolves symbols stored under untrimmed variants and removes
+// stray rows that cannot belong to any ingest
+func dedupeDatabase(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("db dedupe", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Print what would change without modifying the database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	paths, err := databaseFiles(dbPath)
+	if err != nil {
+		return err
+	}
+	total := 0
+	for _, path := range paths {
+		n, err := dedupeFile(path, *dryRun)
+		if err != nil {
+			return err
+		}
+		total += n
+	}
+
+	if *dryRun {
+		slog.Info("Dry run completed, nothing was changed", "changes", total)
+	} else {
+		slog.Info("Deduplication completed", "changes", total)
+	}
+	return nil
+}
+
+// dedupeFile cleans a single database file inside one transaction, rolled
+// back on a dry run. Every change is printed as a tab separated
+// "action date symbol detail" line.
+func dedupeFile(path string, dryRun bool) (int, error) {
+	db, err := openDatabase(path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	changes := 0
+	report := func(action, date, symbol, detail string) {
+		fmt.Printf("%s\t%s\t%q\t%s\n", action, date, symbol, detail)
+		changes++
+	}
+
+	// Stray rows: no symbol or a date no ingest could have written
+	rows, err := tx.Query(`SELECT id, COALESCE(date, ''), COALESCE(symbol, '') FROM market_data
+		WHERE symbol IS NULL OR TRIM(symbol) = '' OR date IS NULL OR date NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]'`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look for stray rows: %w", err)
+	}
+	var stray []int64
+	for rows.Next() {
+		var id int64
+		var date, symbol string
+		if err := rows.Scan(&id, &date, &symbol); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read stray rows: %w", err)
+		}
+		stray = append(stray, id)
+		report("delete", date, symbol, "stray row without a valid date or symbol")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read stray rows: %w", err)
+	}
+	for _, id := range stray {
+		if _, err := tx.Exec("DELETE FROM market_data WHERE id = ?", id); err != nil {
+			return 0, fmt.Errorf("failed to delete stray row: %w", err)
+		}
+	}
+
+	// Untrimmed variants: dropped when the trimmed symbol exists for the day,
+	// renamed otherwise
+	type variant struct {
+		id           int64
+		date, symbol string
+	}
+	rows, err = tx.Query("SELECT id, date, symbol FROM market_data WHERE symbol <> TRIM(symbol) ORDER BY date, id")
+	if err != nil {
+		return 0, fmt.Errorf("failed to look for symbol variants: %w", err)
+	}
+	var variants []variant
+	for rows.Next() {
+		var v variant
+		if err := rows.Scan(&v.id, &v.date, &v.symbol); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read symbol variants: %w", err)
+		}
+		variants = append(variants, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read symbol variants: %w", err)
+	}
+
+	var renamedDates []string
+	for _, v := range variants {
+		trimmed := strings.TrimSpace(v.symbol)
+		// Checked per row as an earlier variant of the day may have been renamed
+		var exists bool
+		if err := tx.QueryRow("SELECT COUNT(*) > 0 FROM market_data WHERE date = ? AND symbol = ?", v.date, trimmed).Scan(&exists); err != nil {
+			return 0, fmt.Errorf("failed to look up %s: %w", trimmed, err)
+		}
+		if exists {
+			report("delete", v.date, v.symbol, "duplicate of "+trimmed)
+			if _, err := tx.Exec("DELETE FROM market_data WHERE id = ?", v.id); err != nil {
+				return 0, fmt.Errorf("failed to delete duplicate row: %w", err)
+			}
+			continue
+		}
+		report("rename", v.date, v.symbol, "to "+trimmed)
+		if _, err := tx.Exec("UPDATE market_data SET symbol = ? WHERE id = ?", trimmed, v.id); err != nil {
+			return 0, fmt.Errorf("failed to rename symbol: %w", err)
+		}
+		// The extra columns of the row follow it
+		_, err := tx.Exec("UPDATE OR REPLACE market_data_extra SET symbol = ? WHERE date = ? AND symbol = ?", trimmed, v.date, v.symbol)
+		if err != nil {
+			return 0, fmt.Errorf("failed to rename extra columns: %w", err)
+		}
+		if len(renamedDates) == 0 || renamedDates[len(renamedDates)-1] != v.date {
+			renamedDates = append(renamedDates, v.date)
+		}
+	}
+
+	if changes > 0 {
+		if err := dedupeDerived(tx, renamedDates); err != nil {
+			return 0, err
+		}
+	}
+
+	if changes == 0 || dryRun {
+		return changes, nil
+	}
+
+	// Keep the ingest log checksums in line with the cleaned rows
+	if err := refreshIngestChecksums(tx); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return changes, nil
+}
+
+// dedupeDerived applies the deletes and renames to the tables keyed by the
+// symbols of market_data: rows of symbols or days no longer stored are
+// removed, and the companies, latest prices and returns of the dates with
+// renamed symbols are recorded again
+func dedupeDerived(tx *sql.Tx, renamedDates []string) error {
+	orphans := map[string]string{
+		"market_data_extra": "m.date = market_data_extra.date AND m.symbol = market_data_extra.symbol",
+		"daily_returns":     "m.date = daily_returns.date AND m.symbol = daily_returns.symbol",
+		"companies":         "m.symbol = companies.symbol",
+		"latest_prices":     "m.symbol = latest_prices.symbol",
+	}
+	for table, match := range orphans {
+		_, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE NOT EXISTS (SELECT 1 FROM market_data m WHERE %s)", table, match))
+		if err != nil {
+			return fmt.Errorf("failed to clean up %s: %w", table, err)
+		}
+	}
+	for _, date := range renamedDates {
+		if err := updateCompanies(tx, date); err != nil {
+			return err
+		}
+		if err := updateLatestPrices(tx, date); err != nil {
+			return err
+		}
+		if err := updateDailyReturns(tx, date); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshIngestChecksums recomputes the row count and checksum of the latest
+// ingest log entry of every day after rows were changed outside an ingest
+func refreshIngestChecksums(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT MAX(id), date FROM ingest_log GROUP BY date")
+	if err != nil {
+		return fmt.Errorf("failed to read ingest log: %w", err)
+	}
+	type entry struct {
+		id   int64
+		date string
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.date); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read ingest log: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read ingest log: %w", err)
+	}
+
+	for _, e := range entries {
+		count, checksum, err := dayChecksum(tx, e.date)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE ingest_log SET row_count = ?, checksum = ? WHERE id = ?", count, checksum, e.id)
+		if err != nil {
+			return fmt.Errorf("failed to update ingest log: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// remoteSchemes are the destinations outputs can be delivered to besides
+// local files
+var remoteSchemes = map[string]func(u *url.URL, data []byte, contentType string) error{
+	"s3":   uploadS3,
+	"gs":   uploadGCS,
+	"az":   uploadAzure,
+	"sftp": uploadSFTP,
+	"ftp":  uploadFTP,
+	"ftps": uploadFTP,
+}
+
+// isRemoteURL reports whether dest names a remote destination
+func isRemoteURL(dest string) bool {
+	scheme, _, ok := strings.Cut(dest, "://")
+	return ok && remoteSchemes[scheme] != nil
+}
+
+// uploadRemote delivers data to a remote destination URL
+func uploadRemote(dest string, data []byte, contentType string) error {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return fmt.Errorf("invalid destination %q: %w", dest, err)
+	}
+	upload := remoteSchemes[u.Scheme]
+	if upload == nil {
+		return fmt.Errorf("unsupported destination scheme %q", u.Scheme)
+	}
+	if err := upload(u, data, contentType); err != nil {
+		return fmt.Errorf("failed to upload to %s: %w", u.Redacted(), err)
+	}
+	return nil
+}
+
+// writeOutput runs write against dest, which is - for stdout, a local path or
+// a remote URL. Remote outputs are buffered and uploaded in one go, so a
+// failed write never leaves a partial file behind.
+func writeOutput(dest, contentType string, write func(w io.Writer) error) error {
+	switch {
+	case dest == "-" || dest == "":
+		return write(os.Stdout)
+	case isRemoteURL(dest):
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			return err
+		}
+		return uploadRemote(dest, buf.Bytes(), contentType)
+	default:
+		f, err := os.Create(dest)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", dest, err)
+		}
+		defer f.Close()
+		if err := write(f); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write %s: %w", dest, err)
+		}
+		return nil
+	}
+}
+
+// expandDestination fills the date placeholders of a destination template:
+// {date} (2006-01-02), {year}, {month} and {day}
+func expandDestination(template string, date time.Time) string {
+	return strings.NewReplacer(
+		"{date}", date.Format("2006-01-02"),
+		"{year}", date.Format("2006"),
+		"{month}", date.Format("01"),
+		"{day}", date.Format("02"),
+	).Replace(template)
+}
+
+// displayDestination is dest with any password redacted
+func displayDestination(dest string) string {
+	if !isRemoteURL(dest) {
+		return dest
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return dest
+	}
+	return u.Redacted()
+}
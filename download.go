@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// downloadResumeAttempts is how many times an interrupted download is resumed
+// with a Range request before giving up
+var downloadResumeAttempts = 3
+
+// downloadFile fetches url into memory. When the body is cut off part way the
+// download continues from the last byte received instead of starting over,
+// provided the server honours Range requests.
+func downloadFile(url string) ([]byte, error) {
+	client := httpClient()
+	httpLog := moduleLogger("http")
+
+	var data []byte
+	for resumes := 0; ; resumes++ {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		if len(data) > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(data)))
+		}
+
+		httpLog.Debug("HTTP request", "method", http.MethodGet, "url", url, "timeout", client.Timeout, "range", req.Header.Get("Range"))
+		requestStart := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			httpLog.Debug("HTTP request failed", "url", url, "error", err, "elapsed", time.Since(requestStart))
+			if len(data) > 0 && resumes < downloadResumeAttempts {
+				httpLog.Warn("Failed to resume download, retrying", "url", url, "received", len(data), "error", err)
+				time.Sleep(time.Duration(resumes+1) * time.Second)
+				continue
+			}
+			return nil, fmt.Errorf("failed to download file: %w", err)
+		}
+		httpLog.Debug("HTTP response", "url", url, "status", resp.Status, "contentLength", resp.ContentLength,
+			"contentType", resp.Header.Get("Content-Type"), "elapsed", time.Since(requestStart))
+
+		switch {
+		case resp.StatusCode == http.StatusPartialContent && len(data) > 0:
+			if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != int64(len(data)) {
+				resp.Body.Close()
+				return nil, fmt.Errorf("server resumed at an unexpected offset: %q", resp.Header.Get("Content-Range"))
+			}
+		case resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html"):
+			page, _ := io.ReadAll(io.LimitReader(resp.Body, htmlPageLimit))
+			resp.Body.Close()
+			return nil, newHTMLPageError(page)
+		case resp.StatusCode == http.StatusOK:
+			// The server ignored the range, start from the beginning
+			data = data[:0]
+		case resp.StatusCode == http.StatusNotFound:
+			resp.Body.Close()
+			return nil, fmt.Errorf("download failed with status: %s: %w", resp.Status, errNotPublished)
+		default:
+			resp.Body.Close()
+			return nil, fmt.Errorf("download failed with status: %s", resp.Status)
+		}
+
+		data, err = appendBody(data, resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			return data, nil
+		}
+		if resumes >= downloadResumeAttempts || len(data) == 0 {
+			return nil, fmt.Errorf("failed to read response body: %w", err)
+		}
+		httpLog.Warn("Download interrupted, resuming", "url", url, "received", len(data), "error", err)
+	}
+}
+
+// appendBody reads r to the end, appending to data. What was read is returned
+// even on error so the download can be resumed from there.
+func appendBody(data []byte, r io.Reader) ([]byte, error) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return data, err
+		}
+	}
+}
+
+// contentRangeStart returns the first byte position of a Content-Range header
+// such as "bytes 1024-2047/4096"
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	return n, err == nil
+}
+
+// errNotPublished is returned when PSX has no market summary for a date,
+// which is the case on days the market was closed
+var errNotPublished = errors.New("no market summary published")
+
+// errMarketClosed marks an ingest that found no market summary on a day the
+// calendar has the market closed, which is expected rather than a failure
+var errMarketClosed = errors.New("market closed")
+
+// htmlPageLimit bounds how much of an HTML page is read to describe it
+const htmlPageLimit = 64 * 1024
+
+// htmlPageError is returned when PSX answers with an HTML page, such as its
+// not found or maintenance pages, where an archive was expected
+type htmlPageError struct {
+	title    string
+	notFound bool
+}
+
+func (e *htmlPageError) Error() string {
+	if e.notFound {
+		return fmt.Sprintf("PSX served a not found page (%q) instead of the archive", e.title)
+	}
+	return fmt.Sprintf("PSX served an HTML page (%q) instead of the archive, the site may be under maintenance", e.title)
+}
+
+// Unwrap makes not found pages match errNotPublished
+func (e *htmlPageError) Unwrap() error {
+	if e.notFound {
+		return errNotPublished
+	}
+	return nil
+}
+
+// notFoundPhrases mark the titles of HTML pages that say the file does not
+// exist. Only titles are matched, the body of a proxy or maintenance page may
+// mention anything and must not pass for a missing file, which reads as a
+// holiday.
+var notFoundPhrases = []string{"not found", "does not exist", "no record"}
+
+// titlePattern finds the title of an HTML page, in any case
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// newHTMLPageError describes page by its title and whether that says the
+// requested file does not exist
+func newHTMLPageError(page []byte) error {
+	title := ""
+	if m := titlePattern.FindSubmatch(page); m != nil {
+		title = strings.TrimSpace(html.UnescapeString(string(m[1])))
+	}
+	e := &htmlPageError{title: title}
+	for _, phrase := range notFoundPhrases {
+		if strings.Contains(strings.ToLower(title), phrase) {
+			e.notFound = true
+			break
+		}
+	}
+	return e
+}
+
+// isHTML reports whether data is an HTML document, for pages served with a
+// content type other than text/html
+func isHTML(data []byte) bool {
+	start := strings.ToLower(string(bytes.TrimLeft(data[:min(len(data), 512)], " \t\r\n\ufeff")))
+	return strings.HasPrefix(start, "<!doctype html") || strings.HasPrefix(start, "<html") || strings.HasPrefix(start, "<head")
+}
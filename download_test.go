@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestContentRangeStart(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+		ok     bool
+	}{
+		{"bytes 1024-2047/4096", 1024, true},
+		{"bytes 0-99/100", 0, true},
+		{"bytes 1024-2047/*", 1024, true},
+		{"bytes 5000000000-5000000099/5000000100", 5000000000, true},
+		{"", 0, false},
+		{"bytes */4096", 0, false},
+		{"bytes 1024/4096", 0, false},
+		{"bytes -1024/4096", 0, false},
+		{"bytes x-2047/4096", 0, false},
+		{"items 1024-2047/4096", 0, false},
+		{"1024-2047/4096", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := contentRangeStart(tt.header)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("contentRangeStart(%q) = %d, %v, want %d, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
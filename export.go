@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportOptions selects the rows of an export and how they are laid out
+type exportOptions struct {
+	from, to string
+	symbols  []string
+	// by groups the rows per "symbol" or per "date" for formats with sheets
+	by string
+	// currency converts prices at the stored exchange rates, empty keeps
+	// rupees
+	currency string
+}
+
+// exportRow is a market_data row as written by the exporters
+type exportRow struct {
+	date          string
+	symbol        string
+	code          string
+	companyName   string
+	open          float64
+	high          float64
+	low           float64
+	close         float64
+	volume        int64
+	previousClose float64
+}
+
+// exporter writes the rows in one file format
+type exporter func(w io.Writer, rows []exportRow, opts exportOptions) error
+
+// exportFormat is a registered exporter with the media type of its files
+type exportFormat struct {
+	contentType string
+	write       exporter
+}
+
+var exporters = map[string]exportFormat{}
+
+// registerExporter makes a file format available to the export command
+func registerExporter(format, contentType string, fn exporter) {
+	exporters[format] = exportFormat{contentType: contentType, write: fn}
+}
+
+// exportFormats lists the registered formats for error messages
+func exportFormats() string {
+	formats := make([]string, 0, len(exporters))
+	for format := range exporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return strings.Join(formats, ", ")
+}
+
+// runExportCommand writes the selected rows to a file in the given format
+func runExportCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing export format, expected one of %s", exportFormats())
+	}
+	format := args[0]
+	if _, ok := exporters[format]; !ok {
+		return fmt.Errorf("unknown export format %q, expected one of %s", format, exportFormats())
+	}
+
+	fs := flag.NewFlagSet("export "+format, flag.ContinueOnError)
+	out := fs.String("out", "", "File or s3://, gs://, az://, sftp:// or ftp:// URL to write, - for stdout. {date}, {year}, {month} and {day} are filled from -to, or today.")
+	from := fs.String("from", "", "First date to export (YYYY-MM-DD)")
+	to := fs.String("to", "", "Last date to export (YYYY-MM-DD)")
+	symbols := fs.String("symbols", "", "Comma separated symbols to export, all when empty")
+	by := fs.String("by", "symbol", "Group rows per symbol or per date, for formats with sheets")
+	currency := fs.String("currency", "", "Convert prices from PKR at the stored exchange rates, e.g. USD")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("missing -out file")
+	}
+	if *by != "symbol" && *by != "date" {
+		return fmt.Errorf("invalid -by %q, expected symbol or date", *by)
+	}
+
+	code, err := parseCurrency(*currency)
+	if err != nil {
+		return err
+	}
+
+	opts := exportOptions{from: *from, to: *to, by: *by, symbols: parseSymbolList(*symbols), currency: code}
+	date := time.Now()
+	if *to != "" {
+		if date, err = time.Parse("2006-01-02", *to); err != nil {
+			return fmt.Errorf("invalid -to date: %w", err)
+		}
+	}
+	return writeExport(dbPath, format, opts, expandDestination(*out, date))
+}
+
+// parseSymbolList splits a comma separated symbol list
+func parseSymbolList(list string) []string {
+	var symbols []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			symbols = append(symbols, strings.ToUpper(s))
+		}
+	}
+	return symbols
+}
+
+// writeExport loads the selected rows and writes them to dest, which is a
+// local path, - for stdout, or a remote URL
+func writeExport(dbPath, format string, opts exportOptions, dest string) error {
+	export := exporters[format]
+
+	st, err := openStore(dbPath)
+	if err != nil {
+		return err
+	}
+	rows, err := st.queryRows(opts)
+	st.close()
+	if err != nil {
+		return err
+	}
+	if opts.currency != "" {
+		// Rates are only kept in SQLite, whatever the backend
+		db, err := openQueryDatabase(dbPath)
+		if err != nil {
+			return err
+		}
+		c, err := loadConverter(db, opts.currency)
+		db.Close()
+		if err != nil {
+			return err
+		}
+		if err := convertExportRows(c, rows); err != nil {
+			return err
+		}
+	}
+
+	err = writeOutput(dest, export.contentType, func(w io.Writer) error {
+		if err := export.write(w, rows, opts); err != nil {
+			return fmt.Errorf("failed to write %s export: %w", format, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Export completed", "format", format, "rows", len(rows), "out", displayDestination(dest))
+	return nil
+}
+
+// scheduledExport is an export written by the export module for every
+// ingested date, configured in the exports list of the config file
+type scheduledExport struct {
+	Format string `json:"format"`
+	// To is the destination with date placeholders, e.g.
+	// s3://bucket/psx/{year}/{date}.csv
+	To      string   `json:"to"`
+	Symbols []string `json:"symbols"`
+	By      string   `json:"by"`
+	// Currency converts prices from rupees, e.g. USD
+	Currency string `json:"currency"`
+}
+
+// scheduledExports are the exports from the config file
+var scheduledExports []scheduledExport
+
+func init() {
+	registerModule("export", runScheduledExports)
+	registerExporter("csv", "text/csv", writeCSV)
+}
+
+// applyExports validates and installs the exports of the config file
+func applyExports(list []scheduledExport) error {
+	for i, e := range list {
+		if _, ok := exporters[e.Format]; !ok {
+			return fmt.Errorf("export %d: unknown format %q, expected one of %s", i+1, e.Format, exportFormats())
+		}
+		if e.To == "" {
+			return fmt.Errorf("export %d: missing destination", i+1)
+		}
+		if e.By == "" {
+			list[i].By = "symbol"
+		} else if e.By != "symbol" && e.By != "date" {
+			return fmt.Errorf("export %d: invalid by %q, expected symbol or date", i+1, e.By)
+		}
+		code, err := parseCurrency(e.Currency)
+		if err != nil {
+			return fmt.Errorf("export %d: %w", i+1, err)
+		}
+		list[i].Currency = code
+	}
+	scheduledExports = list
+	return nil
+}
+
+// runScheduledExports writes the rows of the ingested date to every
+// configured destination
+func runScheduledExports(date time.Time, dbPath string) error {
+	day := date.Format("2006-01-02")
+	var errs []error
+	for _, e := range scheduledExports {
+		opts := exportOptions{from: day, to: day, by: e.By, symbols: parseSymbolList(strings.Join(e.Symbols, ",")), currency: e.Currency}
+		if err := writeExport(dbPath, e.Format, opts, expandDestination(e.To, date)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeCSV writes the rows with a header line, prices use the shortest
+// representation that round trips
+func writeCSV(w io.Writer, rows []exportRow, opts exportOptions) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "symbol", "code", "company_name", "open", "high", "low", "close", "volume", "previous_close"})
+	price := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, r := range rows {
+		cw.Write([]string{r.date, r.symbol, r.code, r.companyName, price(r.open), price(r.high),
+			price(r.low), price(r.close), strconv.FormatInt(r.volume, 10), price(r.previousClose)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// loadExportRows reads the selected market_data rows ordered by date and symbol
+func loadExportRows(db *sql.DB, opts exportOptions) ([]exportRow, error) {
+	query := `SELECT date, symbol, COALESCE(code, ''), COALESCE(company_name, ''), COALESCE(open, 0),
+		COALESCE(high, 0), COALESCE(low, 0), COALESCE(close, 0), COALESCE(volume, 0), COALESCE(previous_close, 0)
+		FROM market_data WHERE date IS NOT NULL AND symbol IS NOT NULL`
+	var args []any
+	if opts.from != "" {
+		query += " AND date >= ?"
+		args = append(args, opts.from)
+	}
+	if opts.to != "" {
+		query += " AND date <= ?"
+		args = append(args, opts.to)
+	}
+	if len(opts.symbols) > 0 {
+		query += " AND symbol IN (?" + strings.Repeat(", ?", len(opts.symbols)-1) + ")"
+		for _, s := range opts.symbols {
+			args = append(args, s)
+		}
+	}
+	query += " ORDER BY date, symbol"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query export rows: %w", err)
+	}
+	defer rows.Close()
+
+	var result []exportRow
+	for rows.Next() {
+		var r exportRow
+		err := rows.Scan(&r.date, &r.symbol, &r.code, &r.companyName, &r.open, &r.high, &r.low, &r.close, &r.volume, &r.previousClose)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read export row: %w", err)
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
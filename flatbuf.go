@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// A minimal FlatBuffers encoder, enough for the Arrow IPC metadata. Objects
+// are described as a tree and laid out front to back: every object is placed
+// before the objects it references, so all offsets point forward as the
+// format requires.
+
+// fbObject is a table, vector or string to encode
+type fbObject interface{}
+
+// fbTable is a table with its fields keyed by vtable slot
+type fbTable struct {
+	fields []fbField
+}
+
+// fbField is one table field, either an inline little endian scalar or a
+// reference to another object
+type fbField struct {
+	slot   int
+	scalar []byte
+	ref    fbObject
+}
+
+// fbStructs is a vector of inline scalars or structs of elemSize bytes
+type fbStructs struct {
+	data  []byte
+	count int
+	align int
+}
+
+// fbRefs is a vector of references to tables
+type fbRefs []fbObject
+
+// fbString is a string
+type fbString string
+
+func (t *fbTable) addScalar(slot int, v any) {
+	var b []byte
+	switch v := v.(type) {
+	case bool:
+		if v {
+			b = []byte{1}
+		} else {
+			b = []byte{0}
+		}
+	case uint8:
+		b = []byte{v}
+	case int16:
+		b = binary.LittleEndian.AppendUint16(nil, uint16(v))
+	case int32:
+		b = binary.LittleEndian.AppendUint32(nil, uint32(v))
+	case int64:
+		b = binary.LittleEndian.AppendUint64(nil, uint64(v))
+	default:
+		panic("unsupported flatbuffer scalar")
+	}
+	t.fields = append(t.fields, fbField{slot: slot, scalar: b})
+}
+
+func (t *fbTable) addRef(slot int, obj fbObject) {
+	t.fields = append(t.fields, fbField{slot: slot, ref: obj})
+}
+
+// fbBuilder accumulates the encoded buffer
+type fbBuilder struct {
+	buf []byte
+}
+
+// encodeFlatbuffer encodes root and returns the buffer padded to a multiple of 8 bytes
+func encodeFlatbuffer(root fbObject) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	pos := b.place(root)
+	binary.LittleEndian.PutUint32(b.buf[0:], uint32(pos))
+	b.pad(8)
+	return b.buf
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// patch points the offset stored at at to target
+func (b *fbBuilder) patch(at, target int) {
+	binary.LittleEndian.PutUint32(b.buf[at:], uint32(target-at))
+}
+
+// place writes obj followed by everything it references and returns its
+// position
+func (b *fbBuilder) place(obj fbObject) int {
+	switch o := obj.(type) {
+	case *fbTable:
+		return b.placeTable(o)
+	case fbString:
+		b.pad(4)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(o)))
+		b.buf = append(b.buf, o...)
+		b.buf = append(b.buf, 0)
+		return pos
+	case fbStructs:
+		// The elements, not the length prefix, carry the alignment
+		for (len(b.buf)+4)%max(o.align, 4) != 0 {
+			b.buf = append(b.buf, 0)
+		}
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(o.count))
+		b.buf = append(b.buf, o.data...)
+		return pos
+	case fbRefs:
+		b.pad(4)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(o)))
+		slots := len(b.buf)
+		b.buf = append(b.buf, make([]byte, 4*len(o))...)
+		for i, item := range o {
+			b.patch(slots+4*i, b.place(item))
+		}
+		return pos
+	}
+	panic("unsupported flatbuffer object")
+}
+
+func (b *fbBuilder) placeTable(t *fbTable) int {
+	// Lay the fields out largest first after the vtable offset, each aligned
+	// to its size; references are 4 byte offsets
+	fields := append([]fbField(nil), t.fields...)
+	size := func(f fbField) int {
+		if f.ref != nil {
+			return 4
+		}
+		return len(f.scalar)
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return size(fields[i]) > size(fields[j]) })
+
+	offsets := make([]int, len(fields))
+	end, slots := 4, 0
+	for i, f := range fields {
+		for end%size(f) != 0 {
+			end++
+		}
+		offsets[i] = end
+		end += size(f)
+		slots = max(slots, f.slot+1)
+	}
+
+	// The vtable goes first, the table follows 8 byte aligned
+	b.pad(2)
+	vtable := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(4+2*slots))
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(end))
+	entries := make([]uint16, slots)
+	for i, f := range fields {
+		entries[f.slot] = uint16(offsets[i])
+	}
+	for _, e := range entries {
+		b.buf = binary.LittleEndian.AppendUint16(b.buf, e)
+	}
+
+	b.pad(8)
+	pos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, end)...)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(int32(pos-vtable)))
+	for i, f := range fields {
+		if f.ref == nil {
+			copy(b.buf[pos+offsets[i]:], f.scalar)
+		}
+	}
+	for i, f := range fields {
+		if f.ref != nil {
+			b.patch(pos+offsets[i], b.place(f.ref))
+		}
+	}
+	return pos
+}
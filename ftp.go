@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ftpTimeout bounds every exchange with an FTP server
+const ftpTimeout = time.Minute
+
+// ftpSession is a logged in FTP control connection
+type ftpSession struct {
+	conn   net.Conn
+	text   *textproto.Conn
+	host   string
+	secure *tls.Config
+}
+
+// uploadFTP stores data at the path of an ftp:// or ftps:// URL, the latter
+// upgrading both connections with explicit TLS (AUTH TLS). Without a user in
+// the URL the login is anonymous. Like sftp the file is stored under a
+// temporary name and renamed once complete.
+func uploadFTP(u *url.URL, data []byte, contentType string) error {
+	target := strings.TrimPrefix(u.Path, "/")
+	if target == "" || strings.HasSuffix(target, "/") {
+		return errors.New("ftp destination needs a file name")
+	}
+
+	s, err := dialFTP(u)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	// Directories are created one level at a time, existing ones fail
+	// harmlessly
+	if dir := path.Dir(target); dir != "." {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			s.cmd(0, "MKD %s", strings.Join(parts[:i+1], "/"))
+		}
+	}
+
+	partial := target + ".part"
+	if err := s.store(partial, data); err != nil {
+		return err
+	}
+	if _, err := s.cmd(3, "RNFR %s", partial); err != nil {
+		return err
+	}
+	if _, err := s.cmd(2, "RNTO %s", target); err != nil {
+		// Some servers refuse to rename over an existing file
+		s.cmd(0, "DELE %s", target)
+		if _, err := s.cmd(3, "RNFR %s", partial); err != nil {
+			return err
+		}
+		if _, err := s.cmd(2, "RNTO %s", target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dialFTP connects and logs in, upgrading to TLS for ftps
+func dialFTP(u *url.URL) (*ftpSession, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "21")
+	}
+	conn, err := net.DialTimeout("tcp", addr, ftpTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	s := &ftpSession{conn: conn, text: textproto.NewConn(conn), host: u.Hostname()}
+	conn.SetDeadline(time.Now().Add(ftpTimeout))
+	if _, _, err := s.text.ReadResponse(2); err != nil {
+		s.close()
+		return nil, fmt.Errorf("ftp server refused the connection: %w", err)
+	}
+
+	if u.Scheme == "ftps" {
+		if _, err := s.cmd(2, "AUTH TLS"); err != nil {
+			s.close()
+			return nil, err
+		}
+		// Data connections resume this session, which many servers require
+		s.secure = &tls.Config{ServerName: s.host, ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+		tlsConn := tls.Client(conn, s.secure)
+		if err := tlsConn.Handshake(); err != nil {
+			s.close()
+			return nil, fmt.Errorf("ftp TLS handshake failed: %w", err)
+		}
+		s.conn, s.text = tlsConn, textproto.NewConn(tlsConn)
+	}
+
+	user, password := "anonymous", "anonymous"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	code, err := s.cmd(0, "USER %s", user)
+	if err == nil && code == 331 {
+		_, err = s.cmd(2, "PASS %s", password)
+	} else if err == nil && code != 230 {
+		err = fmt.Errorf("ftp login failed with code %d", code)
+	}
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+
+	commands := []string{"TYPE I"}
+	if s.secure != nil {
+		commands = append(commands, "PBSZ 0", "PROT P")
+	}
+	for _, command := range commands {
+		if _, err := s.cmd(2, "%s", command); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// cmd sends a command and reads its reply, failing unless the reply code
+// starts with expect. An expect of 0 accepts any reply.
+func (s *ftpSession) cmd(expect int, format string, args ...any) (int, error) {
+	s.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	if err := s.text.PrintfLine(format, args...); err != nil {
+		return 0, fmt.Errorf("failed to send ftp command: %w", err)
+	}
+	code, msg, err := s.text.ReadResponse(expect)
+	if err != nil && expect != 0 {
+		command, _, _ := strings.Cut(format, " ")
+		return code, fmt.Errorf("ftp %s failed: %d %s", command, code, msg)
+	}
+	return code, nil
+}
+
+// store uploads data to name over a passive data connection
+func (s *ftpSession) store(name string, data []byte) error {
+	dataConn, err := s.passive()
+	if err != nil {
+		return err
+	}
+	defer dataConn.Close()
+
+	if _, err := s.cmd(1, "STOR %s", name); err != nil {
+		return err
+	}
+	dataConn.SetDeadline(time.Now().Add(ftpTimeout))
+	if _, err := dataConn.Write(data); err != nil {
+		return fmt.Errorf("failed to send ftp data: %w", err)
+	}
+	if err := dataConn.Close(); err != nil {
+		return fmt.Errorf("failed to send ftp data: %w", err)
+	}
+
+	s.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	if code, msg, err := s.text.ReadResponse(2); err != nil {
+		return fmt.Errorf("ftp STOR failed: %d %s", code, msg)
+	}
+	return nil
+}
+
+// passive opens a data connection with EPSV, falling back to PASV. The
+// address the server reports is ignored in favour of the control host, which
+// keeps servers behind NAT working.
+func (s *ftpSession) passive() (net.Conn, error) {
+	s.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	var port int
+	if err := s.text.PrintfLine("EPSV"); err != nil {
+		return nil, fmt.Errorf("failed to send ftp command: %w", err)
+	}
+	if _, msg, err := s.text.ReadResponse(229); err == nil {
+		// 229 Entering Extended Passive Mode (|||port|)
+		if start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)"); start >= 0 && end > start+4 {
+			port, _ = strconv.Atoi(msg[start+4 : end])
+		}
+	} else {
+		err := s.text.PrintfLine("PASV")
+		if err == nil {
+			_, msg, err = s.text.ReadResponse(227)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ftp passive mode failed: %w", err)
+		}
+		// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+		inner := msg[strings.Index(msg, "(")+1:]
+		inner, _, _ = strings.Cut(inner, ")")
+		fields := strings.Split(inner, ",")
+		if len(fields) == 6 {
+			hi, _ := strconv.Atoi(fields[4])
+			lo, _ := strconv.Atoi(fields[5])
+			port = hi<<8 | lo
+		}
+	}
+	if port <= 0 {
+		return nil, errors.New("ftp server sent an invalid passive port")
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(s.host, strconv.Itoa(port)), ftpTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ftp data connection: %w", err)
+	}
+	if s.secure != nil {
+		return tls.Client(conn, s.secure), nil
+	}
+	return conn, nil
+}
+
+func (s *ftpSession) close() {
+	s.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	s.text.PrintfLine("QUIT")
+	s.conn.Close()
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mufapNAVURL is the MUFAP page listing the latest NAV of every open-end fund
+const mufapNAVURL = "https://www.mufap.com.pk/Industry/IndustryStatDaily?tab=1"
+
+// collectFundNAVs enables the funds module, MUFAP is not needed for equities
+// so the module is off unless asked for
+var collectFundNAVs bool
+
+func init() {
+	registerModule("funds", func(date time.Time, dbPath string) error {
+		if !collectFundNAVs {
+			return nil
+		}
+		_, err := importMUFAPNAVs(dbPath, mufapNAVURL, date)
+		return err
+	})
+}
+
+// fundNAV is a row of the fund_navs table. Offer and repurchase prices are
+// nil for funds without loads.
+type fundNAV struct {
+	date       string
+	fund       string
+	amc        string
+	category   string
+	nav        float64
+	offer      *float64
+	repurchase *float64
+	source     string
+}
+
+// runFundsCommand dispatches the "funds" subcommands
+func runFundsCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing funds command, expected import, list, premiums or refresh-premiums")
+	}
+
+	switch args[0] {
+	case "import":
+		return importFundNAVs(dbPath, args[1:])
+	case "list":
+		return printFundNAVs(dbPath, args[1:])
+	case "premiums":
+		return printFundPremiums(dbPath, args[1:])
+	case "refresh-premiums":
+		return rebuildFundPremiums(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown funds command %q, expected import, list, premiums or refresh-premiums", args[0])
+	}
+}
+
+// importFundNAVs stores the NAVs on the MUFAP page, or those of a CSV file of
+// date,fund,nav rows
+func importFundNAVs(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("funds import", flag.ContinueOnError)
+	file := fs.String("file", "", "CSV file with date,fund,nav rows, fetch the MUFAP page when empty")
+	url := fs.String("url", mufapNAVURL, "MUFAP NAV page to fetch")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *file == "" {
+		saved, err := importMUFAPNAVs(dbPath, *url, time.Now())
+		if err != nil {
+			return err
+		}
+		slog.Info("Imported MUFAP NAVs", "saved", saved)
+		return nil
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open NAV file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	var navs []fundNAV
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read NAV file: %w", err)
+		}
+		date := strings.TrimSpace(record[0])
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			// Tolerate a header row
+			if line == 1 {
+				continue
+			}
+			return fmt.Errorf("line %d: invalid date %q", line, date)
+		}
+		if len(record) < 3 || strings.TrimSpace(record[1]) == "" {
+			return fmt.Errorf("line %d: expected date,fund,nav", line)
+		}
+		nav, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+		if err != nil || nav <= 0 {
+			return fmt.Errorf("line %d: invalid nav %q", line, record[2])
+		}
+		navs = append(navs, fundNAV{date: date, fund: strings.TrimSpace(record[1]), nav: nav, source: "file"})
+	}
+
+	saved, err := saveFundNAVs(dbPath, navs)
+	if err != nil {
+		return err
+	}
+	slog.Info("Imported fund NAVs", "file", *file, "read", len(navs), "saved", saved)
+	refreshFundPremiums(dbPath, navs)
+	return nil
+}
+
+// printFundNAVs lists stored NAVs, of the latest stored day by default
+func printFundNAVs(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("funds list", flag.ContinueOnError)
+	date := fs.String("date", "", "Day to list (YYYY-MM-DD), defaults to the latest stored")
+	fund := fs.String("fund", "", "List the history of the funds whose name contains this instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	query := `SELECT date, fund, COALESCE(category, ''), nav, COALESCE(offer_price, ''), COALESCE(repurchase_price, '')
+		FROM fund_navs`
+	var queryArgs []any
+	switch {
+	case *fund != "":
+		query += " WHERE fund LIKE ? ORDER BY fund, date"
+		queryArgs = append(queryArgs, "%"+*fund+"%")
+	case *date != "":
+		query += " WHERE date = ? ORDER BY fund"
+		queryArgs = append(queryArgs, *date)
+	default:
+		query += " WHERE date = (SELECT MAX(date) FROM fund_navs) ORDER BY fund"
+	}
+	rows, err := db.Query(query, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to query fund NAVs: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day, name, category, offer, repurchase string
+		var nav float64
+		if err := rows.Scan(&day, &name, &category, &nav, &offer, &repurchase); err != nil {
+			return fmt.Errorf("failed to read fund NAVs: %w", err)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", day, name, category, formatFloat(nav), offer, repurchase)
+	}
+	return rows.Err()
+}
+
+// importMUFAPNAVs fetches the MUFAP NAV page and stores its funds. Rows
+// without a validity date of their own are taken to be of date.
+func importMUFAPNAVs(dbPath, url string, date time.Time) (int, error) {
+	resp, err := httpClient().Get(url)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch MUFAP NAVs: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("fetching MUFAP NAVs failed with status: %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read MUFAP page: %w", err)
+	}
+
+	navs, err := parseMUFAPPage(string(body), date.Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	saved, err := saveFundNAVs(dbPath, navs)
+	if err != nil {
+		return saved, err
+	}
+	slog.Info("Stored fund NAVs", "funds", len(navs), "changed", saved)
+	if saved > 0 {
+		refreshFundPremiums(dbPath, navs)
+	}
+	return saved, nil
+}
+
+// parseMUFAPPage reads the NAV tables of the MUFAP page. The columns are
+// found by their headers, the page being meant for people its layout moves
+// around, and every row after a header with a fund and a NAV column is a
+// fund.
+func parseMUFAPPage(page, date string) ([]fundNAV, error) {
+	var navs []fundNAV
+	columns := map[string]int{}
+	for _, row := range tableRowPattern.FindAllStringSubmatch(page, -1) {
+		var cells []string
+		for _, cell := range tableCellPattern.FindAllStringSubmatch(row[1], -1) {
+			cells = append(cells, strings.Join(strings.Fields(html.UnescapeString(tagPattern.ReplaceAllString(cell[1], " "))), " "))
+		}
+		if header := mufapColumns(cells); header != nil {
+			columns = header
+			continue
+		}
+		fundColumn, ok := columns["fund"]
+		if !ok || fundColumn >= len(cells) || cells[fundColumn] == "" {
+			continue
+		}
+		nav, ok := mufapNumber(cells, columns, "nav")
+		if !ok || *nav <= 0 {
+			continue
+		}
+		n := fundNAV{date: date, fund: cells[fundColumn], nav: *nav, source: "mufap"}
+		n.offer, _ = mufapNumber(cells, columns, "offer")
+		n.repurchase, _ = mufapNumber(cells, columns, "repurchase")
+		if i, ok := columns["category"]; ok && i < len(cells) {
+			n.category = cells[i]
+		}
+		if i, ok := columns["amc"]; ok && i < len(cells) {
+			n.amc = cells[i]
+		}
+		if i, ok := columns["date"]; ok && i < len(cells) {
+			if d, ok := parseHolidayDate(cells[i]); ok {
+				n.date = d
+			}
+		}
+		navs = append(navs, n)
+	}
+	if len(navs) == 0 {
+		return nil, errors.New("no fund NAVs found on the MUFAP page, its layout may have changed")
+	}
+	return navs, nil
+}
+
+// mufapColumns maps the known headers of a header row to their index, nil
+// when cells is not a header row with a fund and a NAV column
+func mufapColumns(cells []string) map[string]int {
+	columns := map[string]int{}
+	for i, cell := range cells {
+		text := strings.ToLower(cell)
+		var name string
+		switch {
+		case text == "fund" || text == "fund name" || text == "scheme":
+			name = "fund"
+		case text == "nav" || strings.HasPrefix(text, "nav ") || text == "net asset value":
+			name = "nav"
+		case strings.HasPrefix(text, "offer"):
+			name = "offer"
+		case strings.HasPrefix(text, "repurchase") || strings.HasPrefix(text, "redemption"):
+			name = "repurchase"
+		case text == "category" || text == "fund category":
+			name = "category"
+		case text == "amc" || strings.Contains(text, "asset management"):
+			name = "amc"
+		case strings.Contains(text, "date") && !strings.Contains(text, "inception"):
+			name = "date"
+		default:
+			continue
+		}
+		if _, ok := columns[name]; !ok {
+			columns[name] = i
+		}
+	}
+	_, hasFund := columns["fund"]
+	_, hasNAV := columns["nav"]
+	if !hasFund || !hasNAV {
+		return nil
+	}
+	return columns
+}
+
+// mufapNumber parses the cell of a column, with thousands separators
+func mufapNumber(cells []string, columns map[string]int, name string) (*float64, bool) {
+	i, ok := columns[name]
+	if !ok || i >= len(cells) {
+		return nil, false
+	}
+	v, err := strconv.ParseFloat(strings.ReplaceAll(cells[i], ",", ""), 64)
+	if err != nil {
+		return nil, false
+	}
+	return &v, true
+}
+
+// saveFundNAVs upserts navs, each in the shard of its date, returning how
+// many were new or changed
+func saveFundNAVs(dbPath string, navs []fundNAV) (int, error) {
+	byPath := map[string][]fundNAV{}
+	var paths []string
+	for _, n := range navs {
+		day, err := time.Parse("2006-01-02", n.date)
+		if err != nil {
+			return 0, fmt.Errorf("invalid NAV date %q: %w", n.date, err)
+		}
+		path := marketDBPath(dbPath, day)
+		if _, ok := byPath[path]; !ok {
+			paths = append(paths, path)
+		}
+		byPath[path] = append(byPath[path], n)
+	}
+
+	saved := 0
+	now := time.Now().Format(time.RFC3339)
+	for _, path := range paths {
+		db, err := sharedDatabase(path)
+		if err != nil {
+			return saved, err
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return saved, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		for _, n := range byPath[path] {
+			res, err := tx.Exec(`INSERT INTO fund_navs (date, fund, amc, category, nav, offer_price, repurchase_price, source, fetched_at)
+				VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?)
+				ON CONFLICT(fund, date) DO UPDATE SET amc = COALESCE(excluded.amc, fund_navs.amc),
+					category = COALESCE(excluded.category, fund_navs.category), nav = excluded.nav,
+					offer_price = excluded.offer_price, repurchase_price = excluded.repurchase_price,
+					source = excluded.source, fetched_at = excluded.fetched_at
+				WHERE (nav, offer_price, repurchase_price, source) IS NOT
+					(excluded.nav, excluded.offer_price, excluded.repurchase_price, excluded.source)`,
+				n.date, n.fund, n.amc, n.category, n.nav, n.offer, n.repurchase, n.source, now)
+			if err != nil {
+				tx.Rollback()
+				return saved, fmt.Errorf("failed to store NAV of %s on %s: %w", n.fund, n.date, err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				saved++
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return saved, fmt.Errorf("failed to commit fund NAVs: %w", err)
+		}
+	}
+	return saved, nil
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// fxLookbackDays is how far back the fx module fetches on every run, so
+	// rates SBP publishes late or revises are picked up
+	fxLookbackDays = 14
+	// maxRateAgeDays is how many days a rate is carried forward to prices of
+	// days SBP published none for, such as bank holidays
+	maxRateAgeDays = 7
+)
+
+var (
+	// usdRateSeries is the EasyData series of the daily PKR per USD rate
+	usdRateSeries = "TS_GP_ER_FAERPKR_D.E00220"
+)
+
+func init() {
+	registerModule("fx", func(date time.Time, dbPath string) error {
+		if sbpAPIKey == "" {
+			return nil
+		}
+		_, err := collectRates(dbPath, date.AddDate(0, 0, -fxLookbackDays), date)
+		return err
+	})
+}
+
+// exchangeRate is what a unit of currency cost in rupees on a day
+type exchangeRate struct {
+	date     string
+	currency string
+	rate     float64
+	source   string
+}
+
+// runFXCommand dispatches the "fx" subcommands
+func runFXCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing fx command, expected import or list")
+	}
+
+	switch args[0] {
+	case "import":
+		return importRates(dbPath, args[1:])
+	case "list":
+		return printRates(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown fx command %q, expected import or list", args[0])
+	}
+}
+
+// importRates stores the USD rates of a date range fetched from SBP, or those
+// of a CSV file of date,rate rows
+func importRates(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("fx import", flag.ContinueOnError)
+	file := fs.String("file", "", "CSV file with date,rate rows, fetch from SBP EasyData when empty")
+	from := fs.String("from", "", "First day to fetch (YYYY-MM-DD), defaults to 30 days ago")
+	to := fs.String("to", "", "Last day to fetch (YYYY-MM-DD), defaults to today")
+	currency := fs.String("currency", "USD", "Currency the rates of -file are for")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *file == "" {
+		if sbpAPIKey == "" {
+			return errors.New("missing -sbp-api-key to fetch rates from SBP EasyData")
+		}
+		start, end := time.Now().AddDate(0, 0, -30), time.Now()
+		var err error
+		if *from != "" {
+			if start, err = time.Parse("2006-01-02", *from); err != nil {
+				return fmt.Errorf("invalid -from date: %w", err)
+			}
+		}
+		if *to != "" {
+			if end, err = time.Parse("2006-01-02", *to); err != nil {
+				return fmt.Errorf("invalid -to date: %w", err)
+			}
+		}
+		saved, err := collectRates(dbPath, start, end)
+		if err != nil {
+			return err
+		}
+		slog.Info("Imported SBP exchange rates", "from", start.Format("2006-01-02"), "to", end.Format("2006-01-02"), "saved", saved)
+		return nil
+	}
+
+	code, err := parseCurrency(*currency)
+	if err != nil || code == "" {
+		return fmt.Errorf("invalid -currency %q, expected a code such as USD", *currency)
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open rate file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	var rates []exchangeRate
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read rate file: %w", err)
+		}
+		date := strings.TrimSpace(record[0])
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			// Tolerate a header row
+			if line == 1 {
+				continue
+			}
+			return fmt.Errorf("line %d: invalid date %q", line, date)
+		}
+		if len(record) < 2 {
+			return fmt.Errorf("line %d: missing rate", line)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil || rate <= 0 {
+			return fmt.Errorf("line %d: invalid rate %q", line, record[1])
+		}
+		rates = append(rates, exchangeRate{date: date, currency: code, rate: rate, source: "file"})
+	}
+
+	saved, err := saveRates(dbPath, rates)
+	if err != nil {
+		return err
+	}
+	slog.Info("Imported exchange rates", "file", *file, "read", len(rates), "saved", saved)
+	return nil
+}
+
+// printRates lists the stored rates of a currency
+func printRates(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("fx list", flag.ContinueOnError)
+	from := fs.String("from", "", "First day (YYYY-MM-DD)")
+	to := fs.String("to", "", "Last day (YYYY-MM-DD)")
+	currency := fs.String("currency", "USD", "Currency to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	code, err := parseCurrency(*currency)
+	if err != nil || code == "" {
+		return fmt.Errorf("invalid -currency %q, expected a code such as USD", *currency)
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	query := "SELECT date, rate, source FROM exchange_rates WHERE currency = ?"
+	queryArgs := []any{code}
+	if *from != "" {
+		query += " AND date >= ?"
+		queryArgs = append(queryArgs, *from)
+	}
+	if *to != "" {
+		query += " AND date <= ?"
+		queryArgs = append(queryArgs, *to)
+	}
+	rows, err := db.Query(query+" ORDER BY date", queryArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to query exchange rates: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var date, source string
+		var rate float64
+		if err := rows.Scan(&date, &rate, &source); err != nil {
+			return fmt.Errorf("failed to read exchange rates: %w", err)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", date, code, formatFloat(rate), source)
+	}
+	return rows.Err()
+}
+
+// collectRates fetches the USD rates of SBP from from to to and stores them,
+// returning how many were new or changed
+func collectRates(dbPath string, from, to time.Time) (int, error) {
+	observations, err := fetchSBPSeries(usdRateSeries, from, to)
+	if err != nil {
+		return 0, err
+	}
+	var rates []exchangeRate
+	for _, o := range observations {
+		if o.value > 0 {
+			rates = append(rates, exchangeRate{date: o.date, currency: "USD", rate: o.value, source: "sbp"})
+		}
+	}
+	saved, err := saveRates(dbPath, rates)
+	if err != nil {
+		return saved, err
+	}
+	slog.Info("Stored exchange rates", "currency", "USD", "days", len(rates), "changed", saved)
+	return saved, nil
+}
+
+// saveRates upserts rates, each in the shard of its date, returning how many
+// were new or changed
+func saveRates(dbPath string, rates []exchangeRate) (int, error) {
+	byPath := map[string][]exchangeRate{}
+	var paths []string
+	for _, r := range rates {
+		day, err := time.Parse("2006-01-02", r.date)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rate date %q: %w", r.date, err)
+		}
+		path := marketDBPath(dbPath, day)
+		if _, ok := byPath[path]; !ok {
+			paths = append(paths, path)
+		}
+		byPath[path] = append(byPath[path], r)
+	}
+
+	saved := 0
+	now := time.Now().Format(time.RFC3339)
+	for _, path := range paths {
+		db, err := sharedDatabase(path)
+		if err != nil {
+			return saved, err
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return saved, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		for _, r := range byPath[path] {
+			res, err := tx.Exec(`INSERT INTO exchange_rates (date, currency, rate, source, fetched_at) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT(currency, date) DO UPDATE SET rate = excluded.rate, source = excluded.source, fetched_at = excluded.fetched_at
+				WHERE (rate, source) IS NOT (excluded.rate, excluded.source)`,
+				r.date, r.currency, r.rate, r.source, now)
+			if err != nil {
+				tx.Rollback()
+				return saved, fmt.Errorf("failed to store %s rate of %s: %w", r.currency, r.date, err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				saved++
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return saved, fmt.Errorf("failed to commit exchange rates: %w", err)
+		}
+	}
+	return saved, nil
+}
+
+// parseCurrency validates a currency prices are requested in. PKR, the
+// currency prices are stored in, and an empty value mean no conversion.
+func parseCurrency(value string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(value))
+	if code == "" || code == "PKR" {
+		return "", nil
+	}
+	if len(code) != 3 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", fmt.Errorf("invalid currency %q, expected a code such as USD", value)
+	}
+	return code, nil
+}
+
+// currencyConverter converts rupee prices at the stored rates of a currency
+type currencyConverter struct {
+	currency string
+	dates    []string
+	rates    []float64
+}
+
+// loadConverter reads every stored rate of currency
+func loadConverter(db *sql.DB, currency string) (*currencyConverter, error) {
+	rows, err := db.Query("SELECT date, rate FROM exchange_rates WHERE currency = ? AND rate > 0 ORDER BY date", currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s rates: %w", currency, err)
+	}
+	defer rows.Close()
+	c := &currencyConverter{currency: currency}
+	for rows.Next() {
+		var date string
+		var rate float64
+		if err := rows.Scan(&date, &rate); err != nil {
+			return nil, fmt.Errorf("failed to read %s rates: %w", currency, err)
+		}
+		c.dates = append(c.dates, date)
+		c.rates = append(c.rates, rate)
+	}
+	return c, rows.Err()
+}
+
+// rate is the latest rate before or, with onDay, on date, no older than
+// maxRateAgeDays
+func (c *currencyConverter) rate(date string, onDay bool) (float64, error) {
+	i := sort.SearchStrings(c.dates, date)
+	if onDay && i < len(c.dates) && c.dates[i] == date {
+		return c.rates[i], nil
+	}
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return 0, err
+	}
+	oldest := day.AddDate(0, 0, -maxRateAgeDays).Format("2006-01-02")
+	if i == 0 || c.dates[i-1] < oldest {
+		return 0, fmt.Errorf("no %s rate stored for %s, see fx import", c.currency, date)
+	}
+	return c.rates[i-1], nil
+}
+
+// convert divides the prices of a row of date by the rate of that day. The
+// previous close is of the session before and converted at the rate before
+// date, so changes in the currency include the move of the rupee, or at that
+// of date when the stored rates start on it.
+func (c *currencyConverter) convert(date string, previousClose *float64, prices ...*float64) error {
+	rate, err := c.rate(date, true)
+	if err != nil {
+		return err
+	}
+	for _, p := range prices {
+		*p = convertPrice(*p, rate)
+	}
+	if *previousClose != 0 {
+		previousRate, err := c.rate(date, false)
+		if err != nil {
+			previousRate = rate
+		}
+		*previousClose = convertPrice(*previousClose, previousRate)
+	}
+	return nil
+}
+
+// convertPrice keeps six decimals, enough for the cheapest shares in dollars
+func convertPrice(price, rate float64) float64 {
+	return math.Round(price/rate*1e6) / 1e6
+}
+
+// convertPriceRows converts /prices rows into currency
+func convertPriceRows(db *sql.DB, prices []priceRow, currency string) error {
+	c, err := loadConverter(db, currency)
+	if err != nil {
+		return err
+	}
+	for i := range prices {
+		p := &prices[i]
+		if err := c.convert(p.Date, &p.PreviousClose, &p.Open, &p.High, &p.Low, &p.Close); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertExportRows converts export rows into the currency of c
+func convertExportRows(c *currencyConverter, rows []exportRow) error {
+	for i := range rows {
+		r := &rows[i]
+		if err := c.convert(r.date, &r.previousClose, &r.open, &r.high, &r.low, &r.close); err != nil {
+			return err
+		}
+	}
+	return nil
+}
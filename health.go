@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// healthStatus is the JSON body returned by the health endpoints
+type healthStatus struct {
+	Status     string `json:"status"`
+	Database   string `json:"database"`
+	LatestDate string `json:"latestDate,omitempty"`
+	Staleness  string `json:"staleness,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// newHTTPHandler routes the health, metrics and API endpoints
+func newHTTPHandler(dbPath string, maxStaleness time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		status, code := checkHealth(r.Context(), dbPath, 0)
+		writeHealth(w, status, code)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status, code := checkHealth(r.Context(), dbPath, maxStaleness)
+		writeHealth(w, status, code)
+	})
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		writeMetrics(w, dbPath)
+	})
+
+	mux.HandleFunc("GET /download", func(w http.ResponseWriter, r *http.Request) {
+		serveDownload(w, r, dbPath)
+	})
+
+	// Dashboards poll the query endpoints, answer repeats from memory until
+	// the next ingest
+	cache := newResponseCache(dbPath)
+	mux.HandleFunc("GET /prices", cache.cached(func(w http.ResponseWriter, r *http.Request) {
+		servePrices(w, r, dbPath)
+	}))
+	mux.HandleFunc("GET /symbols", cache.cached(func(w http.ResponseWriter, r *http.Request) {
+		serveSymbols(w, r, dbPath)
+	}))
+	mux.HandleFunc("GET /indicators", cache.cached(func(w http.ResponseWriter, r *http.Request) {
+		serveIndicators(w, r, dbPath)
+	}))
+	mux.HandleFunc("GET /latest", cache.cached(func(w http.ResponseWriter, r *http.Request) {
+		serveLatest(w, r, dbPath)
+	}))
+	mux.HandleFunc("GET /actions", cache.cached(func(w http.ResponseWriter, r *http.Request) {
+		serveActions(w, r, dbPath)
+	}))
+	mux.HandleFunc("GET /anomalies", cache.cached(func(w http.ResponseWriter, r *http.Request) {
+		serveAnomalies(w, r, dbPath)
+	}))
+	mux.HandleFunc("GET /total-return", cache.cached(func(w http.ResponseWriter, r *http.Request) {
+		serveTotalReturn(w, r, dbPath)
+	}))
+	registerPortfolioRoutes(mux, cache, dbPath)
+
+	mux.HandleFunc("GET /openapi.json", serveOpenAPI)
+	mux.HandleFunc("GET /docs", serveDocs)
+	return withBasePath(withCORS(withRateLimit(withAPIKey(dbPath, mux))))
+}
+
+// newHTTPServer returns the server for the endpoints on addr
+func newHTTPServer(addr, dbPath string, maxStaleness time.Duration) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           newHTTPHandler(dbPath, maxStaleness),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// startHTTPServer serves the health endpoints on addr in the background
+func startHTTPServer(addr, dbPath string, maxStaleness time.Duration) {
+	server := newHTTPServer(addr, dbPath, maxStaleness)
+	go func() {
+		if err := listenAndServe(server, dbPath); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP server failed", "error", err, "addr", addr)
+		}
+	}()
+}
+
+// checkHealth verifies database connectivity and, when maxStaleness is set,
+// that the most recent ingested date is recent enough
+func checkHealth(ctx context.Context, dbPath string, maxStaleness time.Duration) (healthStatus, int) {
+	status := healthStatus{Status: "ok", Database: "ok"}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// A single read-only attempt, probes must answer quickly rather than retry
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		status.Status, status.Database, status.Error = "unavailable", "error", err.Error()
+		return status, http.StatusServiceUnavailable
+	}
+	defer db.Close()
+
+	if err := db.PingContext(ctx); err != nil {
+		status.Status, status.Database, status.Error = "unavailable", "error", err.Error()
+		return status, http.StatusServiceUnavailable
+	}
+
+	latest, err := latestIngestedDate(db)
+	if err != nil {
+		status.Status, status.Error = "unavailable", err.Error()
+		return status, http.StatusServiceUnavailable
+	}
+	if !latest.IsZero() {
+		status.LatestDate = latest.Format("2006-01-02")
+		status.Staleness = time.Since(latest).Truncate(time.Second).String()
+	}
+
+	if maxStaleness > 0 {
+		if latest.IsZero() {
+			status.Status, status.Error = "not ready", "no data ingested yet"
+			return status, http.StatusServiceUnavailable
+		}
+		if time.Since(latest) > maxStaleness {
+			status.Status, status.Error = "stale", "latest ingested date is older than "+maxStaleness.String()
+			return status, http.StatusServiceUnavailable
+		}
+	}
+
+	return status, http.StatusOK
+}
+
+func writeHealth(w http.ResponseWriter, status healthStatus, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// writeMetrics reports ingest statistics from the ingest log in the
+// Prometheus text exposition format
+func writeMetrics(w http.ResponseWriter, dbPath string) {
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer db.Close()
+
+	var runs, inserted, updated, unchanged, errors int64
+	err = db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(inserted), 0), COALESCE(SUM(updated), 0),
+		COALESCE(SUM(unchanged), 0), COALESCE(SUM(errors), 0) FROM ingest_log`).
+		Scan(&runs, &inserted, &updated, &unchanged, &errors)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var last struct {
+		date                                          sql.NullString
+		records, inserted, updated, unchanged, errors sql.NullInt64
+	}
+	err = db.QueryRow(`SELECT date, records, inserted, updated, unchanged, errors
+		FROM ingest_log ORDER BY finished_at DESC, id DESC LIMIT 1`).
+		Scan(&last.date, &last.records, &last.inserted, &last.updated, &last.unchanged, &last.errors)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP psx_ingest_runs_total Number of completed ingest runs.")
+	fmt.Fprintln(w, "# TYPE psx_ingest_runs_total counter")
+	fmt.Fprintf(w, "psx_ingest_runs_total %d\n", runs)
+	fmt.Fprintln(w, "# HELP psx_ingest_rows_total Rows processed by ingest runs by outcome.")
+	fmt.Fprintln(w, "# TYPE psx_ingest_rows_total counter")
+	fmt.Fprintf(w, "psx_ingest_rows_total{change=\"inserted\"} %d\n", inserted)
+	fmt.Fprintf(w, "psx_ingest_rows_total{change=\"updated\"} %d\n", updated)
+	fmt.Fprintf(w, "psx_ingest_rows_total{change=\"unchanged\"} %d\n", unchanged)
+	fmt.Fprintln(w, "# HELP psx_ingest_errors_total Records that failed to parse or store.")
+	fmt.Fprintln(w, "# TYPE psx_ingest_errors_total counter")
+	fmt.Fprintf(w, "psx_ingest_errors_total %d\n", errors)
+
+	if last.date.Valid {
+		fmt.Fprintln(w, "# HELP psx_last_ingest_rows Rows processed by the most recent ingest run by outcome.")
+		fmt.Fprintln(w, "# TYPE psx_last_ingest_rows gauge")
+		fmt.Fprintf(w, "psx_last_ingest_rows{date=%q,change=\"inserted\"} %d\n", last.date.String, last.inserted.Int64)
+		fmt.Fprintf(w, "psx_last_ingest_rows{date=%q,change=\"updated\"} %d\n", last.date.String, last.updated.Int64)
+		fmt.Fprintf(w, "psx_last_ingest_rows{date=%q,change=\"unchanged\"} %d\n", last.date.String, last.unchanged.Int64)
+		fmt.Fprintln(w, "# HELP psx_last_ingest_errors Records that failed in the most recent ingest run.")
+		fmt.Fprintln(w, "# TYPE psx_last_ingest_errors gauge")
+		fmt.Fprintf(w, "psx_last_ingest_errors{date=%q} %d\n", last.date.String, last.errors.Int64)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// defaultHookTimeout bounds a hook without a timeout of its own
+const defaultHookTimeout = 10 * time.Minute
+
+// hookOutputLimit is how much of a failed hook's output is logged
+const hookOutputLimit = 4096
+
+// postIngestHook is an external command run after every successful ingest,
+// configured in the hooks list of the config file
+type postIngestHook struct {
+	// Command is the program and its arguments, run without a shell, e.g.
+	// ["sh", "-c", "make -C /srv/reports"]
+	Command []string `json:"command"`
+	Timeout string   `json:"timeout"`
+
+	timeout time.Duration
+}
+
+// postIngestHooks are the hooks from the config file
+var postIngestHooks []postIngestHook
+
+// applyHooks validates the hooks of the config file
+func applyHooks(list []postIngestHook) error {
+	for i, h := range list {
+		if len(h.Command) == 0 || h.Command[0] == "" {
+			return fmt.Errorf("hook %d: missing command", i+1)
+		}
+		h.timeout = defaultHookTimeout
+		if h.Timeout != "" {
+			d, err := time.ParseDuration(h.Timeout)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("hook %d: invalid timeout %q", i+1, h.Timeout)
+			}
+			h.timeout = d
+		}
+		postIngestHooks = append(postIngestHooks, h)
+	}
+	return nil
+}
+
+// hookInput is the JSON written to a hook's stdin
+type hookInput struct {
+	ingestSummary
+	DB string `json:"db"`
+}
+
+// runPostIngestHooks runs the hooks one after the other. The ingest is
+// described in PSX_INGEST_* environment variables and as JSON on stdin,
+// PSX_DB points psx-data-downloader commands run by the hook at the same
+// database. Failures raise an alert but never fail the ingest.
+func runPostIngestHooks(s ingestSummary, dbPath string) {
+	if len(postIngestHooks) == 0 {
+		return
+	}
+	input, err := json.Marshal(hookInput{ingestSummary: s, DB: dbPath})
+	if err != nil {
+		slog.Error("Failed to encode hook input", "error", err)
+		return
+	}
+	env := append(os.Environ(),
+		"PSX_DB="+dbPath,
+		"PSX_INGEST_DATE="+s.Date,
+		"PSX_INGEST_RECORDS="+strconv.Itoa(s.Records),
+		"PSX_INGEST_INSERTED="+strconv.Itoa(s.Inserted),
+		"PSX_INGEST_UPDATED="+strconv.Itoa(s.Updated),
+		"PSX_INGEST_UNCHANGED="+strconv.Itoa(s.Unchanged),
+		"PSX_INGEST_ERRORS="+strconv.Itoa(s.Errors),
+	)
+
+	for _, h := range postIngestHooks {
+		start := time.Now()
+		output, err := h.run(input, env)
+		if err != nil {
+			if len(output) > hookOutputLimit {
+				output = output[len(output)-hookOutputLimit:]
+			}
+			slog.Error("Post-ingest hook failed", "command", h.Command, "date", s.Date, "error", err, "output", string(output))
+			raiseAlert(alert{
+				Level:   "warning",
+				Title:   "Post-ingest hook failed",
+				Message: fmt.Sprintf("%v for %s: %v", h.Command, s.Date, err),
+				Date:    s.Date,
+			})
+			continue
+		}
+		slog.Info("Post-ingest hook completed", "command", h.Command, "date", s.Date, "elapsed", time.Since(start))
+		slog.Debug("Post-ingest hook output", "command", h.Command, "output", string(output))
+	}
+}
+
+// run executes the hook and returns its combined output
+func (h postIngestHook) run(input []byte, env []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.WaitDelay = 10 * time.Second
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", h.timeout)
+	}
+	return output.Bytes(), err
+}
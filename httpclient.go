@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// httpTimeouts bounds the phases of requests made to PSX and other sources
+type httpTimeouts struct {
+	dial           time.Duration
+	tlsHandshake   time.Duration
+	responseHeader time.Duration
+	// overall covers the whole request including reading the body, slow links
+	// downloading large files need it well above the other timeouts
+	overall time.Duration
+}
+
+var httpConfig = httpTimeouts{
+	dial:           10 * time.Second,
+	tlsHandshake:   10 * time.Second,
+	responseHeader: 30 * time.Second,
+	overall:        2 * time.Minute,
+}
+
+var (
+	httpClientOnce sync.Once
+	sharedClient   *http.Client
+)
+
+// httpClient returns the client applying the configured timeouts. It is
+// built on first use, once the flags are parsed, and shared so connections
+// are kept alive from one download to the next.
+func httpClient() *http.Client {
+	httpClientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = (&net.Dialer{
+			Timeout:   httpConfig.dial,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		transport.TLSHandshakeTimeout = httpConfig.tlsHandshake
+		transport.ResponseHeaderTimeout = httpConfig.responseHeader
+
+		sharedClient = &http.Client{
+			Transport: transport,
+			Timeout:   httpConfig.overall,
+		}
+	})
+	return sharedClient
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// marketDataIndexes are the secondary indexes of market_data, next to the
+// unique index on (date, symbol). Per symbol histories read (symbol, date),
+// scans of whole days (date).
+var marketDataIndexes = []struct {
+	name, columns string
+}{
+	{"market_data_symbol_date", "symbol, date"},
+	{"market_data_date", "date"},
+}
+
+// deferIndexes drops the secondary indexes for the length of a backload and
+// builds them once it is done, which is faster than updating them row by row
+var deferIndexes bool
+
+// indexesDeferred is set while a backload runs without indexes, databases
+// opened meanwhile, such as new shards, are left without them too
+var indexesDeferred atomic.Bool
+
+// createIndexes creates the secondary indexes that are missing. It runs on
+// every open, so a backload interrupted before rebuilding them is repaired by
+// the next run.
+func createIndexes(ctx context.Context, db *sql.DB) error {
+	if indexesDeferred.Load() {
+		return nil
+	}
+	for _, idx := range marketDataIndexes {
+		stmt := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON market_data(%s)", idx.name, idx.columns)
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create index %s: %w", idx.name, err)
+		}
+	}
+	return nil
+}
+
+// dropIndexesForBackload removes the secondary indexes from the database
+// files of dbPath. The returned function builds them again.
+func dropIndexesForBackload(dbPath string) (rebuild func(), err error) {
+	indexesDeferred.Store(true)
+	defer func() {
+		if err != nil {
+			indexesDeferred.Store(false)
+		}
+	}()
+	paths, err := databaseFiles(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		db, err := sharedDatabase(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, idx := range marketDataIndexes {
+			if _, err := db.Exec("DROP INDEX IF EXISTS " + idx.name); err != nil {
+				return nil, fmt.Errorf("failed to drop index %s: %w", idx.name, err)
+			}
+		}
+	}
+	slog.Info("Dropped market_data indexes for the backload", "files", len(paths))
+
+	return func() {
+		indexesDeferred.Store(false)
+		// Shards the backload created are only known now
+		paths, err := databaseFiles(dbPath)
+		if err != nil {
+			slog.Error("Failed to rebuild indexes, they are built on the next run", "error", err)
+			return
+		}
+		for _, path := range paths {
+			start := time.Now()
+			if err := rebuildIndexes(path); err != nil {
+				slog.Error("Failed to rebuild indexes, they are built on the next run", "db", path, "error", err)
+				continue
+			}
+			slog.Info("Rebuilt market_data indexes", "db", path, "elapsed", time.Since(start))
+		}
+	}, nil
+}
+
+// rebuildIndexes creates the secondary indexes of a file and refreshes the
+// planner statistics so queries pick them up
+func rebuildIndexes(path string) error {
+	db, err := sharedDatabase(path)
+	if err != nil {
+		return err
+	}
+	if err := createIndexes(context.Background(), db); err != nil {
+		return err
+	}
+	if _, err := db.Exec("ANALYZE market_data"); err != nil {
+		return fmt.Errorf("failed to analyze market_data: %w", err)
+	}
+	return nil
+}
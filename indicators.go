@@ -0,0 +1,620 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indicatorBar is a stored day of a symbol as the indicators see it
+type indicatorBar struct {
+	date                   string
+	open, high, low, close float64
+	previousClose          float64
+	// indexClose and indexPreviousClose are those of the benchmark index,
+	// 0 when it is not stored for the day
+	indexClose, indexPreviousClose float64
+}
+
+// traded tells days with trades apart from the rows PSX lists for untraded
+// symbols, which carry no open, high or low
+func (b indicatorBar) traded() bool {
+	return !(b.open == 0 && b.high == 0 && b.low == 0)
+}
+
+// indicator derives values per day from the bars of a symbol up to it
+type indicator struct {
+	// params names the parameters of a series, in the order they are
+	// configured and appear in its name
+	params []string
+	// outputs names the values compute returns, appended to the series name;
+	// "" is stored under the series name itself
+	outputs []string
+	// check validates the parameters of a series
+	check func(p []float64) error
+	// lookback is how many bars, the day included, compute needs for the
+	// parameters
+	lookback func(p []float64) int
+	// compute returns the values at the last of bars, one per output, false
+	// when the history is too short or unusable
+	compute func(bars []indicatorBar, p []float64) ([]float64, bool)
+}
+
+var indicators = map[string]indicator{}
+
+// registerIndicator makes an indicator available to the indicators config
+func registerIndicator(name string, ind indicator) {
+	indicators[name] = ind
+}
+
+// windowIndicator is an indicator with a single value over a window of days
+func windowIndicator(compute func(bars []indicatorBar, window int) (float64, bool)) indicator {
+	return indicator{
+		params:  []string{"window"},
+		outputs: []string{""},
+		check: func(p []float64) error {
+			return checkWindow("window", p[0])
+		},
+		lookback: func(p []float64) int { return int(p[0]) },
+		compute: func(bars []indicatorBar, p []float64) ([]float64, bool) {
+			v, ok := compute(bars, int(p[0]))
+			return []float64{v}, ok
+		},
+	}
+}
+
+// checkWindow checks a parameter counts at least 2 days
+func checkWindow(name string, days float64) error {
+	if days < 2 || days != math.Trunc(days) {
+		return fmt.Errorf("invalid %s %g, expected a whole number of at least 2 days", name, days)
+	}
+	return nil
+}
+
+// indicatorParams is a configured parameter set of an indicator, written as
+// a bare number for a window or an array such as [12, 26, 9]
+type indicatorParams []float64
+
+func (p *indicatorParams) UnmarshalJSON(data []byte) error {
+	var window float64
+	if err := json.Unmarshal(data, &window); err == nil {
+		*p = indicatorParams{window}
+		return nil
+	}
+	var list []float64
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("expected a window or an array of parameters")
+	}
+	*p = list
+	return nil
+}
+
+// indicatorParamSets maps the enabled indicators to the parameter sets they
+// are kept for, the config file replaces it
+var indicatorParamSets = map[string][]indicatorParams{
+	"atr":       {{14}},
+	"stdev":     {{20}},
+	"beta":      {{250}},
+	"macd":      {{12, 26, 9}},
+	"bollinger": {{20, 2}},
+}
+
+// applyIndicators checks the indicators of the config file. An indicator
+// with an empty list of parameter sets is turned off.
+func applyIndicators(sets map[string][]indicatorParams) error {
+	if sets == nil {
+		return nil
+	}
+	for name, list := range sets {
+		ind, ok := indicators[name]
+		if !ok {
+			known := make([]string, 0, len(indicators))
+			for n := range indicators {
+				known = append(known, n)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown indicator %q, expected one of %s", name, strings.Join(known, ", "))
+		}
+		for _, p := range list {
+			if len(p) != len(ind.params) {
+				return fmt.Errorf("invalid parameters %v of %s, expected %s", []float64(p), name, strings.Join(ind.params, ", "))
+			}
+			if err := ind.check(p); err != nil {
+				return fmt.Errorf("invalid parameters of %s: %w", name, err)
+			}
+		}
+	}
+	indicatorParamSets = sets
+	return nil
+}
+
+// indicatorSeries is an indicator kept for one parameter set, stored under
+// names such as atr_14 or macd_12_26_9_signal
+type indicatorSeries struct {
+	name   string
+	ind    indicator
+	params []float64
+}
+
+// seriesName joins an indicator and its parameters, e.g. bollinger_20_2
+func seriesName(name string, params []float64) string {
+	for _, p := range params {
+		name += "_" + strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	return name
+}
+
+// outputName is the stored name of an output of a series
+func (s indicatorSeries) outputName(output string) string {
+	if output == "" {
+		return s.name
+	}
+	return s.name + "_" + output
+}
+
+// enabledSeries lists the configured series by name
+func enabledSeries() []indicatorSeries {
+	var series []indicatorSeries
+	for name, sets := range indicatorParamSets {
+		for _, p := range sets {
+			series = append(series, indicatorSeries{seriesName(name, p), indicators[name], p})
+		}
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].name < series[j].name })
+	return series
+}
+
+// seriesLookback is the most bars any of series needs
+func seriesLookback(series []indicatorSeries) int {
+	n := 1
+	for _, s := range series {
+		n = max(n, s.ind.lookback(s.params))
+	}
+	return n
+}
+
+// indicatorValue is a stored value of one series of a symbol
+type indicatorValue struct {
+	date, name string
+	value      float64
+}
+
+// indicatorUpdate replaces the values of a symbol on some dates
+type indicatorUpdate struct {
+	symbol string
+	dates  []string
+	values []indicatorValue
+}
+
+// rebuildIndicatorsBatch is how many symbols a rebuild computes before
+// writing them out
+const rebuildIndicatorsBatch = 50
+
+// computeIndicators evaluates series for every day of bars from start on,
+// the bars before start only serve as history
+func computeIndicators(bars []indicatorBar, start int, series []indicatorSeries) []indicatorValue {
+	var values []indicatorValue
+	for i := start; i < len(bars); i++ {
+		for _, s := range series {
+			n := s.ind.lookback(s.params)
+			if i+1 < n {
+				continue
+			}
+			if v, ok := s.ind.compute(bars[i+1-n:i+1], s.params); ok {
+				for j, output := range s.ind.outputs {
+					values = append(values, indicatorValue{bars[i].date, s.outputName(output), v[j]})
+				}
+			}
+		}
+	}
+	return values
+}
+
+// updateIndicators computes the series of the symbols stored for date, called
+// after every ingest. A day backloaded into existing history changes the
+// windows of the days after it, those are computed again as well.
+func updateIndicators(dbPath string, date time.Time) error {
+	series := enabledSeries()
+	if len(series) == 0 {
+		return nil
+	}
+	lookback := seriesLookback(series)
+	day := date.Format("2006-01-02")
+
+	src, err := sharedQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+
+	symbols, err := symbolsOn(src, day)
+	if err != nil {
+		return err
+	}
+	var updates []indicatorUpdate
+	for _, symbol := range symbols {
+		before, err := loadIndicatorBars(src, "m.symbol = ? AND m.date <= ? ORDER BY m.date DESC LIMIT ?", symbol, day, lookback)
+		if err != nil {
+			return err
+		}
+		if len(before) == 0 {
+			continue
+		}
+		slices.Reverse(before)
+		after, err := loadIndicatorBars(src, "m.symbol = ? AND m.date > ? ORDER BY m.date LIMIT ?", symbol, day, lookback-1)
+		if err != nil {
+			return err
+		}
+		bars := append(before, after...)
+		dates := make([]string, 0, len(after)+1)
+		for _, b := range bars[len(before)-1:] {
+			dates = append(dates, b.date)
+		}
+		updates = append(updates, indicatorUpdate{symbol, dates, computeIndicators(bars, len(before)-1, series)})
+	}
+	return storeIndicators(dbPath, updates)
+}
+
+// rebuildIndicators computes the series of every symbol for the days stored
+// between from and to
+func rebuildIndicators(dbPath, from, to string) (int, error) {
+	series := enabledSeries()
+	lookback := seriesLookback(series)
+
+	src, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	rows, err := src.Query("SELECT DISTINCT symbol FROM market_data WHERE date >= ? AND date <= ? AND symbol IS NOT NULL ORDER BY symbol", from, to)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list symbols: %w", err)
+	}
+	var symbols []string
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to list symbols: %w", err)
+		}
+		symbols = append(symbols, symbol)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list symbols: %w", err)
+	}
+
+	var updates []indicatorUpdate
+	for _, symbol := range symbols {
+		history, err := loadIndicatorBars(src, "m.symbol = ? AND m.date < ? ORDER BY m.date DESC LIMIT ?", symbol, from, max(lookback-1, 0))
+		if err != nil {
+			return 0, err
+		}
+		slices.Reverse(history)
+		bars, err := loadIndicatorBars(src, "m.symbol = ? AND m.date >= ? AND m.date <= ? ORDER BY m.date", symbol, from, to)
+		if err != nil {
+			return 0, err
+		}
+		dates := make([]string, len(bars))
+		for i, b := range bars {
+			dates[i] = b.date
+		}
+		updates = append(updates, indicatorUpdate{symbol, dates, computeIndicators(append(history, bars...), len(history), series)})
+		if len(updates) == rebuildIndicatorsBatch {
+			if err := storeIndicators(dbPath, updates); err != nil {
+				return 0, err
+			}
+			updates = updates[:0]
+		}
+	}
+	return len(symbols), storeIndicators(dbPath, updates)
+}
+
+// symbolsOn lists the symbols stored for date
+func symbolsOn(db *sql.DB, date string) ([]string, error) {
+	rows, err := db.Query("SELECT symbol FROM market_data WHERE date = ? AND symbol IS NOT NULL", date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list symbols: %w", err)
+	}
+	defer rows.Close()
+	var symbols []string
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			return nil, fmt.Errorf("failed to list symbols: %w", err)
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols, rows.Err()
+}
+
+// loadIndicatorBars reads the market_data rows m matching the condition,
+// with the benchmark index of the same day
+func loadIndicatorBars(db *sql.DB, cond string, args ...any) ([]indicatorBar, error) {
+	query := `SELECT m.date, m.open, m.high, m.low, m.close, m.previous_close, i.close, i.previous_close
+		FROM market_data m LEFT JOIN index_data i ON i.symbol = ? AND i.date = m.date WHERE ` + cond
+	rows, err := db.Query(query, append([]any{benchmarkIndex}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bars: %w", err)
+	}
+	defer rows.Close()
+	var bars []indicatorBar
+	for rows.Next() {
+		var b indicatorBar
+		var open, high, low, close, previousClose, indexClose, indexPreviousClose sql.NullFloat64
+		if err := rows.Scan(&b.date, &open, &high, &low, &close, &previousClose, &indexClose, &indexPreviousClose); err != nil {
+			return nil, fmt.Errorf("failed to read bars: %w", err)
+		}
+		b.open, b.high, b.low, b.close, b.previousClose = open.Float64, high.Float64, low.Float64, close.Float64, previousClose.Float64
+		b.indexClose, b.indexPreviousClose = indexClose.Float64, indexPreviousClose.Float64
+		bars = append(bars, b)
+	}
+	return bars, rows.Err()
+}
+
+// storeIndicators writes the updates, each date in the shard it belongs to
+// with one transaction per shard
+func storeIndicators(dbPath string, updates []indicatorUpdate) error {
+	byPath := map[string][]indicatorUpdate{}
+	var paths []string
+	for _, u := range updates {
+		byDate := map[string][]indicatorValue{}
+		for _, v := range u.values {
+			byDate[v.date] = append(byDate[v.date], v)
+		}
+		for _, date := range u.dates {
+			day, err := time.Parse("2006-01-02", date)
+			if err != nil {
+				return fmt.Errorf("invalid stored date %q: %w", date, err)
+			}
+			path := marketDBPath(dbPath, day)
+			if _, ok := byPath[path]; !ok {
+				paths = append(paths, path)
+			}
+			byPath[path] = append(byPath[path], indicatorUpdate{u.symbol, []string{date}, byDate[date]})
+		}
+	}
+
+	for _, path := range paths {
+		db, err := sharedDatabase(path)
+		if err != nil {
+			return err
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		for _, u := range byPath[path] {
+			if err := replaceIndicators(tx, u); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit indicators: %w", err)
+		}
+	}
+	return nil
+}
+
+// replaceIndicators replaces the values of a symbol on the single date of u
+func replaceIndicators(tx *sql.Tx, u indicatorUpdate) error {
+	if _, err := tx.Exec("DELETE FROM indicators WHERE symbol = ? AND date = ?", u.symbol, u.dates[0]); err != nil {
+		return fmt.Errorf("failed to clear indicators: %w", err)
+	}
+	for _, v := range u.values {
+		if _, err := tx.Exec("INSERT INTO indicators (date, symbol, name, value) VALUES (?, ?, ?, ?)", v.date, u.symbol, v.name, v.value); err != nil {
+			return fmt.Errorf("failed to store %s of %s: %w", v.name, u.symbol, err)
+		}
+	}
+	return nil
+}
+
+// runIndicatorsCommand dispatches the "indicators" subcommands
+func runIndicatorsCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing indicators command, expected export or rebuild")
+	}
+
+	switch args[0] {
+	case "export":
+		return exportIndicators(dbPath, args[1:])
+	case "rebuild":
+		return rebuildIndicatorsCommand(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown indicators command %q, expected export or rebuild", args[0])
+	}
+}
+
+// rebuildIndicatorsCommand computes the indicators between -from and -to, by
+// default the whole stored range, e.g. after changing their parameters
+func rebuildIndicatorsCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("indicators rebuild", flag.ContinueOnError)
+	from := fs.String("from", "0000-01-01", "First date to compute (YYYY-MM-DD)")
+	to := fs.String("to", "9999-12-31", "Last date to compute (YYYY-MM-DD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	start := time.Now()
+	symbols, err := rebuildIndicators(dbPath, *from, *to)
+	if err != nil {
+		return err
+	}
+	slog.Info("Rebuilt indicators", "symbols", symbols, "elapsed", time.Since(start))
+	return nil
+}
+
+// exportIndicators writes the stored indicators with one column per series
+func exportIndicators(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("indicators export", flag.ContinueOnError)
+	format := fs.String("format", "csv", "Output format: table, csv or json")
+	out := fs.String("out", "-", "File to write, - for stdout")
+	from := fs.String("from", "", "First date to export (YYYY-MM-DD)")
+	to := fs.String("to", "", "Last date to export (YYYY-MM-DD)")
+	symbols := fs.String("symbols", "", "Comma separated symbols to export, all when empty")
+	names := fs.String("names", "", "Comma separated series such as atr_14, all stored ones when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	series := parseNameList(*names)
+	if len(series) == 0 {
+		if series, err = storedSeries(db); err != nil {
+			return err
+		}
+	}
+	if len(series) == 0 {
+		return errors.New("no indicators stored, run indicators rebuild first")
+	}
+
+	var where []string
+	var queryArgs []any
+	if *from != "" {
+		where = append(where, "date >= ?")
+		queryArgs = append(queryArgs, *from)
+	}
+	if *to != "" {
+		where = append(where, "date <= ?")
+		queryArgs = append(queryArgs, *to)
+	}
+	if list := parseSymbolList(*symbols); len(list) > 0 {
+		where = append(where, "symbol IN (?"+strings.Repeat(", ?", len(list)-1)+")")
+		queryArgs = append(queryArgs, toArgs(list)...)
+	}
+	columns := make([]string, len(series))
+	for i, name := range series {
+		columns[i] = "MAX(CASE WHEN name = ? THEN value END) AS " + quoteIdentifier(name)
+	}
+	query := "SELECT date, symbol, " + strings.Join(columns, ", ") + " FROM indicators"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " GROUP BY date, symbol ORDER BY date, symbol"
+
+	rows, err := db.Query(query, append(toArgs(series), queryArgs...)...)
+	if err != nil {
+		return fmt.Errorf("failed to query indicators: %w", err)
+	}
+	defer rows.Close()
+
+	if *out == "-" {
+		return writeRows(os.Stdout, rows, *format)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", *out, err)
+	}
+	if err := writeRows(f, rows, *format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// storedSeries lists the names of the stored series
+func storedSeries(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT name FROM indicators ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indicators: %w", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to list indicators: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// parseNameList splits a comma separated list of series names
+func parseNameList(list string) []string {
+	var names []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			names = append(names, strings.ToLower(s))
+		}
+	}
+	return names
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// indicatorRow is one stored value as returned by /indicators
+type indicatorRow struct {
+	Date   string  `json:"date"`
+	Symbol string  `json:"symbol"`
+	Name   string  `json:"name"`
+	Value  float64 `json:"value"`
+}
+
+// serveIndicators lists stored indicator values: GET /indicators
+// ?symbols=OGDC&names=atr_14,stdev_20&from=2024-01-01&to=2024-01-31
+func serveIndicators(w http.ResponseWriter, r *http.Request, dbPath string) {
+	q := r.URL.Query()
+	sortable := []string{"date", "symbol", "name", "value"}
+	lq, err := parseListQuery(q, sortable, "date,symbol,name", "date, symbol, name")
+	if err == nil {
+		err = lq.dateRange(q, "date", "date")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if symbols := parseSymbolList(q.Get("symbols")); len(symbols) > 0 {
+		lq.filter("symbol IN (?"+strings.Repeat(", ?", len(symbols)-1)+")", toArgs(symbols)...)
+	}
+	if names := parseNameList(q.Get("names")); len(names) > 0 {
+		lq.filter("name IN (?"+strings.Repeat(", ?", len(names)-1)+")", toArgs(names)...)
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer db.Close()
+
+	total, rows, err := lq.run(db, r, "date, symbol, name, value", "indicators")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	values := []indicatorRow{}
+	for rows.Next() {
+		var v indicatorRow
+		if err := rows.Scan(&v.Date, &v.Symbol, &v.Name, &v.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, page{Data: values, Total: total, Limit: lq.limit, Offset: lq.offset, Next: nextPage(r, lq, total)})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// benchmarkIndex is the index betas are measured against
+const benchmarkIndex = "KSE100"
+
+// trackedIndices is the comma separated list of indices the indices module
+// stores
+var trackedIndices = benchmarkIndex
+
+// psxZone is Pakistan Standard Time, which has no daylight saving, so the
+// timeseries need no time zone database
+var psxZone = time.FixedZone("PKT", 5*60*60)
+
+func init() {
+	registerModule("indices", func(date time.Time, dbPath string) error {
+		return collectIndices(dbPath)
+	})
+}
+
+// timeseriesURL is the end of day history PSX keeps for a symbol or an index
+func timeseriesURL(symbol string) string {
+	return "https://dps.psx.com.pk/timeseries/eod/" + symbol
+}
+
+// timeseriesPoint is a day of the timeseries of a symbol or an index
+type timeseriesPoint struct {
+	date          string
+	open, close   float64
+	volume        int64
+	previousClose float64
+}
+
+// fetchTimeseries downloads the history of a symbol or an index. PSX answers
+// with every day it has, each point being [unix time, close, volume, open].
+func fetchTimeseries(symbol string) ([]timeseriesPoint, error) {
+	data, err := downloadFile(timeseriesURL(symbol))
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Status  int         `json:"status"`
+		Message string      `json:"message"`
+		Data    [][]float64 `json:"data"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("invalid timeseries of %s: %w", symbol, err)
+	}
+	if body.Status != 1 {
+		return nil, fmt.Errorf("timeseries of %s failed: %s", symbol, body.Message)
+	}
+
+	byDate := map[string]timeseriesPoint{}
+	for _, p := range body.Data {
+		if len(p) < 4 {
+			return nil, fmt.Errorf("invalid timeseries point of %s: %v", symbol, p)
+		}
+		date := time.Unix(int64(p[0]), 0).In(psxZone).Format("2006-01-02")
+		byDate[date] = timeseriesPoint{date: date, close: p[1], volume: int64(p[2]), open: p[3]}
+	}
+	points := make([]timeseriesPoint, 0, len(byDate))
+	for _, p := range byDate {
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].date < points[j].date })
+	for i := 1; i < len(points); i++ {
+		points[i].previousClose = points[i-1].close
+	}
+	return points, nil
+}
+
+// collectIndices stores the history of the tracked indices. Days that are new
+// or changed have their indicators computed again, they feed the betas.
+func collectIndices(dbPath string) error {
+	changedFrom := ""
+	for _, index := range strings.Split(trackedIndices, ",") {
+		index = strings.ToUpper(strings.TrimSpace(index))
+		if index == "" {
+			continue
+		}
+		points, err := fetchTimeseries(index)
+		if err != nil {
+			return err
+		}
+		first, changed, err := storeIndex(dbPath, index, points)
+		if err != nil {
+			return err
+		}
+		slog.Info("Stored index", "index", index, "days", len(points), "changed", changed)
+		if changed > 0 && index == benchmarkIndex {
+			changedFrom = first
+		}
+	}
+
+	if changedFrom != "" {
+		symbols, err := rebuildIndicators(dbPath, changedFrom, "9999-12-31")
+		if err != nil {
+			return fmt.Errorf("failed to update indicators: %w", err)
+		}
+		slog.Info("Updated indicators for the benchmark index", "from", changedFrom, "symbols", symbols)
+	}
+	return nil
+}
+
+// storeIndex upserts the points of an index, each in the shard of its date.
+// It returns the first date that changed and how many did.
+func storeIndex(dbPath, index string, points []timeseriesPoint) (string, int, error) {
+	byPath := map[string][]timeseriesPoint{}
+	var paths []string
+	for _, p := range points {
+		day, err := time.Parse("2006-01-02", p.date)
+		if err != nil {
+			return "", 0, err
+		}
+		path := marketDBPath(dbPath, day)
+		if _, ok := byPath[path]; !ok {
+			paths = append(paths, path)
+		}
+		byPath[path] = append(byPath[path], p)
+	}
+
+	first, changed := "", 0
+	for _, path := range paths {
+		db, err := sharedDatabase(path)
+		if err != nil {
+			return "", 0, err
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		stmt, err := tx.Prepare(`INSERT INTO index_data (date, symbol, open, close, volume, previous_close)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(symbol, date) DO UPDATE SET open = excluded.open, close = excluded.close,
+				volume = excluded.volume, previous_close = excluded.previous_close
+			WHERE (open, close, volume, previous_close) IS NOT
+				(excluded.open, excluded.close, excluded.volume, excluded.previous_close)`)
+		if err != nil {
+			tx.Rollback()
+			return "", 0, fmt.Errorf("failed to prepare index insert: %w", err)
+		}
+		for _, p := range byPath[path] {
+			previousClose := any(nil)
+			if p.previousClose > 0 {
+				previousClose = p.previousClose
+			}
+			res, err := stmt.Exec(p.date, index, p.open, p.close, p.volume, previousClose)
+			if err != nil {
+				stmt.Close()
+				tx.Rollback()
+				return "", 0, fmt.Errorf("failed to store %s of %s: %w", index, p.date, err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				if first == "" || p.date < first {
+					first = p.date
+				}
+				changed++
+			}
+		}
+		stmt.Close()
+		if err := tx.Commit(); err != nil {
+			return "", 0, fmt.Errorf("failed to commit index data: %w", err)
+		}
+	}
+	return first, changed, nil
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processMarketData downloads and stores the market summary for a date and
+// records metrics about the run
+func processMarketData(date time.Time, dbPath string) error {
+	metrics := runMetrics{date: date.Format("2006-01-02"), startedAt: time.Now()}
+	st, err := openStore(dbPath)
+	if err == nil {
+		err = ingestMarketData(date, dbPath, st, &metrics)
+		st.close()
+	}
+	return completeIngest(date, dbPath, &metrics, err)
+}
+
+// completeIngest records the outcome of the ingest of date once its rows are
+// committed, or it failed: the run metrics, the retry queue, notifications
+// and, on success, the symbols that came or went since the previous session,
+// the bars, the symbol summary, the indicators, anomalies and post-ingest
+// hooks. It returns err, telling missing summaries on closed days
+// apart with errMarketClosed.
+func completeIngest(date time.Time, dbPath string, metrics *runMetrics, err error) error {
+	if errors.Is(err, errNotPublished) {
+		if isTradingDay(date) {
+			// Trading days always get a summary, its absence is a real failure
+			err = fmt.Errorf("market summary missing on a trading day: %w", err)
+		} else {
+			err = fmt.Errorf("%w: %w", errMarketClosed, err)
+		}
+	}
+	var diff sessionDiff
+	if err == nil {
+		var diffErr error
+		if diff, diffErr = diffSessions(dbPath, date); diffErr != nil {
+			slog.Warn("Failed to compare symbols with the previous session", "date", date.Format("2006-01-02"), "error", diffErr)
+		}
+		metrics.newSymbols, metrics.missingSymbols = diff.added, diff.missing
+	}
+	metrics.finish(err)
+	saveRunMetrics(marketDBPath(dbPath, date), *metrics)
+	recordIngestOutcome(dbPath, date, err)
+	switch {
+	case errors.Is(err, errMarketClosed):
+		// Only forced dates such as those of -dates-file get here
+	case err != nil:
+		notifyIngestFailure(date, err)
+	default:
+		slog.Info("Successfully processed market data", "date", date.Format("2006-01-02"))
+		summary := ingestSummary{
+			Date:      metrics.date,
+			Records:   metrics.records,
+			Inserted:  metrics.changes.inserted,
+			Updated:   metrics.changes.updated,
+			Unchanged: metrics.changes.unchanged,
+			Errors:    metrics.errors,
+			Duration:  metrics.duration.Round(time.Millisecond).String(),
+
+			PreviousDate:   diff.previousDate,
+			NewSymbols:     diff.added,
+			MissingSymbols: diff.missing,
+		}
+		if len(diff.added) > 0 || len(diff.missing) > 0 {
+			slog.Info("Symbols changed since the previous session", "date", summary.Date, "previousDate", diff.previousDate,
+				"new", diff.added, "missing", diff.missing)
+		}
+		notifyIngestSuccess(summary)
+		alertMissingSymbols(summary.Date, diff)
+		if listings, listErr := newListings(dbPath, date, diff); listErr != nil {
+			slog.Warn("Failed to detect new listings", "date", summary.Date, "error", listErr)
+		} else {
+			announceListings(listings)
+		}
+		if aggErr := updateAggregates(dbPath, date); aggErr != nil {
+			slog.Warn("Failed to update weekly and monthly bars", "date", date.Format("2006-01-02"), "error", aggErr)
+		}
+		if statsErr := refreshSymbolStats(dbPath, date); statsErr != nil {
+			slog.Warn("Failed to update symbol summary", "date", date.Format("2006-01-02"), "error", statsErr)
+		}
+		if sectorErr := updateSectors(dbPath, date); sectorErr != nil {
+			slog.Warn("Failed to update sectors", "date", date.Format("2006-01-02"), "error", sectorErr)
+		}
+		if indErr := updateIndicators(dbPath, date); indErr != nil {
+			slog.Warn("Failed to update indicators", "date", date.Format("2006-01-02"), "error", indErr)
+		}
+		if anomalyErr := updateAnomalies(dbPath, date); anomalyErr != nil {
+			slog.Warn("Failed to detect price anomalies", "date", date.Format("2006-01-02"), "error", anomalyErr)
+		}
+		if _, premiumErr := updateFundPremiums(dbPath, summary.Date, summary.Date); premiumErr != nil {
+			slog.Warn("Failed to update fund premiums", "date", summary.Date, "error", premiumErr)
+		}
+		// Hooks see the derived tables of the day as well
+		runPostIngestHooks(summary, dbPath)
+	}
+	return err
+}
+
+// ingestMarketData downloads the market summary of date and writes it to st.
+// Stores in a batch only commit it on flush.
+func ingestMarketData(date time.Time, dbPath string, st store, metrics *runMetrics) error {
+	slog.Info("Processing market data", "date", date.Format("2006-01-02"), "db", dbPath)
+	day, err := fetchMarketData(date, metrics)
+	if err != nil {
+		return err
+	}
+	return storeMarketData(dbPath, st, day, metrics)
+}
+
+// fetchMarketData is the first stage of an ingest, it downloads and parses
+// the market summary of date
+func fetchMarketData(date time.Time, metrics *runMetrics) (ingestDay, error) {
+	day := ingestDay{date: date, startedAt: metrics.startedAt}
+
+	// 1. Download the zip file
+	url := marketSummaryURL(date)
+	slog.Info("Downloading market data", "url", url)
+
+	requestStart := time.Now()
+	archive, err := downloadFile(url)
+	if err != nil {
+		return day, err
+	}
+
+	metrics.downloadBytes = len(archive)
+	metrics.downloadTime = time.Since(requestStart)
+	slog.Info("Downloaded archive", "size", len(archive), "date", date.Format("2006-01-02"))
+
+	// 2. Extract the archive
+	fileName, fileData, err := extractArchive(archive, date)
+	if err != nil {
+		return day, err
+	}
+	day.filename = fileName
+	slog.Info("Processing file from archive", "filename", fileName, "date", date.Format("2006-01-02"))
+
+	// 3. Parse the records
+	parseStart := time.Now()
+	day.records, day.errors, err = parseRecords(fileData, date)
+	if err != nil {
+		return day, err
+	}
+	if keepRawRows {
+		day.raw = splitRawLines(fileData)
+	}
+	metrics.parseTime = time.Since(parseStart)
+	return day, nil
+}
+
+// storeMarketData is the second stage of an ingest, it writes a fetched day
+// and its ingest log entry to st
+func storeMarketData(dbPath string, st store, day ingestDay, metrics *runMetrics) error {
+	if err := checkDBSizeLimit(dbPath); err != nil {
+		return err
+	}
+	if err := checkRowCount(dbPath, st, day.date, len(day.records)); err != nil {
+		metrics.records, metrics.errors = len(day.records), day.errors
+		return err
+	}
+	day.afterCommit = func(changed []changedRow) {
+		if changelogPath == "" {
+			return
+		}
+		changedAt := time.Now()
+		events := make([]changeEvent, len(changed))
+		for i, c := range changed {
+			events[i] = newChangeEvent(c.change, c.rec, changedAt)
+		}
+		// The rows are stored already, a failed append cannot undo them
+		if err := appendChangelog(events); err != nil {
+			slog.Error("Failed to append to the changelog", "date", day.date.Format("2006-01-02"), "events", len(events), "error", err)
+		}
+	}
+
+	// 4. Store the records and the ingest log entry
+	slog.Info("Inserting data into database", "date", day.date.Format("2006-01-02"))
+	storeStart := time.Now()
+	result, err := st.upsertDay(day)
+	metrics.records, metrics.errors = result.records, result.errors
+	metrics.storeTime = time.Since(storeStart)
+	metrics.changes = result.changes
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Database operation completed",
+		"date", day.date.Format("2006-01-02"),
+		"records", result.records,
+		"inserted", result.changes.inserted,
+		"updated", result.changes.updated,
+		"unchanged", result.changes.unchanged,
+		"errorCount", result.errors,
+		"filename", day.filename)
+	return nil
+}
+
+// marketSummaryURL returns the address of the market summary archive for date
+func marketSummaryURL(date time.Time) string {
+	return fmt.Sprintf("https://dps.psx.com.pk/download/mkt_summary/%s.Z", date.Format("2006-01-02"))
+}
+
+// extractArchive returns the name and contents of the market summary of
+// date in a downloaded archive: the first file of a zip archive, or the
+// single file of a legacy compress (.Z) one or of a plain text response,
+// which are named after the date
+func extractArchive(archive []byte, date time.Time) (string, []byte, error) {
+	switch {
+	case bytes.HasPrefix(archive, compressMagic):
+		fileData, err := uncompressLZW(archive)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to uncompress file: %w", err)
+		}
+		return date.Format("2006-01-02"), fileData, nil
+	case isHTML(archive):
+		return "", nil, newHTMLPageError(archive)
+	case isPlainText(archive):
+		slog.Debug("Archive is plain text, parsing it as is", "date", date.Format("2006-01-02"))
+		return date.Format("2006-01-02"), archive, nil
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse zip file: %w", err)
+	}
+
+	// We only process the first file
+	if len(zipReader.File) == 0 {
+		return "", nil, fmt.Errorf("no files found in the archive")
+	}
+	file := zipReader.File[0]
+
+	f, err := file.Open()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open file within zip: %w", err)
+	}
+	defer f.Close()
+
+	fileData, err := io.ReadAll(f)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read file within zip: %w", err)
+	}
+	return file.Name, fileData, nil
+}
+
+// isPlainText reports whether data starts like an uncompressed summary, with
+// no control bytes but line breaks and tabs in its first kilobyte. Archives
+// have binary headers that always contain some.
+func isPlainText(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	for _, b := range data[:min(len(data), 1024)] {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// parsedRecord is a market summary line ready to be stored
+type parsedRecord struct {
+	date   string
+	symbol string
+	row    marketRow
+	// extra holds the columns a transform script derived, stored in
+	// market_data_extra
+	extra map[string]any
+}
+
+// parseMarketSummary parses the pipe separated market summary in layout,
+// returning the valid records and the number of lines that had to be skipped
+func parseMarketSummary(fileData []byte, date time.Time, layout summaryLayout) ([]parsedRecord, int) {
+	reader := csv.NewReader(bytes.NewReader(fileData))
+	reader.Comma = '|'          // Set delimiter to pipe
+	reader.FieldsPerRecord = -1 // Allow variable number of fields
+
+	parseLog := moduleLogger("parse")
+	minFields := layout.minFields()
+	var records []parsedRecord
+	errorCount := 0
+
+	// Read and process all records
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			slog.Warn("Error reading CSV record", "error", err, "date", date.Format("2006-01-02"))
+			errorCount++
+			continue
+		}
+
+		// Skip empty lines
+		if len(record) == 0 {
+			continue
+		}
+
+		// Ensure we have enough fields
+		if len(record) < minFields {
+			parseLog.Debug("Skipping record with insufficient fields", "record", record, "fieldCount", len(record))
+			errorCount++
+			continue
+		}
+
+		// Extract fields
+		recordDate := strings.TrimSpace(record[layout.date])
+
+		recordParsedDate, err := time.Parse("02Jan2006", recordDate)
+		if err != nil {
+			slog.Error("Failed to parse record date", "error", err, "record", record)
+			errorCount++
+			continue
+		}
+
+		recordDate = recordParsedDate.Format("2006-01-02")
+
+		symbol := strings.TrimSpace(record[layout.symbol])
+		code := strings.TrimSpace(record[layout.code])
+		companyName := strings.TrimSpace(record[layout.companyName])
+
+		// Parse numeric values, malformed values are stored as zero
+		open, err := parseNumeric(record[layout.open])
+		logParseFailure(parseLog, err, "open", symbol, record[layout.open])
+		high, err := parseNumeric(record[layout.high])
+		logParseFailure(parseLog, err, "high", symbol, record[layout.high])
+		low, err := parseNumeric(record[layout.low])
+		logParseFailure(parseLog, err, "low", symbol, record[layout.low])
+		close, err := parseNumeric(record[layout.close])
+		logParseFailure(parseLog, err, "close", symbol, record[layout.close])
+		volume, err := parseInt(record[layout.volume])
+		logParseFailure(parseLog, err, "volume", symbol, record[layout.volume])
+		previousClose, err := parseNumeric(record[layout.previousClose])
+		logParseFailure(parseLog, err, "previous_close", symbol, record[layout.previousClose])
+
+		records = append(records, parsedRecord{
+			date:   recordDate,
+			symbol: symbol,
+			row:    marketRow{code, companyName, open, high, low, close, volume, previousClose, layout.extraFields(record)},
+		})
+	}
+	return records, errorCount
+}
+
+// logParseFailure records a numeric field that could not be parsed
+func logParseFailure(logger *slog.Logger, err error, field, symbol, value string) {
+	if err != nil {
+		logger.Debug("Failed to parse numeric field, storing zero", "field", field, "symbol", symbol, "value", value, "error", err)
+	}
+}
+
+// Helper function to parse numeric values that handles both float and int
+func parseNumeric(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" || s == "0.0" {
+		return 0.0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// Helper function specifically for parsing integers
+func parseInt(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
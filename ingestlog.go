@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// rowChange classifies what an upsert did to the stored row
+type rowChange int
+
+const (
+	rowInserted rowChange = iota
+	rowUpdated
+	rowUnchanged
+)
+
+// rowChanges counts the outcome of every upsert in a run
+type rowChanges struct {
+	inserted  int
+	updated   int
+	unchanged int
+}
+
+func (c *rowChanges) add(change rowChange) {
+	switch change {
+	case rowInserted:
+		c.inserted++
+	case rowUpdated:
+		c.updated++
+	case rowUnchanged:
+		c.unchanged++
+	}
+}
+
+// marketRow holds the stored values of a market_data row besides its key
+type marketRow struct {
+	code          string
+	companyName   string
+	open          float64
+	high          float64
+	low           float64
+	close         float64
+	volume        int
+	previousClose float64
+	// extraFields holds the non-empty fields outside the column mapping as a
+	// JSON object keyed by field index, empty when there are none
+	extraFields string
+}
+
+// classifyRow compares row with what is stored for date and symbol using a
+// statement prepared from the market_data lookup query
+func classifyRow(lookup *sql.Stmt, date, symbol string, row marketRow) (rowChange, error) {
+	var code, companyName, extraFields sql.NullString
+	var open, high, low, close, previousClose sql.NullFloat64
+	var volume sql.NullInt64
+	err := lookup.QueryRow(date, symbol).Scan(&code, &companyName, &open, &high, &low, &close, &volume, &previousClose, &extraFields)
+	if err == sql.ErrNoRows {
+		return rowInserted, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	stored := marketRow{code.String, companyName.String, open.Float64, high.Float64, low.Float64,
+		close.Float64, int(volume.Int64), previousClose.Float64, extraFields.String}
+	if stored == row {
+		return rowUnchanged, nil
+	}
+	return rowUpdated, nil
+}
+
+// storedRows returns the market_data rows of a date keyed by symbol
+func storedRows(q querier, date string) (map[string]marketRow, error) {
+	rows, err := q.Query(`SELECT symbol, code, company_name, open, high, low, close, volume, previous_close, extra_fields
+		FROM market_data WHERE date = ?`, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stored rows: %w", err)
+	}
+	defer rows.Close()
+
+	stored := make(map[string]marketRow)
+	for rows.Next() {
+		var symbol string
+		var code, companyName, extraFields sql.NullString
+		var open, high, low, close, previousClose sql.NullFloat64
+		var volume sql.NullInt64
+		if err := rows.Scan(&symbol, &code, &companyName, &open, &high, &low, &close, &volume, &previousClose, &extraFields); err != nil {
+			return nil, fmt.Errorf("failed to read stored row: %w", err)
+		}
+		stored[symbol] = marketRow{code.String, companyName.String, open.Float64, high.Float64, low.Float64,
+			close.Float64, int(volume.Int64), previousClose.Float64, extraFields.String}
+	}
+	return stored, rows.Err()
+}
+
+// ingestEntry is one row of the ingest log, written for every processed day
+type ingestEntry struct {
+	date       string
+	filename   string
+	records    int
+	errors     int
+	changes    rowChanges
+	rowCount   int
+	checksum   string
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// recordIngest writes an ingest log entry for a processed day. The row count
+// and checksum are taken from the stored rows so they can be verified later.
+func recordIngest(q querier, entry ingestEntry) error {
+	rowCount, checksum, err := dayChecksum(q, entry.date)
+	if err != nil {
+		return err
+	}
+	entry.rowCount, entry.checksum = rowCount, checksum
+
+	_, err = q.Exec(`
+	INSERT INTO ingest_log (date, filename, records, errors, inserted, updated, unchanged, row_count, checksum, started_at, finished_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.date, entry.filename, entry.records, entry.errors,
+		entry.changes.inserted, entry.changes.updated, entry.changes.unchanged, entry.rowCount, entry.checksum,
+		entry.startedAt.UTC().Format(time.RFC3339), entry.finishedAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to write ingest log: %w", err)
+	}
+	return nil
+}
+
+// dayChecksum returns the number of rows stored for a date and a SHA-256 over
+// their contents in symbol order
+func dayChecksum(q querier, date string) (int, string, error) {
+	rows, err := q.Query(`
+	SELECT symbol, code, company_name, open, high, low, close, volume, previous_close
+	FROM market_data WHERE date = ? ORDER BY symbol`, date)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read rows for checksum: %w", err)
+	}
+	defer rows.Close()
+
+	h := sha256.New()
+	count := 0
+	for rows.Next() {
+		var symbol, code, companyName sql.NullString
+		var open, high, low, close, previousClose sql.NullFloat64
+		var volume sql.NullInt64
+		if err := rows.Scan(&symbol, &code, &companyName, &open, &high, &low, &close, &volume, &previousClose); err != nil {
+			return 0, "", fmt.Errorf("failed to read row for checksum: %w", err)
+		}
+		fmt.Fprintf(h, "%s|%s|%s|%v|%v|%v|%v|%v|%v\n", symbol.String, code.String, companyName.String,
+			open.Float64, high.Float64, low.Float64, close.Float64, volume.Int64, previousClose.Float64)
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, "", fmt.Errorf("failed to read rows for checksum: %w", err)
+	}
+	return count, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ingestMismatch describes a day whose stored rows no longer match the ingest log
+type ingestMismatch struct {
+	date             string
+	expectedRows     int
+	actualRows       int
+	expectedChecksum string
+	actualChecksum   string
+}
+
+// verifyIngestLog recomputes the row count and checksum of every day in the
+// ingest log, using the most recent entry per day, and returns the days that differ
+func verifyIngestLog(db *sql.DB) (checked int, mismatches []ingestMismatch, err error) {
+	rows, err := db.Query(`
+	SELECT date, row_count, checksum FROM ingest_log
+	WHERE id IN (SELECT MAX(id) FROM ingest_log GROUP BY date)
+	ORDER BY date`)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read ingest log: %w", err)
+	}
+
+	var expected []ingestMismatch
+	for rows.Next() {
+		var m ingestMismatch
+		if err := rows.Scan(&m.date, &m.expectedRows, &m.expectedChecksum); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to read ingest log: %w", err)
+		}
+		expected = append(expected, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("failed to read ingest log: %w", err)
+	}
+
+	for _, m := range expected {
+		m.actualRows, m.actualChecksum, err = dayChecksum(db, m.date)
+		if err != nil {
+			return checked, mismatches, err
+		}
+		checked++
+		if m.actualRows != m.expectedRows || m.actualChecksum != m.expectedChecksum {
+			mismatches = append(mismatches, m)
+		}
+	}
+	return checked, mismatches, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// isinPattern matches the shape of an ISIN, a country code, nine characters
+// and a check digit
+var isinPattern = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{9}[0-9]$`)
+
+// validISIN reports whether s is an ISIN with a valid check digit, the Luhn
+// digit of its characters with letters counted as 10 to 35
+func validISIN(s string) bool {
+	if !isinPattern.MatchString(s) {
+		return false
+	}
+	var digits []int
+	for _, c := range s {
+		if c >= 'A' && c <= 'Z' {
+			n := int(c-'A') + 10
+			digits = append(digits, n/10, n%10)
+		} else {
+			digits = append(digits, int(c-'0'))
+		}
+	}
+	sum := 0
+	for i := range digits {
+		d := digits[len(digits)-1-i]
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// isinMapping is a row of the isins table. A symbol keeps the ISINs it had
+// before, such as after a scheme of arrangement, updated_at tells the latest.
+type isinMapping struct {
+	isin, symbol, name string
+	source             string
+	updatedAt          string
+}
+
+// runISINsCommand dispatches the "isins" subcommands
+func runISINsCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing isins command, expected import, list or lookup")
+	}
+
+	switch args[0] {
+	case "import":
+		return importISINs(dbPath, args[1:])
+	case "list":
+		return printISINs(dbPath, args[1:])
+	case "lookup":
+		return lookupISINs(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown isins command %q, expected import, list or lookup", args[0])
+	}
+}
+
+// importISINs stores the mappings of a CSV file of symbol,isin[,name] rows.
+// The columns may also come isin first, as in CDC statements.
+func importISINs(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("isins import", flag.ContinueOnError)
+	file := fs.String("file", "", "CSV file with symbol,isin[,name] rows")
+	source := fs.String("source", "file", "Source recorded with the mappings, e.g. cdc")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("missing -file")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open ISIN file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	var mappings []isinMapping
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read ISIN file: %w", err)
+		}
+		if len(record) < 2 {
+			return fmt.Errorf("line %d: expected symbol,isin", line)
+		}
+		symbol := strings.ToUpper(strings.TrimSpace(record[0]))
+		isin := strings.ToUpper(strings.TrimSpace(record[1]))
+		if validISIN(symbol) && !validISIN(isin) {
+			symbol, isin = isin, symbol
+		}
+		if !validISIN(isin) {
+			// Tolerate a header row
+			if line == 1 && !isinPattern.MatchString(isin) {
+				continue
+			}
+			return fmt.Errorf("line %d: invalid ISIN %q", line, isin)
+		}
+		if !symbolPattern.MatchString(symbol) {
+			return fmt.Errorf("line %d: invalid symbol %q", line, symbol)
+		}
+		m := isinMapping{isin: isin, symbol: symbol, source: *source}
+		if len(record) > 2 {
+			m.name = strings.TrimSpace(record[2])
+		}
+		mappings = append(mappings, m)
+	}
+
+	saved, err := saveISINs(dbPath, mappings)
+	if err != nil {
+		return err
+	}
+	slog.Info("Imported ISINs", "file", *file, "read", len(mappings), "saved", saved)
+	return nil
+}
+
+// saveISINs upserts mappings, returning how many were new or changed. They
+// live in the -db file, as they are not tied to a day.
+func saveISINs(dbPath string, mappings []isinMapping) (int, error) {
+	db, err := sharedDatabase(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	saved := 0
+	now := time.Now().Format(time.RFC3339)
+	for _, m := range mappings {
+		// An empty name keeps the one stored
+		res, err := tx.Exec(`INSERT INTO isins (isin, symbol, name, source, updated_at) VALUES (?, ?, NULLIF(?, ''), ?, ?)
+			ON CONFLICT(isin) DO UPDATE SET symbol = excluded.symbol, name = COALESCE(excluded.name, isins.name),
+				source = excluded.source, updated_at = excluded.updated_at
+			WHERE (symbol, name, source) IS NOT (excluded.symbol, COALESCE(excluded.name, isins.name), excluded.source)`,
+			m.isin, m.symbol, m.name, m.source, now)
+		if err != nil {
+			return saved, fmt.Errorf("failed to store ISIN %s: %w", m.isin, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			saved++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return saved, fmt.Errorf("failed to commit ISINs: %w", err)
+	}
+	return saved, nil
+}
+
+// queryISINs returns the stored mappings matching where, the latest first
+// within a symbol
+func queryISINs(q querier, where string, args ...any) ([]isinMapping, error) {
+	rows, err := q.Query(`SELECT isin, symbol, COALESCE(name, ''), source, updated_at FROM isins
+		WHERE `+where+` ORDER BY symbol, updated_at DESC, isin`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ISINs: %w", err)
+	}
+	defer rows.Close()
+	var mappings []isinMapping
+	for rows.Next() {
+		var m isinMapping
+		if err := rows.Scan(&m.isin, &m.symbol, &m.name, &m.source, &m.updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read ISINs: %w", err)
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, rows.Err()
+}
+
+// printISINs lists the stored mappings, optionally of some symbols only
+func printISINs(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("isins list", flag.ContinueOnError)
+	symbols := fs.String("symbols", "", "Comma separated symbols, all when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	db, err := openReadOnlyDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	where, whereArgs := "1 = 1", []any(nil)
+	if list := parseSymbolList(*symbols); len(list) > 0 {
+		where = "symbol IN (?" + strings.Repeat(", ?", len(list)-1) + ")"
+		whereArgs = toArgs(list)
+	}
+	mappings, err := queryISINs(db, where, whereArgs...)
+	if err != nil {
+		return err
+	}
+	for _, m := range mappings {
+		fmt.Printf("%s\t%s\t%s\t%s\n", m.symbol, m.isin, m.name, m.source)
+	}
+	return nil
+}
+
+// lookupISINs resolves each argument, an ISIN to its symbol and a symbol to
+// its ISINs
+func lookupISINs(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing ISIN or symbol to look up")
+	}
+	db, err := openReadOnlyDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, arg := range args {
+		arg = strings.ToUpper(strings.TrimSpace(arg))
+		column := "symbol"
+		if validISIN(arg) {
+			column = "isin"
+		}
+		mappings, err := queryISINs(db, column+" = ?", arg)
+		if err != nil {
+			return err
+		}
+		if len(mappings) == 0 {
+			return fmt.Errorf("no ISIN stored for %s", arg)
+		}
+		for _, m := range mappings {
+			fmt.Printf("%s\t%s\t%s\n", m.symbol, m.isin, m.name)
+		}
+	}
+	return nil
+}
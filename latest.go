@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// upsertLatestPrices moves the latest_prices rows of the symbols stored for a
+// date forward. Rows only ever move to a newer date, so backloading history
+// leaves the current prices alone.
+const upsertLatestPrices = `INSERT INTO latest_prices (symbol, date, code, company_name, open, high, low, close,
+		volume, previous_close, change, change_percent, updated_at)
+	SELECT symbol, date, code, company_name, open, high, low, close, volume, previous_close,
+		ROUND(close - previous_close, 4),
+		CASE WHEN previous_close > 0 THEN ROUND((close - previous_close) * 100.0 / previous_close, 4) END,
+		?
+	FROM market_data WHERE %s
+	ON CONFLICT(symbol) DO UPDATE SET
+		date = excluded.date, code = excluded.code, company_name = excluded.company_name,
+		open = excluded.open, high = excluded.high, low = excluded.low, close = excluded.close,
+		volume = excluded.volume, previous_close = excluded.previous_close,
+		change = excluded.change, change_percent = excluded.change_percent, updated_at = excluded.updated_at
+	WHERE excluded.date >= latest_prices.date`
+
+// updateLatestPrices refreshes latest_prices from the rows stored for date,
+// in the transaction of the ingest
+func updateLatestPrices(q querier, date string) error {
+	stmt := fmt.Sprintf(upsertLatestPrices, "date = ? AND symbol IS NOT NULL")
+	if _, err := q.Exec(stmt, time.Now().UTC().Format(time.RFC3339), date); err != nil {
+		return fmt.Errorf("failed to update latest prices: %w", err)
+	}
+	return nil
+}
+
+// seedLatestPrices fills an empty latest_prices table from market_data, for
+// databases created before the table existed
+func seedLatestPrices(ctx context.Context, db *sql.DB) error {
+	var empty bool
+	if err := db.QueryRowContext(ctx, "SELECT NOT EXISTS (SELECT 1 FROM latest_prices)").Scan(&empty); err != nil {
+		return fmt.Errorf("failed to inspect latest prices: %w", err)
+	}
+	if !empty {
+		return nil
+	}
+	stmt := fmt.Sprintf(upsertLatestPrices,
+		"symbol IS NOT NULL AND date = (SELECT MAX(date) FROM market_data m WHERE m.symbol = market_data.symbol)")
+	if _, err := db.ExecContext(ctx, stmt, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("failed to seed latest prices: %w", err)
+	}
+	return nil
+}
+
+// latestRow is the current price of a symbol as returned by /latest
+type latestRow struct {
+	priceRow
+	Change        *float64 `json:"change"`
+	ChangePercent *float64 `json:"change_percent"`
+	// DividendYield is the trailing yield from the stored corporate actions
+	DividendYield float64 `json:"dividend_yield"`
+}
+
+// serveLatest lists the most recent row of every symbol: GET /latest
+// ?symbols=OGDC,HBL&sort=-change_percent&limit=50&offset=0
+func serveLatest(w http.ResponseWriter, r *http.Request, dbPath string) {
+	q := r.URL.Query()
+	sortable := []string{"symbol", "date", "close", "volume", "change", "change_percent"}
+	lq, err := parseListQuery(q, sortable, "symbol", "symbol")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if symbols := parseSymbolList(q.Get("symbols")); len(symbols) > 0 {
+		lq.filter("symbol IN (?"+strings.Repeat(", ?", len(symbols)-1)+")", toArgs(symbols)...)
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer db.Close()
+
+	from := "latest_prices"
+	if shardByYear {
+		// Every shard keeps the latest prices of its year, the newest wins
+		from = `(SELECT * FROM latest_prices l WHERE date = (SELECT MAX(date) FROM latest_prices WHERE symbol = l.symbol))`
+	}
+	total, rows, err := lq.run(db, r, `symbol, date, COALESCE(code, ''), COALESCE(company_name, ''),
+		COALESCE(open, 0), COALESCE(high, 0), COALESCE(low, 0), COALESCE(close, 0),
+		COALESCE(volume, 0), COALESCE(previous_close, 0), change, change_percent`, from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	prices := []latestRow{}
+	for rows.Next() {
+		var p latestRow
+		if err := rows.Scan(&p.Symbol, &p.Date, &p.Code, &p.CompanyName, &p.Open, &p.High, &p.Low, &p.Close,
+			&p.Volume, &p.PreviousClose, &p.Change, &p.ChangePercent); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		prices = append(prices, p)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows.Close()
+	if err := addDividendYields(db, prices); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, page{Data: prices, Total: total, Limit: lq.limit, Offset: lq.offset, Next: nextPage(r, lq, total)})
+}
+
+// addDividendYields fills in the trailing dividend yields of a page of latest
+// prices
+func addDividendYields(db *sql.DB, prices []latestRow) error {
+	if len(prices) == 0 {
+		return nil
+	}
+	symbols := make([]string, len(prices))
+	first, last := prices[0].Date, prices[0].Date
+	for i, p := range prices {
+		symbols[i] = p.Symbol
+		first, last = min(first, p.Date), max(last, p.Date)
+	}
+	actions, err := loadActions(db, symbols, yearBefore(first), last)
+	if err != nil {
+		return err
+	}
+	bySymbol := map[string][]corporateAction{}
+	for _, a := range actions {
+		bySymbol[a.Symbol] = append(bySymbol[a.Symbol], a)
+	}
+	for i, p := range prices {
+		prices[i].DividendYield = trailingYield(bySymbol[p.Symbol], p.Date, p.Close)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// listing is a symbol stored for the first time, most often an IPO
+type listing struct {
+	Date    string   `json:"date"`
+	Symbol  string   `json:"symbol"`
+	Code    string   `json:"code,omitempty"`
+	Company string   `json:"company_name,omitempty"`
+	Close   *float64 `json:"close,omitempty"`
+	Volume  int64    `json:"volume"`
+}
+
+// newListings returns the symbols of d.added that no stored day before date
+// has. Their companies row was created by the ingest itself. The first
+// stored day has no previous session and so no listings, a backload into an
+// empty database does not announce every symbol.
+func newListings(dbPath string, date time.Time, d sessionDiff) ([]listing, error) {
+	if len(d.added) == 0 {
+		return nil, nil
+	}
+	day := date.Format("2006-01-02")
+	db, err := sharedQueryDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Shards each have their own companies row, the earliest first_seen
+	// decides
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(d.added)), ",")
+	rows, err := db.Query(`SELECT m.symbol, m.code, m.company_name, m.close, COALESCE(m.volume, 0)
+		FROM market_data m
+		JOIN (SELECT symbol, MIN(first_seen) AS first_seen FROM companies GROUP BY symbol) c ON c.symbol = m.symbol
+		WHERE m.date = ? AND c.first_seen = ? AND m.symbol IN (`+placeholders+`)
+		ORDER BY m.symbol`, append([]any{day, day}, toArgs(d.added)...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query new listings: %w", err)
+	}
+	defer rows.Close()
+
+	var listings []listing
+	for rows.Next() {
+		l := listing{Date: day}
+		var code, name sql.NullString
+		if err := rows.Scan(&l.Symbol, &code, &name, &l.Close, &l.Volume); err != nil {
+			return nil, fmt.Errorf("failed to read new listings: %w", err)
+		}
+		l.Code, l.Company = code.String, name.String
+		listings = append(listings, l)
+	}
+	return listings, rows.Err()
+}
+
+// announceListings logs the new listings of an ingest and passes each to the
+// notifiers
+func announceListings(listings []listing) {
+	for _, l := range listings {
+		slog.Info("New listing", "date", l.Date, "symbol", l.Symbol, "company", l.Company, "close", formatOptional(l.Close), "volume", l.Volume)
+		notify("listing", func(n notifier) error { return n.onNewListing(l) })
+	}
+}
+
+// description is the one line summary of l the notifiers send
+func (l listing) description() string {
+	name := l.Symbol
+	if l.Company != "" {
+		name += " (" + l.Company + ")"
+	}
+	text := name + " first appeared in the market summary of " + l.Date
+	if l.Close != nil {
+		text += fmt.Sprintf(", closing at %s on a volume of %d", formatFloat(*l.Close), l.Volume)
+	}
+	return text
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errLocked is returned by tryLockFile when another process holds the lock
+var errLocked = errors.New("file is locked")
+
+// instanceLock guards a database against concurrent writers from other processes
+type instanceLock struct {
+	f *os.File
+}
+
+// acquireInstanceLock takes an exclusive lock on <dbPath>.lock. It fails
+// immediately, naming the holder's pid, if another instance already has it.
+func acquireInstanceLock(dbPath string) (*instanceLock, error) {
+	path := dbPath + ".lock"
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := tryLockFile(f); err != nil {
+		defer f.Close()
+		if errors.Is(err, errLocked) {
+			pid := "unknown"
+			if data, readErr := os.ReadFile(path); readErr == nil && len(strings.TrimSpace(string(data))) > 0 {
+				pid = strings.TrimSpace(string(data))
+			}
+			return nil, fmt.Errorf("another instance (pid %s) is already running against %s", pid, dbPath)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	// Record our pid so a second instance can report who holds the lock
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &instanceLock{f: f}, nil
+}
+
+// Release drops the lock. The lock file itself is left in place.
+func (l *instanceLock) Release() error {
+	unlockFile(l.f)
+	return l.f.Close()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// logRotation configures rotation and retention of the log file
+type logRotation struct {
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	maxAge     time.Duration
+}
+
+// setupLogging installs the default slog logger using the requested format
+// and destination. The returned closer releases the log file, if any.
+func setupLogging(format, file string, rotation logRotation, level string, moduleLevels string) (io.Closer, error) {
+	defaultLevel, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	levels, err := parseModuleLevels(moduleLevels)
+	if err != nil {
+		return nil, err
+	}
+
+	var out io.Writer = os.Stderr
+	var closer io.Closer = io.NopCloser(nil)
+
+	if file != "" {
+		f, err := newRotatingFile(file, rotation.maxSize, rotation.interval, rotation.maxBackups, rotation.maxAge)
+		if err != nil {
+			return nil, err
+		}
+		out, closer = f, f
+	}
+
+	// The wrapped handler accepts everything, moduleLevelHandler does the filtering
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var handler slog.Handler
+	switch {
+	case file == "" && runningAsService():
+		// Services have no console, log to the Windows event log instead
+		handler, closer, err = newEventLogHandler(format, opts)
+		if err != nil {
+			return nil, err
+		}
+	case format == "text":
+		handler = slog.NewTextHandler(out, opts)
+	case format == "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		closer.Close()
+		return nil, fmt.Errorf("unknown log format %q, expected json or text", format)
+	}
+
+	slog.SetDefault(slog.New(&moduleLevelHandler{
+		next:   handler,
+		level:  defaultLevel,
+		levels: levels,
+	}))
+	return closer, nil
+}
+
+// logModuleKey is the attribute identifying which part of the program logged a record
+const logModuleKey = "logger"
+
+// moduleLogger returns the default logger tagged with a module name, whose
+// verbosity can be tuned independently with -log-modules
+func moduleLogger(name string) *slog.Logger {
+	return slog.Default().With(logModuleKey, name)
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// parseModuleLevels parses a list such as "http=debug,sql=warn"
+func parseModuleLevels(s string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid module log level %q, expected module=level", entry)
+		}
+		level, err := parseLogLevel(value)
+		if err != nil {
+			return nil, err
+		}
+		levels[strings.TrimSpace(name)] = level
+	}
+	return levels, nil
+}
+
+// moduleLevelHandler filters records by the level configured for the module
+// the logger was tagged with, falling back to the global level
+type moduleLevelHandler struct {
+	next   slog.Handler
+	level  slog.Level
+	levels map[string]slog.Level
+	module string
+}
+
+func (h *moduleLevelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	min := h.level
+	if l, ok := h.levels[h.module]; ok && h.module != "" {
+		min = l
+	}
+	return level >= min
+}
+
+func (h *moduleLevelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *moduleLevelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	for _, a := range attrs {
+		if a.Key == logModuleKey {
+			clone.module = a.Value.String()
+		}
+	}
+	clone.next = h.next.WithAttrs(attrs)
+	return &clone
+}
+
+func (h *moduleLevelHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithGroup(name)
+	return &clone
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// compressMagic starts files written by Unix compress, the .Z format the
+// oldest market summaries were published in
+var compressMagic = []byte{0x1f, 0x9d}
+
+const (
+	lzwInitBits  = 9
+	lzwClearCode = 256
+	// lzwBlockMode in the header marks archives that use lzwClearCode to
+	// reset the dictionary
+	lzwBlockMode = 0x80
+)
+
+// uncompressLZW decodes a Unix compress file. compress/lzw cannot read these:
+// compress packs codes in groups of one code width, whole groups are skipped
+// whenever the width grows or the dictionary is cleared.
+func uncompressLZW(data []byte) ([]byte, error) {
+	if len(data) < 3 || !bytes.HasPrefix(data, compressMagic) {
+		return nil, errors.New("not a compress (.Z) file")
+	}
+	maxBits := int(data[2] & 0x1f)
+	blockMode := data[2]&lzwBlockMode != 0
+	if maxBits < lzwInitBits || maxBits > 16 {
+		return nil, fmt.Errorf("unsupported compress code width of %d bits", maxBits)
+	}
+	maxMaxCode := 1 << maxBits
+
+	input := data[3:]
+	totalBits := len(input) * 8
+	prefix := make([]uint16, maxMaxCode)
+	suffix := make([]byte, maxMaxCode)
+	for i := range 256 {
+		suffix[i] = byte(i)
+	}
+
+	nBits := lzwInitBits
+	maxCode := 1<<nBits - 1
+	nextCode := 256
+	if blockMode {
+		nextCode = lzwClearCode + 1
+	}
+	// pos is the bit position of the next code, groupStart where the codes of
+	// the current width started
+	pos, groupStart := 0, 0
+	alignGroup := func() {
+		group := nBits * 8
+		if r := (pos - groupStart) % group; r != 0 {
+			pos += group - r
+		}
+		groupStart = pos
+	}
+
+	var out bytes.Buffer
+	stack := make([]byte, 0, maxMaxCode)
+	oldCode := -1
+	var finChar byte
+	for pos+nBits <= totalBits {
+		if nextCode > maxCode {
+			alignGroup()
+			nBits++
+			maxCode = 1<<nBits - 1
+			if nBits == maxBits {
+				maxCode = maxMaxCode
+			}
+			continue
+		}
+
+		// Codes are stored least significant bit first
+		code := 0
+		for i := 0; i < nBits; i++ {
+			bit := pos + i
+			code |= int(input[bit>>3]>>(bit&7)&1) << i
+		}
+		pos += nBits
+
+		if oldCode == -1 {
+			if code >= 256 {
+				return nil, errors.New("corrupt compress data: invalid first code")
+			}
+			finChar = byte(code)
+			oldCode = code
+			out.WriteByte(finChar)
+			continue
+		}
+		if code == lzwClearCode && blockMode {
+			alignGroup()
+			nBits = lzwInitBits
+			maxCode = 1<<nBits - 1
+			// The code after a clear adds no usable entry
+			nextCode = lzwClearCode
+			continue
+		}
+
+		inCode := code
+		stack = stack[:0]
+		if code >= nextCode {
+			// The code being defined, its string is the previous one plus
+			// its own first byte
+			if code > nextCode {
+				return nil, errors.New("corrupt compress data: code out of range")
+			}
+			stack = append(stack, finChar)
+			code = oldCode
+		}
+		for code >= 256 {
+			stack = append(stack, suffix[code])
+			code = int(prefix[code])
+		}
+		finChar = suffix[code]
+		stack = append(stack, finChar)
+		for i := len(stack) - 1; i >= 0; i-- {
+			out.WriteByte(stack[i])
+		}
+
+		if nextCode < maxMaxCode {
+			prefix[nextCode] = uint16(oldCode)
+			suffix[nextCode] = finChar
+			nextCode++
+		}
+		oldCode = inCode
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// compressLZW encodes data the way Unix compress does, so the tests can
+// produce .Z files with every width and clear pattern. In block mode a
+// CLEAR code follows every clearEvery codes, 0 meaning never.
+func compressLZW(data []byte, maxBits int, blockMode bool, clearEvery int) []byte {
+	header := byte(maxBits)
+	if blockMode {
+		header |= lzwBlockMode
+	}
+	out := append(bytes.Clone(compressMagic), header)
+	if len(data) == 0 {
+		return out
+	}
+	maxMaxCode := 1 << maxBits
+	first := 256
+	if blockMode {
+		first = lzwClearCode + 1
+	}
+
+	var bits []byte
+	nBits := lzwInitBits
+	maxCode := 1<<nBits - 1
+	// decodeNext mirrors the next code of the decoder, one behind the
+	// encoder as the decoder adds no entry for the first code
+	decodeNext := first
+	pos, groupStart := 0, 0
+	align := func() {
+		group := nBits * 8
+		if r := (pos - groupStart) % group; r != 0 {
+			pos += group - r
+		}
+		groupStart = pos
+	}
+	write := func(code int) {
+		if decodeNext > maxCode {
+			align()
+			nBits++
+			maxCode = 1<<nBits - 1
+			if nBits == maxBits {
+				maxCode = maxMaxCode
+			}
+		}
+		for i := 0; i < nBits; i++ {
+			for pos+i >= len(bits)*8 {
+				bits = append(bits, 0)
+			}
+			if code>>i&1 != 0 {
+				bits[(pos+i)>>3] |= 1 << ((pos + i) & 7)
+			}
+		}
+		pos += nBits
+	}
+
+	type entry struct {
+		prefix int
+		c      byte
+	}
+	dict := map[entry]int{}
+	next := first
+	codes := 0
+	emit := func(code int) {
+		write(code)
+		if codes > 0 && decodeNext < maxMaxCode {
+			decodeNext++
+		}
+		codes++
+	}
+
+	ent := int(data[0])
+	for _, c := range data[1:] {
+		if code, ok := dict[entry{ent, c}]; ok {
+			ent = code
+			continue
+		}
+		emit(ent)
+		if next < maxMaxCode {
+			dict[entry{ent, c}] = next
+			next++
+		}
+		ent = int(c)
+		if blockMode && clearEvery > 0 && codes%clearEvery == 0 {
+			write(lzwClearCode)
+			align()
+			nBits = lzwInitBits
+			maxCode = 1<<nBits - 1
+			clear(dict)
+			next = first
+			// The decoder's code after the clear adds a throwaway entry
+			decodeNext = lzwClearCode
+		}
+	}
+	emit(ent)
+	// The final partial byte is all that is written of the last group
+	return append(out, bits[:(pos+7)/8]...)
+}
+
+// marketText builds n lines resembling a market summary, repetitive enough
+// for the dictionary to fill and the code width to grow
+func marketText(n int) []byte {
+	r := rand.New(rand.NewPCG(1, 2))
+	var buf bytes.Buffer
+	for i := range n {
+		fmt.Fprintf(&buf, "%d|SYM%03d|COMPANY %d LIMITED|%d.%02d|%d.%02d|%d\n",
+			i%7, r.IntN(500), r.IntN(500), r.IntN(1000), r.IntN(100), r.IntN(1000), r.IntN(100), r.IntN(1000000))
+	}
+	return buf.Bytes()
+}
+
+func TestUncompressLZW(t *testing.T) {
+	text := marketText(20000)
+	tests := []struct {
+		name       string
+		data       []byte
+		maxBits    int
+		blockMode  bool
+		clearEvery int
+	}{
+		{"empty", nil, 16, true, 0},
+		{"single byte", []byte("a"), 16, true, 0},
+		{"repeats", []byte("TOBEORNOTTOBEORTOBEORNOT#TOBEORNOTTOBEORTOBEORNOT"), 16, true, 0},
+		{"one run", bytes.Repeat([]byte("a"), 5000), 16, true, 0},
+		{"to 16 bits", text, 16, true, 0},
+		{"to 12 bits then full", text, 12, true, 0},
+		{"9 bits only", text, 9, true, 0},
+		{"without block mode", text, 12, false, 0},
+		{"clear within the 9 bit group", text, 16, true, 7},
+		{"clear before the width grows", text, 16, true, 250},
+		{"clear at wider codes", text, 16, true, 1001},
+		{"clear at 12 bits", text, 12, true, 5003},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := uncompressLZW(compressLZW(tt.data, tt.maxBits, tt.blockMode, tt.clearEvery))
+			if err != nil {
+				t.Fatalf("uncompressLZW: %v", err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("uncompressLZW returned %d bytes differing from the %d compressed", len(got), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestUncompressLZWErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte{0x1f, 0x9d}},
+		{"gzip magic", []byte{0x1f, 0x8b, 0x08, 0x00}},
+		{"code width too small", []byte{0x1f, 0x9d, 0x88, 0x61, 0x00}},
+		{"code width too large", []byte{0x1f, 0x9d, 0x91, 0x61, 0x00}},
+		// 300 as the first 9 bit code
+		{"invalid first code", []byte{0x1f, 0x9d, 0x90, 0x2c, 0x01}},
+		// 'a' then 300, past the 257 the second code may define
+		{"code out of range", []byte{0x1f, 0x9d, 0x90, 0x61, 0x58, 0x02}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := uncompressLZW(tt.data); err == nil {
+				t.Error("uncompressLZW succeeded, want an error")
+			}
+		})
+	}
+}
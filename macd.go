@@ -0,0 +1,76 @@
+package main
+
+import "errors"
+
+// macdWarmup is how many slow windows of closes a MACD value is computed
+// from. Each EMA starts from the average of its first window, whose weight
+// is well under one percent by the last bar, so values barely differ from an
+// EMA over the whole history.
+const macdWarmup = 4
+
+func init() {
+	registerIndicator("macd", indicator{
+		params:  []string{"fast", "slow", "signal"},
+		outputs: []string{"", "signal", "histogram"},
+		check: func(p []float64) error {
+			for i, name := range []string{"fast", "slow", "signal"} {
+				if err := checkWindow(name, p[i]); err != nil {
+					return err
+				}
+			}
+			if p[0] >= p[1] {
+				return errors.New("the fast window must be shorter than the slow one")
+			}
+			return nil
+		},
+		lookback: func(p []float64) int { return macdWarmup*int(p[1]) + int(p[2]) },
+		compute:  macd,
+	})
+}
+
+// macd is the moving average convergence divergence of the closes: the fast
+// EMA less the slow one, the signal EMA of that line and the histogram, the
+// line less its signal. Every bar needs a positive close.
+func macd(bars []indicatorBar, p []float64) ([]float64, bool) {
+	fast, slow, signal := int(p[0]), int(p[1]), int(p[2])
+	closes := make([]float64, len(bars))
+	for i, b := range bars {
+		if b.close <= 0 {
+			return nil, false
+		}
+		closes[i] = b.close
+	}
+	fastEMA, slowEMA := ema(closes, fast), ema(closes, slow)
+	// Both end on the last bar, the fast one starts earlier
+	line := make([]float64, len(slowEMA))
+	offset := len(fastEMA) - len(slowEMA)
+	for i := range slowEMA {
+		line[i] = fastEMA[offset+i] - slowEMA[i]
+	}
+	signalEMA := ema(line, signal)
+	if len(signalEMA) == 0 {
+		return nil, false
+	}
+	last, lastSignal := line[len(line)-1], signalEMA[len(signalEMA)-1]
+	return []float64{last, lastSignal, last - lastSignal}, true
+}
+
+// ema is the exponential moving average of values over period, seeded with
+// the simple average of the first period values. The result starts at the
+// last of those, so it is period-1 values shorter.
+func ema(values []float64, period int) []float64 {
+	if len(values) < period {
+		return nil
+	}
+	var seed float64
+	for _, v := range values[:period] {
+		seed += v
+	}
+	out := make([]float64, 0, len(values)-period+1)
+	out = append(out, seed/float64(period))
+	k := 2 / float64(period+1)
+	for _, v := range values[period:] {
+		out = append(out, v*k+out[len(out)-1]*(1-k))
+	}
+	return out
+}
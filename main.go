@@ -1,21 +1,14 @@
 package main
 
 import (
-	"archive/zip"
-	"bytes"
-	"database/sql"
-	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
@@ -23,291 +16,636 @@ func main() {
 	dbPath := flag.String("db", "market_data.db", "SQLite database path")
 	backloadFrom := flag.String("backloadFrom", "", "Backload data from this date (YYYY-MM-DD)")
 	backloadTo := flag.String("backloadTo", time.Now().Format("2006-01-02"), "Backload data to this date (YYYY-MM-DD)")
+	disabledModules := flag.String("disable-modules", "", "Comma separated list of optional modules to disable")
+	httpAddr := flag.String("http-addr", "", "Serve /healthz, /readyz and /metrics on this address (e.g. :8080), disabled when empty")
+	maxStaleness := flag.Duration("max-staleness", 96*time.Hour, "Report not ready when the latest ingested date is older than this")
+	flag.BoolVar(&requireAPIKey, "require-api-key", false, "Require an API key (see the apikey command) on every HTTP endpoint except the health probes")
+	flag.Float64Var(&rateLimitConfig.rate, "rate-limit", 0, "Requests per second each API key or client IP may make to the HTTP server (0 disables)")
+	flag.IntVar(&rateLimitConfig.burst, "rate-burst", rateLimitConfig.burst, "Requests a client may make at once before -rate-limit applies")
+	flag.StringVar(&tlsConfig.certFile, "tls-cert", "", "PEM certificate file to serve HTTPS with, reloaded when it changes")
+	flag.StringVar(&tlsConfig.keyFile, "tls-key", "", "PEM private key file of -tls-cert")
+	flag.StringVar(&tlsConfig.acmeDomains, "acme-domains", "", "Comma separated domains to obtain certificates for from Let's Encrypt, which must reach -http-addr on port 443")
+	flag.StringVar(&tlsConfig.acmeEmail, "acme-email", "", "Contact email for the ACME account, for expiry notices")
+	flag.StringVar(&tlsConfig.acmeCache, "acme-cache", "", "Directory to keep ACME certificates in, defaults to acme next to -db")
+	flag.StringVar(&tlsConfig.acmeHTTPAddr, "acme-http-addr", "", "Also answer ACME HTTP-01 challenges and redirect to HTTPS on this address, e.g. :80")
+	flag.StringVar(&basePath, "base-path", "", "Path prefix the HTTP server is reached under behind a reverse proxy, e.g. /psx")
+	flag.IntVar(&cacheEntries, "cache-entries", cacheEntries, "API responses kept in memory until the next ingest (0 disables)")
+	flag.StringVar(&corsConfig.origins, "cors-origins", "", "Comma separated browser origins allowed to call the HTTP server, e.g. https://dash.example.com, or * for any")
+	flag.StringVar(&corsConfig.headers, "cors-headers", corsConfig.headers, "Request headers allowed from -cors-origins")
+	pprofAddr := flag.String("pprof-addr", "", "Expose net/http/pprof on this address (e.g. localhost:6060), disabled when empty")
+	logFormat := flag.String("log-format", "text", "Log format: json or text")
+	logFile := flag.String("log-file", "", "Write logs to this file instead of stderr")
+	logMaxSize := flag.Int64("log-max-size", 100, "Rotate the log file once it exceeds this many megabytes (0 disables)")
+	logRotateEvery := flag.Duration("log-rotate-every", 0, "Rotate the log file after it has been open this long (0 disables)")
+	logMaxBackups := flag.Int("log-max-backups", 7, "Number of rotated log files to keep (0 keeps all)")
+	logMaxAge := flag.Duration("log-max-age", 30*24*time.Hour, "Delete rotated log files older than this (0 keeps all)")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	logModules := flag.String("log-modules", "", "Per-module log levels overriding -log-level, e.g. http=debug,parse=debug,sql=debug")
+	order := flag.String("order", "asc", "Backload order: asc fetches the oldest date first, desc the most recent")
+	missingOnly := flag.Bool("missing-only", false, "Backload only dates that have no rows in the database yet")
+	datesFile := flag.String("dates-file", "", "Backload the dates (YYYY-MM-DD) listed one per line in this file instead of a range")
+	once := flag.Bool("once", false, "Process today's data (or the backload range) once and exit instead of running the scheduler")
+	flag.IntVar(&dbConnectAttempts, "db-connect-attempts", dbConnectAttempts, "Number of attempts to connect to the database before giving up")
+	flag.DurationVar(&dbConnectBackoff, "db-connect-backoff", dbConnectBackoff, "Initial delay between database connection attempts, doubled after each failure")
+	flag.DurationVar(&sqliteConfig.busyTimeout, "sqlite-busy-timeout", sqliteConfig.busyTimeout, "How long SQLite waits on a locked database before failing")
+	flag.IntVar(&sqliteConfig.cacheSizeKB, "sqlite-cache-size", 0, "SQLite page cache size in KiB (0 uses the SQLite default)")
+	flag.BoolVar(&sqliteConfig.foreignKeys, "sqlite-foreign-keys", false, "Enforce foreign key constraints")
+	flag.StringVar(&sqliteConfig.journalMode, "sqlite-journal-mode", "", "SQLite journal mode, e.g. WAL to let other processes read while ingesting")
+	flag.BoolVar(&sqliteConfig.immutable, "sqlite-immutable", false, "Open read-only connections as immutable, only safe when nothing writes the file")
+	flag.StringVar(&dbKey, "db-key", "", "SQLCipher passphrase to encrypt the database with, requires a SQLCipher build")
+	dbKeyFile := flag.String("db-key-file", "", "Read the SQLCipher passphrase from this file")
+	flag.BoolVar(&keepRawRows, "keep-raw-rows", false, "Also store every line of the market summary files in the raw_rows table")
+	flag.StringVar(&transformScript, "transform", "", "Starlark script whose transform(row) function rewrites or drops every parsed record")
+	flag.StringVar(&storeBackend, "store", storeBackend, "Storage backend ingest writes to and exports read from")
+	flag.BoolVar(&shardByYear, "shard-by-year", false, "Store each calendar year in its own database file, e.g. market_data_2024.db")
+	scheduleExpr := flag.String("schedule", defaultSchedule, "Cron expression (minute hour day month weekday) for the daily run, optionally prefixed with CRON_TZ=<zone>")
+	runNow := flag.Bool("run-now", false, "Ingest today's data (or the most recent trading day's) on startup instead of waiting for the first scheduled run")
+	catchUpDays := flag.Int("catch-up-days", 30, "On startup ingest trading days missed since the last ingest, at most this many days back (0 disables)")
+	flag.BoolVar(&includeWeekends, "include-weekends", false, "Also fetch Saturdays and Sundays, for special trading sessions")
+	flag.DurationVar(&httpConfig.dial, "http-dial-timeout", httpConfig.dial, "Timeout for establishing connections to PSX")
+	flag.DurationVar(&httpConfig.tlsHandshake, "http-tls-timeout", httpConfig.tlsHandshake, "Timeout for the TLS handshake")
+	flag.DurationVar(&httpConfig.responseHeader, "http-header-timeout", httpConfig.responseHeader, "Timeout for waiting on response headers once the request is sent")
+	flag.DurationVar(&httpConfig.overall, "http-timeout", httpConfig.overall, "Overall timeout of a request including the download (0 disables)")
+	flag.StringVar(&changelogPath, "changelog", "", "JSONL file every inserted and updated row is appended to with a sequence number")
+	flag.StringVar(&trackedIndices, "indices", trackedIndices, "Comma separated indices the indices module stores, e.g. KSE100,KSE30,KMI30")
+	flag.StringVar(&sbpAPIKey, "sbp-api-key", "", "SBP EasyData API key the fx and rates modules fetch with")
+	flag.StringVar(&usdRateSeries, "fx-series", usdRateSeries, "SBP EasyData series of the daily PKR per USD rate")
+	flag.BoolVar(&collectFundNAVs, "fund-navs", false, "Store the daily MUFAP mutual fund NAVs in fund_navs with the funds module")
+	flag.StringVar(&replicateTarget, "replicate-to", "", "Postgres or MySQL URL the replicate module mirrors market_data into after each ingest")
+	flag.StringVar(&sftpConfig.keyFile, "sftp-key", "", "Private key file for sftp:// export destinations")
+	flag.StringVar(&sftpConfig.knownHosts, "sftp-known-hosts", sftpConfig.knownHosts, "known_hosts file verifying sftp:// servers")
+	flag.IntVar(&downloadResumeAttempts, "download-resume-attempts", downloadResumeAttempts, "Times an interrupted download is resumed with a Range request before giving up")
+	flag.BoolVar(&forceRowCount, "force", false, "Store days whose row count is far off the trailing average instead of failing them as suspect")
+	flag.Float64Var(&rowCountTolerance, "row-count-tolerance", rowCountTolerance, "Share of the trailing average the row count of a day may deviate by before it is suspect (0 disables the check)")
+	maxDBSizeMB := flag.Int64("max-db-size", 0, "Refuse ingests and backloads that would grow the database files beyond this many megabytes (0 disables)")
+	flag.IntVar(&backloadChunkDays, "backload-chunk-days", backloadChunkDays, "Days of a backload committed in one transaction (1 commits every day on its own)")
+	flag.BoolVar(&deferIndexes, "backload-defer-indexes", false, "Drop the market_data indexes during a backload and build them once it finishes")
+	flag.IntVar(&backloadPrefetch, "backload-prefetch", backloadPrefetch, "Days a backload downloads and parses ahead of the one it is storing")
+	flag.IntVar(&retryAttempts, "retry-attempts", retryAttempts, "Times a failed date is ingested again on later runs before giving up (0 disables the retry queue)")
+	timezone := flag.String("timezone", "Asia/Karachi", "Time zone schedules are evaluated in and dates are taken from, unless a schedule sets CRON_TZ")
+	configPath := flag.String("config", "", "JSON config file with per-collector schedules and retention policies")
+	flag.Usage = envUsage(flag.CommandLine)
 	flag.Parse()
 
-	// Check if in backload mode
-	if *backloadFrom != "" {
-		// Parse start date for backloading
-		startDate, err := time.Parse("2006-01-02", *backloadFrom)
-		if err != nil {
-			slog.Error("Invalid backload start date format", "error", err, "date", *backloadFrom)
-			os.Exit(1)
-		}
+	envErr := applyEnv(flag.CommandLine)
 
-		endDate := time.Now()
-		if *backloadTo != "" {
-			endDate, err = time.Parse("2006-01-02", *backloadTo)
-			if err != nil {
-				slog.Error("Invalid backload end date format", "error", err, "date", *backloadTo)
-				os.Exit(1)
-			}
+	logCloser, err := setupLogging(*logFormat, *logFile, logRotation{
+		maxSize:    *logMaxSize << 20,
+		interval:   *logRotateEvery,
+		maxBackups: *logMaxBackups,
+		maxAge:     *logMaxAge,
+	}, *logLevel, *logModules)
+	if err != nil {
+		slog.Error("Failed to set up logging", "error", err)
+		os.Exit(1)
+	}
+	defer logCloser.Close()
 
-		}
-		if startDate.After(endDate) {
-			slog.Error("Backload start date cannot be in the future", "startDate", *backloadFrom)
+	if envErr != nil {
+		slog.Error("Invalid environment configuration", "error", envErr)
+		os.Exit(1)
+	}
+	maxDBSize = *maxDBSizeMB << 20
+
+	if *dbKeyFile != "" {
+		if dbKey, err = readDatabaseKey(*dbKeyFile); err != nil {
+			slog.Error("Invalid database key", "error", err)
 			os.Exit(1)
 		}
+	}
 
-		slog.Info("Starting backload operation",
-			"fromDate", startDate.Format("2006-01-02"),
-			"toDate", endDate.Format("2006-01-02"))
-
-		backloadData(startDate, endDate, *dbPath)
-
-		slog.Info("Backload operation completed successfully")
+	if err := disableModules(*disabledModules); err != nil {
+		slog.Error("Invalid module list", "error", err, "modules", *disabledModules)
+		os.Exit(1)
 	}
 
-	// Define Pakistan time zone (UTC+5)
-	pakistanLocation, err := time.LoadLocation("Asia/Karachi")
+	cfg, err := loadConfig(*configPath)
 	if err != nil {
-		slog.Error("Failed to load timezone for pakistan", "error", err)
+		slog.Error("Failed to load config", "error", err, "path", *configPath)
 		os.Exit(1)
 	}
 
-	for {
-		// Get the current time in Pakistan Time Zone
-		now := time.Now().In(pakistanLocation)
+	if err := applyRetention(cfg.Retention); err != nil {
+		slog.Error("Invalid retention policy", "error", err)
+		os.Exit(1)
+	}
+	if err := applyExports(cfg.Exports); err != nil {
+		slog.Error("Invalid export config", "error", err)
+		os.Exit(1)
+	}
+	if err := applyNotifiers(cfg.Notifiers); err != nil {
+		slog.Error("Invalid notifier config", "error", err)
+		os.Exit(1)
+	}
+	if err := applyHooks(cfg.Hooks); err != nil {
+		slog.Error("Invalid hook config", "error", err)
+		os.Exit(1)
+	}
+	if err := applySummaryColumns(cfg.SummaryColumns); err != nil {
+		slog.Error("Invalid summary column mapping", "error", err)
+		os.Exit(1)
+	}
+	if err := applySummaryVersions(cfg.SummaryVersions); err != nil {
+		slog.Error("Invalid summary versions", "error", err)
+		os.Exit(1)
+	}
+	if err := applyIndicators(cfg.Indicators); err != nil {
+		slog.Error("Invalid indicators", "error", err)
+		os.Exit(1)
+	}
+	if err := applyAnomalies(cfg.Anomalies); err != nil {
+		slog.Error("Invalid anomaly thresholds", "error", err)
+		os.Exit(1)
+	}
+	if err := applyRateSeries(cfg.Rates); err != nil {
+		slog.Error("Invalid rate series", "error", err)
+		os.Exit(1)
+	}
+	if err := applyListedFunds(cfg.ListedFunds); err != nil {
+		slog.Error("Invalid listed funds", "error", err)
+		os.Exit(1)
+	}
 
-		// Calculate the next 11 PM Pakistan Time
-		nextRun := time.Date(now.Year(), now.Month(), now.Day(), 23, 0, 0, 0, pakistanLocation)
-		if now.After(nextRun) {
-			// If it's already past 11 PM today, schedule for tomorrow
-			nextRun = nextRun.Add(24 * time.Hour)
+	switch flag.Arg(0) {
+	case "":
+		// No command, run the scheduler below
+	case "service":
+		// The flags given before the command become the service's arguments
+		serviceArgs := os.Args[1 : len(os.Args)-flag.NArg()]
+		if err := serviceCommand(flag.Arg(1), serviceArgs); err != nil {
+			slog.Error("Service command failed", "command", flag.Arg(1), "error", err)
+			os.Exit(1)
 		}
-
-		// Calculate the duration to sleep until the next 11 PM
-		sleepDuration := time.Until(nextRun)
-		slog.Info("Scheduling next run", "duration", nextRun)
-
-		time.Sleep(sleepDuration)
-
-		current := time.Now().In(pakistanLocation)
-		// Run the task at 11 PM
-		err = processMarketData(current, *dbPath)
-		if err != nil {
-			slog.Error("Failed to process market data", "date", current.Format("2006-01-02"), "error", err)
+		return
+	case "db":
+		if err := runDBCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Database command failed", "error", err)
+			os.Exit(1)
 		}
-	}
-}
-
-// backloadData downloads and processes data for a range of dates
-func backloadData(startDate, endDate time.Time, dbPath string) {
-	currentDate := startDate
-	for currentDate.Before(endDate) {
-		slog.Info("Starting backload for", "date", currentDate.Format("2006-01-02"))
-
-		err := processMarketData(currentDate, dbPath)
-		if err != nil {
-			slog.Error("Failed to backload data", "date", currentDate.Format("2006-01-02"))
-		} else {
-			slog.Info("Successfully backloaded date", "date", currentDate.Format("2006-01-02"))
+		return
+	case "calendar":
+		if err := runCalendarCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Calendar command failed", "error", err)
+			os.Exit(1)
 		}
-
-		currentDate = currentDate.AddDate(0, 0, 1)
+		return
+	case "verify":
+		if err := runVerifyCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Verification failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "check":
+		if err := runCheckCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Check failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "prune":
+		if err := runPruneCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Prune failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "query":
+		if err := runQueryCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Query failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "symbols":
+		if err := runSymbolsCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Symbols command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "indicators":
+		if err := runIndicatorsCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Indicators command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "fx":
+		if err := runFXCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("FX command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "rates":
+		if err := runRatesCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Rates command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "funds":
+		if err := runFundsCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Funds command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "constituents":
+		if err := runConstituentsCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Constituents command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "sectors":
+		if err := runSectorsCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Sectors command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "isins":
+		if err := runISINsCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("ISINs command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "anomalies":
+		if err := runAnomaliesCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Anomalies command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "actions":
+		if err := runActionsCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Actions command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "portfolio":
+		if err := runPortfolioCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Portfolio command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "screen":
+		if err := screenCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Screen failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "report":
+		if err := runReportCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Report failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "export":
+		if err := runExportCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Export failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "changes":
+		if err := runChangesCommand(flag.Args()[1:]); err != nil {
+			slog.Error("Reading changes failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "apikey":
+		if err := runAPIKeyCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("API key command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "serve":
+		if err := runServeCommand(*dbPath, *httpAddr, *maxStaleness); err != nil {
+			slog.Error("HTTP server failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "replicate":
+		if err := runReplicateCommand(*dbPath, flag.Args()[1:]); err != nil {
+			slog.Error("Replication failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	default:
+		slog.Error("Unknown command", "command", flag.Arg(0))
+		os.Exit(1)
 	}
-}
 
-func processMarketData(date time.Time, dbPath string) error {
-	slog.Info("Processing market data", "date", date.Format("2006-01-02"), "db", dbPath)
-	// 1. Download the zip file
-	url := fmt.Sprintf("https://dps.psx.com.pk/download/mkt_summary/%s.Z", date.Format("2006-01-02"))
-	slog.Info("Downloading market data", "url", url)
+	if *pprofAddr != "" {
+		startPprofServer(*pprofAddr)
+	}
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	if *httpAddr != "" {
+		startHTTPServer(*httpAddr, *dbPath, *maxStaleness)
 	}
 
-	resp, err := client.Get(url)
+	// Only one instance may write to a database at a time
+	lock, err := acquireInstanceLock(*dbPath)
 	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
+		slog.Error("Failed to acquire instance lock", "error", err)
+		os.Exit(1)
 	}
-	defer resp.Body.Close()
+	defer lock.Release()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status: %s", resp.Status)
+	// Verify the database is reachable before doing any work, the handle is
+	// kept for the runs to come
+	if _, err := sharedDatabase(marketDBPath(*dbPath, time.Now())); err != nil {
+		slog.Error("Failed to connect to database", "db", *dbPath, "error", err)
+		os.Exit(1)
 	}
-	// Read response body
-	zipData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+	defer closeSharedDatabases()
+
+	if err := loadHolidays(*dbPath); err != nil {
+		slog.Warn("Failed to load market holidays, only weekends are skipped", "error", err)
 	}
 
-	slog.Info("Downloaded zip file", "size", len(zipData), "date", date.Format("2006-01-02"))
+	// Check if in backload mode
+	if *backloadFrom != "" || *datesFile != "" {
+		var dates []time.Time
+		if *datesFile != "" {
+			dates, err = readDatesFile(*datesFile)
+			if err != nil {
+				slog.Error("Invalid dates file", "error", err, "file", *datesFile)
+				os.Exit(1)
+			}
+			slog.Info("Starting backload operation", "datesFile", *datesFile, "dates", len(dates))
+		} else {
+			// Parse start date for backloading
+			startDate, err := time.Parse("2006-01-02", *backloadFrom)
+			if err != nil {
+				slog.Error("Invalid backload start date format", "error", err, "date", *backloadFrom)
+				os.Exit(1)
+			}
 
-	// 2. Extract the zip file
-	zipReader, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
-	if err != nil {
-		return fmt.Errorf("failed to parse zip file: %w", err)
-	}
+			endDate := time.Now()
+			if *backloadTo != "" {
+				endDate, err = time.Parse("2006-01-02", *backloadTo)
+				if err != nil {
+					slog.Error("Invalid backload end date format", "error", err, "date", *backloadTo)
+					os.Exit(1)
+				}
 
-	// Find the file in the archive
-	var fileData []byte
-	var fileName string
-	for _, file := range zipReader.File {
-		fileName = file.Name
-		slog.Info("Processing file from archive", "filename", fileName, "date", date.Format("2006-01-02"))
+			}
+			if startDate.After(endDate) {
+				slog.Error("Backload start date cannot be in the future", "startDate", *backloadFrom)
+				os.Exit(1)
+			}
 
-		f, err := file.Open()
-		if err != nil {
-			return fmt.Errorf("failed to open file within zip: %w", err)
+			slog.Info("Starting backload operation",
+				"fromDate", startDate.Format("2006-01-02"),
+				"toDate", endDate.Format("2006-01-02"))
+
+			dates = backloadDates(startDate, endDate)
+		}
+		if *missingOnly {
+			dates, err = missingDates(dates, *dbPath)
+			if err != nil {
+				slog.Error("Failed to look up ingested dates", "error", err)
+				os.Exit(1)
+			}
+		}
+		switch *order {
+		case "asc":
+		case "desc":
+			// The freshest data becomes usable first while history trickles in
+			slices.Reverse(dates)
+		default:
+			slog.Error("Invalid backload order, expected asc or desc", "order", *order)
+			os.Exit(1)
 		}
 
-		fileData, err = io.ReadAll(f)
-		f.Close()
-		if err != nil {
-			return fmt.Errorf("failed to read file within zip: %w", err)
+		if err := preflightBackload(*dbPath, len(dates)); err != nil {
+			slog.Error("Preflight check failed", "error", err)
+			os.Exit(1)
 		}
+		backloadData(dates, *dbPath)
 
-		// We only process the first file
-		break
-	}
+		slog.Info("Backload operation completed successfully")
 
-	if fileData == nil {
-		return fmt.Errorf("no files found in the archive")
+		if *once {
+			return
+		}
 	}
 
-	// 3. Parse the data using CSV parser
-	reader := csv.NewReader(bytes.NewReader(fileData))
-	reader.Comma = '|'          // Set delimiter to pipe
-	reader.FieldsPerRecord = -1 // Allow variable number of fields
-
-	// 4. Create or open the SQLite database
-	db, err := sql.Open("sqlite3", dbPath)
+	// Schedules are evaluated in this zone unless they name their own
+	location, err := time.LoadLocation(*timezone)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		slog.Error("Failed to load timezone", "error", err, "timezone", *timezone)
+		os.Exit(1)
 	}
-	defer db.Close()
 
-	// 5. Create table if it doesn't exist
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS market_data (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		date TEXT,
-		symbol TEXT,
-		code TEXT,
-		company_name TEXT,
-		open REAL,
-		high REAL,
-		low REAL,
-		close REAL,
-		volume INTEGER,
-		previous_close REAL,
-		UNIQUE(date, symbol)
-	);`
-
-	_, err = db.Exec(createTableSQL)
-	if err != nil {
-		return fmt.Errorf("failed to create table: %w", err)
+	// An explicit -schedule wins over the eod entry of the config file
+	if expr, ok := cfg.Schedules[eodCollector]; ok && !flagSet(flag.CommandLine, "schedule") {
+		*scheduleExpr = expr
 	}
-
-	// 6. Insert data into the database
-	slog.Info("Inserting data into database", "date", date.Format("2006-01-02"))
-	tx, err := db.Begin()
+	schedule, err := parseCron(*scheduleExpr, location)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		slog.Error("Invalid schedule", "error", err)
+		os.Exit(1)
 	}
-
-	stmt, err := tx.Prepare(`
-	INSERT OR REPLACE INTO market_data
-	(date, symbol, code, company_name, open, high, low, close, volume, previous_close)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to prepare insert statement: %w", err)
+	if err := scheduleModules(cfg.Schedules, location); err != nil {
+		slog.Error("Invalid collector schedule", "error", err)
+		os.Exit(1)
 	}
-	defer stmt.Close()
-
-	recordCount := 0
-	errorCount := 0
 
-	// Read and process all records
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
+	if *once {
+		// Run-once mode for external schedulers such as systemd timers
+		current := time.Now().In(schedule.location)
+		if !isTradingDay(current) {
+			slog.Info("Skipping non-trading day", "date", current.Format("2006-01-02"))
+			return
 		}
+		err = processMarketData(current, *dbPath)
+		runOptionalModules(current, *dbPath).Wait()
 		if err != nil {
-			slog.Warn("Error reading CSV record", "error", err, "date", date.Format("2006-01-02"))
-			errorCount++
-			continue
+			slog.Error("Failed to process market data", "date", current.Format("2006-01-02"), "error", err)
+			os.Exit(1)
 		}
+		return
+	}
 
-		// Skip empty lines
-		if len(record) == 0 {
-			continue
+	schedOpts := schedulerOptions{runNow: *runNow, catchUpDays: *catchUpDays}
+	if runningAsService() {
+		if err := runAsService(func() { runScheduler(schedule, *dbPath, schedOpts) }); err != nil {
+			slog.Error("Windows service failed", "error", err)
+			os.Exit(1)
 		}
+		return
+	}
+
+	runScheduler(schedule, *dbPath, schedOpts)
+}
 
-		// Ensure we have enough fields
-		if len(record) < 10 {
-			slog.Debug("Skipping record with insufficient fields", "record", record, "fieldCount", len(record))
-			errorCount++
+// backloadDates returns the trading days from startDate up to, but not
+// including, endDate
+func backloadDates(startDate, endDate time.Time) []time.Time {
+	var dates []time.Time
+	for currentDate := startDate; currentDate.Before(endDate); currentDate = currentDate.AddDate(0, 0, 1) {
+		if !isTradingDay(currentDate) {
+			slog.Debug("Skipping non-trading day", "date", currentDate.Format("2006-01-02"))
 			continue
 		}
+		dates = append(dates, currentDate)
+	}
+	return dates
+}
 
-		// Extract fields
-		recordDate := strings.TrimSpace(record[0])
+// readDatesFile parses a file listing one YYYY-MM-DD date per line, blank
+// lines and lines starting with # are ignored. The dates are returned sorted
+// without duplicates and are fetched even if the calendar marks them closed.
+func readDatesFile(path string) ([]time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dates file: %w", err)
+	}
 
-		recordParsedDate, err := time.Parse("02Jan2006", recordDate)
-		if err != nil {
-			slog.Error("Failed to parse record date", "error", err, "record", record)
-			errorCount++
+	var dates []time.Time
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
+		date, err := time.Parse("2006-01-02", line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", i+1, line)
+		}
+		dates = append(dates, date)
+	}
+	slices.SortFunc(dates, time.Time.Compare)
+	return slices.CompactFunc(dates, time.Time.Equal), nil
+}
 
-		recordDate = recordParsedDate.Format("2006-01-02")
+// missingDates drops the dates that already have rows in the database
+func missingDates(dates []time.Time, dbPath string) ([]time.Time, error) {
+	if len(dates) == 0 {
+		return dates, nil
+	}
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
 
-		symbol := strings.TrimSpace(record[1])
-		code := strings.TrimSpace(record[2])
-		companyName := strings.TrimSpace(record[3])
+	first, last := slices.MinFunc(dates, time.Time.Compare), slices.MaxFunc(dates, time.Time.Compare)
+	present, err := ingestedDates(db, first.Format("2006-01-02"), last.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
 
-		// Parse numeric values
-		open, _ := parseNumeric(record[4])
-		high, _ := parseNumeric(record[5])
-		low, _ := parseNumeric(record[6])
-		close, _ := parseNumeric(record[7])
-		volume, _ := parseInt(record[8])
-		previousClose, _ := parseNumeric(record[9])
+	missing := dates[:0:0]
+	for _, date := range dates {
+		if !present[date.Format("2006-01-02")] {
+			missing = append(missing, date)
+		}
+	}
+	slog.Info("Skipping dates already in the database", "skipped", len(dates)-len(missing), "missing", len(missing))
+	return missing, nil
+}
 
-		// Insert record
-		_, err = stmt.Exec(recordDate, symbol, code, companyName, open, high, low, close, volume, previousClose)
+// backloadChunkDays is how many days of a backload are committed together
+var backloadChunkDays = 20
+
+// backloadPrefetch is how many days a backload downloads and parses ahead of
+// the one it is storing
+var backloadPrefetch = 2
+
+// backloadData downloads and processes data for a list of dates. Downloads
+// and parsing run ahead in their own goroutine while the days before are
+// stored. Backends that support it keep their files open for the whole
+// backload and commit every backloadChunkDays days; a day only counts as
+// done, with its metrics, notifications and hooks, once its chunk is
+// committed.
+func backloadData(dates []time.Time, dbPath string) {
+	if deferIndexes {
+		rebuild, err := dropIndexesForBackload(dbPath)
 		if err != nil {
-			slog.Error("Failed to insert record", "error", err, "symbol", symbol, "date", date.Format("2006-01-02"))
-			errorCount++
-			continue
+			slog.Error("Failed to drop indexes for backload", "error", err)
+			return
 		}
-
-		recordCount++
+		defer rebuild()
+	}
+	st, err := openStore(dbPath)
+	if err != nil {
+		slog.Error("Failed to open store for backload", "error", err)
+		return
+	}
+	defer st.close()
+	chunkDays := 1
+	batch, batching := st.(batchStore)
+	if batching && backloadChunkDays > 1 {
+		batch.beginBatch()
+		chunkDays = backloadChunkDays
+	} else {
+		batching = false
 	}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	// Failed days wait for the chunk as well, recording them needs the
+	// write lock the open transaction holds
+	type pendingDay struct {
+		date    time.Time
+		metrics runMetrics
+		err     error
 	}
+	var pending []pendingDay
+	stored := 0
+	for f := range fetchDays(dates) {
+		metrics := f.metrics
+		err := f.err
+		if err == nil {
+			err = storeMarketData(dbPath, st, f.day, &metrics)
+		}
+		metrics.duration = time.Since(metrics.startedAt)
+		pending = append(pending, pendingDay{f.day.date, metrics, err})
+		stored++
 
-	slog.Info("Database operation completed",
-		"date", date.Format("2006-01-02"),
-		"recordsInserted", recordCount,
-		"errorCount", errorCount,
-		"filename", fileName)
+		if len(pending) < chunkDays && stored < len(dates) {
+			continue
+		}
+		var flushErr error
+		if batching {
+			flushErr = batch.flush()
+			if flushErr != nil {
+				slog.Error("Failed to commit backload chunk", "days", len(pending), "error", flushErr)
+			} else {
+				slog.Info("Committed backload chunk", "days", len(pending),
+					"from", pending[0].date.Format("2006-01-02"), "to", pending[len(pending)-1].date.Format("2006-01-02"))
+			}
+		}
+		for _, p := range pending {
+			if p.err == nil {
+				p.err = flushErr
+			}
+			logBackloadResult(p.date, completeIngest(p.date, dbPath, &p.metrics, p.err))
+		}
+		pending = pending[:0]
+	}
+}
 
-	slog.Info("Successfully processed market data", "date", date.Format("2006-01-02"))
-	return nil
+// fetchedDay is a day the download stage of a backload has finished with
+type fetchedDay struct {
+	day     ingestDay
+	metrics runMetrics
+	err     error
 }
 
-// Helper function to parse numeric values that handles both float and int
-func parseNumeric(s string) (float64, error) {
-	s = strings.TrimSpace(s)
-	if s == "" || s == "0" || s == "0.0" {
-		return 0.0, nil
-	}
-	return strconv.ParseFloat(s, 64)
+// fetchDays downloads and parses dates in order in the background, at most
+// backloadPrefetch days ahead of the reader
+func fetchDays(dates []time.Time) <-chan fetchedDay {
+	fetched := make(chan fetchedDay, max(backloadPrefetch-1, 0))
+	go func() {
+		defer close(fetched)
+		for _, date := range dates {
+			slog.Info("Starting backload for", "date", date.Format("2006-01-02"))
+			metrics := runMetrics{date: date.Format("2006-01-02"), startedAt: time.Now()}
+			day, err := fetchMarketData(date, &metrics)
+			fetched <- fetchedDay{day, metrics, err}
+		}
+	}()
+	return fetched
 }
 
-// Helper function specifically for parsing integers
-func parseInt(s string) (int, error) {
-	s = strings.TrimSpace(s)
-	if s == "" || s == "0" {
-		return 0, nil
+// logBackloadResult logs how the backload of a date ended
+func logBackloadResult(date time.Time, err error) {
+	if errors.Is(err, errMarketClosed) {
+		slog.Info("No market summary, the market was closed", "date", date.Format("2006-01-02"))
+	} else if err != nil {
+		slog.Error("Failed to backload data", "date", date.Format("2006-01-02"), "error", err)
+	} else {
+		slog.Info("Successfully backloaded date", "date", date.Format("2006-01-02"))
 	}
-	return strconv.Atoi(s)
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// runMetrics describes the performance of a single ingest run
+type runMetrics struct {
+	date          string
+	startedAt     time.Time
+	duration      time.Duration
+	downloadBytes int
+	downloadTime  time.Duration
+	parseTime     time.Duration
+	storeTime     time.Duration
+	records       int
+	errors        int
+	changes       rowChanges
+	// newSymbols and missingSymbols are those that came and went since the
+	// previous session
+	newSymbols, missingSymbols []string
+	status                     string
+	errorMessage               string
+}
+
+// finish completes the metrics once the run has ended. The duration of a
+// day stored in a batch is taken before it waits for the batch to commit.
+func (m *runMetrics) finish(err error) {
+	if m.duration == 0 {
+		m.duration = time.Since(m.startedAt)
+	}
+	m.status = "success"
+	switch {
+	case errors.Is(err, errMarketClosed):
+		m.status = "closed"
+		m.errorMessage = err.Error()
+	case errors.Is(err, errSuspectRowCount):
+		m.status = "suspect"
+		m.errorMessage = err.Error()
+	case err != nil:
+		m.status = "failed"
+		m.errorMessage = err.Error()
+	}
+}
+
+// rowsPerSecond is the parse throughput of the run
+func (m *runMetrics) rowsPerSecond() float64 {
+	if m.parseTime <= 0 {
+		return 0
+	}
+	return float64(m.records) / m.parseTime.Seconds()
+}
+
+// errorRate is the share of records that failed to parse or store
+func (m *runMetrics) errorRate() float64 {
+	total := m.records + m.errors
+	if total == 0 {
+		return 0
+	}
+	return float64(m.errors) / float64(total)
+}
+
+// saveRunMetrics stores the metrics of a run in the run_metrics table. Metrics
+// are best effort, a failure to store them is logged and otherwise ignored.
+func saveRunMetrics(dbPath string, m runMetrics) {
+	db, err := sharedDatabase(dbPath)
+	if err != nil {
+		slog.Warn("Failed to store run metrics", "date", m.date, "error", err)
+		return
+	}
+
+	_, err = db.Exec(`
+	INSERT INTO run_metrics (date, started_at, duration_ms, download_bytes, download_ms, parse_ms, store_ms,
+		records, errors, rows_per_second, error_rate, status, error, new_symbols, missing_symbols)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.date, m.startedAt.UTC().Format(time.RFC3339), m.duration.Milliseconds(), m.downloadBytes,
+		m.downloadTime.Milliseconds(), m.parseTime.Milliseconds(), m.storeTime.Milliseconds(), m.records, m.errors,
+		m.rowsPerSecond(), m.errorRate(), m.status, sql.NullString{String: m.errorMessage, Valid: m.errorMessage != ""},
+		strings.Join(m.newSymbols, ","), strings.Join(m.missingSymbols, ","))
+	if err != nil {
+		slog.Warn("Failed to store run metrics", "date", m.date, "error", err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// moduleRetryBase is the delay before the first retry of a failed module
+	moduleRetryBase = 15 * time.Minute
+	// moduleRetryMax caps the backoff between retries of a failed module
+	moduleRetryMax = 6 * time.Hour
+	// moduleMaxAttempts is how many times a module is tried for a single date
+	moduleMaxAttempts = 5
+)
+
+// module is an optional data collector (announcements, indices, futures, ...)
+// that runs after the core daily price load. Its failures are tracked
+// separately and never affect the core ingest.
+type module struct {
+	name    string
+	enabled bool
+	run     func(date time.Time, dbPath string) error
+	// schedule runs the module on its own, without it the module runs after
+	// every core ingest
+	schedule *cronSchedule
+
+	mu          sync.Mutex
+	failures    int
+	lastErr     error
+	lastSuccess time.Time
+	nextAttempt time.Time
+	// queued holds the dates that came in while the module was backed off,
+	// they run one after the other once the pending retry is done
+	queued []time.Time
+}
+
+var (
+	modulesMu sync.Mutex
+	modules   []*module
+)
+
+// registerModule adds an optional collector. Modules are enabled by default.
+func registerModule(name string, run func(date time.Time, dbPath string) error) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	modules = append(modules, &module{name: name, enabled: true, run: run})
+}
+
+// disableModules turns off the named modules, given as a comma separated list
+func disableModules(names string) error {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, m := range modules {
+			if m.name == name {
+				m.enabled = false
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown module %q", name)
+		}
+	}
+	return nil
+}
+
+// scheduleModules assigns the config file schedules to the named modules
+func scheduleModules(schedules map[string]string, loc *time.Location) error {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	for name, expr := range schedules {
+		if name == eodCollector {
+			continue
+		}
+		var target *module
+		for _, m := range modules {
+			if m.name == name {
+				target = m
+			}
+		}
+		if target == nil {
+			return fmt.Errorf("schedule for unknown collector %q", name)
+		}
+		schedule, err := parseCron(expr, loc)
+		if err != nil {
+			return fmt.Errorf("collector %s: %w", name, err)
+		}
+		target.schedule = schedule
+	}
+	return nil
+}
+
+// scheduledModules returns the enabled modules that have their own schedule
+func scheduledModules() []*module {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	var scheduled []*module
+	for _, m := range modules {
+		if m.enabled && m.schedule != nil {
+			scheduled = append(scheduled, m)
+		}
+	}
+	return scheduled
+}
+
+// runOptionalModules runs every enabled module without its own schedule for
+// the given date in the background. A failing module is logged, backed off,
+// and retried later. The returned WaitGroup completes once every module made
+// its first attempt.
+func runOptionalModules(date time.Time, dbPath string) *sync.WaitGroup {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	var wg sync.WaitGroup
+	for _, m := range modules {
+		if !m.enabled || m.schedule != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.attempt(date, dbPath, 1)
+		}()
+	}
+	return &wg
+}
+
+// attempt runs the module once and schedules a retry on failure
+func (m *module) attempt(date time.Time, dbPath string, attempt int) {
+	m.mu.Lock()
+	if wait := time.Until(m.nextAttempt); wait > 0 && attempt == 1 {
+		if !slices.ContainsFunc(m.queued, date.Equal) {
+			m.queued = append(m.queued, date)
+		}
+		queued, lastErr := len(m.queued), m.lastErr
+		m.mu.Unlock()
+		slog.Warn("Queued date behind the retry of unhealthy module", "module", m.name, "date", date.Format("2006-01-02"),
+			"retryIn", wait, "queued", queued, "lastError", lastErr)
+		return
+	}
+	m.mu.Unlock()
+
+	err := m.safeRun(date, dbPath)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		m.failures = 0
+		m.lastErr = nil
+		m.lastSuccess = time.Now()
+		m.nextAttempt = time.Time{}
+		slog.Info("Module completed", "module", m.name, "date", date.Format("2006-01-02"))
+		m.runNextQueued(dbPath, 0)
+		return
+	}
+
+	m.failures++
+	m.lastErr = err
+	backoff := moduleRetryBase << (m.failures - 1)
+	if backoff > moduleRetryMax || backoff <= 0 {
+		backoff = moduleRetryMax
+	}
+	m.nextAttempt = time.Now().Add(backoff)
+
+	if attempt >= moduleMaxAttempts {
+		slog.Warn("Module failed, giving up for date", "module", m.name, "date", date.Format("2006-01-02"), "attempts", attempt, "error", err)
+		raiseAlert(alert{
+			Level:   "warning",
+			Title:   "Module " + m.name + " failed",
+			Message: fmt.Sprintf("Gave up on %s after %d attempts: %v", date.Format("2006-01-02"), attempt, err),
+			Date:    date.Format("2006-01-02"),
+		})
+		// Queued dates get their own attempts once the backoff has passed
+		m.runNextQueued(dbPath, backoff)
+		return
+	}
+	slog.Warn("Module failed, will retry", "module", m.name, "date", date.Format("2006-01-02"), "attempt", attempt, "retryIn", backoff, "error", err)
+	time.AfterFunc(backoff, func() { m.attempt(date, dbPath, attempt+1) })
+}
+
+// runNextQueued attempts the oldest queued date after delay, the others
+// follow as each is done. m.mu must be held.
+func (m *module) runNextQueued(dbPath string, delay time.Duration) {
+	if len(m.queued) == 0 {
+		return
+	}
+	next := m.queued[0]
+	m.queued = m.queued[1:]
+	time.AfterFunc(delay, func() { m.attempt(next, dbPath, 1) })
+}
+
+// safeRun shields the caller from panics inside a module
+func (m *module) safeRun(date time.Time, dbPath string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("module panicked: %v", r)
+		}
+	}()
+	return m.run(date, dbPath)
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// swaggerUIVersion is the swagger-ui-dist release the /docs page loads
+const swaggerUIVersion = "5.17.14"
+
+// openAPIDocument describes the HTTP endpoints as OpenAPI 3. It is built at
+// runtime so the formats and the security requirement follow the registered
+// exporters and -require-api-key.
+func openAPIDocument() map[string]any {
+	formats := make([]string, 0, len(exporters))
+	for format := range exporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+
+	health := map[string]any{
+		"get": map[string]any{
+			"summary":  "Database health",
+			"tags":     []string{"health"},
+			"security": []any{},
+			"responses": map[string]any{
+				"200": jsonResponse("Healthy", "#/components/schemas/Health"),
+				"503": jsonResponse("Unavailable, or stale for /readyz", "#/components/schemas/Health"),
+			},
+		},
+	}
+	ready := map[string]any{"get": map[string]any{
+		"summary":   "Readiness, failing while the latest ingested date is older than -max-staleness",
+		"tags":      []string{"health"},
+		"security":  []any{},
+		"responses": health["get"].(map[string]any)["responses"],
+	}}
+
+	doc := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "PSX data downloader",
+			"description": "Pakistan Stock Exchange end of day prices stored by psx-data-downloader.",
+			"version":     "1.0.0",
+		},
+		"paths": map[string]any{
+			"/healthz": health,
+			"/readyz":  ready,
+			"/metrics": map[string]any{"get": map[string]any{
+				"summary": "Ingest statistics in the Prometheus text format",
+				"tags":    []string{"health"},
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "Metrics",
+						"content":     map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}},
+					},
+				},
+			}},
+			"/prices": map[string]any{"get": map[string]any{
+				"summary":     "List stored rows a page at a time",
+				"operationId": "listPrices",
+				"tags":        []string{"data"},
+				"parameters": append([]any{
+					symbolsParameter(),
+					sectorsParameter("Only rows of symbols in one of these sector codes on their day"),
+					dateParameter("from", "First date", false),
+					dateParameter("to", "Last date", false),
+					map[string]any{
+						"name": "min_volume", "in": "query", "description": "Only rows with at least this volume",
+						"schema": map[string]any{"type": "integer", "minimum": 0},
+					},
+					map[string]any{
+						"name": "adjusted", "in": "query", "description": "Back-adjust for the stored bonus issues and splits",
+						"schema": map[string]any{"type": "boolean", "default": false},
+					},
+					currencyParameter(),
+				}, pageParameters("date,symbol", "-volume")...),
+				"responses": map[string]any{
+					"200": jsonResponse("A page of rows", "#/components/schemas/PricePage"),
+					"400": errorResponse("Invalid parameters"),
+					"422": errorResponse("No exchange rate stored for a day of the page"),
+				},
+			}},
+			"/symbols": map[string]any{"get": map[string]any{
+				"summary":     "List the companies seen in the daily files a page at a time",
+				"operationId": "listSymbols",
+				"tags":        []string{"data"},
+				"parameters": append([]any{
+					symbolsParameter(),
+					sectorsParameter("Only companies in one of these sector codes on the last day they traded"),
+					dateParameter("from", "Only companies that traded on or after this date", false),
+					dateParameter("to", "Only companies that traded on or before this date", false),
+				}, pageParameters("symbol", "-last_seen")...),
+				"responses": map[string]any{
+					"200": jsonResponse("A page of companies", "#/components/schemas/SymbolPage"),
+					"400": errorResponse("Invalid parameters"),
+				},
+			}},
+			"/latest": map[string]any{"get": map[string]any{
+				"summary":     "List the most recent row of every symbol a page at a time",
+				"operationId": "listLatest",
+				"tags":        []string{"data"},
+				"parameters":  append([]any{symbolsParameter()}, pageParameters("symbol", "-change_percent")...),
+				"responses": map[string]any{
+					"200": jsonResponse("A page of latest prices", "#/components/schemas/LatestPage"),
+					"400": errorResponse("Invalid parameters"),
+				},
+			}},
+			"/indicators": map[string]any{"get": map[string]any{
+				"summary":     "List stored indicator values a page at a time",
+				"operationId": "listIndicators",
+				"tags":        []string{"data"},
+				"parameters": append([]any{
+					symbolsParameter(),
+					map[string]any{
+						"name": "names", "in": "query", "description": "Comma separated series, all when omitted",
+						"schema": map[string]any{"type": "string"}, "example": "atr_14,macd_12_26_9_signal",
+					},
+					dateParameter("from", "First date", false),
+					dateParameter("to", "Last date", false),
+				}, pageParameters("date,symbol,name", "-value")...),
+				"responses": map[string]any{
+					"200": jsonResponse("A page of indicator values", "#/components/schemas/IndicatorPage"),
+					"400": errorResponse("Invalid parameters"),
+				},
+			}},
+			"/actions": map[string]any{"get": map[string]any{
+				"summary":     "List the stored corporate actions",
+				"operationId": "listActions",
+				"tags":        []string{"data"},
+				"parameters": []any{
+					symbolsParameter(),
+					dateParameter("from", "First ex-date", false),
+					dateParameter("to", "Last ex-date", false),
+				},
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "The actions by symbol and ex-date",
+						"content": map[string]any{"application/json": map[string]any{"schema": map[string]any{
+							"type": "array", "items": map[string]any{"$ref": "#/components/schemas/Action"},
+						}}},
+					},
+					"400": errorResponse("Invalid dates"),
+				},
+			}},
+			"/anomalies": map[string]any{"get": map[string]any{
+				"summary":     "List the stored price anomalies",
+				"operationId": "listAnomalies",
+				"tags":        []string{"data"},
+				"parameters": []any{
+					symbolsParameter(),
+					dateParameter("from", "First date", false),
+					dateParameter("to", "Last date", false),
+				},
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "The anomalies by date and symbol",
+						"content": map[string]any{"application/json": map[string]any{"schema": map[string]any{
+							"type": "array", "items": map[string]any{"$ref": "#/components/schemas/Anomaly"},
+						}}},
+					},
+					"400": errorResponse("Invalid dates"),
+				},
+			}},
+			"/total-return": map[string]any{"get": map[string]any{
+				"summary":     "Total return index and trailing dividend yield of a symbol",
+				"operationId": "getTotalReturn",
+				"tags":        []string{"data"},
+				"parameters": []any{
+					map[string]any{
+						"name": "symbol", "in": "query", "required": true,
+						"schema": map[string]any{"type": "string"}, "example": "OGDC",
+					},
+					dateParameter("from", "First day, the index is 100 on it", false),
+					dateParameter("to", "Last day", false),
+				},
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "One point per stored day",
+						"content": map[string]any{"application/json": map[string]any{"schema": map[string]any{
+							"type": "array", "items": map[string]any{"$ref": "#/components/schemas/TotalReturn"},
+						}}},
+					},
+					"400": errorResponse("Missing symbol or invalid dates"),
+				},
+			}},
+			"/portfolios": map[string]any{
+				"get": map[string]any{
+					"summary":     "List the portfolios",
+					"operationId": "listPortfolios",
+					"tags":        []string{"portfolios"},
+					"responses": map[string]any{
+						"200": map[string]any{
+							"description": "Every portfolio by name",
+							"content": map[string]any{"application/json": map[string]any{"schema": map[string]any{
+								"type": "array", "items": map[string]any{"$ref": "#/components/schemas/Portfolio"},
+							}}},
+						},
+					},
+				},
+				"post": map[string]any{
+					"summary":     "Create a portfolio",
+					"operationId": "createPortfolio",
+					"tags":        []string{"portfolios"},
+					"requestBody": jsonBody("#/components/schemas/Portfolio"),
+					"responses": map[string]any{
+						"201": jsonResponse("The created portfolio", "#/components/schemas/Portfolio"),
+						"400": errorResponse("Invalid portfolio"),
+						"409": errorResponse("A portfolio of that name exists"),
+					},
+				},
+			},
+			"/portfolios/{name}": map[string]any{
+				"get": map[string]any{
+					"summary":     "Value a portfolio at the close of a day",
+					"operationId": "getPortfolio",
+					"tags":        []string{"portfolios"},
+					"parameters": []any{
+						portfolioParameter(),
+						dateParameter("date", "Day to value at, the latest stored when omitted", false),
+					},
+					"responses": map[string]any{
+						"200": jsonResponse("The valuation with every holding", "#/components/schemas/Valuation"),
+						"400": errorResponse("Invalid date"),
+						"404": errorResponse("No such portfolio"),
+					},
+				},
+				"patch": map[string]any{
+					"summary":     "Set the cash balance of a portfolio",
+					"operationId": "updatePortfolio",
+					"tags":        []string{"portfolios"},
+					"parameters":  []any{portfolioParameter()},
+					"requestBody": jsonBody("#/components/schemas/Portfolio"),
+					"responses": map[string]any{
+						"200": jsonResponse("The updated portfolio", "#/components/schemas/Portfolio"),
+						"400": errorResponse("Missing cash"),
+						"404": errorResponse("No such portfolio"),
+					},
+				},
+				"delete": map[string]any{
+					"summary":     "Delete a portfolio with its holdings",
+					"operationId": "deletePortfolio",
+					"tags":        []string{"portfolios"},
+					"parameters":  []any{portfolioParameter()},
+					"responses": map[string]any{
+						"204": map[string]any{"description": "Deleted"},
+						"404": errorResponse("No such portfolio"),
+					},
+				},
+			},
+			"/portfolios/{name}/history": map[string]any{"get": map[string]any{
+				"summary":     "Value a portfolio at the close of every trading day",
+				"operationId": "getPortfolioHistory",
+				"tags":        []string{"portfolios"},
+				"parameters": []any{
+					portfolioParameter(),
+					dateParameter("from", "First day", false),
+					dateParameter("to", "Last day", false),
+				},
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "One valuation per day, without the holdings",
+						"content": map[string]any{"application/json": map[string]any{"schema": map[string]any{
+							"type": "array", "items": map[string]any{"$ref": "#/components/schemas/Valuation"},
+						}}},
+					},
+					"400": errorResponse("Invalid dates"),
+					"404": errorResponse("No such portfolio"),
+				},
+			}},
+			"/portfolios/{name}/holdings": map[string]any{"post": map[string]any{
+				"summary":     "Add a holding to a portfolio",
+				"operationId": "addHolding",
+				"tags":        []string{"portfolios"},
+				"parameters":  []any{portfolioParameter()},
+				"requestBody": jsonBody("#/components/schemas/Holding"),
+				"responses": map[string]any{
+					"201": jsonResponse("The added holding with its id", "#/components/schemas/Holding"),
+					"400": errorResponse("Invalid holding"),
+					"404": errorResponse("No such portfolio"),
+				},
+			}},
+			"/portfolios/{name}/holdings/{id}": map[string]any{"delete": map[string]any{
+				"summary":     "Remove a holding from a portfolio",
+				"operationId": "removeHolding",
+				"tags":        []string{"portfolios"},
+				"parameters": []any{
+					portfolioParameter(),
+					map[string]any{"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "integer"}},
+				},
+				"responses": map[string]any{
+					"204": map[string]any{"description": "Removed"},
+					"404": errorResponse("No such portfolio or holding"),
+				},
+			}},
+			"/download": map[string]any{"get": map[string]any{
+				"summary":     "Download a date range as a zip with one file per stored day",
+				"operationId": "download",
+				"tags":        []string{"data"},
+				"parameters": []any{
+					dateParameter("from", "First date of the range", true),
+					dateParameter("to", "Last date of the range", true),
+					map[string]any{
+						"name": "format", "in": "query", "description": "Format of the files in the zip",
+						"schema": map[string]any{"type": "string", "enum": formats, "default": "csv"},
+					},
+					symbolsParameter(),
+					currencyParameter(),
+				},
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "Zip archive",
+						"content": map[string]any{"application/zip": map[string]any{
+							"schema": map[string]any{"type": "string", "format": "binary"},
+						}},
+					},
+					"400": errorResponse("Invalid dates or format"),
+					"404": errorResponse("No data stored in the range"),
+					"422": errorResponse("No exchange rate stored for a day of the range"),
+				},
+			}},
+		},
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Price": objectSchema(map[string]any{
+					"date":           map[string]any{"type": "string", "format": "date"},
+					"symbol":         map[string]any{"type": "string", "example": "OGDC"},
+					"code":           map[string]any{"type": "string"},
+					"company_name":   map[string]any{"type": "string"},
+					"open":           map[string]any{"type": "number"},
+					"high":           map[string]any{"type": "number"},
+					"low":            map[string]any{"type": "number"},
+					"close":          map[string]any{"type": "number"},
+					"volume":         map[string]any{"type": "integer", "format": "int64"},
+					"previous_close": map[string]any{"type": "number"},
+				}),
+				"Symbol": objectSchema(map[string]any{
+					"symbol":       map[string]any{"type": "string", "example": "OGDC"},
+					"code":         map[string]any{"type": "string"},
+					"company_name": map[string]any{"type": "string"},
+					"first_seen":   map[string]any{"type": "string", "format": "date"},
+					"last_seen":    map[string]any{"type": "string", "format": "date"},
+					"days":         map[string]any{"type": "integer", "description": "Stored days, 0 before the symbol summary was built"},
+					"high_52w":     map[string]any{"type": "number", "nullable": true},
+					"low_52w":      map[string]any{"type": "number", "nullable": true},
+				}),
+				"Latest": map[string]any{"allOf": []any{
+					map[string]any{"$ref": "#/components/schemas/Price"},
+					objectSchema(map[string]any{
+						"change":         map[string]any{"type": "number", "nullable": true},
+						"change_percent": map[string]any{"type": "number", "nullable": true, "example": 1.25},
+						"dividend_yield": map[string]any{"type": "number", "description": "Trailing 12 month yield in percent"},
+					}),
+				}},
+				"PricePage":  pageSchema("#/components/schemas/Price"),
+				"SymbolPage": pageSchema("#/components/schemas/Symbol"),
+				"LatestPage": pageSchema("#/components/schemas/Latest"),
+				"Indicator": objectSchema(map[string]any{
+					"date":   map[string]any{"type": "string", "format": "date"},
+					"symbol": map[string]any{"type": "string", "example": "OGDC"},
+					"name":   map[string]any{"type": "string", "example": "atr_14"},
+					"value":  map[string]any{"type": "number"},
+				}),
+				"IndicatorPage": pageSchema("#/components/schemas/Indicator"),
+				"Action": objectSchema(map[string]any{
+					"symbol":      map[string]any{"type": "string", "example": "OGDC"},
+					"ex_date":     map[string]any{"type": "string", "format": "date"},
+					"type":        map[string]any{"type": "string", "enum": []string{actionDividend, actionBonus, actionSplit}},
+					"value":       map[string]any{"type": "number"},
+					"description": map[string]any{"type": "string"},
+				}),
+				"Anomaly": objectSchema(map[string]any{
+					"date":           map[string]any{"type": "string", "format": "date"},
+					"symbol":         map[string]any{"type": "string", "example": "OGDC"},
+					"close":          map[string]any{"type": "number"},
+					"previous_close": map[string]any{"type": "number"},
+					"change_percent": map[string]any{"type": "number", "description": "Move from the previous close net of corporate actions"},
+					"zscore":         map[string]any{"type": "number", "nullable": true},
+					"reason":         map[string]any{"type": "string", "example": "change,zscore"},
+					"detected_at":    map[string]any{"type": "string", "format": "date-time"},
+				}),
+				"TotalReturn": objectSchema(map[string]any{
+					"date":               map[string]any{"type": "string", "format": "date"},
+					"symbol":             map[string]any{"type": "string", "example": "OGDC"},
+					"close":              map[string]any{"type": "number"},
+					"dividend":           map[string]any{"type": "number"},
+					"share_factor":       map[string]any{"type": "number"},
+					"dividend_yield":     map[string]any{"type": "number"},
+					"total_return_index": map[string]any{"type": "number"},
+				}),
+				"Portfolio": objectSchema(map[string]any{
+					"name":       map[string]any{"type": "string", "example": "main"},
+					"cash":       map[string]any{"type": "number"},
+					"created_at": map[string]any{"type": "string", "format": "date-time", "readOnly": true},
+				}),
+				"Holding": objectSchema(map[string]any{
+					"id":        map[string]any{"type": "integer", "readOnly": true},
+					"symbol":    map[string]any{"type": "string", "example": "OGDC"},
+					"buy_date":  map[string]any{"type": "string", "format": "date"},
+					"buy_price": map[string]any{"type": "number"},
+					"quantity":  map[string]any{"type": "integer", "format": "int64"},
+				}),
+				"HoldingValue": map[string]any{"allOf": []any{
+					map[string]any{"$ref": "#/components/schemas/Holding"},
+					objectSchema(map[string]any{
+						"price_date":   map[string]any{"type": "string", "format": "date"},
+						"close":        map[string]any{"type": "number", "nullable": true},
+						"cost":         map[string]any{"type": "number"},
+						"market_value": map[string]any{"type": "number"},
+						"pnl":          map[string]any{"type": "number"},
+						"pnl_percent":  map[string]any{"type": "number"},
+					}),
+				}},
+				"Valuation": objectSchema(map[string]any{
+					"portfolio":    map[string]any{"type": "string"},
+					"date":         map[string]any{"type": "string", "format": "date"},
+					"cash":         map[string]any{"type": "number"},
+					"cost":         map[string]any{"type": "number"},
+					"market_value": map[string]any{"type": "number"},
+					"value":        map[string]any{"type": "number"},
+					"pnl":          map[string]any{"type": "number"},
+					"pnl_percent":  map[string]any{"type": "number", "nullable": true},
+					"holdings": map[string]any{
+						"type": "array", "items": map[string]any{"$ref": "#/components/schemas/HoldingValue"},
+					},
+				}),
+				"Health": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"status":     map[string]any{"type": "string", "example": "ok"},
+						"database":   map[string]any{"type": "string", "example": "ok"},
+						"latestDate": map[string]any{"type": "string", "format": "date"},
+						"staleness":  map[string]any{"type": "string", "example": "26h0m0s"},
+						"error":      map[string]any{"type": "string"},
+					},
+					"required": []string{"status", "database"},
+				},
+			},
+		},
+		"tags": []any{
+			map[string]any{"name": "data", "description": "Stored market data"},
+			map[string]any{"name": "portfolios", "description": "Holdings valued at the stored prices"},
+			map[string]any{"name": "health", "description": "Probes and metrics"},
+		},
+	}
+
+	if prefix := cleanBasePath(basePath); prefix != "" {
+		doc["servers"] = []any{map[string]any{"url": prefix}}
+	}
+	if requireAPIKey {
+		doc["components"].(map[string]any)["securitySchemes"] = map[string]any{
+			"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+		}
+		doc["security"] = []any{map[string]any{"bearer": []string{}}, map[string]any{"apiKey": []string{}}}
+		for _, op := range operations(doc) {
+			if _, public := op["security"]; !public {
+				op["responses"].(map[string]any)["401"] = errorResponse("Missing or invalid API key")
+			}
+		}
+	} else {
+		// Without keys there is nothing to opt out of
+		for _, op := range operations(doc) {
+			delete(op, "security")
+		}
+	}
+	if rateLimitConfig.rate > 0 {
+		for path, p := range doc["paths"].(map[string]any) {
+			if path != "/healthz" && path != "/readyz" {
+				for _, op := range p.(map[string]any) {
+					op.(map[string]any)["responses"].(map[string]any)["429"] = errorResponse("Rate limit exceeded, retry after the Retry-After header")
+				}
+			}
+		}
+	}
+	return doc
+}
+
+// operations lists every operation of every path of the document
+func operations(doc map[string]any) []map[string]any {
+	var ops []map[string]any
+	for _, p := range doc["paths"].(map[string]any) {
+		for _, op := range p.(map[string]any) {
+			ops = append(ops, op.(map[string]any))
+		}
+	}
+	return ops
+}
+
+func dateParameter(name, description string, required bool) map[string]any {
+	return map[string]any{
+		"name": name, "in": "query", "description": description, "required": required,
+		"schema": map[string]any{"type": "string", "format": "date"}, "example": "2024-01-31",
+	}
+}
+
+func portfolioParameter() map[string]any {
+	return map[string]any{
+		"name": "name", "in": "path", "required": true, "description": "Name of the portfolio",
+		"schema": map[string]any{"type": "string"}, "example": "main",
+	}
+}
+
+func symbolsParameter() map[string]any {
+	return map[string]any{
+		"name": "symbols", "in": "query", "description": "Comma separated symbols, all when omitted",
+		"schema": map[string]any{"type": "string"}, "example": "OGDC,HBL",
+	}
+}
+
+func sectorsParameter(description string) map[string]any {
+	return map[string]any{
+		"name": "sectors", "in": "query", "description": description,
+		"schema": map[string]any{"type": "string"}, "example": "0807,0823",
+	}
+}
+
+func currencyParameter() map[string]any {
+	return map[string]any{
+		"name": "currency", "in": "query", "description": "Convert prices from PKR at the stored exchange rates of this currency",
+		"schema": map[string]any{"type": "string", "default": "PKR"}, "example": "USD",
+	}
+}
+
+// pageParameters describes the limit, offset and sort parameters of the list
+// endpoints
+func pageParameters(defaultSort, example string) []any {
+	return []any{
+		map[string]any{
+			"name": "limit", "in": "query", "description": "Page size",
+			"schema": map[string]any{"type": "integer", "minimum": 1, "maximum": maxPageLimit, "default": defaultPageLimit},
+		},
+		map[string]any{
+			"name": "offset", "in": "query", "description": "Rows to skip, the next field of a page holds the URL of the following one",
+			"schema": map[string]any{"type": "integer", "minimum": 0, "default": 0},
+		},
+		map[string]any{
+			"name": "sort", "in": "query", "description": "Comma separated columns, prefixed with - for descending",
+			"schema": map[string]any{"type": "string", "default": defaultSort}, "example": example,
+		},
+	}
+}
+
+func objectSchema(properties map[string]any) map[string]any {
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+func pageSchema(ref string) map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"data":   map[string]any{"type": "array", "items": map[string]any{"$ref": ref}},
+			"total":  map[string]any{"type": "integer"},
+			"limit":  map[string]any{"type": "integer"},
+			"offset": map[string]any{"type": "integer"},
+			"next":   map[string]any{"type": "string"},
+		},
+		"required": []string{"data", "total", "limit", "offset"},
+	}
+}
+
+func jsonResponse(description, ref string) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"application/json": map[string]any{"schema": map[string]any{"$ref": ref}}},
+	}
+}
+
+func jsonBody(ref string) map[string]any {
+	return map[string]any{
+		"required": true,
+		"content":  map[string]any{"application/json": map[string]any{"schema": map[string]any{"$ref": ref}}},
+	}
+}
+
+func errorResponse(description string) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}},
+	}
+}
+
+// serveOpenAPI writes the OpenAPI document
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(openAPIDocument())
+}
+
+// swaggerUIPage renders the document with Swagger UI loaded from a CDN, the
+// binary stays free of bundled assets
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>PSX data downloader API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui-bundle.js" crossorigin></script>
+<script>
+window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+
+// serveDocs writes the Swagger UI page
+func serveDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
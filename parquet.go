@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// Parquet constants from the parquet-format Thrift definitions
+const (
+	parquetTypeInt32     = 1
+	parquetTypeInt64     = 2
+	parquetTypeDouble    = 5
+	parquetTypeByteArray = 6
+	parquetRequired      = 0
+	parquetConvertedUTF8 = 0
+	parquetConvertedDate = 6
+	parquetLogicalString = 1
+	parquetLogicalDate   = 6
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+	parquetCodecGzip     = 2
+	parquetPageData      = 0
+	// parquetRowGroupRows is how many rows go into each row group
+	parquetRowGroupRows = 65536
+)
+
+// parquetMagic opens and closes a Parquet file
+var parquetMagic = []byte("PAR1")
+
+func init() {
+	registerExporter("parquet", "application/vnd.apache.parquet", writeParquet)
+}
+
+// parquetColumn describes one exported column and how to encode its values
+type parquetColumn struct {
+	name string
+	// physical is the Parquet type, logical the logical type annotating it
+	// and converted the same as the legacy converted type, 0 meaning none
+	physical  int32
+	logical   int16
+	converted int32
+	// values returns the PLAIN encoding of the column
+	values func(rows []exportRow) []byte
+}
+
+var parquetColumns = []parquetColumn{
+	{"date", parquetTypeInt32, parquetLogicalDate, parquetConvertedDate, func(rows []exportRow) []byte {
+		buf := make([]byte, 0, 4*len(rows))
+		for _, r := range rows {
+			var days int32
+			if t, err := time.Parse("2006-01-02", r.date); err == nil {
+				days = int32(t.Unix() / 86400)
+			}
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(days))
+		}
+		return buf
+	}},
+	{"symbol", parquetTypeByteArray, parquetLogicalString, parquetConvertedUTF8, parquetStrings(func(r exportRow) string { return r.symbol })},
+	{"code", parquetTypeByteArray, parquetLogicalString, parquetConvertedUTF8, parquetStrings(func(r exportRow) string { return r.code })},
+	{"company_name", parquetTypeByteArray, parquetLogicalString, parquetConvertedUTF8, parquetStrings(func(r exportRow) string { return r.companyName })},
+	{"open", parquetTypeDouble, 0, 0, parquetDoubles(func(r exportRow) float64 { return r.open })},
+	{"high", parquetTypeDouble, 0, 0, parquetDoubles(func(r exportRow) float64 { return r.high })},
+	{"low", parquetTypeDouble, 0, 0, parquetDoubles(func(r exportRow) float64 { return r.low })},
+	{"close", parquetTypeDouble, 0, 0, parquetDoubles(func(r exportRow) float64 { return r.close })},
+	{"volume", parquetTypeInt64, 0, 0, func(rows []exportRow) []byte {
+		buf := make([]byte, 0, 8*len(rows))
+		for _, r := range rows {
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(r.volume))
+		}
+		return buf
+	}},
+	{"previous_close", parquetTypeDouble, 0, 0, parquetDoubles(func(r exportRow) float64 { return r.previousClose })},
+}
+
+func parquetStrings(field func(exportRow) string) func([]exportRow) []byte {
+	return func(rows []exportRow) []byte {
+		var buf []byte
+		for _, r := range rows {
+			s := field(r)
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s)))
+			buf = append(buf, s...)
+		}
+		return buf
+	}
+}
+
+func parquetDoubles(field func(exportRow) float64) func([]exportRow) []byte {
+	return func(rows []exportRow) []byte {
+		buf := make([]byte, 0, 8*len(rows))
+		for _, r := range rows {
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(field(r)))
+		}
+		return buf
+	}
+}
+
+// parquetChunk is where a column chunk was written, for the footer
+type parquetChunk struct {
+	offset             int64
+	uncompressed, size int64
+}
+
+// writeParquet writes a Parquet file with required columns, one gzip
+// compressed PLAIN data page per column and row group of at most
+// parquetRowGroupRows rows. Without repeated or optional columns the pages
+// carry no repetition or definition levels.
+func writeParquet(w io.Writer, rows []exportRow, opts exportOptions) error {
+	if _, err := w.Write(parquetMagic); err != nil {
+		return err
+	}
+	offset := int64(len(parquetMagic))
+
+	var groups [][]parquetChunk
+	var groupRows []int
+	var compressed bytes.Buffer
+	for start := 0; start < len(rows); start += parquetRowGroupRows {
+		batch := rows[start:min(start+parquetRowGroupRows, len(rows))]
+		chunks := make([]parquetChunk, len(parquetColumns))
+		for i, col := range parquetColumns {
+			values := col.values(batch)
+			compressed.Reset()
+			zw := gzip.NewWriter(&compressed)
+			if _, err := zw.Write(values); err != nil {
+				return err
+			}
+			if err := zw.Close(); err != nil {
+				return err
+			}
+
+			var header thriftWriter
+			header.i32(1, parquetPageData)
+			header.i32(2, int32(len(values)))
+			header.i32(3, int32(compressed.Len()))
+			header.beginStruct(5)
+			header.i32(1, int32(len(batch)))
+			header.i32(2, parquetEncodingPlain)
+			header.i32(3, parquetEncodingRLE)
+			header.i32(4, parquetEncodingRLE)
+			header.endStruct()
+			header.stop()
+
+			if _, err := w.Write(header.buf); err != nil {
+				return err
+			}
+			if _, err := w.Write(compressed.Bytes()); err != nil {
+				return err
+			}
+			chunks[i] = parquetChunk{
+				offset:       offset,
+				uncompressed: int64(len(header.buf) + len(values)),
+				size:         int64(len(header.buf) + compressed.Len()),
+			}
+			offset += chunks[i].size
+		}
+		groups = append(groups, chunks)
+		groupRows = append(groupRows, len(batch))
+	}
+
+	footer := parquetFooter(len(rows), groups, groupRows)
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, parquetMagic...)
+	_, err := w.Write(footer)
+	return err
+}
+
+// parquetFooter encodes the FileMetaData of the written row groups
+func parquetFooter(numRows int, groups [][]parquetChunk, groupRows []int) []byte {
+	var t thriftWriter
+	t.i32(1, 1)
+
+	t.beginList(2, thriftStruct, len(parquetColumns)+1)
+	t.binary(4, "schema")
+	t.i32(5, int32(len(parquetColumns)))
+	t.stop()
+	for _, col := range parquetColumns {
+		t.i32(1, col.physical)
+		t.i32(3, parquetRequired)
+		t.binary(4, col.name)
+		if col.logical != 0 {
+			t.i32(6, col.converted)
+			t.beginStruct(10)
+			// The logical type is a union, the member's field holds an empty
+			// struct
+			t.beginStruct(col.logical)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.stop()
+	}
+	t.endList()
+
+	t.i64(3, int64(numRows))
+
+	t.beginList(4, thriftStruct, len(groups))
+	for g, chunks := range groups {
+		t.beginList(1, thriftStruct, len(chunks))
+		var total int64
+		for i, c := range chunks {
+			col := parquetColumns[i]
+			t.i64(2, c.offset)
+			t.beginStruct(3)
+			t.i32(1, col.physical)
+			t.beginList(2, thriftI32, 1)
+			t.zigzag(parquetEncodingPlain)
+			t.endList()
+			t.beginList(3, thriftBinary, 1)
+			t.rawBinary(col.name)
+			t.endList()
+			t.i32(4, parquetCodecGzip)
+			t.i64(5, int64(groupRows[g]))
+			t.i64(6, c.uncompressed)
+			t.i64(7, c.size)
+			t.i64(9, c.offset)
+			t.endStruct()
+			t.stop()
+			total += c.uncompressed
+		}
+		t.endList()
+		t.i64(2, total)
+		t.i64(3, int64(groupRows[g]))
+		t.stop()
+	}
+	t.endList()
+
+	t.binary(6, "psx-data-downloader")
+	t.stop()
+	return t.buf
+}
+
+// Thrift compact protocol type ids
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol, which
+// Parquet uses for its page headers and footer. Field ids are written as
+// deltas from the previous field of the same struct.
+type thriftWriter struct {
+	buf  []byte
+	last int16
+	// stack keeps the last field ids of the enclosing structs
+	stack []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.zigzag(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(v<<1)^uint64(v>>63))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.rawBinary(s)
+}
+
+func (t *thriftWriter) rawBinary(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// stop ends the current struct, also each struct element of a list
+func (t *thriftWriter) stop() {
+	t.buf = append(t.buf, 0)
+	t.last = 0
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+// beginList starts a list field, its elements follow. Struct elements each
+// end with stop.
+func (t *thriftWriter) beginList(id int16, elem byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf = append(t.buf, byte(size)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xf0|elem)
+		t.buf = binary.AppendUvarint(t.buf, uint64(size))
+	}
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) endList() {
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
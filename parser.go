@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// marketSummaryFeed is the daily market summary (mkt_summary) archive
+const marketSummaryFeed = "mkt_summary"
+
+// parser reads the file of a feed into records. It returns the valid records
+// and the number of lines it had to skip.
+type parser interface {
+	parse(data []byte, date time.Time) ([]parsedRecord, int)
+}
+
+// parserFunc lets a plain function be registered as a parser
+type parserFunc func(data []byte, date time.Time) ([]parsedRecord, int)
+
+func (f parserFunc) parse(data []byte, date time.Time) ([]parsedRecord, int) {
+	return f(data, date)
+}
+
+// parserKey identifies a file layout, versions count up from 1 as a feed's
+// layout changes
+type parserKey struct {
+	feed    string
+	version int
+}
+
+var parsers = map[parserKey]parser{}
+
+// registerParser adds the parser of one layout of feed. New layouts register
+// a higher version next to the old ones, which keep reading older files.
+func registerParser(feed string, version int, p parser) {
+	parsers[parserKey{feed, version}] = p
+}
+
+// parserFor returns the parser of a layout of feed, 0 selecting the latest
+func parserFor(feed string, version int) (parser, error) {
+	if version == 0 {
+		version = latestVersion(feed)
+	}
+	p, ok := parsers[parserKey{feed, version}]
+	if !ok {
+		return nil, fmt.Errorf("no parser for version %d of the %s feed", version, feed)
+	}
+	return p, nil
+}
+
+// summaryLayout holds the field index of every column in the lines of the
+// market summary
+type summaryLayout struct {
+	date, symbol, code, companyName, open, high, low, close, volume, previousClose int
+}
+
+// summaryColumns is the current layout, version 2, the summary_columns of
+// the config file override single entries when PSX moves them
+var summaryColumns = summaryLayout{
+	date: 0, symbol: 1, code: 2, companyName: 3, open: 4,
+	high: 5, low: 6, close: 7, volume: 8, previousClose: 9,
+}
+
+// legacySummaryColumns is version 1, found in older archives, which carry
+// the previous close ahead of the day's prices and the volume last
+var legacySummaryColumns = summaryLayout{
+	date: 0, symbol: 1, code: 2, companyName: 3, previousClose: 4,
+	open: 5, high: 6, low: 7, close: 8, volume: 9,
+}
+
+// field returns the index of a column by its market_data name
+func (l *summaryLayout) field(column string) *int {
+	switch column {
+	case "date":
+		return &l.date
+	case "symbol":
+		return &l.symbol
+	case "code":
+		return &l.code
+	case "company_name":
+		return &l.companyName
+	case "open":
+		return &l.open
+	case "high":
+		return &l.high
+	case "low":
+		return &l.low
+	case "close":
+		return &l.close
+	case "volume":
+		return &l.volume
+	case "previous_close":
+		return &l.previousClose
+	}
+	return nil
+}
+
+// minFields is the number of fields a line needs to hold every column
+func (l summaryLayout) minFields() int {
+	return max(l.date, l.symbol, l.code, l.companyName, l.open, l.high, l.low, l.close, l.volume, l.previousClose) + 1
+}
+
+// extraFields encodes the non-empty fields of a line that no column is
+// mapped to, keyed by their index, as stored in market_data.extra_fields
+func (l summaryLayout) extraFields(record []string) string {
+	mapped := map[int]bool{
+		l.date: true, l.symbol: true, l.code: true, l.companyName: true, l.open: true,
+		l.high: true, l.low: true, l.close: true, l.volume: true, l.previousClose: true,
+	}
+	extra := map[string]string{}
+	for i, field := range record {
+		if field = strings.TrimSpace(field); field != "" && !mapped[i] {
+			extra[strconv.Itoa(i)] = field
+		}
+	}
+	if len(extra) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(extra)
+	return string(data)
+}
+
+// applySummaryColumns sets the field indices of the config file, counted
+// from 0
+func applySummaryColumns(columns map[string]int) error {
+	for column, index := range columns {
+		field := summaryColumns.field(column)
+		if field == nil {
+			return fmt.Errorf("unknown column %q", column)
+		}
+		if index < 0 {
+			return fmt.Errorf("invalid field index %d for %s", index, column)
+		}
+		*field = index
+	}
+	return nil
+}
+
+// summaryParser reads the pipe separated market summary in one layout
+type summaryParser struct {
+	layout *summaryLayout
+}
+
+func (p summaryParser) parse(data []byte, date time.Time) ([]parsedRecord, int) {
+	return parseMarketSummary(data, date, *p.layout)
+}
+
+// detect returns the share of the first lines of data that read as plausible
+// prices in this layout: a valid date, an integer volume and a high no lower
+// than the open, close and low
+func (p summaryParser) detect(data []byte) float64 {
+	layout := *p.layout
+	lines := strings.SplitN(string(data), "\n", detectSampleLines+1)
+	sampled, matched := 0, 0
+	for _, line := range lines[:min(len(lines), detectSampleLines)] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		sampled++
+		fields := strings.Split(strings.TrimRight(line, "\r"), "|")
+		if len(fields) < layout.minFields() {
+			continue
+		}
+		if _, err := time.Parse("02Jan2006", strings.TrimSpace(fields[layout.date])); err != nil {
+			continue
+		}
+		if _, err := parseInt(fields[layout.volume]); err != nil {
+			continue
+		}
+		open, err1 := parseNumeric(fields[layout.open])
+		high, err2 := parseNumeric(fields[layout.high])
+		low, err3 := parseNumeric(fields[layout.low])
+		close, err4 := parseNumeric(fields[layout.close])
+		if err := errors.Join(err1, err2, err3, err4); err != nil {
+			continue
+		}
+		// Untraded symbols report zeros, which fit any layout
+		if high == 0 || (high >= open && high >= close && high >= low) {
+			matched++
+		}
+	}
+	if sampled == 0 {
+		return 0
+	}
+	return float64(matched) / float64(sampled)
+}
+
+// detectSampleLines is how many lines of a file detection looks at
+const detectSampleLines = 50
+
+// detector is implemented by parsers that can tell whether a file is in
+// their layout, scoring it from 0 to 1
+type detector interface {
+	detect(data []byte) float64
+}
+
+// summaryVersion pins the layout version of the files from From to To,
+// inclusive dates, for ranges where detection guesses wrong
+type summaryVersion struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Version int    `json:"version"`
+}
+
+// summaryVersions are the pinned ranges of the config file
+var summaryVersions []summaryVersion
+
+// applySummaryVersions checks the summary_versions of the config file
+func applySummaryVersions(versions []summaryVersion) error {
+	for _, v := range versions {
+		for _, date := range []string{v.From, v.To} {
+			if _, err := time.Parse("2006-01-02", date); err != nil {
+				return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+			}
+		}
+		if v.To < v.From {
+			return fmt.Errorf("range %s to %s ends before it starts", v.From, v.To)
+		}
+		if _, err := parserFor(marketSummaryFeed, v.Version); err != nil {
+			return err
+		}
+	}
+	summaryVersions = versions
+	return nil
+}
+
+// detectParser picks the parser of the layout date's file is in. A pinned
+// range wins, otherwise every version scores the file and the best one is
+// used, the latest winning ties and files no version reads at all.
+func detectParser(feed string, data []byte, date time.Time) (parser, int, error) {
+	day := date.Format("2006-01-02")
+	if feed == marketSummaryFeed {
+		for _, v := range summaryVersions {
+			if v.From <= day && day <= v.To {
+				p, err := parserFor(feed, v.Version)
+				return p, v.Version, err
+			}
+		}
+	}
+
+	best, bestScore := 0, 0.0
+	for key, p := range parsers {
+		d, ok := p.(detector)
+		if key.feed != feed || !ok {
+			continue
+		}
+		score := d.detect(data)
+		if score > bestScore || (score == bestScore && score > 0 && key.version > best) {
+			best, bestScore = key.version, score
+		}
+	}
+	p, err := parserFor(feed, best)
+	if err != nil {
+		return nil, 0, err
+	}
+	if best == 0 {
+		best = latestVersion(feed)
+	}
+	return p, best, nil
+}
+
+// latestVersion returns the highest registered version of feed
+func latestVersion(feed string) int {
+	latest := 0
+	for key := range parsers {
+		if key.feed == feed && key.version > latest {
+			latest = key.version
+		}
+	}
+	return latest
+}
+
+// parseRecords parses a market summary file in the layout detected for it
+// and passes the records through the -transform script. The count includes
+// records the script failed on.
+func parseRecords(data []byte, date time.Time) ([]parsedRecord, int, error) {
+	p, version, err := detectParser(marketSummaryFeed, data, date)
+	if err != nil {
+		return nil, 0, err
+	}
+	if latest := latestVersion(marketSummaryFeed); version != latest {
+		slog.Info("Reading market summary in an older layout", "date", date.Format("2006-01-02"), "version", version, "latest", latest)
+	}
+	records, errorCount := p.parse(data, date)
+	if transformScript == "" {
+		return records, errorCount, nil
+	}
+
+	t, err := loadTransform(transformScript)
+	if err != nil {
+		return nil, 0, err
+	}
+	records, dropped, failed := t.apply(records)
+	if dropped > 0 || failed > 0 {
+		slog.Info("Transform script applied", "date", date.Format("2006-01-02"), "dropped", dropped, "failed", failed)
+	}
+	return records, errorCount + failed, nil
+}
+
+func init() {
+	registerParser(marketSummaryFeed, 1, summaryParser{&legacySummaryColumns})
+	registerParser(marketSummaryFeed, 2, summaryParser{&summaryColumns})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Lines of the same prices in the current layout and in the legacy one,
+// which moves the previous close ahead of the prices and the volume last
+const (
+	currentLine  = "02Jan2024|OGDC|0001|OIL & GAS DEV|120.50|123.75|119.10|122.40|1500300|120.05"
+	currentLine2 = "02Jan2024|PPL|0002|PAKISTAN PETROLEUM|98.00|99.20|97.55|98.90|870000|97.80"
+	legacyLine   = "02Jan2024|OGDC|0001|OIL & GAS DEV|120.05|120.50|123.75|119.10|122.40|1500300"
+	legacyLine2  = "02Jan2024|PPL|0002|PAKISTAN PETROLEUM|97.80|98.00|99.20|97.55|98.90|870000"
+	// untradedLine reports zeros, which fit any layout
+	untradedLine = "02Jan2024|XYZ|0003|UNTRADED CO|0|0|0|0|0|0"
+)
+
+func summaryLines(lines ...string) []byte {
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func repeatLine(line string, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = line
+	}
+	return lines
+}
+
+func TestSummaryParserDetect(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            []byte
+		current, legacy float64
+	}{
+		{"current layout", summaryLines(currentLine, currentLine2), 1, 0},
+		{"legacy layout", summaryLines(legacyLine, legacyLine2), 0, 1},
+		{"untraded symbols", summaryLines(untradedLine, untradedLine), 1, 1},
+		{"untraded among traded", summaryLines(currentLine, untradedLine), 1, 0.5},
+		{"crlf and blank lines", []byte(currentLine + "\r\n\r\n" + currentLine2 + "\r\n"), 1, 0},
+		{"high below the close", summaryLines(currentLine, "02Jan2024|OGDC|0001|OIL & GAS DEV|120.50|121.00|119.10|122.40|1500300|120.05"), 0.5, 0},
+		{"invalid date", summaryLines("2024-01-02|OGDC|0001|OIL & GAS DEV|120.50|123.75|119.10|122.40|1500300|120.05"), 0, 0},
+		{"too few fields", summaryLines("02Jan2024|OGDC|0001|OIL & GAS DEV|120.50|123.75|119.10|122.40|1500300"), 0, 0},
+		{"not prices", summaryLines("<html>", "<body>Not Found</body>", "</html>"), 0, 0},
+		{"empty", nil, 0, 0},
+		{"only the first lines are sampled", summaryLines(append(repeatLine(currentLine, detectSampleLines), repeatLine(legacyLine, detectSampleLines)...)...), 1, 0},
+	}
+
+	current := summaryParser{&summaryColumns}
+	legacy := summaryParser{&legacySummaryColumns}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := current.detect(tt.data); got != tt.current {
+				t.Errorf("current layout detect = %v, want %v", got, tt.current)
+			}
+			if got := legacy.detect(tt.data); got != tt.legacy {
+				t.Errorf("legacy layout detect = %v, want %v", got, tt.legacy)
+			}
+		})
+	}
+}
+
+func TestDetectParser(t *testing.T) {
+	date := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		data     []byte
+		versions []summaryVersion
+		want     int
+	}{
+		{"current layout", summaryLines(currentLine, currentLine2), nil, 2},
+		{"legacy layout", summaryLines(legacyLine, legacyLine2), nil, 1},
+		{"ties go to the latest", summaryLines(untradedLine), nil, 2},
+		{"unreadable files get the latest", summaryLines("garbage"), nil, 2},
+		{"a pinned range wins", summaryLines(currentLine, currentLine2), []summaryVersion{{From: "2024-01-01", To: "2024-01-31", Version: 1}}, 1},
+		{"pinned ranges elsewhere are ignored", summaryLines(legacyLine, legacyLine2), []summaryVersion{{From: "2023-01-01", To: "2023-12-31", Version: 2}}, 1},
+	}
+
+	saved := summaryVersions
+	t.Cleanup(func() { summaryVersions = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summaryVersions = tt.versions
+			_, version, err := detectParser(marketSummaryFeed, tt.data, date)
+			if err != nil {
+				t.Fatalf("detectParser: %v", err)
+			}
+			if version != tt.want {
+				t.Errorf("detectParser picked version %d, want %d", version, tt.want)
+			}
+		})
+	}
+}
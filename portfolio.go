@@ -0,0 +1,377 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Errors of the portfolio operations, the API answers them with 404, 409 and
+// 400
+var (
+	errNoPortfolio      = errors.New("no such portfolio")
+	errNoHolding        = errors.New("no such holding")
+	errPortfolioExists  = errors.New("portfolio already exists")
+	errInvalidPortfolio = errors.New("invalid portfolio")
+)
+
+// portfolio is a named set of holdings with a cash balance. Portfolios live
+// in the -db file itself, also when the data is sharded by year.
+type portfolio struct {
+	Name      string  `json:"name"`
+	Cash      float64 `json:"cash"`
+	CreatedAt string  `json:"created_at"`
+}
+
+// holding is a purchase of a symbol
+type holding struct {
+	ID       int64   `json:"id"`
+	Symbol   string  `json:"symbol"`
+	BuyDate  string  `json:"buy_date"`
+	BuyPrice float64 `json:"buy_price"`
+	Quantity int64   `json:"quantity"`
+}
+
+// validate normalises the symbol and checks the purchase makes sense
+func (h *holding) validate() error {
+	h.Symbol = strings.ToUpper(strings.TrimSpace(h.Symbol))
+	if !isSymbol(h.Symbol) {
+		return fmt.Errorf("%w: symbol %q", errInvalidPortfolio, h.Symbol)
+	}
+	if _, err := time.Parse("2006-01-02", h.BuyDate); err != nil {
+		return fmt.Errorf("%w: buy date %q, expected YYYY-MM-DD", errInvalidPortfolio, h.BuyDate)
+	}
+	if h.BuyPrice <= 0 {
+		return fmt.Errorf("%w: buy price must be positive", errInvalidPortfolio)
+	}
+	if h.Quantity <= 0 {
+		return fmt.Errorf("%w: quantity must be positive", errInvalidPortfolio)
+	}
+	return nil
+}
+
+// holdingValue is a holding valued at the close of a day
+type holdingValue struct {
+	holding
+	// PriceDate is the day of the close used, the last one stored up to the
+	// valuation date. Holdings without a stored close are valued at cost.
+	PriceDate   string   `json:"price_date,omitempty"`
+	Close       *float64 `json:"close"`
+	Cost        float64  `json:"cost"`
+	MarketValue float64  `json:"market_value"`
+	PnL         float64  `json:"pnl"`
+	PnLPercent  float64  `json:"pnl_percent"`
+}
+
+// valuation is a portfolio valued at the close of a day. Value is the market
+// value of the holdings plus the cash, PnL is measured against their cost.
+type valuation struct {
+	Portfolio   string         `json:"portfolio"`
+	Date        string         `json:"date"`
+	Cash        float64        `json:"cash"`
+	Cost        float64        `json:"cost"`
+	MarketValue float64        `json:"market_value"`
+	Value       float64        `json:"value"`
+	PnL         float64        `json:"pnl"`
+	PnLPercent  *float64       `json:"pnl_percent"`
+	Holdings    []holdingValue `json:"holdings,omitempty"`
+}
+
+// createPortfolio adds an empty portfolio with a cash balance
+func createPortfolio(db *sql.DB, name string, cash float64) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("%w: missing name", errInvalidPortfolio)
+	}
+	_, err := db.Exec("INSERT INTO portfolios (name, cash, created_at) VALUES (?, ?, ?)",
+		name, cash, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return fmt.Errorf("%w: %q", errPortfolioExists, name)
+		}
+		return fmt.Errorf("failed to create portfolio: %w", err)
+	}
+	return nil
+}
+
+// deletePortfolio removes a portfolio with its holdings
+func deletePortfolio(db *sql.DB, name string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM portfolio_holdings WHERE portfolio = ?", name); err != nil {
+		return fmt.Errorf("failed to delete holdings: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM portfolios WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to delete portfolio: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w %q", errNoPortfolio, name)
+	}
+	return tx.Commit()
+}
+
+// setPortfolioCash replaces the cash balance of a portfolio
+func setPortfolioCash(db *sql.DB, name string, cash float64) error {
+	result, err := db.Exec("UPDATE portfolios SET cash = ? WHERE name = ?", cash, name)
+	if err != nil {
+		return fmt.Errorf("failed to update cash: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w %q", errNoPortfolio, name)
+	}
+	return nil
+}
+
+// addHolding records a purchase in a portfolio and returns its id
+func addHolding(db *sql.DB, name string, h *holding) (int64, error) {
+	if err := h.validate(); err != nil {
+		return 0, err
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM portfolios WHERE name = ?)", name).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("failed to look up portfolio: %w", err)
+	}
+	if !exists {
+		return 0, fmt.Errorf("%w %q", errNoPortfolio, name)
+	}
+	result, err := db.Exec(`INSERT INTO portfolio_holdings (portfolio, symbol, buy_date, buy_price, quantity, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, name, h.Symbol, h.BuyDate, h.BuyPrice, h.Quantity, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, fmt.Errorf("failed to add holding: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// removeHolding deletes a holding of a portfolio, e.g. once it is sold
+func removeHolding(db *sql.DB, name string, id int64) error {
+	result, err := db.Exec("DELETE FROM portfolio_holdings WHERE portfolio = ? AND id = ?", name, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove holding: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w %d in portfolio %q", errNoHolding, id, name)
+	}
+	return nil
+}
+
+// listPortfolios returns every portfolio by name
+func listPortfolios(db *sql.DB) ([]portfolio, error) {
+	rows, err := db.Query("SELECT name, cash, created_at FROM portfolios ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list portfolios: %w", err)
+	}
+	defer rows.Close()
+
+	portfolios := []portfolio{}
+	for rows.Next() {
+		var p portfolio
+		if err := rows.Scan(&p.Name, &p.Cash, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to list portfolios: %w", err)
+		}
+		portfolios = append(portfolios, p)
+	}
+	return portfolios, rows.Err()
+}
+
+// loadPortfolio returns a portfolio with its holdings in purchase order
+func loadPortfolio(db *sql.DB, name string) (portfolio, []holding, error) {
+	p := portfolio{Name: name}
+	err := db.QueryRow("SELECT cash, created_at FROM portfolios WHERE name = ?", name).Scan(&p.Cash, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return p, nil, fmt.Errorf("%w %q", errNoPortfolio, name)
+	}
+	if err != nil {
+		return p, nil, fmt.Errorf("failed to load portfolio: %w", err)
+	}
+
+	rows, err := db.Query(`SELECT id, symbol, buy_date, buy_price, quantity FROM portfolio_holdings
+		WHERE portfolio = ? ORDER BY buy_date, id`, name)
+	if err != nil {
+		return p, nil, fmt.Errorf("failed to load holdings: %w", err)
+	}
+	defer rows.Close()
+
+	holdings := []holding{}
+	for rows.Next() {
+		var h holding
+		if err := rows.Scan(&h.ID, &h.Symbol, &h.BuyDate, &h.BuyPrice, &h.Quantity); err != nil {
+			return p, nil, fmt.Errorf("failed to load holdings: %w", err)
+		}
+		holdings = append(holdings, h)
+	}
+	return p, holdings, rows.Err()
+}
+
+// holdingSymbols lists the distinct symbols of the holdings
+func holdingSymbols(holdings []holding) []string {
+	seen := map[string]bool{}
+	var symbols []string
+	for _, h := range holdings {
+		if !seen[h.Symbol] {
+			seen[h.Symbol] = true
+			symbols = append(symbols, h.Symbol)
+		}
+	}
+	return symbols
+}
+
+// valuePortfolio values the holdings bought up to date at the last close
+// stored for each of them up to that day. An empty date values at the latest
+// stored day.
+func valuePortfolio(prices *sql.DB, p portfolio, holdings []holding, date string) (valuation, error) {
+	if date == "" {
+		var latest sql.NullString
+		if err := prices.QueryRow("SELECT MAX(date) FROM market_data").Scan(&latest); err != nil {
+			return valuation{}, fmt.Errorf("failed to find the latest date: %w", err)
+		}
+		date = latest.String
+		if date == "" {
+			date = time.Now().Format("2006-01-02")
+		}
+	}
+
+	type lastClose struct {
+		date  string
+		price *float64
+	}
+	closes := map[string]lastClose{}
+	v := valuation{Portfolio: p.Name, Date: date, Cash: p.Cash, Holdings: []holdingValue{}}
+	for _, h := range holdings {
+		if h.BuyDate > date {
+			continue
+		}
+		c, ok := closes[h.Symbol]
+		if !ok {
+			var price float64
+			err := prices.QueryRow(`SELECT date, close FROM market_data
+				WHERE symbol = ? AND date <= ? AND close > 0 ORDER BY date DESC LIMIT 1`, h.Symbol, date).Scan(&c.date, &price)
+			if err != nil && err != sql.ErrNoRows {
+				return v, fmt.Errorf("failed to look up the close of %s: %w", h.Symbol, err)
+			}
+			if err == nil {
+				c.price = &price
+			}
+			closes[h.Symbol] = c
+		}
+		hv := holdingValue{holding: h, PriceDate: c.date, Close: c.price}
+		v.Holdings = append(v.Holdings, hv.priced())
+	}
+	for _, hv := range v.Holdings {
+		v.Cost += hv.Cost
+		v.MarketValue += hv.MarketValue
+	}
+	v.total()
+	return v, nil
+}
+
+// priced fills in the cost, market value and P&L of the holding
+func (hv holdingValue) priced() holdingValue {
+	hv.Cost = hv.BuyPrice * float64(hv.Quantity)
+	hv.MarketValue = hv.Cost
+	if hv.Close != nil {
+		hv.MarketValue = *hv.Close * float64(hv.Quantity)
+	}
+	hv.PnL = roundAmount(hv.MarketValue - hv.Cost)
+	hv.PnLPercent = roundPercent(hv.PnL / hv.Cost)
+	return hv
+}
+
+// total derives the value and P&L from the cost and market value
+func (v *valuation) total() {
+	v.Cost, v.MarketValue = roundAmount(v.Cost), roundAmount(v.MarketValue)
+	v.Value = roundAmount(v.MarketValue + v.Cash)
+	v.PnL = roundAmount(v.MarketValue - v.Cost)
+	v.PnLPercent = nil
+	if v.Cost > 0 {
+		pct := roundPercent(v.PnL / v.Cost)
+		v.PnLPercent = &pct
+	}
+}
+
+// roundAmount rounds away the float noise of summing rupee amounts
+func roundAmount(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
+
+// roundPercent turns a fraction into a percentage rounded to 4 decimals
+func roundPercent(fraction float64) float64 {
+	return math.Round(fraction*100*10000) / 10000
+}
+
+// portfolioHistory values the portfolio at the close of every trading day
+// from from to to. The cash balance is the current one on every day, only
+// the holdings follow the purchases.
+func portfolioHistory(prices *sql.DB, p portfolio, holdings []holding, from, to string) ([]valuation, error) {
+	history := []valuation{}
+	if len(holdings) == 0 {
+		return history, nil
+	}
+	if first := holdings[0].BuyDate; from < first {
+		from = first
+	}
+
+	symbols := holdingSymbols(holdings)
+	rows, err := prices.Query(`SELECT date, symbol, close FROM market_data
+		WHERE symbol IN (?`+strings.Repeat(", ?", len(symbols)-1)+`) AND date >= ? AND date <= ? AND close > 0`,
+		append(toArgs(symbols), holdings[0].BuyDate, to)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query closes: %w", err)
+	}
+	defer rows.Close()
+	closes := map[string]map[string]float64{}
+	for rows.Next() {
+		var date, symbol string
+		var price float64
+		if err := rows.Scan(&date, &symbol, &price); err != nil {
+			return nil, fmt.Errorf("failed to read closes: %w", err)
+		}
+		if closes[date] == nil {
+			closes[date] = map[string]float64{}
+		}
+		closes[date][symbol] = price
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read closes: %w", err)
+	}
+	dates := make([]string, 0, len(closes))
+	for date := range closes {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	// Walk the days carrying each symbol's last close forward
+	last := map[string]float64{}
+	for _, date := range dates {
+		for symbol, price := range closes[date] {
+			last[symbol] = price
+		}
+		if date < from {
+			continue
+		}
+		v := valuation{Portfolio: p.Name, Date: date, Cash: p.Cash}
+		for _, h := range holdings {
+			if h.BuyDate > date {
+				break
+			}
+			hv := holdingValue{holding: h}
+			if price, ok := last[h.Symbol]; ok {
+				hv.Close = &price
+			}
+			hv = hv.priced()
+			v.Cost += hv.Cost
+			v.MarketValue += hv.MarketValue
+		}
+		v.total()
+		history = append(history, v)
+	}
+	return history, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registerPortfolioRoutes adds the portfolio endpoints. Changes to the
+// holdings drop the cached responses as soon as they are committed, other
+// processes changing them are noticed through the version of the -db file.
+func registerPortfolioRoutes(mux *http.ServeMux, cache *responseCache, dbPath string) {
+	mux.HandleFunc("GET /portfolios", cache.cached(func(w http.ResponseWriter, r *http.Request) {
+		db, err := openReadOnlyDatabase(dbPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer db.Close()
+		portfolios, err := listPortfolios(db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, portfolios)
+	}))
+	mux.HandleFunc("GET /portfolios/{name}", cache.cached(func(w http.ResponseWriter, r *http.Request) {
+		date := r.URL.Query().Get("date")
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			http.Error(w, "invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		v, err := loadValuation(dbPath, r.PathValue("name"), date)
+		if err != nil {
+			writePortfolioError(w, err)
+			return
+		}
+		writeJSON(w, v)
+	}))
+	mux.HandleFunc("GET /portfolios/{name}/history", cache.cached(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from, to := q.Get("from"), q.Get("to")
+		for _, date := range []string{from, to} {
+			if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+				http.Error(w, "invalid from or to date, expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+		if to == "" {
+			to = "9999-12-31"
+		}
+		history, err := loadHistory(dbPath, r.PathValue("name"), from, to)
+		if err != nil {
+			writePortfolioError(w, err)
+			return
+		}
+		writeJSON(w, history)
+	}))
+
+	mux.HandleFunc("POST /portfolios", func(w http.ResponseWriter, r *http.Request) {
+		var body portfolio
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		writePortfolioChange(w, cache, dbPath, http.StatusCreated, func(db *sql.DB) (any, error) {
+			if err := createPortfolio(db, body.Name, body.Cash); err != nil {
+				return nil, err
+			}
+			p, _, err := loadPortfolio(db, body.Name)
+			return p, err
+		})
+	})
+	mux.HandleFunc("PATCH /portfolios/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Cash *float64 `json:"cash"`
+		}
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		if body.Cash == nil {
+			http.Error(w, "missing cash", http.StatusBadRequest)
+			return
+		}
+		writePortfolioChange(w, cache, dbPath, http.StatusOK, func(db *sql.DB) (any, error) {
+			if err := setPortfolioCash(db, r.PathValue("name"), *body.Cash); err != nil {
+				return nil, err
+			}
+			p, _, err := loadPortfolio(db, r.PathValue("name"))
+			return p, err
+		})
+	})
+	mux.HandleFunc("DELETE /portfolios/{name}", func(w http.ResponseWriter, r *http.Request) {
+		writePortfolioChange(w, cache, dbPath, http.StatusNoContent, func(db *sql.DB) (any, error) {
+			return nil, deletePortfolio(db, r.PathValue("name"))
+		})
+	})
+	mux.HandleFunc("POST /portfolios/{name}/holdings", func(w http.ResponseWriter, r *http.Request) {
+		var h holding
+		if !decodeBody(w, r, &h) {
+			return
+		}
+		writePortfolioChange(w, cache, dbPath, http.StatusCreated, func(db *sql.DB) (any, error) {
+			id, err := addHolding(db, r.PathValue("name"), &h)
+			h.ID = id
+			return h, err
+		})
+	})
+	mux.HandleFunc("DELETE /portfolios/{name}/holdings/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid holding id", http.StatusBadRequest)
+			return
+		}
+		writePortfolioChange(w, cache, dbPath, http.StatusNoContent, func(db *sql.DB) (any, error) {
+			return nil, removeHolding(db, r.PathValue("name"), id)
+		})
+	})
+}
+
+// decodeBody reads a JSON request body into v, answering 400 when it is not
+// valid
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writePortfolioChange applies a change to the portfolios of the -db file
+// and answers with its result
+func writePortfolioChange(w http.ResponseWriter, cache *responseCache, dbPath string, status int, change func(db *sql.DB) (any, error)) {
+	db, err := sharedDatabase(dbPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	result, err := change(db)
+	if err != nil {
+		writePortfolioError(w, err)
+		return
+	}
+	cache.reset()
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// writePortfolioError answers with the status matching an error of the
+// portfolio operations
+func writePortfolioError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNoPortfolio), errors.Is(err, errNoHolding):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errPortfolioExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errInvalidPortfolio):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
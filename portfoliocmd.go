@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runPortfolioCommand dispatches the "portfolio" subcommands
+func runPortfolioCommand(dbPath string, args []string) error {
+	const commands = "create, list, delete, cash, add, remove, show or history"
+	if len(args) == 0 {
+		return fmt.Errorf("missing portfolio command, expected %s", commands)
+	}
+
+	switch args[0] {
+	case "create":
+		return portfolioCreateCommand(dbPath, args[1:])
+	case "list":
+		return portfolioListCommand(dbPath)
+	case "delete":
+		return portfolioDeleteCommand(dbPath, args[1:])
+	case "cash":
+		return portfolioCashCommand(dbPath, args[1:])
+	case "add":
+		return portfolioAddCommand(dbPath, args[1:])
+	case "remove":
+		return portfolioRemoveCommand(dbPath, args[1:])
+	case "show":
+		return portfolioShowCommand(dbPath, args[1:])
+	case "history":
+		return portfolioHistoryCommand(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown portfolio command %q, expected %s", args[0], commands)
+	}
+}
+
+// parsePortfolioFlags parses the flags of a subcommand, which all need -name
+func parsePortfolioFlags(fs *flag.FlagSet, args []string) (string, error) {
+	name := fs.String("name", "", "Name of the portfolio")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if *name == "" {
+		return "", errors.New("missing -name of the portfolio")
+	}
+	return *name, nil
+}
+
+func portfolioCreateCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("portfolio create", flag.ContinueOnError)
+	cash := fs.Float64("cash", 0, "Cash balance in PKR")
+	name, err := parsePortfolioFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := createPortfolio(db, name, *cash); err != nil {
+		return err
+	}
+	slog.Info("Created portfolio", "name", name, "cash", *cash)
+	return nil
+}
+
+func portfolioListCommand(dbPath string) error {
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	portfolios, err := listPortfolios(db)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "name\tcash\tcreated_at")
+	for _, p := range portfolios {
+		fmt.Fprintf(tw, "%s\t%.2f\t%s\n", p.Name, p.Cash, p.CreatedAt)
+	}
+	return tw.Flush()
+}
+
+func portfolioDeleteCommand(dbPath string, args []string) error {
+	name, err := parsePortfolioFlags(flag.NewFlagSet("portfolio delete", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := deletePortfolio(db, name); err != nil {
+		return err
+	}
+	slog.Info("Deleted portfolio", "name", name)
+	return nil
+}
+
+func portfolioCashCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("portfolio cash", flag.ContinueOnError)
+	set := fs.String("set", "", "New cash balance in PKR")
+	name, err := parsePortfolioFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	cash, err := strconv.ParseFloat(*set, 64)
+	if err != nil {
+		return fmt.Errorf("invalid or missing -set %q, expected the cash balance", *set)
+	}
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := setPortfolioCash(db, name, cash); err != nil {
+		return err
+	}
+	slog.Info("Updated portfolio cash", "name", name, "cash", cash)
+	return nil
+}
+
+func portfolioAddCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("portfolio add", flag.ContinueOnError)
+	var h holding
+	fs.StringVar(&h.Symbol, "symbol", "", "Symbol bought")
+	fs.StringVar(&h.BuyDate, "date", time.Now().Format("2006-01-02"), "Day of the purchase (YYYY-MM-DD)")
+	fs.Float64Var(&h.BuyPrice, "price", 0, "Price paid per share")
+	fs.Int64Var(&h.Quantity, "quantity", 0, "Number of shares")
+	name, err := parsePortfolioFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	id, err := addHolding(db, name, &h)
+	if err != nil {
+		return err
+	}
+	slog.Info("Added holding", "portfolio", name, "id", id, "symbol", h.Symbol, "quantity", h.Quantity)
+	return nil
+}
+
+func portfolioRemoveCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("portfolio remove", flag.ContinueOnError)
+	id := fs.Int64("id", 0, "Id of the holding, as printed by portfolio show")
+	name, err := parsePortfolioFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := removeHolding(db, name, *id); err != nil {
+		return err
+	}
+	slog.Info("Removed holding", "portfolio", name, "id", *id)
+	return nil
+}
+
+// portfolioShowCommand prints the holdings of a portfolio valued at a day
+func portfolioShowCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("portfolio show", flag.ContinueOnError)
+	date := fs.String("date", "", "Day to value at (YYYY-MM-DD), the latest stored when empty")
+	format := fs.String("format", "table", "Output format: table or json")
+	name, err := parsePortfolioFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	v, err := loadValuation(dbPath, name, *date)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(v)
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "id\tsymbol\tbuy_date\tquantity\tbuy_price\tclose\tcost\tmarket_value\tpnl\tpnl_percent\t")
+		for _, h := range v.Holdings {
+			price := "-"
+			if h.Close != nil {
+				price = fmt.Sprintf("%.2f", *h.Close)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%.2f\t%s\t%.2f\t%.2f\t%.2f\t%.2f\t\n", h.ID, h.Symbol, h.BuyDate,
+				h.Quantity, h.BuyPrice, price, h.Cost, h.MarketValue, h.PnL, h.PnLPercent)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("\n%s on %s: value %.2f (holdings %.2f, cash %.2f), P&L %.2f%s\n", v.Portfolio, v.Date,
+			v.Value, v.MarketValue, v.Cash, v.PnL, formatPercent(v.PnLPercent))
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, expected table or json", *format)
+	}
+}
+
+// portfolioHistoryCommand prints the daily valuation of a portfolio
+func portfolioHistoryCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("portfolio history", flag.ContinueOnError)
+	from := fs.String("from", "0000-01-01", "First day (YYYY-MM-DD)")
+	to := fs.String("to", "9999-12-31", "Last day (YYYY-MM-DD)")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	name, err := parsePortfolioFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	history, err := loadHistory(dbPath, name, *from, *to)
+	if err != nil {
+		return err
+	}
+	return writeHistory(os.Stdout, history, *format)
+}
+
+// loadValuation values a portfolio of the -db file against the stored prices
+func loadValuation(dbPath, name, date string) (valuation, error) {
+	db, err := openReadOnlyDatabase(dbPath)
+	if err != nil {
+		return valuation{}, err
+	}
+	defer db.Close()
+	p, holdings, err := loadPortfolio(db, name)
+	if err != nil {
+		return valuation{}, err
+	}
+
+	prices, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return valuation{}, err
+	}
+	defer prices.Close()
+	return valuePortfolio(prices, p, holdings, date)
+}
+
+// loadHistory values a portfolio of the -db file on every day from from to to
+func loadHistory(dbPath, name, from, to string) ([]valuation, error) {
+	db, err := openReadOnlyDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	p, holdings, err := loadPortfolio(db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	prices, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer prices.Close()
+	return portfolioHistory(prices, p, holdings, from, to)
+}
+
+// writeHistory writes one line per day of a portfolio history
+func writeHistory(w io.Writer, history []valuation, format string) error {
+	header := []string{"date", "cash", "cost", "market_value", "value", "pnl", "pnl_percent"}
+	cells := func(v valuation) []string {
+		pct := ""
+		if v.PnLPercent != nil {
+			pct = strconv.FormatFloat(*v.PnLPercent, 'f', 4, 64)
+		}
+		return []string{v.Date, formatAmount(v.Cash), formatAmount(v.Cost), formatAmount(v.MarketValue),
+			formatAmount(v.Value), formatAmount(v.PnL), pct}
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		for _, v := range history {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		for _, v := range history {
+			cw.Write(cells(v))
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+		for _, v := range history {
+			fmt.Fprintln(tw, strings.Join(cells(v), "\t")+"\t")
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown format %q, expected table, csv or json", format)
+	}
+}
+
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func formatPercent(pct *float64) string {
+	if pct == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (%+.2f%%)", *pct)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// maxDBSize caps the size of the database files in bytes, 0 leaves them
+// unbounded
+var maxDBSize int64
+
+const (
+	// defaultDayBytes is the growth estimated for one ingested day while the
+	// database holds too little data to measure it
+	defaultDayBytes = 256 << 10
+	// preflightHeadroom is kept free on top of the estimate for the journal,
+	// temporary files and the indexes SQLite rebuilds
+	preflightHeadroom = 64 << 20
+)
+
+// databaseSize sums the files of the database including their write-ahead
+// logs, missing files count as empty
+func databaseSize(dbPath string) (int64, error) {
+	files, err := databaseFiles(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	if shardByYear {
+		// Keys, the retry queue and other bookkeeping stay in -db itself
+		files = append(files, dbPath)
+	}
+	var size int64
+	for _, file := range files {
+		for _, name := range []string{file, file + "-wal"} {
+			info, err := os.Stat(name)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+// estimateDayBytes measures how much the database grows per stored day from
+// the days it already holds
+func estimateDayBytes(dbPath string, size int64) int64 {
+	if size == 0 {
+		return defaultDayBytes
+	}
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return defaultDayBytes
+	}
+	defer db.Close()
+
+	var days int64
+	if err := db.QueryRow("SELECT COUNT(DISTINCT date) FROM market_data").Scan(&days); err != nil || days < 5 {
+		return defaultDayBytes
+	}
+	return max(size/days, defaultDayBytes/4)
+}
+
+// preflightBackload fails early when the disk, or -max-db-size, has no room
+// for the days about to be backloaded, rather than letting the backload die
+// part way through on a full disk
+func preflightBackload(dbPath string, days int) error {
+	if days == 0 {
+		return nil
+	}
+	size, err := databaseSize(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to measure the database: %w", err)
+	}
+	perDay := estimateDayBytes(dbPath, size)
+	needed := perDay * int64(days)
+
+	dir, err := filepath.Abs(filepath.Dir(dbPath))
+	if err != nil {
+		return err
+	}
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		// Some filesystems cannot report their space, the limit still applies
+		slog.Warn("Failed to check free disk space", "dir", dir, "error", err)
+	} else if uint64(needed+preflightHeadroom) > free {
+		return fmt.Errorf("backloading %d days needs about %s but only %s is free in %s, free up space or backload a shorter range",
+			days, formatBytes(needed+preflightHeadroom), formatBytes(int64(free)), dir)
+	}
+	if maxDBSize > 0 && size+needed > maxDBSize {
+		return fmt.Errorf("backloading %d days would grow the database from %s to about %s, over -max-db-size of %s",
+			days, formatBytes(size), formatBytes(size+needed), formatBytes(maxDBSize))
+	}
+	slog.Info("Preflight check passed", "days", days, "estimate", formatBytes(needed), "databaseSize", formatBytes(size))
+	return nil
+}
+
+// checkDBSizeLimit refuses further ingests once the database has reached
+// -max-db-size
+func checkDBSizeLimit(dbPath string) error {
+	if maxDBSize <= 0 {
+		return nil
+	}
+	size, err := databaseSize(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to measure the database: %w", err)
+	}
+	if size >= maxDBSize {
+		return fmt.Errorf("database is %s, at or over -max-db-size of %s", formatBytes(size), formatBytes(maxDBSize))
+	}
+	return nil
+}
+
+// formatBytes renders a size in binary units, e.g. 1.5 GiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// listedFund is an ETF or REIT traded on PSX whose premium or discount to
+// NAV is tracked, configured in the listed_funds list of the config file.
+// Fund is its name in fund_navs, where MUFAP puts the NAVs of ETFs and
+// funds import -file those of REIT disclosures.
+type listedFund struct {
+	Symbol string `json:"symbol"`
+	Type   string `json:"type"`
+	Fund   string `json:"fund"`
+}
+
+// listedFunds are the tracked ETFs and REITs of the config file
+var listedFunds []listedFund
+
+// applyListedFunds validates and installs the listed_funds of the config file
+func applyListedFunds(list []listedFund) error {
+	seen := map[string]bool{}
+	for i, f := range list {
+		list[i].Symbol = strings.ToUpper(strings.TrimSpace(f.Symbol))
+		if list[i].Symbol == "" || strings.TrimSpace(f.Fund) == "" {
+			return fmt.Errorf("listed fund %d: missing symbol or fund", i+1)
+		}
+		if f.Type != "etf" && f.Type != "reit" {
+			return fmt.Errorf("listed fund %s: invalid type %q, expected etf or reit", list[i].Symbol, f.Type)
+		}
+		if seen[list[i].Symbol] {
+			return fmt.Errorf("listed fund %s: listed twice", list[i].Symbol)
+		}
+		seen[list[i].Symbol] = true
+	}
+	listedFunds = list
+	return nil
+}
+
+// fundPremium is a row of the fund_premiums table, the close of a listed
+// fund against the latest NAV on or before its day
+type fundPremium struct {
+	date           string
+	symbol         string
+	typ            string
+	close          float64
+	nav            float64
+	navDate        string
+	premiumPercent float64
+}
+
+// updateFundPremiums computes the premiums of the listed funds for the days
+// from from to to, returning how many rows it stored
+func updateFundPremiums(dbPath, from, to string) (int, error) {
+	if len(listedFunds) == 0 {
+		return 0, nil
+	}
+	db, err := sharedQueryDatabase(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	var premiums []fundPremium
+	for _, f := range listedFunds {
+		p, err := listedFundPremiums(db, f, from, to)
+		if err != nil {
+			return 0, err
+		}
+		premiums = append(premiums, p...)
+	}
+	return storeFundPremiums(dbPath, premiums)
+}
+
+// listedFundPremiums pairs the stored closes of f with its NAVs. Days before
+// the first stored NAV have no premium.
+func listedFundPremiums(db *sql.DB, f listedFund, from, to string) ([]fundPremium, error) {
+	rows, err := db.Query(`SELECT date, nav FROM fund_navs WHERE fund = ? COLLATE NOCASE AND date <= ? ORDER BY date`, f.Fund, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query NAVs of %s: %w", f.Fund, err)
+	}
+	var navDates []string
+	var navs []float64
+	for rows.Next() {
+		var date string
+		var nav float64
+		if err := rows.Scan(&date, &nav); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read NAVs of %s: %w", f.Fund, err)
+		}
+		navDates = append(navDates, date)
+		navs = append(navs, nav)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read NAVs of %s: %w", f.Fund, err)
+	}
+	if len(navs) == 0 {
+		return nil, nil
+	}
+
+	rows, err = db.Query(`SELECT date, close FROM market_data
+		WHERE symbol = ? AND date >= ? AND date <= ? AND close > 0 ORDER BY date`, f.Symbol, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query closes of %s: %w", f.Symbol, err)
+	}
+	defer rows.Close()
+	var premiums []fundPremium
+	for rows.Next() {
+		p := fundPremium{symbol: f.Symbol, typ: f.Type}
+		if err := rows.Scan(&p.date, &p.close); err != nil {
+			return nil, fmt.Errorf("failed to read closes of %s: %w", f.Symbol, err)
+		}
+		i := sort.SearchStrings(navDates, p.date)
+		if i < len(navDates) && navDates[i] == p.date {
+			i++
+		}
+		if i == 0 || navs[i-1] <= 0 {
+			continue
+		}
+		p.nav, p.navDate = navs[i-1], navDates[i-1]
+		p.premiumPercent = math.Round((p.close/p.nav-1)*100*100) / 100
+		premiums = append(premiums, p)
+	}
+	return premiums, rows.Err()
+}
+
+// storeFundPremiums upserts premiums, each in the shard of its date
+func storeFundPremiums(dbPath string, premiums []fundPremium) (int, error) {
+	byPath := map[string][]fundPremium{}
+	var paths []string
+	for _, p := range premiums {
+		day, err := time.Parse("2006-01-02", p.date)
+		if err != nil {
+			return 0, err
+		}
+		path := marketDBPath(dbPath, day)
+		if _, ok := byPath[path]; !ok {
+			paths = append(paths, path)
+		}
+		byPath[path] = append(byPath[path], p)
+	}
+
+	stored := 0
+	for _, path := range paths {
+		db, err := sharedDatabase(path)
+		if err != nil {
+			return stored, err
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return stored, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		for _, p := range byPath[path] {
+			_, err := tx.Exec(`INSERT INTO fund_premiums (date, symbol, type, close, nav, nav_date, premium_percent)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(symbol, date) DO UPDATE SET type = excluded.type, close = excluded.close, nav = excluded.nav,
+					nav_date = excluded.nav_date, premium_percent = excluded.premium_percent`,
+				p.date, p.symbol, p.typ, p.close, p.nav, p.navDate, p.premiumPercent)
+			if err != nil {
+				tx.Rollback()
+				return stored, fmt.Errorf("failed to store premium of %s on %s: %w", p.symbol, p.date, err)
+			}
+			stored++
+		}
+		if err := tx.Commit(); err != nil {
+			return stored, fmt.Errorf("failed to commit fund premiums: %w", err)
+		}
+	}
+	return stored, nil
+}
+
+// refreshFundPremiums recomputes the premiums from the first day of navs on,
+// a new NAV applies to every day until the next one
+func refreshFundPremiums(dbPath string, navs []fundNAV) {
+	if len(navs) == 0 || len(listedFunds) == 0 {
+		return
+	}
+	from := navs[0].date
+	for _, n := range navs {
+		from = min(from, n.date)
+	}
+	if stored, err := updateFundPremiums(dbPath, from, "9999-12-31"); err != nil {
+		slog.Warn("Failed to update fund premiums", "from", from, "error", err)
+	} else {
+		slog.Info("Updated fund premiums", "from", from, "rows", stored)
+	}
+}
+
+// printFundPremiums lists the stored premiums of the listed funds
+func printFundPremiums(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("funds premiums", flag.ContinueOnError)
+	from := fs.String("from", "", "First day (YYYY-MM-DD), defaults to the latest stored")
+	to := fs.String("to", "", "Last day (YYYY-MM-DD)")
+	symbols := fs.String("symbols", "", "Comma separated symbols, all listed funds when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	query := "SELECT date, symbol, type, close, nav, nav_date, premium_percent FROM fund_premiums WHERE "
+	var queryArgs []any
+	if *from == "" {
+		query += "date = (SELECT MAX(date) FROM fund_premiums)"
+	} else {
+		query += "date >= ?"
+		queryArgs = append(queryArgs, *from)
+	}
+	if *to != "" {
+		query += " AND date <= ?"
+		queryArgs = append(queryArgs, *to)
+	}
+	if list := parseSymbolList(*symbols); len(list) > 0 {
+		query += " AND symbol IN (?" + strings.Repeat(", ?", len(list)-1) + ")"
+		queryArgs = append(queryArgs, toArgs(list)...)
+	}
+	rows, err := db.Query(query+" ORDER BY date, symbol", queryArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to query fund premiums: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p fundPremium
+		if err := rows.Scan(&p.date, &p.symbol, &p.typ, &p.close, &p.nav, &p.navDate, &p.premiumPercent); err != nil {
+			return fmt.Errorf("failed to read fund premiums: %w", err)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\t%s%%\n", p.date, p.symbol, p.typ, formatFloat(p.close), formatFloat(p.nav), p.navDate, formatFloat(p.premiumPercent))
+	}
+	return rows.Err()
+}
+
+// rebuildFundPremiums recomputes the premiums of a range, after the
+// listed_funds config changed
+func rebuildFundPremiums(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("funds refresh-premiums", flag.ContinueOnError)
+	from := fs.String("from", "0000-01-01", "First day (YYYY-MM-DD)")
+	to := fs.String("to", "9999-12-31", "Last day (YYYY-MM-DD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(listedFunds) == 0 {
+		return errors.New("no listed_funds in the config file")
+	}
+	stored, err := updateFundPremiums(dbPath, *from, *to)
+	if err != nil {
+		return err
+	}
+	slog.Info("Updated fund premiums", "from", *from, "to", *to, "rows", stored)
+	return nil
+}
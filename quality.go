@@ -0,0 +1,233 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// qualityMonth is the coverage of a month of a quality report. Days count
+// those of the month within the range reported on.
+type qualityMonth struct {
+	Month        string  `json:"month"`
+	ExpectedDays int     `json:"expected_days"`
+	PresentDays  int     `json:"present_days"`
+	Coverage     float64 `json:"coverage_percent"`
+	AvgSymbols   float64 `json:"avg_symbols"`
+	Records      int     `json:"records"`
+	ParseErrors  int     `json:"parse_errors"`
+	ErrorRate    float64 `json:"error_rate_percent"`
+	// MissingDates are trading days without rows, ExtraDates days with rows
+	// the calendar has as closed
+	MissingDates []string `json:"missing_dates"`
+	ExtraDates   []string `json:"extra_dates"`
+	// SuspiciousDates have a row count outside half to one and a half times
+	// the median of the range, like the check command
+	SuspiciousDates []string `json:"suspicious_dates"`
+}
+
+// qualityReport writes the per month coverage of the stored data
+func qualityReport(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("report quality", flag.ContinueOnError)
+	from := fs.String("from", "", "First day (YYYY-MM-DD), defaults to the earliest stored date")
+	to := fs.String("to", "", "Last day (YYYY-MM-DD), defaults to the latest stored date")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	out := fs.String("out", "-", "File to write, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := loadHolidays(dbPath); err != nil {
+		return err
+	}
+
+	var first, last sql.NullString
+	if err := db.QueryRow("SELECT MIN(date), MAX(date) FROM market_data").Scan(&first, &last); err != nil {
+		return fmt.Errorf("failed to query stored date range: %w", err)
+	}
+	if *from == "" {
+		*from = first.String
+	}
+	if *to == "" {
+		*to = last.String
+	}
+	if *from == "" || *to == "" {
+		return errors.New("no data to report on")
+	}
+	months, err := qualityMonths(db, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"month", "expected_days", "present_days", "missing_days", "extra_days", "coverage_percent",
+		"avg_symbols", "records", "parse_errors", "error_rate_percent", "suspicious_days"}
+	cells := func(m qualityMonth) []string {
+		return []string{m.Month, strconv.Itoa(m.ExpectedDays), strconv.Itoa(m.PresentDays), strconv.Itoa(len(m.MissingDates)),
+			strconv.Itoa(len(m.ExtraDates)), formatFloat(m.Coverage), formatFloat(m.AvgSymbols), strconv.Itoa(m.Records),
+			strconv.Itoa(m.ParseErrors), formatFloat(m.ErrorRate), strconv.Itoa(len(m.SuspiciousDates))}
+	}
+	return writeReport(*out, func(w io.Writer) error {
+		switch *format {
+		case "json":
+			enc := json.NewEncoder(w)
+			for _, m := range months {
+				if err := enc.Encode(m); err != nil {
+					return err
+				}
+			}
+			return nil
+		case "csv":
+			cw := csv.NewWriter(w)
+			cw.Write(header)
+			for _, m := range months {
+				cw.Write(cells(m))
+			}
+			cw.Flush()
+			return cw.Error()
+		case "table":
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+			fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+			for _, m := range months {
+				fmt.Fprintln(tw, strings.Join(cells(m), "\t")+"\t")
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			// The dates behind the counts, which the table has no room for
+			for _, m := range months {
+				for _, list := range []struct {
+					name  string
+					dates []string
+				}{{"missing", m.MissingDates}, {"extra", m.ExtraDates}, {"suspicious", m.SuspiciousDates}} {
+					if len(list.dates) > 0 {
+						fmt.Fprintf(w, "%s %s: %s\n", m.Month, list.name, strings.Join(list.dates, ", "))
+					}
+				}
+			}
+			return nil
+		default:
+			return fmt.Errorf("unknown format %q, expected table, csv or json", *format)
+		}
+	})
+}
+
+// qualityMonths summarises the months from from to to
+func qualityMonths(db *sql.DB, from, to string) ([]qualityMonth, error) {
+	start, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -from date: %w", err)
+	}
+	end, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -to date: %w", err)
+	}
+	counts, err := symbolCounts(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+	logged, err := ingestErrors(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	byMonth := map[string]*qualityMonth{}
+	month := func(day string) *qualityMonth {
+		m, ok := byMonth[day[:7]]
+		if !ok {
+			m = &qualityMonth{Month: day[:7], MissingDates: []string{}, ExtraDates: []string{}, SuspiciousDates: []string{}}
+			byMonth[day[:7]] = m
+		}
+		return m
+	}
+	expected := map[string]bool{}
+	for _, date := range backloadDates(start, end.AddDate(0, 0, 1)) {
+		day := date.Format("2006-01-02")
+		expected[day] = true
+		m := month(day)
+		m.ExpectedDays++
+		if counts[day] == 0 {
+			m.MissingDates = append(m.MissingDates, day)
+		}
+	}
+
+	median := medianCount(counts)
+	days := make([]string, 0, len(counts))
+	for day := range counts {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	for _, day := range days {
+		m, n := month(day), counts[day]
+		if expected[day] {
+			m.PresentDays++
+		} else {
+			m.ExtraDates = append(m.ExtraDates, day)
+		}
+		m.AvgSymbols += float64(n)
+		if n < median/2 || n > median*3/2 {
+			m.SuspiciousDates = append(m.SuspiciousDates, day)
+		}
+	}
+	for day, l := range logged {
+		m := month(day)
+		m.Records += l.records
+		m.ParseErrors += l.errors
+	}
+
+	months := make([]qualityMonth, 0, len(byMonth))
+	for _, m := range byMonth {
+		if stored := m.PresentDays + len(m.ExtraDates); stored > 0 {
+			m.AvgSymbols = math.Round(m.AvgSymbols/float64(stored)*10) / 10
+		}
+		if m.ExpectedDays > 0 {
+			m.Coverage = math.Round(float64(m.PresentDays)/float64(m.ExpectedDays)*100*100) / 100
+		}
+		if m.Records+m.ParseErrors > 0 {
+			m.ErrorRate = math.Round(float64(m.ParseErrors)/float64(m.Records+m.ParseErrors)*100*100) / 100
+		}
+		months = append(months, *m)
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Month < months[j].Month })
+	return months, nil
+}
+
+// loggedIngest is how many lines the last ingest of a day parsed and failed
+// to parse
+type loggedIngest struct {
+	records, errors int
+}
+
+// ingestErrors reads the last ingest_log entry of every day in the range
+func ingestErrors(db *sql.DB, from, to string) (map[string]loggedIngest, error) {
+	rows, err := db.Query(`SELECT date, records, errors FROM ingest_log
+		WHERE date >= ? AND date <= ? ORDER BY date, finished_at`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ingest log: %w", err)
+	}
+	defer rows.Close()
+	logged := map[string]loggedIngest{}
+	for rows.Next() {
+		var day string
+		var l loggedIngest
+		if err := rows.Scan(&day, &l.records, &l.errors); err != nil {
+			return nil, fmt.Errorf("failed to read ingest log: %w", err)
+		}
+		logged[day] = l
+	}
+	return logged, rows.Err()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// runQueryCommand runs a SQL query, or the shorthand of a symbol's latest
+// rows, against the database and prints the result
+func runQueryCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	last := fs.Int("last", 30, "Number of most recent days shown for a symbol")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New(`expected a single query such as "SELECT ..." or a symbol`)
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	query, queryArgs := positional[0], []any{}
+	if isSymbol(query) {
+		query = `SELECT * FROM (
+			SELECT date, symbol, open, high, low, close, volume, previous_close
+			FROM market_data WHERE symbol = ? ORDER BY date DESC LIMIT ?
+		) ORDER BY date`
+		queryArgs = []any{strings.ToUpper(positional[0]), *last}
+	}
+
+	rows, err := db.Query(query, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+	return writeRows(os.Stdout, rows, *format)
+}
+
+// parseInterspersed parses flags given before, between or after positional
+// arguments and returns the positional ones
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// isSymbol tells a bare ticker such as OGDC apart from a SQL statement
+func isSymbol(text string) bool {
+	return text != "" && !strings.ContainsAny(text, " \t\n;()*=")
+}
+
+// writeRows prints every row of the result in the requested format
+func writeRows(w io.Writer, rows *sql.Rows, format string) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("failed to read columns: %w", err)
+	}
+
+	var emit func(values []any) error
+	var flush func() error
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(columns, "\t"))
+		emit = func(values []any) error {
+			cells := make([]string, len(values))
+			for i, v := range values {
+				cells[i] = formatValue(v)
+			}
+			_, err := fmt.Fprintln(tw, strings.Join(cells, "\t"))
+			return err
+		}
+		flush = tw.Flush
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return err
+		}
+		emit = func(values []any) error {
+			cells := make([]string, len(values))
+			for i, v := range values {
+				cells[i] = formatValue(v)
+			}
+			return cw.Write(cells)
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case "json":
+		enc := json.NewEncoder(w)
+		emit = func(values []any) error {
+			obj := make(map[string]any, len(values))
+			for i, v := range values {
+				obj[columns[i]] = v
+			}
+			return enc.Encode(obj)
+		}
+		flush = func() error { return nil }
+	default:
+		return fmt.Errorf("unknown format %q, expected table, csv or json", format)
+	}
+
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return fmt.Errorf("failed to read row: %w", err)
+		}
+		for i, v := range values {
+			// Text comes back as bytes from some expressions
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		if err := emit(values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read rows: %w", err)
+	}
+	return flush()
+}
+
+// formatValue renders a column value for text output, NULL as an empty string
+func formatValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitOptions configures the per client token buckets of the HTTP
+// server. A rate of 0 disables limiting.
+type rateLimitOptions struct {
+	rate  float64
+	burst int
+}
+
+var rateLimitConfig = rateLimitOptions{burst: 20}
+
+// rateLimiterMaxIdle is how long an untouched bucket is kept, a bucket idle
+// that long has refilled anyway
+const rateLimiterMaxIdle = 10 * time.Minute
+
+// tokenBucket holds the tokens left for one client
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter hands out tokens per client key
+type rateLimiter struct {
+	opts rateLimitOptions
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(opts rateLimitOptions) *rateLimiter {
+	return &rateLimiter{opts: opts, buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+// allow takes a token for key. When none is left it returns how long until
+// the next one is available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimiterMaxIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > rateLimiterMaxIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.opts.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.opts.burst), b.tokens+now.Sub(b.last).Seconds()*l.opts.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.opts.rate * float64(time.Second))
+	return false, wait
+}
+
+// validatedKeys holds the hashes of the API keys withAPIKey accepted. Only
+// those get a bucket of their own, unknown keys would otherwise hand every
+// request a full one.
+var validatedKeys sync.Map
+
+// rateLimitKey identifies the client: a previously validated API key, or the
+// remote IP address
+func rateLimitKey(r *http.Request) string {
+	if key := requestAPIKey(r); key != "" {
+		hash := hashAPIKey(key)
+		if _, ok := validatedKeys.Load(hash); ok {
+			return "key:" + hash
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// withRateLimit answers 429 Too Many Requests to clients over their limit. It
+// runs before authentication so floods of bad keys never reach the database.
+func withRateLimit(next http.Handler) http.Handler {
+	if rateLimitConfig.rate <= 0 {
+		return next
+	}
+	opts := rateLimitConfig
+	opts.burst = max(opts.burst, 1)
+	limiter := newRateLimiter(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := limiter.allow(rateLimitKey(r), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	type step struct {
+		key  string
+		at   time.Duration
+		ok   bool
+		wait time.Duration
+	}
+	tests := []struct {
+		name  string
+		opts  rateLimitOptions
+		steps []step
+	}{
+		{"burst then refill", rateLimitOptions{rate: 1, burst: 2}, []step{
+			{"a", 0, true, 0},
+			{"a", 0, true, 0},
+			{"a", 0, false, time.Second},
+			{"a", 500 * time.Millisecond, false, 500 * time.Millisecond},
+			{"a", time.Second, true, 0},
+			{"a", time.Second, false, time.Second},
+		}},
+		{"clients have their own buckets", rateLimitOptions{rate: 1, burst: 1}, []step{
+			{"a", 0, true, 0},
+			{"a", 0, false, time.Second},
+			{"b", 0, true, 0},
+			{"b", 0, false, time.Second},
+		}},
+		{"refill stops at the burst", rateLimitOptions{rate: 10, burst: 3}, []step{
+			{"a", 0, true, 0},
+			{"a", time.Hour, true, 0},
+			{"a", time.Hour, true, 0},
+			{"a", time.Hour, true, 0},
+			{"a", time.Hour, false, 100 * time.Millisecond},
+		}},
+		{"fractional rates", rateLimitOptions{rate: 0.5, burst: 1}, []step{
+			{"a", 0, true, 0},
+			{"a", 0, false, 2 * time.Second},
+			{"a", time.Second, false, time.Second},
+			{"a", 2 * time.Second, true, 0},
+		}},
+		// An idle bucket is swept and comes back full, without the sweep
+		// it would have refilled to only 0.66 tokens
+		{"idle buckets are swept", rateLimitOptions{rate: 0.001, burst: 1}, []step{
+			{"a", 0, true, 0},
+			{"a", 0, false, 1000 * time.Second},
+			{"a", rateLimiterMaxIdle + time.Minute, true, 0},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.opts)
+			start := l.lastSweep
+			for i, s := range tt.steps {
+				ok, wait := l.allow(s.key, start.Add(s.at))
+				if ok != s.ok || (wait-s.wait).Abs() > time.Millisecond {
+					t.Errorf("step %d: allow(%q) at %s = %v, %s, want %v, %s", i, s.key, s.at, ok, wait, s.ok, s.wait)
+				}
+			}
+		})
+	}
+}
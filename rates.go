@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ratesLookbackDays is how far back the rates module fetches on every run
+const ratesLookbackDays = 14
+
+// rateSeries maps the name of a rate, such as kibor_3m or policy_rate, to
+// the EasyData series it is read from
+var rateSeries = map[string]string{}
+
+// rateNamePattern keeps rate names usable as SQL values and file names alike
+var rateNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func init() {
+	registerModule("rates", func(date time.Time, dbPath string) error {
+		if sbpAPIKey == "" || len(rateSeries) == 0 {
+			return nil
+		}
+		_, err := collectRateSeries(dbPath, date.AddDate(0, 0, -ratesLookbackDays), date)
+		return err
+	})
+}
+
+// applyRateSeries installs the rates entry of the config file
+func applyRateSeries(series map[string]string) error {
+	for name, key := range series {
+		if !rateNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid rate name %q, expected lower case letters, digits and underscores", name)
+		}
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("rate %s: missing series", name)
+		}
+	}
+	if series != nil {
+		rateSeries = series
+	}
+	return nil
+}
+
+// rateValue is a day of a named rate, in percent
+type rateValue struct {
+	date   string
+	name   string
+	value  float64
+	source string
+}
+
+// runRatesCommand dispatches the "rates" subcommands
+func runRatesCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing rates command, expected import or list")
+	}
+
+	switch args[0] {
+	case "import":
+		return importRateValues(dbPath, args[1:])
+	case "list":
+		return printRateValues(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown rates command %q, expected import or list", args[0])
+	}
+}
+
+// importRateValues stores the configured rates of a date range fetched from
+// SBP, or those of a CSV file of date,name,value rows
+func importRateValues(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("rates import", flag.ContinueOnError)
+	file := fs.String("file", "", "CSV file with date,name,value rows, fetch the configured series from SBP EasyData when empty")
+	from := fs.String("from", "", "First day to fetch (YYYY-MM-DD), defaults to 30 days ago")
+	to := fs.String("to", "", "Last day to fetch (YYYY-MM-DD), defaults to today")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *file == "" {
+		if sbpAPIKey == "" || len(rateSeries) == 0 {
+			return errors.New("missing -sbp-api-key or the rates entry of the config file to fetch rates from SBP EasyData")
+		}
+		start, end := time.Now().AddDate(0, 0, -30), time.Now()
+		var err error
+		if *from != "" {
+			if start, err = time.Parse("2006-01-02", *from); err != nil {
+				return fmt.Errorf("invalid -from date: %w", err)
+			}
+		}
+		if *to != "" {
+			if end, err = time.Parse("2006-01-02", *to); err != nil {
+				return fmt.Errorf("invalid -to date: %w", err)
+			}
+		}
+		saved, err := collectRateSeries(dbPath, start, end)
+		if err != nil {
+			return err
+		}
+		slog.Info("Imported SBP rates", "from", start.Format("2006-01-02"), "to", end.Format("2006-01-02"), "saved", saved)
+		return nil
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open rate file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	var values []rateValue
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read rate file: %w", err)
+		}
+		date := strings.TrimSpace(record[0])
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			// Tolerate a header row
+			if line == 1 {
+				continue
+			}
+			return fmt.Errorf("line %d: invalid date %q", line, date)
+		}
+		if len(record) < 3 {
+			return fmt.Errorf("line %d: expected date,name,value", line)
+		}
+		name := strings.ToLower(strings.TrimSpace(record[1]))
+		if !rateNamePattern.MatchString(name) {
+			return fmt.Errorf("line %d: invalid rate name %q", line, record[1])
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid value %q", line, record[2])
+		}
+		values = append(values, rateValue{date: date, name: name, value: value, source: "file"})
+	}
+
+	saved, err := saveRateValues(dbPath, values)
+	if err != nil {
+		return err
+	}
+	slog.Info("Imported rates", "file", *file, "read", len(values), "saved", saved)
+	return nil
+}
+
+// printRateValues lists the stored rates, optionally only some of them
+func printRateValues(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("rates list", flag.ContinueOnError)
+	from := fs.String("from", "", "First day (YYYY-MM-DD)")
+	to := fs.String("to", "", "Last day (YYYY-MM-DD)")
+	names := fs.String("names", "", "Comma separated rates to list, all when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	query := "SELECT date, name, value, source FROM rates WHERE 1 = 1"
+	var queryArgs []any
+	if *from != "" {
+		query += " AND date >= ?"
+		queryArgs = append(queryArgs, *from)
+	}
+	if *to != "" {
+		query += " AND date <= ?"
+		queryArgs = append(queryArgs, *to)
+	}
+	if *names != "" {
+		list := strings.Split(strings.ToLower(*names), ",")
+		for i := range list {
+			list[i] = strings.TrimSpace(list[i])
+		}
+		query += " AND name IN (?" + strings.Repeat(", ?", len(list)-1) + ")"
+		queryArgs = append(queryArgs, toArgs(list)...)
+	}
+	rows, err := db.Query(query+" ORDER BY date, name", queryArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to query rates: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var date, name, source string
+		var value float64
+		if err := rows.Scan(&date, &name, &value, &source); err != nil {
+			return fmt.Errorf("failed to read rates: %w", err)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", date, name, formatFloat(value), source)
+	}
+	return rows.Err()
+}
+
+// collectRateSeries fetches every configured series from from to to and
+// stores it, returning how many values were new or changed
+func collectRateSeries(dbPath string, from, to time.Time) (int, error) {
+	names := make([]string, 0, len(rateSeries))
+	for name := range rateSeries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var values []rateValue
+	for _, name := range names {
+		observations, err := fetchSBPSeries(rateSeries[name], from, to)
+		if err != nil {
+			return 0, fmt.Errorf("rate %s: %w", name, err)
+		}
+		for _, o := range observations {
+			values = append(values, rateValue{date: o.date, name: name, value: o.value, source: "sbp"})
+		}
+	}
+	saved, err := saveRateValues(dbPath, values)
+	if err != nil {
+		return saved, err
+	}
+	slog.Info("Stored rates", "rates", names, "values", len(values), "changed", saved)
+	return saved, nil
+}
+
+// saveRateValues upserts values, each in the shard of its date, returning
+// how many were new or changed
+func saveRateValues(dbPath string, values []rateValue) (int, error) {
+	byPath := map[string][]rateValue{}
+	var paths []string
+	for _, v := range values {
+		day, err := time.Parse("2006-01-02", v.date)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rate date %q: %w", v.date, err)
+		}
+		path := marketDBPath(dbPath, day)
+		if _, ok := byPath[path]; !ok {
+			paths = append(paths, path)
+		}
+		byPath[path] = append(byPath[path], v)
+	}
+
+	saved := 0
+	now := time.Now().Format(time.RFC3339)
+	for _, path := range paths {
+		db, err := sharedDatabase(path)
+		if err != nil {
+			return saved, err
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return saved, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		for _, v := range byPath[path] {
+			res, err := tx.Exec(`INSERT INTO rates (date, name, value, source, fetched_at) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT(name, date) DO UPDATE SET value = excluded.value, source = excluded.source, fetched_at = excluded.fetched_at
+				WHERE (value, source) IS NOT (excluded.value, excluded.source)`,
+				v.date, v.name, v.value, v.source, now)
+			if err != nil {
+				tx.Rollback()
+				return saved, fmt.Errorf("failed to store %s of %s: %w", v.name, v.date, err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				saved++
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return saved, fmt.Errorf("failed to commit rates: %w", err)
+		}
+	}
+	return saved, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// keepRawRows stores every line of the market summary files in raw_rows, so
+// fields the parser drops can be recovered without downloading again
+var keepRawRows bool
+
+// rawLine is a line of a market summary file numbered from 1
+type rawLine struct {
+	number  int
+	content string
+}
+
+// splitRawLines returns the non-empty lines of a file, including those that
+// fail to parse
+func splitRawLines(data []byte) []rawLine {
+	var lines []rawLine
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		lines = append(lines, rawLine{number: i + 1, content: string(line)})
+	}
+	return lines
+}
+
+// storeRawLines replaces the raw lines of date
+func storeRawLines(q querier, date string, lines []rawLine) error {
+	if _, err := q.Exec("DELETE FROM raw_rows WHERE date = ?", date); err != nil {
+		return fmt.Errorf("failed to clear raw rows: %w", err)
+	}
+	for _, line := range lines {
+		if _, err := q.Exec("INSERT INTO raw_rows (date, line, content) VALUES (?, ?, ?)", date, line.number, line.content); err != nil {
+			return fmt.Errorf("failed to store raw row %d: %w", line.number, err)
+		}
+	}
+	return nil
+}
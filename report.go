@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runReportCommand runs one of the reports computed from the stored data
+func runReportCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing report, expected correlation, total-return or quality")
+	}
+
+	switch args[0] {
+	case "correlation":
+		return correlationReport(dbPath, args[1:])
+	case "total-return":
+		return totalReturnReport(dbPath, args[1:])
+	case "quality":
+		return qualityReport(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown report %q, expected correlation, total-return or quality", args[0])
+	}
+}
+
+// writeReport writes a report to out, - for stdout
+func writeReport(out string, write func(w io.Writer) error) error {
+	if out == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", out, err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
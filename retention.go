@@ -0,0 +1,289 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionTables lists the tables that can be pruned with the column their
+// age is taken from and that column's layout
+var retentionTables = map[string]struct{ column, layout string }{
+	"market_data":       {"date", "2006-01-02"},
+	"market_data_extra": {"date", "2006-01-02"},
+	"raw_rows":          {"date", "2006-01-02"},
+	"ingest_log":        {"finished_at", time.RFC3339},
+	"run_metrics":       {"started_at", time.RFC3339},
+}
+
+// retentionPolicy is how long rows of each table are kept, tables missing
+// from it are kept forever. Nothing is pruned unless the config file says so,
+// run_metrics is the history regressions are spotted in.
+var retentionPolicy = map[string]time.Duration{}
+
+func init() {
+	// Keeps the high frequency tables bounded without a separate cron job
+	registerModule("prune", func(date time.Time, dbPath string) error {
+		_, err := pruneDatabase(dbPath, false)
+		return err
+	})
+}
+
+// applyRetention overrides the retention policy with the config file entries,
+// given as durations such as "90d" or "720h", or "forever"
+func applyRetention(config map[string]string) error {
+	for table, value := range config {
+		if _, ok := retentionTables[table]; !ok {
+			return fmt.Errorf("retention for unknown table %q", table)
+		}
+		keep, err := parseRetention(value)
+		if err != nil {
+			return fmt.Errorf("retention for %s: %w", table, err)
+		}
+		if keep == 0 {
+			delete(retentionPolicy, table)
+		} else {
+			retentionPolicy[table] = keep
+		}
+	}
+	return nil
+}
+
+// parseRetention parses a Go duration with an additional "d" unit for days.
+// "forever" and "0" keep rows indefinitely and are returned as 0.
+func parseRetention(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "forever" || value == "0" || value == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid retention %q", value)
+	}
+	return d, nil
+}
+
+// runPruneCommand deletes rows older than the retention policy
+func runPruneCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Report how many rows would be deleted without deleting them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	counts, err := pruneDatabase(dbPath, *dryRun)
+	if err != nil {
+		return err
+	}
+	for table, n := range counts {
+		if *dryRun {
+			slog.Info("Rows to prune", "table", table, "rows", n, "retention", retentionPolicy[table])
+		} else {
+			slog.Info("Pruned rows", "table", table, "rows", n, "retention", retentionPolicy[table])
+		}
+	}
+	return nil
+}
+
+// pruneDatabase applies the retention policy to every database file and
+// returns the number of rows deleted, or that would be, per table
+func pruneDatabase(dbPath string, dryRun bool) (map[string]int64, error) {
+	tables := make([]string, 0, len(retentionPolicy))
+	for table := range retentionPolicy {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	paths, err := databaseFiles(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(tables))
+	for _, path := range paths {
+		db, err := openDatabase(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := prunePath(db, tables, dryRun, counts); err != nil {
+			db.Close()
+			return nil, err
+		}
+		db.Close()
+	}
+	// The summary of a sharded database spans every shard and is kept in the
+	// -db file, it is rebuilt once they are all pruned
+	if shardByYear && !dryRun && counts["market_data"] > 0 {
+		if err := rebuildSymbolStats(dbPath); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// prunePath applies the policy to the tables of one database file in a
+// transaction, adding the rows deleted to counts. The ingest log entries of
+// pruned market_data days go with them, their row counts and checksums no
+// longer match what is stored, and the derived tables are brought in line.
+func prunePath(db *sql.DB, tables []string, dryRun bool, counts map[string]int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		t := retentionTables[table]
+		cutoff := time.Now().Add(-retentionPolicy[table]).UTC().Format(t.layout)
+		n, err := pruneRows(tx, table, fmt.Sprintf("%s < ?", t.column), dryRun, cutoff)
+		if err != nil {
+			return err
+		}
+		counts[table] += n
+		if table == "market_data" {
+			logged, err := pruneRows(tx, "ingest_log", "date < ?", dryRun, cutoff)
+			if err != nil {
+				return err
+			}
+			counts["ingest_log"] += logged
+		}
+		if table == "market_data" && n > 0 {
+			if err := pruneDerived(tx, cutoff, dryRun, counts); err != nil {
+				return err
+			}
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit prune: %w", err)
+	}
+	return nil
+}
+
+// derivedDateTables hold rows computed from the market_data rows of their
+// date, they are pruned with them
+var derivedDateTables = []string{"daily_returns", "indicators", "anomalies"}
+
+// pruneDerived updates the tables derived from market_data once its rows
+// before cutoff are pruned: rows of pruned days are deleted, companies get
+// their first remaining day and the bars of periods the cutoff falls into are
+// computed again, as is the summary when it is kept in this file. Dry runs
+// only count the rows that would be deleted.
+func pruneDerived(tx *sql.Tx, cutoff string, dryRun bool, counts map[string]int64) error {
+	wheres := map[string]string{
+		// Symbols whose latest row was pruned have no rows left
+		"latest_prices": "date < ?",
+		"companies":     "last_seen < ?",
+	}
+	for _, table := range derivedDateTables {
+		wheres[table] = "date < ?"
+	}
+	for _, p := range barPeriods {
+		wheres[p.table] = "last_date < ?"
+	}
+	for table, where := range wheres {
+		n, err := pruneRows(tx, table, where, dryRun, cutoff)
+		if err != nil {
+			return err
+		}
+		counts[table] += n
+	}
+	if dryRun {
+		return nil
+	}
+
+	_, err := tx.Exec(`UPDATE companies SET first_seen = (SELECT MIN(date) FROM market_data m WHERE m.symbol = companies.symbol)
+		WHERE first_seen < ? AND EXISTS (SELECT 1 FROM market_data m WHERE m.symbol = companies.symbol)`, cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to update companies: %w", err)
+	}
+	for _, p := range barPeriods {
+		if err := rebuildPrunedBars(tx, p, cutoff); err != nil {
+			return err
+		}
+	}
+	// Any symbol's 52 week range may have lost days, only without sharding
+	// is the summary in this file
+	if !shardByYear {
+		stats, err := querySymbolStats(tx, "symbol IS NOT NULL")
+		if err != nil {
+			return err
+		}
+		if err := storeSymbolStats(tx, true, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildPrunedBars computes the bars of p that started before cutoff again
+// from the days left. When sharding, only the days in the file are seen.
+func rebuildPrunedBars(tx *sql.Tx, p barPeriod, cutoff string) error {
+	rows, err := tx.Query(fmt.Sprintf("SELECT DISTINCT period_start FROM %s WHERE first_date < ?", p.table), cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", p.table, err)
+	}
+	var starts []string
+	for rows.Next() {
+		var start string
+		if err := rows.Scan(&start); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read %s: %w", p.table, err)
+		}
+		starts = append(starts, start)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", p.table, err)
+	}
+
+	for _, s := range starts {
+		day, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return fmt.Errorf("invalid period start %q in %s: %w", s, p.table, err)
+		}
+		start, end := p.bounds(day)
+		from, to := start.Format("2006-01-02"), end.Format("2006-01-02")
+		bars, err := computeBars(tx, from, to)
+		if err != nil {
+			return err
+		}
+		if err := writeBars(tx, p.table, from, to, bars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneRows deletes the rows of table matching where, or only counts them
+func pruneRows(tx *sql.Tx, table, where string, dryRun bool, args ...any) (int64, error) {
+	var n int64
+	var err error
+	if dryRun {
+		err = tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", table, where), args...).Scan(&n)
+	} else {
+		var res sql.Result
+		res, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", table, where), args...)
+		if err == nil {
+			n, _ = res.RowsAffected()
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune %s: %w", table, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// retryAttempts is how many times a failed date is ingested before the retry
+// queue gives up on it, 0 turns the queue off
+var retryAttempts = 5
+
+func init() {
+	registerModule("retry", func(date time.Time, dbPath string) error {
+		return retryFailedDates(dbPath, date)
+	})
+}
+
+// recordIngestOutcome keeps the retry queue in step with an ingest: failed
+// dates are queued, or their attempts counted, and dates that succeeded or
+// turned out closed leave the queue. The queue lives in the -db file, also
+// when the data is sharded by year.
+func recordIngestOutcome(dbPath string, date time.Time, err error) {
+	if retryAttempts <= 0 {
+		return
+	}
+	db, openErr := sharedDatabase(dbPath)
+	if openErr != nil {
+		slog.Warn("Failed to update retry queue", "date", date.Format("2006-01-02"), "error", openErr)
+		return
+	}
+
+	day := date.Format("2006-01-02")
+	if err == nil || errors.Is(err, errMarketClosed) {
+		if _, dbErr := db.Exec("DELETE FROM retry_queue WHERE date = ?", day); dbErr != nil {
+			slog.Warn("Failed to update retry queue", "date", day, "error", dbErr)
+		}
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	var attempts int
+	dbErr := db.QueryRow(`INSERT INTO retry_queue (date, attempts, last_error, first_failed_at, last_attempt_at)
+		VALUES (?, 1, ?, ?, ?)
+		ON CONFLICT (date) DO UPDATE SET attempts = attempts + 1, last_error = excluded.last_error,
+			last_attempt_at = excluded.last_attempt_at
+		RETURNING attempts`, day, err.Error(), now, now).Scan(&attempts)
+	if dbErr != nil {
+		slog.Warn("Failed to update retry queue", "date", day, "error", dbErr)
+		return
+	}
+	switch {
+	case attempts < retryAttempts:
+		slog.Info("Queued failed date for retry", "date", day, "attempts", attempts, "maxAttempts", retryAttempts)
+	case attempts == retryAttempts:
+		slog.Warn("Giving up retrying failed date", "date", day, "attempts", attempts, "error", err)
+		raiseAlert(alert{
+			Level:   "error",
+			Title:   "Ingest of " + day + " abandoned",
+			Message: fmt.Sprintf("Gave up on %s after %d attempts: %v", day, attempts, err),
+			Date:    day,
+		})
+	}
+}
+
+// retryFailedDates ingests the queued dates that have attempts left, apart
+// from current which the run has just ingested
+func retryFailedDates(dbPath string, current time.Time) error {
+	if retryAttempts <= 0 {
+		return nil
+	}
+	db, err := sharedDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	dates, err := queuedDates(db, current.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	for _, date := range dates {
+		slog.Info("Retrying failed date", "date", date.Format("2006-01-02"))
+		// Failures are counted in the queue, the next run tries again
+		if err := processMarketData(date, dbPath); err != nil {
+			slog.Warn("Retry of failed date failed", "date", date.Format("2006-01-02"), "error", err)
+		}
+	}
+	return nil
+}
+
+// queuedDates lists the dates of the retry queue that have attempts left,
+// oldest first
+func queuedDates(db *sql.DB, except string) ([]time.Time, error) {
+	rows, err := db.Query("SELECT date FROM retry_queue WHERE attempts < ? AND date != ? ORDER BY date", retryAttempts, except)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retry queue: %w", err)
+	}
+	defer rows.Close()
+
+	var dates []time.Time
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			return nil, fmt.Errorf("failed to read retry queue: %w", err)
+		}
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q in retry queue: %w", day, err)
+		}
+		dates = append(dates, date)
+	}
+	return dates, rows.Err()
+}
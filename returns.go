@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// updateDailyReturns replaces the returns of date with those of the rows
+// stored for it, measured against the previous close PSX publishes with every
+// row. Rows without a positive close and previous close carry no return.
+func updateDailyReturns(q querier, date string) error {
+	rows, err := q.Query(`SELECT symbol, close, previous_close FROM market_data
+		WHERE date = ? AND symbol IS NOT NULL AND close > 0 AND previous_close > 0`, date)
+	if err != nil {
+		return fmt.Errorf("failed to read closes: %w", err)
+	}
+	type dailyReturn struct {
+		symbol               string
+		close, previousClose float64
+	}
+	var returns []dailyReturn
+	for rows.Next() {
+		var r dailyReturn
+		if err := rows.Scan(&r.symbol, &r.close, &r.previousClose); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read closes: %w", err)
+		}
+		returns = append(returns, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read closes: %w", err)
+	}
+
+	if _, err := q.Exec("DELETE FROM daily_returns WHERE date = ?", date); err != nil {
+		return fmt.Errorf("failed to clear daily returns: %w", err)
+	}
+	for _, r := range returns {
+		// SQLite is not always built with ln(), compute both here
+		ratio := r.close / r.previousClose
+		_, err := q.Exec(`INSERT INTO daily_returns (date, symbol, close, previous_close, pct_return, log_return)
+			VALUES (?, ?, ?, ?, ?, ?)`, date, r.symbol, r.close, r.previousClose, (ratio-1)*100, math.Log(ratio))
+		if err != nil {
+			return fmt.Errorf("failed to store daily return of %s: %w", r.symbol, err)
+		}
+	}
+	return nil
+}
+
+// rebuildDailyReturns recomputes the returns of every day stored between from
+// and to, returning the number of days
+func rebuildDailyReturns(dbPath, from, to string) (int, error) {
+	paths, err := databaseFiles(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	days := 0
+	for _, path := range paths {
+		db, err := sharedDatabase(path)
+		if err != nil {
+			return days, err
+		}
+		dates, err := ingestedDates(db, from, to)
+		if err != nil {
+			return days, err
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return days, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		for date := range dates {
+			if err := updateDailyReturns(tx, date); err != nil {
+				tx.Rollback()
+				return days, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return days, fmt.Errorf("failed to commit daily returns: %w", err)
+		}
+		days += len(dates)
+	}
+	return days, nil
+}
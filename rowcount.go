@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+)
+
+const (
+	// rowCountHistory is how many logged days the trailing average of the
+	// records of a day is taken over
+	rowCountHistory = 20
+	// rowCountMinHistory is how many logged days are needed before row
+	// counts are checked at all
+	rowCountMinHistory = 5
+)
+
+var (
+	// rowCountTolerance is how far, as a share of the trailing average, the
+	// records of a day may be from it before the ingest is suspect
+	rowCountTolerance = 0.5
+	// forceRowCount stores days with a suspect row count anyway
+	forceRowCount bool
+)
+
+// errSuspectRowCount fails ingests of files whose row count is far off the
+// trailing average, most often truncated downloads
+var errSuspectRowCount = errors.New("suspect row count")
+
+// checkRowCount compares the records parsed for a day with the average of
+// the days logged before it, refusing the ingest unless -force is given. The
+// log is read through st when it can see the days it has not committed.
+func checkRowCount(dbPath string, st store, date time.Time, records int) error {
+	if rowCountTolerance <= 0 {
+		return nil
+	}
+	read := func(path string) (querier, error) { return sharedDatabase(path) }
+	if r, ok := st.(uncommittedReader); ok {
+		read = r.reader
+	}
+	average, days, err := trailingRecords(dbPath, date, read)
+	if err != nil {
+		return err
+	}
+	if days < rowCountMinHistory || math.Abs(float64(records)-average) <= rowCountTolerance*average {
+		return nil
+	}
+
+	day := date.Format("2006-01-02")
+	if forceRowCount {
+		slog.Warn("Storing day with a suspect row count", "date", day, "records", records, "trailingAverage", math.Round(average))
+		return nil
+	}
+	err = fmt.Errorf("%w: %d records against a trailing average of %.0f, rerun with -force to store it", errSuspectRowCount, records, average)
+	raiseAlert(alert{
+		Level:   "critical",
+		Title:   "Suspect row count",
+		Message: fmt.Sprintf("The market summary of %s has %d records against a trailing average of %.0f over %d days, it was not stored", day, records, average, days),
+		Date:    day,
+	})
+	return err
+}
+
+// trailingRecords is the average of the records of the last ingest of each
+// of the latest rowCountHistory days logged before date, with how many days
+// it covers. The files are read newest first through read, which hands out
+// the open transaction of a batch so its uncommitted days count as well.
+func trailingRecords(dbPath string, date time.Time, read func(path string) (querier, error)) (float64, int, error) {
+	files, err := databaseFiles(dbPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	var sum float64
+	days := 0
+	for i := len(files) - 1; i >= 0 && days < rowCountHistory; i-- {
+		q, err := read(files[i])
+		if err != nil {
+			return 0, 0, err
+		}
+		records, err := loggedRecords(q, date.Format("2006-01-02"), rowCountHistory-days)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, n := range records {
+			sum += float64(n)
+		}
+		days += len(records)
+	}
+	if days == 0 {
+		return 0, 0, nil
+	}
+	return sum / float64(days), days, nil
+}
+
+// loggedRecords returns the records of the last ingest of at most limit of
+// the latest days logged in q before day
+func loggedRecords(q querier, day string, limit int) ([]int, error) {
+	// Days can be logged more than once, the latest entry counts
+	rows, err := q.Query(`SELECT date, records FROM ingest_log WHERE date < ? AND records > 0
+		ORDER BY date DESC, finished_at DESC LIMIT ?`, day, limit*3)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ingest log: %w", err)
+	}
+	defer rows.Close()
+
+	var records []int
+	last := ""
+	for rows.Next() && len(records) < limit {
+		var date string
+		var n int
+		if err := rows.Scan(&date, &n); err != nil {
+			return nil, fmt.Errorf("failed to read ingest log: %w", err)
+		}
+		if date == last {
+			continue
+		}
+		last = date
+		records = append(records, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ingest log: %w", err)
+	}
+	return records, nil
+}
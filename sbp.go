@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sbpEasyDataURL is the series API of SBP EasyData, which needs a free API
+// key
+const sbpEasyDataURL = "https://easydata.sbp.org.pk/api/v1/series/"
+
+// sbpAPIKey enables the collectors reading SBP EasyData
+var sbpAPIKey string
+
+// sbpObservation is a day of an EasyData series
+type sbpObservation struct {
+	date  string
+	value float64
+}
+
+// sbpDateLayouts are the observation date formats EasyData answers with
+var sbpDateLayouts = []string{"2006-01-02", "02-Jan-2006", "02-Jan-06", "2006-01-02T15:04:05"}
+
+// fetchSBPSeries downloads an EasyData series, which comes as columns with
+// rows of values, of which the observation date and value are used
+func fetchSBPSeries(series string, from, to time.Time) ([]sbpObservation, error) {
+	query := url.Values{
+		"api_key":    {sbpAPIKey},
+		"format":     {"json"},
+		"start_date": {from.Format("2006-01-02")},
+		"end_date":   {to.Format("2006-01-02")},
+	}
+	// The URL carries the API key and is kept out of errors and logs
+	resp, err := httpClient().Get(sbpEasyDataURL + url.PathEscape(series) + "/data?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch SBP series %s: %w", series, errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching SBP series %s failed with status: %s", series, resp.Status)
+	}
+	var body struct {
+		Columns []string `json:"columns"`
+		Rows    [][]any  `json:"rows"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid SBP series %s: %w", series, err)
+	}
+
+	dateColumn, valueColumn := -1, -1
+	for i, c := range body.Columns {
+		switch strings.ToLower(strings.TrimSpace(c)) {
+		case "observation date":
+			dateColumn = i
+		case "observation value":
+			valueColumn = i
+		}
+	}
+	if dateColumn < 0 || valueColumn < 0 {
+		return nil, fmt.Errorf("SBP series %s has no observation date and value columns: %v", series, body.Columns)
+	}
+
+	var observations []sbpObservation
+	for _, row := range body.Rows {
+		if len(row) <= max(dateColumn, valueColumn) {
+			continue
+		}
+		date, ok := parseSBPDate(fmt.Sprint(row[dateColumn]))
+		if !ok {
+			return nil, fmt.Errorf("invalid date %v in SBP series %s", row[dateColumn], series)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(fmt.Sprint(row[valueColumn])), 64)
+		if err != nil {
+			// Days without an observation come as empty values
+			continue
+		}
+		observations = append(observations, sbpObservation{date: date, value: value})
+	}
+	return observations, nil
+}
+
+func parseSBPDate(value string) (string, bool) {
+	for _, layout := range sbpDateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return t.Format("2006-01-02"), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// eodCollector names the core end of day price load in the config file
+const eodCollector = "eod"
+
+// schedulerOptions controls what the scheduler does before its first
+// scheduled run
+type schedulerOptions struct {
+	// runNow ingests the most recent trading day right away
+	runNow bool
+	// catchUpDays limits how far back days missed while the process was down
+	// are caught up, 0 disables catching up
+	catchUpDays int
+}
+
+// runScheduler runs the core ingest and every separately scheduled module on
+// their own schedules, it never returns
+func runScheduler(schedule *cronSchedule, dbPath string, opts schedulerOptions) {
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("Failed to notify systemd", "error", err)
+	}
+	startWatchdog()
+
+	var wg sync.WaitGroup
+	for _, m := range scheduledModules() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scheduleLoop(m.name, m.schedule, func(current time.Time) {
+				m.attempt(current, dbPath, 1)
+			})
+		}()
+	}
+
+	var caughtUp time.Time
+	if opts.catchUpDays > 0 {
+		missed, err := missedRuns(schedule, dbPath, time.Now(), opts.catchUpDays)
+		if err != nil {
+			slog.Error("Failed to find missed runs", "error", err)
+		}
+		if len(missed) > 0 {
+			slog.Info("Catching up missed runs", "days", len(missed),
+				"from", missed[0].Format("2006-01-02"), "to", missed[len(missed)-1].Format("2006-01-02"))
+		}
+		for _, date := range missed {
+			runEOD(date, dbPath)
+			caughtUp = date
+		}
+	}
+
+	if opts.runNow {
+		date := mostRecentTradingDay(time.Now().In(schedule.location))
+		if sameDay(date, caughtUp) {
+			slog.Info("Skipping startup ingest, already caught up", "date", date.Format("2006-01-02"))
+		} else {
+			slog.Info("Running startup ingest", "date", date.Format("2006-01-02"))
+			runEOD(date, dbPath)
+		}
+	}
+
+	scheduleLoop(eodCollector, schedule, func(current time.Time) {
+		// Pick up holidays added with the calendar command since the last run
+		if err := loadHolidays(dbPath); err != nil {
+			slog.Warn("Failed to reload market holidays", "error", err)
+		}
+		if !isTradingDay(current) {
+			slog.Info("Skipping non-trading day", "date", current.Format("2006-01-02"))
+			return
+		}
+		runEOD(current, dbPath)
+	})
+	wg.Wait()
+}
+
+// runEOD ingests the end of day prices for date and kicks off the optional
+// modules
+func runEOD(date time.Time, dbPath string) {
+	err := processMarketData(date, dbPath)
+	if errors.Is(err, errMarketClosed) {
+		slog.Info("No market summary, the market was closed", "date", date.Format("2006-01-02"))
+	} else if err != nil {
+		slog.Error("Failed to process market data", "date", date.Format("2006-01-02"), "error", err)
+	}
+
+	// Optional modules run in the background and never hold up the core load
+	runOptionalModules(date, dbPath)
+}
+
+// scheduleLoop calls run whenever the schedule fires with the current time in
+// the schedule's zone. It returns only if the schedule can never fire.
+func scheduleLoop(name string, schedule *cronSchedule, run func(current time.Time)) {
+	for {
+		nextRun := schedule.Next(time.Now())
+		if nextRun.IsZero() {
+			slog.Error("Schedule never fires, stopping collector", "collector", name, "schedule", schedule)
+			return
+		}
+
+		// Calculate the duration to sleep until the next run
+		sleepDuration := time.Until(nextRun)
+		slog.Info("Scheduling next run", "collector", name, "duration", nextRun, "schedule", schedule)
+		if name == eodCollector {
+			sdNotify("STATUS=Next run at " + nextRun.Format(time.RFC3339))
+		}
+
+		time.Sleep(sleepDuration)
+
+		// The date processed is the day the run fires on in the schedule's zone
+		run(time.Now().In(schedule.location))
+	}
+}
+
+// missedRuns returns the trading days after the latest ingested date whose
+// scheduled run has already passed, oldest first and at most maxDays back.
+// Nothing is returned for an empty database, filling history is what
+// -backloadFrom is for.
+func missedRuns(schedule *cronSchedule, dbPath string, now time.Time, maxDays int) ([]time.Time, error) {
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	latest, err := latestIngestedDate(db)
+	if err != nil || latest.IsZero() {
+		return nil, err
+	}
+
+	now = now.In(schedule.location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, schedule.location)
+	from := time.Date(latest.Year(), latest.Month(), latest.Day()+1, 0, 0, 0, 0, schedule.location)
+	if earliest := today.AddDate(0, 0, -maxDays); from.Before(earliest) {
+		from = earliest
+	}
+
+	var missed []time.Time
+	for day := from; !day.After(today); day = day.AddDate(0, 0, 1) {
+		if !isTradingDay(day) {
+			continue
+		}
+		// Today only counts once its scheduled run time has gone by
+		if day.Equal(today) {
+			fire := schedule.Next(today.Add(-time.Minute))
+			if fire.IsZero() || fire.After(now) || !sameDay(fire, today) {
+				continue
+			}
+		}
+		missed = append(missed, day)
+	}
+	return missed, nil
+}
+
+// sameDay reports whether a and b fall on the same calendar date
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// screenFilter holds the conditions of a screen, all of which a symbol has
+// to meet. Zero values and nil pointers leave a condition out.
+type screenFilter struct {
+	date               string
+	minPrice, maxPrice float64
+	minAvgVolume       float64
+	volumeDays         int
+	changeDays         int
+	minChange          *float64
+	maxChange          *float64
+	aboveSMA, belowSMA int
+	sectors            []string
+}
+
+// days is how many stored days the conditions look back over, the day
+// screened included
+func (f screenFilter) days() int {
+	return max(f.volumeDays, f.changeDays+1, f.aboveSMA, f.belowSMA, 1)
+}
+
+// screenRow is a symbol meeting a screen with the figures it was judged on.
+// ChangePercent and SMA are nil without enough history.
+type screenRow struct {
+	Symbol        string   `json:"symbol"`
+	Sector        string   `json:"sector"`
+	Date          string   `json:"date"`
+	Close         float64  `json:"close"`
+	AvgVolume     float64  `json:"avg_volume"`
+	ChangePercent *float64 `json:"change_percent"`
+	SMA           *float64 `json:"sma"`
+}
+
+// screenCommand prints the symbols meeting every given condition on a day
+func screenCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("screen", flag.ContinueOnError)
+	var f screenFilter
+	fs.StringVar(&f.date, "date", "", "Day to screen (YYYY-MM-DD), the latest stored when empty")
+	fs.Float64Var(&f.minPrice, "min-price", 0, "Lowest close")
+	fs.Float64Var(&f.maxPrice, "max-price", 0, "Highest close")
+	fs.Float64Var(&f.minAvgVolume, "min-avg-volume", 0, "Lowest average daily volume over -volume-days")
+	fs.IntVar(&f.volumeDays, "volume-days", 20, "Stored days the average volume is taken over")
+	fs.IntVar(&f.changeDays, "change-days", 1, "Stored days the change of -min-change and -max-change is measured over")
+	fs.Func("min-change", "Lowest change of the close in percent over -change-days", func(s string) error {
+		v, err := strconv.ParseFloat(s, 64)
+		f.minChange = &v
+		return err
+	})
+	fs.Func("max-change", "Highest change of the close in percent over -change-days", func(s string) error {
+		v, err := strconv.ParseFloat(s, 64)
+		f.maxChange = &v
+		return err
+	})
+	fs.IntVar(&f.aboveSMA, "above-sma", 0, "Days of the simple moving average the close must be above")
+	fs.IntVar(&f.belowSMA, "below-sma", 0, "Days of the simple moving average the close must be below")
+	sectors := fs.String("sectors", "", "Comma separated sector codes the symbols were classified in on the day, e.g. 0807 for commercial banks")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	out := fs.String("out", "-", "File to write, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if f.volumeDays < 1 || f.changeDays < 1 || f.aboveSMA < 0 || f.belowSMA < 0 {
+		return errors.New("-volume-days and -change-days must be positive, -above-sma and -below-sma not negative")
+	}
+	if f.aboveSMA > 0 && f.belowSMA > 0 {
+		return errors.New("only one of -above-sma and -below-sma can be given")
+	}
+	for _, s := range strings.Split(*sectors, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			f.sectors = append(f.sectors, s)
+		}
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// Sector mappings live in the -db file, which the shard views leave out
+	sectorDB, err := openReadOnlyDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer sectorDB.Close()
+	matches, err := screenSymbols(db, sectorDB, f)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"symbol", "sector", "date", "close", "avg_volume", "change_percent", "sma"}
+	cells := func(r screenRow) []string {
+		return []string{r.Symbol, r.Sector, r.Date, formatFloat(r.Close), strconv.FormatFloat(r.AvgVolume, 'f', 0, 64),
+			formatOptional(r.ChangePercent), formatOptional(r.SMA)}
+	}
+	return writeReport(*out, func(w io.Writer) error {
+		switch *format {
+		case "json":
+			enc := json.NewEncoder(w)
+			for _, r := range matches {
+				if err := enc.Encode(r); err != nil {
+					return err
+				}
+			}
+			return nil
+		case "csv":
+			cw := csv.NewWriter(w)
+			cw.Write(header)
+			for _, r := range matches {
+				cw.Write(cells(r))
+			}
+			cw.Flush()
+			return cw.Error()
+		case "table":
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+			fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+			for _, r := range matches {
+				fmt.Fprintln(tw, strings.Join(cells(r), "\t")+"\t")
+			}
+			return tw.Flush()
+		default:
+			return fmt.Errorf("unknown format %q, expected table, csv or json", *format)
+		}
+	})
+}
+
+func formatOptional(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return formatFloat(*v)
+}
+
+// screenSymbols evaluates a screen against the symbols stored on its day,
+// or the latest stored day, ordered by symbol. Symbols are in the sector
+// sectorDB maps them to on that day, or that of their code without one.
+func screenSymbols(db *sql.DB, sectorDB querier, f screenFilter) ([]screenRow, error) {
+	if f.date == "" {
+		f.date = "9999-12-31"
+	}
+	// The stored days the conditions need, newest first
+	rows, err := db.Query("SELECT DISTINCT date FROM market_data WHERE date <= ? ORDER BY date DESC LIMIT ?", f.date, f.days())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dates: %w", err)
+	}
+	var dates []string
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read dates: %w", err)
+		}
+		dates = append(dates, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dates: %w", err)
+	}
+	if len(dates) == 0 {
+		return []screenRow{}, nil
+	}
+	day := dates[0]
+	sectors, err := sectorsOn(sectorDB, day)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT symbol, COALESCE(code, ''), date, COALESCE(close, 0), COALESCE(volume, 0) FROM market_data
+		WHERE date >= ? AND date <= ? AND symbol IS NOT NULL`
+	args := []any{dates[len(dates)-1], day}
+	rows, err = db.Query(query+" ORDER BY symbol, date DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prices: %w", err)
+	}
+	defer rows.Close()
+
+	matches := []screenRow{}
+	var symbol string
+	var history []screenBar
+	flush := func() {
+		if len(history) == 0 {
+			return
+		}
+		sector, ok := sectors[symbol]
+		if !ok {
+			sector = history[0].code
+		}
+		if len(f.sectors) > 0 && !slices.Contains(f.sectors, sector) {
+			return
+		}
+		if r, ok := f.evaluate(symbol, sector, dates, history); ok {
+			matches = append(matches, r)
+		}
+	}
+	for rows.Next() {
+		var s string
+		var b screenBar
+		if err := rows.Scan(&s, &b.code, &b.date, &b.close, &b.volume); err != nil {
+			return nil, fmt.Errorf("failed to read prices: %w", err)
+		}
+		if s != symbol {
+			flush()
+			symbol, history = s, history[:0]
+		}
+		history = append(history, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read prices: %w", err)
+	}
+	flush()
+	return matches, nil
+}
+
+// screenBar is a stored day of a symbol as a screen sees it
+type screenBar struct {
+	code, date string
+	close      float64
+	volume     int64
+}
+
+// evaluate checks a symbol of sector against the filter from its history
+// over the stored dates, both newest first, and returns the figures it passed
+// on. Symbols not stored on the day screened are left out.
+func (f screenFilter) evaluate(symbol, sector string, dates []string, history []screenBar) (screenRow, bool) {
+	day := dates[0]
+	if len(history) == 0 || history[0].date != day || history[0].close <= 0 {
+		return screenRow{}, false
+	}
+	last := history[0]
+	r := screenRow{Symbol: symbol, Sector: sector, Date: day, Close: last.close}
+	if f.minPrice > 0 && last.close < f.minPrice || f.maxPrice > 0 && last.close > f.maxPrice {
+		return r, false
+	}
+
+	// Days the symbol was not listed on count as no volume
+	days := min(f.volumeDays, len(dates))
+	var volume int64
+	for _, b := range history {
+		if b.date >= dates[days-1] {
+			volume += b.volume
+		}
+	}
+	r.AvgVolume = math.Round(float64(volume) / float64(days))
+	if r.AvgVolume < f.minAvgVolume {
+		return r, false
+	}
+
+	if len(history) > f.changeDays && history[f.changeDays].close > 0 {
+		pct := math.Round((last.close/history[f.changeDays].close-1)*100*10000) / 10000
+		r.ChangePercent = &pct
+	}
+	if f.minChange != nil && (r.ChangePercent == nil || *r.ChangePercent < *f.minChange) ||
+		f.maxChange != nil && (r.ChangePercent == nil || *r.ChangePercent > *f.maxChange) {
+		return r, false
+	}
+
+	if n := max(f.aboveSMA, f.belowSMA); n > 0 {
+		if len(history) < n {
+			return r, false
+		}
+		var sum float64
+		for _, b := range history[:n] {
+			sum += b.close
+		}
+		sma := math.Round(sum/float64(n)*10000) / 10000
+		r.SMA = &sma
+		if f.aboveSMA > 0 && last.close <= sma || f.belowSMA > 0 && last.close >= sma {
+			return r, false
+		}
+	}
+	return r, true
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Sources of symbol_sectors intervals. Summary ones follow the sector code
+// of the daily files and never replace manual ones.
+const (
+	sectorSourceSummary = "summary"
+	sectorSourceManual  = "manual"
+)
+
+// psxSectorNames are the names of the sector codes of the market summary
+// files, the sectors table overrides them
+var psxSectorNames = map[string]string{
+	"0801": "Automobile Assembler",
+	"0802": "Automobile Parts & Accessories",
+	"0803": "Cable & Electrical Goods",
+	"0804": "Cement",
+	"0805": "Chemical",
+	"0806": "Close-End Mutual Fund",
+	"0807": "Commercial Banks",
+	"0808": "Engineering",
+	"0809": "Fertilizer",
+	"0810": "Food & Personal Care Products",
+	"0811": "Glass & Ceramics",
+	"0812": "Insurance",
+	"0813": "Inv. Banks / Inv. Cos. / Securities Cos.",
+	"0814": "Jute",
+	"0815": "Leasing Companies",
+	"0816": "Leather & Tanneries",
+	"0818": "Miscellaneous",
+	"0819": "Modarabas",
+	"0820": "Oil & Gas Exploration Companies",
+	"0821": "Oil & Gas Marketing Companies",
+	"0822": "Paper & Board",
+	"0823": "Pharmaceuticals",
+	"0824": "Power Generation & Distribution",
+	"0825": "Refinery",
+	"0826": "Sugar & Allied Industries",
+	"0827": "Synthetic & Rayon",
+	"0828": "Technology & Communication",
+	"0829": "Textile Composite",
+	"0830": "Textile Spinning",
+	"0831": "Textile Weaving",
+	"0832": "Tobacco",
+	"0833": "Transport",
+	"0834": "Vanaspati & Allied Industries",
+	"0835": "Woollen",
+	"0836": "Real Estate Investment Trust",
+	"0837": "Exchange Traded Funds",
+	"0838": "Property",
+}
+
+// sectorInterval is a row of symbol_sectors, the sector of a symbol from
+// start on and before end, which is empty while it holds
+type sectorInterval struct {
+	symbol, sector string
+	start, end     string
+	source         string
+}
+
+// runSectorsCommand dispatches the "sectors" subcommands
+func runSectorsCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing sectors command, expected list, name, set, history or refresh")
+	}
+
+	switch args[0] {
+	case "list":
+		return printSectors(dbPath, args[1:])
+	case "name":
+		return nameSector(dbPath, args[1:])
+	case "set":
+		return setSymbolSector(dbPath, args[1:])
+	case "history":
+		return printSectorHistory(dbPath, args[1:])
+	case "refresh":
+		return refreshSectors(dbPath)
+	default:
+		return fmt.Errorf("unknown sectors command %q, expected list, name, set, history or refresh", args[0])
+	}
+}
+
+// updateSectors follows the sector codes the symbols of date are stored with.
+// A code differing from the sector of a later open interval is a
+// reclassification, days older than the mapping extend or fill it in.
+// Mappings live in the -db file, their intervals span shards.
+func updateSectors(dbPath string, date time.Time) error {
+	day := date.Format("2006-01-02")
+	shard, err := sharedDatabase(marketDBPath(dbPath, date))
+	if err != nil {
+		return err
+	}
+	rows, err := shard.Query(`SELECT symbol, code FROM market_data
+		WHERE date = ? AND symbol IS NOT NULL AND code IS NOT NULL AND code <> ''`, day)
+	if err != nil {
+		return fmt.Errorf("failed to query sector codes: %w", err)
+	}
+	codes := map[string]string{}
+	for rows.Next() {
+		var symbol, code string
+		if err := rows.Scan(&symbol, &code); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read sector codes: %w", err)
+		}
+		codes[symbol] = code
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read sector codes: %w", err)
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+
+	db, err := sharedDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var reclassified []string
+	for symbol, code := range codes {
+		changed, err := mapSector(tx, symbol, code, day)
+		if err != nil {
+			return err
+		}
+		if changed {
+			reclassified = append(reclassified, symbol)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit sectors: %w", err)
+	}
+	if len(reclassified) > 0 {
+		sort.Strings(reclassified)
+		slog.Info("Symbols reclassified", "date", day, "symbols", reclassified)
+	}
+	return nil
+}
+
+// mapSector records that symbol had sector code on day, reporting whether
+// that reclassified it
+func mapSector(tx *sql.Tx, symbol, code, day string) (bool, error) {
+	covering, err := sectorOn(tx, symbol, day)
+	if err != nil {
+		return false, err
+	}
+	if covering != nil {
+		switch {
+		case covering.sector == code || covering.source == sectorSourceManual:
+			return false, nil
+		case covering.start == day:
+			// A re-ingest of the first day with a corrected code
+			_, err = tx.Exec(`UPDATE symbol_sectors SET sector = ? WHERE symbol = ? AND start_date = ?`, code, symbol, day)
+		case covering.end == "":
+			if _, err = tx.Exec(`UPDATE symbol_sectors SET end_date = ? WHERE symbol = ? AND start_date = ?`, day, symbol, covering.start); err == nil {
+				_, err = tx.Exec(`INSERT INTO symbol_sectors (symbol, sector, start_date, end_date, source)
+					VALUES (?, ?, ?, NULL, ?)`, symbol, code, day, sectorSourceSummary)
+			}
+			if err == nil {
+				return true, nil
+			}
+		default:
+			// Older days than a later reclassification are left alone
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to update sector of %s: %w", symbol, err)
+		}
+		return false, nil
+	}
+
+	var next sectorInterval
+	var end sql.NullString
+	err = tx.QueryRow(`SELECT sector, start_date, end_date, source FROM symbol_sectors
+		WHERE symbol = ? AND start_date > ? ORDER BY start_date LIMIT 1`, symbol, day).Scan(&next.sector, &next.start, &end, &next.source)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = tx.Exec(`INSERT INTO symbol_sectors (symbol, sector, start_date, end_date, source)
+			VALUES (?, ?, ?, NULL, ?)`, symbol, code, day, sectorSourceSummary)
+	case err != nil:
+	case next.sector == code && next.source == sectorSourceSummary:
+		_, err = tx.Exec(`UPDATE symbol_sectors SET start_date = ? WHERE symbol = ? AND start_date = ?`, day, symbol, next.start)
+	default:
+		_, err = tx.Exec(`INSERT INTO symbol_sectors (symbol, sector, start_date, end_date, source)
+			VALUES (?, ?, ?, ?, ?)`, symbol, code, day, next.start, sectorSourceSummary)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update sector of %s: %w", symbol, err)
+	}
+	return false, nil
+}
+
+// sectorOn returns the interval of symbol covering day, nil without one
+func sectorOn(q querier, symbol, day string) (*sectorInterval, error) {
+	i := &sectorInterval{symbol: symbol}
+	var end sql.NullString
+	err := q.QueryRow(`SELECT sector, start_date, end_date, source FROM symbol_sectors
+		WHERE symbol = ? AND start_date <= ? AND (end_date IS NULL OR end_date > ?)`, symbol, day, day).Scan(&i.sector, &i.start, &end, &i.source)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sector of %s: %w", symbol, err)
+	}
+	i.end = end.String
+	return i, nil
+}
+
+// sectorsOn maps every symbol with a sector on day to it
+func sectorsOn(q querier, day string) (map[string]string, error) {
+	rows, err := q.Query(`SELECT symbol, sector FROM symbol_sectors
+		WHERE start_date <= ? AND (end_date IS NULL OR end_date > ?)`, day, day)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sectors: %w", err)
+	}
+	defer rows.Close()
+	sectors := map[string]string{}
+	for rows.Next() {
+		var symbol, sector string
+		if err := rows.Scan(&symbol, &sector); err != nil {
+			return nil, fmt.Errorf("failed to read sectors: %w", err)
+		}
+		sectors[symbol] = sector
+	}
+	return sectors, rows.Err()
+}
+
+// sectorNames returns the names of the sector codes, those of the sectors
+// table over the built-in ones
+func sectorNames(q querier) (map[string]string, error) {
+	names := make(map[string]string, len(psxSectorNames))
+	for code, name := range psxSectorNames {
+		names[code] = name
+	}
+	rows, err := q.Query("SELECT code, name FROM sectors")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sector names: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code, name string
+		if err := rows.Scan(&code, &name); err != nil {
+			return nil, fmt.Errorf("failed to read sector names: %w", err)
+		}
+		names[code] = name
+	}
+	return names, rows.Err()
+}
+
+// printSectors lists the sectors with their names and how many symbols they
+// had on a day
+func printSectors(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("sectors list", flag.ContinueOnError)
+	date := fs.String("date", "", "Day the members are counted on (YYYY-MM-DD), defaults to today")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *date == "" {
+		*date = time.Now().Format("2006-01-02")
+	}
+	db, err := openReadOnlyDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	names, err := sectorNames(db)
+	if err != nil {
+		return err
+	}
+	members, err := sectorsOn(db, *date)
+	if err != nil {
+		return err
+	}
+	counts := map[string]int{}
+	for _, sector := range members {
+		counts[sector]++
+		if _, ok := names[sector]; !ok {
+			names[sector] = ""
+		}
+	}
+	codes := make([]string, 0, len(names))
+	for code := range names {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CODE\tNAME\tSYMBOLS")
+	for _, code := range codes {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", code, names[code], counts[code])
+	}
+	return tw.Flush()
+}
+
+// nameSector sets the name of a sector code
+func nameSector(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("sectors name", flag.ContinueOnError)
+	code := fs.String("code", "", "Sector code, e.g. 0807")
+	name := fs.String("name", "", "Name of the sector")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *code == "" || *name == "" {
+		return errors.New("missing -code or -name of the sector")
+	}
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(`INSERT INTO sectors (code, name) VALUES (?, ?)
+		ON CONFLICT(code) DO UPDATE SET name = excluded.name`, *code, *name); err != nil {
+		return fmt.Errorf("failed to name sector %s: %w", *code, err)
+	}
+	slog.Info("Sector named", "code", *code, "name", *name)
+	return nil
+}
+
+// setSymbolSector classifies a symbol by hand from a day on, replacing the
+// intervals after it. The sector codes of later files no longer change it.
+func setSymbolSector(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("sectors set", flag.ContinueOnError)
+	symbol := fs.String("symbol", "", "Symbol to classify")
+	sector := fs.String("sector", "", "Sector code from -date on")
+	date := fs.String("date", "", "First day of the classification (YYYY-MM-DD), defaults to today")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	*symbol = strings.ToUpper(strings.TrimSpace(*symbol))
+	if *symbol == "" || *sector == "" {
+		return errors.New("missing -symbol or -sector")
+	}
+	if *date == "" {
+		*date = time.Now().Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", *date); err != nil {
+		return fmt.Errorf("invalid -date: %w", err)
+	}
+
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM symbol_sectors WHERE symbol = ? AND start_date >= ?", *symbol, *date); err != nil {
+		return fmt.Errorf("failed to classify %s: %w", *symbol, err)
+	}
+	if _, err := tx.Exec(`UPDATE symbol_sectors SET end_date = ?
+		WHERE symbol = ? AND start_date < ? AND (end_date IS NULL OR end_date > ?)`, *date, *symbol, *date, *date); err != nil {
+		return fmt.Errorf("failed to classify %s: %w", *symbol, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO symbol_sectors (symbol, sector, start_date, end_date, source) VALUES (?, ?, ?, NULL, ?)`,
+		*symbol, *sector, *date, sectorSourceManual); err != nil {
+		return fmt.Errorf("failed to classify %s: %w", *symbol, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to classify %s: %w", *symbol, err)
+	}
+	slog.Info("Symbol classified", "symbol", *symbol, "sector", *sector, "from", *date)
+	return nil
+}
+
+// printSectorHistory lists the sector intervals of a symbol
+func printSectorHistory(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("sectors history", flag.ContinueOnError)
+	symbol := fs.String("symbol", "", "Symbol to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *symbol == "" {
+		return errors.New("missing -symbol")
+	}
+	db, err := openReadOnlyDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	names, err := sectorNames(db)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`SELECT sector, start_date, COALESCE(end_date, ''), source FROM symbol_sectors
+		WHERE symbol = ? ORDER BY start_date`, strings.ToUpper(*symbol))
+	if err != nil {
+		return fmt.Errorf("failed to query sectors of %s: %w", *symbol, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i sectorInterval
+		if err := rows.Scan(&i.sector, &i.start, &i.end, &i.source); err != nil {
+			return fmt.Errorf("failed to read sectors of %s: %w", *symbol, err)
+		}
+		until := i.end
+		if until == "" {
+			until = "now"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n", i.start, until, i.sector, names[i.sector], i.source)
+	}
+	return rows.Err()
+}
+
+// refreshSectors rebuilds the summary mappings from every stored day in
+// order, for databases loaded before the table existed
+func refreshSectors(dbPath string) error {
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT DISTINCT date FROM market_data WHERE date IS NOT NULL ORDER BY date")
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to query stored dates: %w", err)
+	}
+	var dates []time.Time
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			rows.Close()
+			db.Close()
+			return fmt.Errorf("failed to read stored dates: %w", err)
+		}
+		if date, err := time.Parse("2006-01-02", day); err == nil {
+			dates = append(dates, date)
+		}
+	}
+	rows.Close()
+	db.Close()
+
+	mainDB, err := sharedDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	if _, err := mainDB.Exec("DELETE FROM symbol_sectors WHERE source = ?", sectorSourceSummary); err != nil {
+		return fmt.Errorf("failed to clear sectors: %w", err)
+	}
+	for _, date := range dates {
+		if err := updateSectors(dbPath, date); err != nil {
+			return err
+		}
+	}
+	slog.Info("Refreshed sectors", "days", len(dates))
+	return nil
+}
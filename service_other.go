@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+)
+
+var errNotWindows = errors.New("Windows services are only supported on Windows")
+
+func runningAsService() bool { return false }
+
+func runAsService(run func()) error { return errNotWindows }
+
+func serviceCommand(command string, args []string) error { return errNotWindows }
+
+func newEventLogHandler(format string, opts *slog.HandlerOptions) (slog.Handler, io.Closer, error) {
+	return nil, nil, errNotWindows
+}
@@ -0,0 +1,223 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	serviceName        = "psx-data-downloader"
+	serviceDisplayName = "PSX Data Downloader"
+	serviceDescription = "Downloads the daily PSX market summary into a SQLite database"
+)
+
+// runningAsService reports whether the process was started by the Windows service manager
+func runningAsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// runAsService hands control to the service manager and calls run in the
+// background until the service is stopped
+func runAsService(run func()) error {
+	return svc.Run(serviceName, &serviceHandler{run: run})
+}
+
+type serviceHandler struct {
+	run func()
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	go h.run()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			changes <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			slog.Info("Stopping Windows service")
+			changes <- svc.Status{State: svc.StopPending}
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// serviceCommand installs, removes, starts or stops the Windows service.
+// args are passed to the service executable when it is started.
+func serviceCommand(command string, args []string) error {
+	switch command {
+	case "install":
+		return installService(args)
+	case "uninstall":
+		return uninstallService()
+	case "start":
+		return controlService(func(s *mgr.Service) error { return s.Start() })
+	case "stop":
+		return controlService(func(s *mgr.Service) error {
+			_, err := s.Control(svc.Stop)
+			return err
+		})
+	default:
+		return fmt.Errorf("unknown service command %q, expected install, uninstall, start or stop", command)
+	}
+}
+
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+	exe, err = filepath.Abs(exe)
+	if err != nil {
+		return fmt.Errorf("failed to resolve executable path: %w", err)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: serviceDescription,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to register event log source: %w", err)
+	}
+
+	slog.Info("Installed Windows service", "name", serviceName, "executable", exe, "args", args)
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	if err := eventlog.Remove(serviceName); err != nil {
+		return fmt.Errorf("failed to remove event log source: %w", err)
+	}
+
+	slog.Info("Removed Windows service", "name", serviceName)
+	return nil
+}
+
+func controlService(control func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	return control(s)
+}
+
+// newEventLogHandler returns a slog handler writing to the Windows event log
+func newEventLogHandler(format string, opts *slog.HandlerOptions) (slog.Handler, io.Closer, error) {
+	log, err := eventlog.Open(serviceName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open event log: %w", err)
+	}
+
+	h := &eventLogHandler{log: log, buf: new(bytes.Buffer), mu: new(sync.Mutex)}
+	switch format {
+	case "text":
+		h.inner = slog.NewTextHandler(h.buf, opts)
+	case "json":
+		h.inner = slog.NewJSONHandler(h.buf, opts)
+	default:
+		log.Close()
+		return nil, nil, fmt.Errorf("unknown log format %q, expected json or text", format)
+	}
+	return h, log, nil
+}
+
+// eventLogHandler formats records with a regular slog handler and writes each
+// one as an event with a matching severity
+type eventLogHandler struct {
+	log   *eventlog.Log
+	inner slog.Handler
+	buf   *bytes.Buffer
+	mu    *sync.Mutex
+}
+
+func (h *eventLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *eventLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	msg := h.buf.String()
+
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.log.Error(1, msg)
+	case r.Level >= slog.LevelWarn:
+		return h.log.Warning(1, msg)
+	default:
+		return h.log.Info(1, msg)
+	}
+}
+
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.inner = h.inner.WithAttrs(attrs)
+	return &clone
+}
+
+func (h *eventLogHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.inner = h.inner.WithGroup(name)
+	return &clone
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sessionDiff is how the symbols of an ingested day differ from those of the
+// stored session before it
+type sessionDiff struct {
+	previousDate string
+	previous     int
+	added        []string
+	missing      []string
+}
+
+// diffSessions compares the symbols stored for date with those of the
+// latest day stored before it. The diff is empty for the first stored day.
+func diffSessions(dbPath string, date time.Time) (sessionDiff, error) {
+	day := date.Format("2006-01-02")
+	db, err := sharedQueryDatabase(dbPath)
+	if err != nil {
+		return sessionDiff{}, err
+	}
+
+	var d sessionDiff
+	var previous sql.NullString
+	if err := db.QueryRow("SELECT MAX(date) FROM market_data WHERE date < ?", day).Scan(&previous); err != nil {
+		return d, fmt.Errorf("failed to find the previous session: %w", err)
+	}
+	if !previous.Valid {
+		return d, nil
+	}
+	d.previousDate = previous.String
+
+	before, err := symbolsOn(db, d.previousDate)
+	if err != nil {
+		return d, err
+	}
+	after, err := symbolsOn(db, day)
+	if err != nil {
+		return d, err
+	}
+	d.previous = len(before)
+	d.added = subtractSymbols(after, before)
+	d.missing = subtractSymbols(before, after)
+	return d, nil
+}
+
+// subtractSymbols returns the symbols of a not in b, sorted
+func subtractSymbols(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, s := range b {
+		in[s] = true
+	}
+	var out []string
+	for _, s := range a {
+		if !in[s] {
+			out = append(out, s)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// alertMissingSymbols raises an alert for symbols that disappeared since the
+// previous session, critical when so many went missing that the file is more
+// likely partial than a few suspensions or delistings
+func alertMissingSymbols(date string, d sessionDiff) {
+	if len(d.missing) == 0 {
+		return
+	}
+	level := "warning"
+	if len(d.missing)*10 > d.previous {
+		level = "critical"
+	}
+	raiseAlert(alert{
+		Level: level,
+		Title: "Symbols missing versus the previous session",
+		Message: fmt.Sprintf("%d of the %d symbols of %s are not in the summary of %s: %s",
+			len(d.missing), d.previous, d.previousDate, date, strings.Join(d.missing, ", ")),
+		Date: date,
+	})
+}
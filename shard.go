@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shardByYear stores every calendar year in its own database file next to
+// the configured path, e.g. market_data_2020.db for -db market_data.db
+var shardByYear bool
+
+// shardPath returns the file holding the given year for a sharded database
+func shardPath(dbPath string, year int) string {
+	ext := filepath.Ext(dbPath)
+	return fmt.Sprintf("%s_%04d%s", strings.TrimSuffix(dbPath, ext), year, ext)
+}
+
+// marketDBPath returns the database file data for date is written to
+func marketDBPath(dbPath string, date time.Time) string {
+	if !shardByYear {
+		return dbPath
+	}
+	return shardPath(dbPath, date.Year())
+}
+
+// shardFiles lists the existing shard files of dbPath ordered by year
+func shardFiles(dbPath string) ([]int, map[int]string, error) {
+	ext := filepath.Ext(dbPath)
+	prefix := strings.TrimSuffix(dbPath, ext) + "_"
+	matches, err := filepath.Glob(prefix + "[0-9][0-9][0-9][0-9]" + ext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list shards: %w", err)
+	}
+
+	files := make(map[int]string)
+	var years []int
+	for _, m := range matches {
+		year, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(m, prefix), ext))
+		if err != nil {
+			continue
+		}
+		files[year] = m
+		years = append(years, year)
+	}
+	sort.Ints(years)
+	return years, files, nil
+}
+
+// databaseFiles returns every file making up the database, one per year when
+// sharding is enabled
+func databaseFiles(dbPath string) ([]string, error) {
+	if !shardByYear {
+		return []string{dbPath}, nil
+	}
+	years, files, err := shardFiles(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(years))
+	for _, year := range years {
+		paths = append(paths, files[year])
+	}
+	return paths, nil
+}
+
+// openQueryDatabase opens the database read-only for queries. With sharding
+// enabled every shard is attached to an in-memory database and the tables
+// are exposed as temporary views unioning all years, next to those kept in
+// the -db file itself.
+func openQueryDatabase(dbPath string) (*sql.DB, error) {
+	if !shardByYear {
+		return openReadOnlyDatabase(dbPath)
+	}
+
+	years, files, err := shardFiles(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(sqliteDriverName, "file::memory:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// Attachments and temporary views live on a single connection, keep it
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+
+	// Empty tables in main keep queries working before the first shard exists
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create table: %w", err)
+		}
+	}
+	// The views take their columns from main, which needs the added ones too
+	if err := addMissingColumns(context.Background(), db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	escape := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23", "'", "''")
+	attach := func(path, name string) error {
+		uri := "file:" + escape.Replace(path) + "?mode=ro"
+		if _, err := db.Exec(fmt.Sprintf("ATTACH DATABASE '%s' AS %s", uri, name)); err != nil {
+			return fmt.Errorf("failed to attach %s (SQLite attaches at most 10 files by default, "+
+				"build with CGO_CFLAGS=-DSQLITE_MAX_ATTACHED=125 for more): %w", path, err)
+		}
+		return nil
+	}
+
+	shards := make([]string, len(years))
+	for i, year := range years {
+		shards[i] = fmt.Sprintf("shard_%d", year)
+		if err := attach(files[year], shards[i]); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	for _, table := range shardedTables {
+		if err := createUnionView(db, table, shards); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	// Tables kept in the -db file itself are exposed as they are
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := attach(dbPath, "base"); err != nil {
+			db.Close()
+			return nil, err
+		}
+		for _, table := range baseTables {
+			if err := createUnionView(db, table, []string{"base"}); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return db, nil
+}
+
+// shardedTables are the tables written to the shard of their date
+var shardedTables = []string{"market_data", "market_data_extra", "raw_rows", "ingest_log", "holidays", "weekly_bars", "monthly_bars", "companies", "latest_prices", "daily_returns", "indicators", "index_data", "corporate_actions", "anomalies", "exchange_rates", "rates", "fund_navs", "fund_premiums", "run_metrics"}
+
+// baseTables are the tables that stay in the -db file when sharding
+var baseTables = []string{"symbol_stats", "retry_queue", "portfolios", "portfolio_holdings", "index_constituents", "sectors", "symbol_sectors", "isins"}
+
+// createUnionView replaces table with a temporary view unioning it across
+// the attached schemas. Older files may lack columns added since, those are
+// selected as NULL, and files without the table are left out.
+func createUnionView(db *sql.DB, table string, schemas []string) error {
+	columns, err := tableColumns(db, "main", table)
+	if err != nil {
+		return err
+	}
+	var selects []string
+	for _, schemaName := range schemas {
+		present, err := tableColumns(db, schemaName, table)
+		if err != nil {
+			return err
+		}
+		if len(present) == 0 {
+			continue
+		}
+		has := make(map[string]bool, len(present))
+		for _, c := range present {
+			has[c] = true
+		}
+		exprs := make([]string, len(columns))
+		for j, c := range columns {
+			if has[c] {
+				exprs[j] = c
+			} else {
+				exprs[j] = "NULL AS " + c
+			}
+		}
+		selects = append(selects, fmt.Sprintf("SELECT %s FROM %s.%s", strings.Join(exprs, ", "), schemaName, table))
+	}
+	if len(selects) == 0 {
+		return nil
+	}
+	view := fmt.Sprintf("CREATE TEMP VIEW %s AS %s", table, strings.Join(selects, " UNION ALL "))
+	if _, err := db.Exec(view); err != nil {
+		return fmt.Errorf("failed to create %s view: %w", table, err)
+	}
+	return nil
+}
+
+// tableColumns returns the column names of a table in the given schema
+func tableColumns(db *sql.DB, schemaName, table string) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?, ?) ORDER BY cid", table, schemaName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect %s.%s: %w", schemaName, table, err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to inspect %s.%s: %w", schemaName, table, err)
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
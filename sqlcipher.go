@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriverName is the go-sqlite3 driver registered with a connect hook
+// that unlocks SQLCipher encrypted databases
+const sqliteDriverName = "sqlite3_psx"
+
+// dbKey is the SQLCipher passphrase, encryption is disabled when empty
+var dbKey string
+
+var errNoSQLCipher = errors.New("a database key was given but this binary is not linked against SQLCipher, " +
+	"rebuild it with -tags libsqlite3 against the SQLCipher library")
+
+var errWrongKey = errors.New("failed to unlock database, wrong key or not an encrypted database")
+
+func init() {
+	sql.Register(sqliteDriverName, &sqlite3.SQLiteDriver{ConnectHook: applyDatabaseKey})
+}
+
+// applyDatabaseKey keys every new connection when a passphrase is configured
+// and fails early if SQLCipher is unavailable or the passphrase is wrong
+func applyDatabaseKey(conn *sqlite3.SQLiteConn) error {
+	if dbKey == "" {
+		return nil
+	}
+
+	if _, err := conn.Exec("PRAGMA key = '"+strings.ReplaceAll(dbKey, "'", "''")+"'", nil); err != nil {
+		return fmt.Errorf("failed to set database key: %w", err)
+	}
+
+	// Plain SQLite silently ignores PRAGMA key, cipher_version tells them apart
+	rows, err := conn.Query("PRAGMA cipher_version", nil)
+	if err != nil {
+		return fmt.Errorf("failed to query SQLCipher version: %w", err)
+	}
+	values := make([]driver.Value, 1)
+	err = rows.Next(values)
+	rows.Close()
+	if err == io.EOF {
+		return errNoSQLCipher
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query SQLCipher version: %w", err)
+	}
+
+	// The key is only checked once a page is read
+	if _, err := conn.Exec("SELECT count(*) FROM sqlite_master", nil); err != nil {
+		return fmt.Errorf("%w: %v", errWrongKey, err)
+	}
+	return nil
+}
+
+// readDatabaseKey loads the passphrase from a file, trimming the trailing newline
+func readDatabaseKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read database key file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
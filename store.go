@@ -0,0 +1,372 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// store is a storage backend for the daily market data. Ingest only talks to
+// the backend selected with -store, so new ones are added in a file of their
+// own, optionally behind a build tag, calling registerStore from init.
+type store interface {
+	// createSchema creates whatever the backend needs, it must be safe to
+	// call on every open
+	createSchema() error
+	// upsertDay stores the records of a day together with its ingest log
+	// entry, all of it or nothing
+	upsertDay(day ingestDay) (ingestResult, error)
+	// queryRows returns the stored rows selected by opts, ordered by date and
+	// symbol
+	queryRows(opts exportOptions) ([]exportRow, error)
+	close() error
+}
+
+// batchStore is implemented by backends that can keep the days of a
+// backload pending and commit them together, saving a transaction per day
+type batchStore interface {
+	store
+	// beginBatch makes upsertDay leave its writes uncommitted until flush, a
+	// day that fails is rolled back on its own
+	beginBatch()
+	// flush commits the days stored since the last flush
+	flush() error
+}
+
+// uncommittedReader is implemented by backends that can read back what they
+// have not committed yet
+type uncommittedReader interface {
+	// reader returns a querier of the database file at path that sees the
+	// pending writes of the store
+	reader(path string) (querier, error)
+}
+
+// ingestDay is one downloaded market summary ready to be stored
+type ingestDay struct {
+	date     time.Time
+	filename string
+	records  []parsedRecord
+	// errors counts the lines that failed to parse
+	errors int
+	// raw holds the lines of the file with -keep-raw-rows
+	raw       []rawLine
+	startedAt time.Time
+	// afterCommit receives the inserted and updated rows once they are
+	// committed, for a day of a batch once the batch is flushed
+	afterCommit func(changed []changedRow)
+}
+
+// changedRow is a record upsertDay inserted or updated
+type changedRow struct {
+	change rowChange
+	rec    parsedRecord
+}
+
+// ingestResult summarises a stored day
+type ingestResult struct {
+	records int
+	// errors counts parse errors and records that failed to store
+	errors  int
+	changes rowChanges
+}
+
+// storeOpener opens a backend at the location given by -db
+type storeOpener func(dbPath string) (store, error)
+
+var stores = map[string]storeOpener{}
+
+// storeBackend names the registered backend ingest writes to
+var storeBackend = "sqlite"
+
+// registerStore makes a backend selectable with -store
+func registerStore(name string, open storeOpener) {
+	stores[name] = open
+}
+
+// storeNames lists the registered backends for error messages
+func storeNames() string {
+	names := make([]string, 0, len(stores))
+	for name := range stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// openStore opens the -store backend and makes sure its schema exists
+func openStore(dbPath string) (store, error) {
+	open, ok := stores[storeBackend]
+	if !ok {
+		return nil, fmt.Errorf("unknown store %q, expected one of %s", storeBackend, storeNames())
+	}
+	st, err := open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := st.createSchema(); err != nil {
+		st.close()
+		return nil, err
+	}
+	return st, nil
+}
+
+func init() {
+	registerStore("sqlite", func(dbPath string) (store, error) {
+		return &sqliteStore{dbPath: dbPath}, nil
+	})
+}
+
+// sqliteStore is the built-in backend. It writes each day to the shared
+// handle of its file, one per year when sharding, and reads through the
+// shared union views of sharedQueryDatabase. In a batch every file keeps a
+// transaction open until flush, along with the afterCommit calls of its days.
+type sqliteStore struct {
+	dbPath string
+
+	batch   bool
+	txs     map[string]*sql.Tx
+	pending map[string][]func()
+}
+
+// createSchema is a no-op, openDatabase creates the tables of every file it
+// opens
+func (s *sqliteStore) createSchema() error {
+	return nil
+}
+
+func (s *sqliteStore) upsertDay(day ingestDay) (ingestResult, error) {
+	path := marketDBPath(s.dbPath, day.date)
+	if s.batch {
+		return s.upsertBatchDay(path, day)
+	}
+
+	sqlLog := moduleLogger("sql")
+	db, err := sharedDatabase(path)
+	if err != nil {
+		return ingestResult{errors: day.errors}, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return ingestResult{errors: day.errors}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, changed, err := writeDay(tx, day)
+	if err != nil {
+		return result, err
+	}
+
+	commitStart := time.Now()
+	if err = tx.Commit(); err != nil {
+		return result, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	sqlLog.Debug("Committed transaction", "date", day.date.Format("2006-01-02"), "elapsed", time.Since(commitStart))
+	if day.afterCommit != nil {
+		day.afterCommit(changed)
+	}
+	return result, nil
+}
+
+// upsertBatchDay writes a day into the open transaction of path inside a
+// savepoint, so a failing day leaves the others of the batch intact
+func (s *sqliteStore) upsertBatchDay(path string, day ingestDay) (ingestResult, error) {
+	tx, ok := s.txs[path]
+	if !ok {
+		db, err := sharedDatabase(path)
+		if err != nil {
+			return ingestResult{errors: day.errors}, err
+		}
+		if tx, err = db.Begin(); err != nil {
+			return ingestResult{errors: day.errors}, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		s.txs[path] = tx
+	}
+
+	if _, err := tx.Exec("SAVEPOINT ingest_day"); err != nil {
+		return ingestResult{errors: day.errors}, fmt.Errorf("failed to begin savepoint: %w", err)
+	}
+	result, changed, err := writeDay(tx, day)
+	if err != nil {
+		tx.Exec("ROLLBACK TO ingest_day")
+		tx.Exec("RELEASE ingest_day")
+		return result, err
+	}
+	if _, err := tx.Exec("RELEASE ingest_day"); err != nil {
+		return result, fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	if day.afterCommit != nil {
+		s.pending[path] = append(s.pending[path], func() { day.afterCommit(changed) })
+	}
+	return result, nil
+}
+
+// reader returns the open batch transaction of path, or its shared handle
+func (s *sqliteStore) reader(path string) (querier, error) {
+	if tx, ok := s.txs[path]; ok {
+		return tx, nil
+	}
+	return sharedDatabase(path)
+}
+
+func (s *sqliteStore) beginBatch() {
+	s.batch = true
+	s.txs = map[string]*sql.Tx{}
+	s.pending = map[string][]func(){}
+}
+
+func (s *sqliteStore) flush() error {
+	sqlLog := moduleLogger("sql")
+	var firstErr error
+	for path, tx := range s.txs {
+		commitStart := time.Now()
+		if err := tx.Commit(); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to commit transaction of %s: %w", path, err)
+			}
+		} else {
+			sqlLog.Debug("Committed batch", "db", path, "elapsed", time.Since(commitStart))
+			for _, fn := range s.pending[path] {
+				fn()
+			}
+		}
+		delete(s.txs, path)
+		delete(s.pending, path)
+	}
+	return firstErr
+}
+
+// writeDay stores the records of a day and its ingest log entry in tx and
+// returns the rows it inserted or updated
+func writeDay(tx *sql.Tx, day ingestDay) (ingestResult, []changedRow, error) {
+	result := ingestResult{errors: day.errors}
+	dateText := day.date.Format("2006-01-02")
+	sqlLog := moduleLogger("sql")
+
+	// Corrections update the stored row in place, so its id and created_at
+	// survive re-ingests for anything referencing them
+	stmt, err := tx.Prepare(`
+	INSERT INTO market_data
+	(date, symbol, code, company_name, open, high, low, close, volume, previous_close, extra_fields, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(date, symbol) DO UPDATE SET
+		code = excluded.code, company_name = excluded.company_name,
+		open = excluded.open, high = excluded.high, low = excluded.low, close = excluded.close,
+		volume = excluded.volume, previous_close = excluded.previous_close,
+		extra_fields = excluded.extra_fields, updated_at = excluded.updated_at
+	WHERE (code, company_name, open, high, low, close, volume, previous_close, extra_fields)
+		IS NOT (excluded.code, excluded.company_name, excluded.open, excluded.high, excluded.low,
+			excluded.close, excluded.volume, excluded.previous_close, excluded.extra_fields)
+	`)
+	if err != nil {
+		return result, nil, fmt.Errorf("failed to prepare insert statement: %w", err)
+	}
+	defer stmt.Close()
+
+	// Looking up the stored row tells new rows apart from corrections and re-ingests
+	existingStmt, err := tx.Prepare(`
+	SELECT code, company_name, open, high, low, close, volume, previous_close, extra_fields
+	FROM market_data WHERE date = ? AND symbol = ?
+	`)
+	if err != nil {
+		return result, nil, fmt.Errorf("failed to prepare lookup statement: %w", err)
+	}
+	defer existingStmt.Close()
+
+	// Extra columns are replaced as a whole, a script no longer deriving one
+	// removes it
+	if _, err := tx.Exec("DELETE FROM market_data_extra WHERE date = ?", dateText); err != nil {
+		return result, nil, fmt.Errorf("failed to clear extra columns: %w", err)
+	}
+	extraStmt, err := tx.Prepare(`INSERT INTO market_data_extra (date, symbol, name, value) VALUES (?, ?, ?, ?)
+		ON CONFLICT(date, symbol, name) DO UPDATE SET value = excluded.value`)
+	if err != nil {
+		return result, nil, fmt.Errorf("failed to prepare extra column statement: %w", err)
+	}
+	defer extraStmt.Close()
+
+	var changed []changedRow
+	insertStart := time.Now()
+	now := insertStart.UTC().Format(time.RFC3339)
+	for _, rec := range day.records {
+		row := rec.row
+		change, err := classifyRow(existingStmt, rec.date, rec.symbol, row)
+		if err != nil {
+			slog.Error("Failed to look up existing record", "error", err, "symbol", rec.symbol, "date", dateText)
+			result.errors++
+			continue
+		}
+
+		// Insert record, identical rows are left untouched
+		if change != rowUnchanged {
+			extraFields := sql.NullString{String: row.extraFields, Valid: row.extraFields != ""}
+			_, err = stmt.Exec(rec.date, rec.symbol, row.code, row.companyName, row.open, row.high, row.low, row.close, row.volume, row.previousClose, extraFields, now, now)
+			if err != nil {
+				slog.Error("Failed to insert record", "error", err, "symbol", rec.symbol, "date", dateText)
+				result.errors++
+				continue
+			}
+			changed = append(changed, changedRow{change: change, rec: rec})
+		}
+		for name, value := range rec.extra {
+			if _, err := extraStmt.Exec(rec.date, rec.symbol, name, value); err != nil {
+				return result, nil, fmt.Errorf("failed to store extra column %s of %s: %w", name, rec.symbol, err)
+			}
+		}
+
+		result.changes.add(change)
+		result.records++
+	}
+	sqlLog.Debug("Executed inserts", "date", dateText, "records", result.records, "elapsed", time.Since(insertStart))
+
+	if err := updateCompanies(tx, dateText); err != nil {
+		return result, nil, err
+	}
+	if err := updateLatestPrices(tx, dateText); err != nil {
+		return result, nil, err
+	}
+	if err := updateDailyReturns(tx, dateText); err != nil {
+		return result, nil, err
+	}
+	if day.raw != nil {
+		if err := storeRawLines(tx, dateText, day.raw); err != nil {
+			return result, nil, err
+		}
+	}
+
+	err = recordIngest(tx, ingestEntry{
+		date:       dateText,
+		filename:   day.filename,
+		records:    result.records,
+		errors:     result.errors,
+		changes:    result.changes,
+		startedAt:  day.startedAt,
+		finishedAt: time.Now(),
+	})
+	if err != nil {
+		return result, nil, err
+	}
+	return result, changed, nil
+}
+
+func (s *sqliteStore) queryRows(opts exportOptions) ([]exportRow, error) {
+	db, err := sharedQueryDatabase(s.dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return loadExportRows(db, opts)
+}
+
+// close rolls back days a batch has not flushed, the handles stay open for
+// the next ingest
+func (s *sqliteStore) close() error {
+	for path, tx := range s.txs {
+		tx.Rollback()
+		delete(s.txs, path)
+		delete(s.pending, path)
+	}
+	return nil
+}
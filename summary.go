@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// symbolStatsQuery summarises the stored history of the symbols matching the
+// filter: the span of dates, the number of rows and the high and low of the
+// year up to the last date, untraded rows left out. Only the index on
+// (symbol, date) and the rows of the last year are read.
+const symbolStatsQuery = `WITH span AS (
+		SELECT symbol, MIN(date) AS first_date, MAX(date) AS last_date, COUNT(*) AS days
+		FROM market_data WHERE %s GROUP BY symbol
+	)
+	SELECT s.symbol, s.first_date, s.last_date, s.days,
+		(SELECT MAX(high) FROM market_data m WHERE m.symbol = s.symbol AND m.date > date(s.last_date, '-1 year')
+			AND NOT (m.open = 0 AND m.high = 0 AND m.low = 0)),
+		(SELECT MIN(low) FROM market_data m WHERE m.symbol = s.symbol AND m.date > date(s.last_date, '-1 year')
+			AND NOT (m.open = 0 AND m.high = 0 AND m.low = 0))
+	FROM span s`
+
+// symbolStats is a row of the symbol_stats table
+type symbolStats struct {
+	symbol              string
+	firstDate, lastDate string
+	days                int
+	high52w, low52w     sql.NullFloat64
+}
+
+// refreshSymbolStats recomputes the summary of the symbols stored for date,
+// called after every ingest. The summary spans every shard and is kept in the
+// -db file.
+func refreshSymbolStats(dbPath string, date time.Time) error {
+	return writeSymbolStats(dbPath, false,
+		"symbol IN (SELECT symbol FROM market_data WHERE date = ? AND symbol IS NOT NULL)", date.Format("2006-01-02"))
+}
+
+// rebuildSymbolStats recomputes the summary of every symbol, for databases
+// loaded before the table existed or after rows were deleted
+func rebuildSymbolStats(dbPath string) error {
+	return writeSymbolStats(dbPath, true, "symbol IS NOT NULL")
+}
+
+// writeSymbolStats stores the summary of the symbols matching filter, replace
+// clears the table first
+func writeSymbolStats(dbPath string, replace bool, filter string, args ...any) error {
+	src, err := sharedQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	stats, err := querySymbolStats(src, filter, args...)
+	if err != nil {
+		return err
+	}
+
+	db, err := sharedDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := storeSymbolStats(tx, replace, stats); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit symbol_stats: %w", err)
+	}
+	return nil
+}
+
+// storeSymbolStats writes stats to symbol_stats in tx, replace clears the
+// table first
+func storeSymbolStats(tx *sql.Tx, replace bool, stats []symbolStats) error {
+	if replace {
+		if _, err := tx.Exec("DELETE FROM symbol_stats"); err != nil {
+			return fmt.Errorf("failed to clear symbol_stats: %w", err)
+		}
+	}
+	stmt, err := tx.Prepare(`INSERT INTO symbol_stats (symbol, first_date, last_date, days, high_52w, low_52w, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(symbol) DO UPDATE SET first_date = excluded.first_date, last_date = excluded.last_date,
+			days = excluded.days, high_52w = excluded.high_52w, low_52w = excluded.low_52w, updated_at = excluded.updated_at`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare symbol_stats insert: %w", err)
+	}
+	defer stmt.Close()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, s := range stats {
+		if _, err := stmt.Exec(s.symbol, s.firstDate, s.lastDate, s.days, s.high52w, s.low52w, now); err != nil {
+			return fmt.Errorf("failed to insert into symbol_stats: %w", err)
+		}
+	}
+	return nil
+}
+
+// querySymbolStats runs symbolStatsQuery for the symbols matching filter
+func querySymbolStats(q querier, filter string, args ...any) ([]symbolStats, error) {
+	rows, err := q.Query(fmt.Sprintf(symbolStatsQuery, filter), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarise symbols: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []symbolStats
+	for rows.Next() {
+		var s symbolStats
+		if err := rows.Scan(&s.symbol, &s.firstDate, &s.lastDate, &s.days, &s.high52w, &s.low52w); err != nil {
+			return nil, fmt.Errorf("failed to read symbol summary: %w", err)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
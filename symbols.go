@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// upsertCompany keeps the companies table in line with the symbols stored for
+// a date, the most recently seen code and name win
+const upsertCompany = `INSERT INTO companies (symbol, code, company_name, first_seen, last_seen)
+	SELECT symbol, code, company_name, date, date FROM market_data WHERE date = ? AND symbol IS NOT NULL
+	ON CONFLICT(symbol) DO UPDATE SET
+		code = CASE WHEN excluded.last_seen >= companies.last_seen THEN excluded.code ELSE companies.code END,
+		company_name = CASE WHEN excluded.last_seen >= companies.last_seen THEN excluded.company_name ELSE companies.company_name END,
+		first_seen = MIN(companies.first_seen, excluded.first_seen),
+		last_seen = MAX(companies.last_seen, excluded.last_seen)`
+
+// updateCompanies records the symbols stored for date in the companies table
+func updateCompanies(q querier, date string) error {
+	if _, err := q.Exec(upsertCompany, date); err != nil {
+		return fmt.Errorf("failed to update companies: %w", err)
+	}
+	return nil
+}
+
+// company is a row of the companies table
+type company struct {
+	symbol, code, name string
+	firstSeen          string
+	lastSeen           string
+}
+
+// runSymbolsCommand dispatches the "symbols" subcommands
+func runSymbolsCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing symbols command, expected search or refresh")
+	}
+
+	switch args[0] {
+	case "search":
+		return searchSymbols(dbPath, args[1:])
+	case "refresh":
+		return refreshCompanies(dbPath)
+	default:
+		return fmt.Errorf("unknown symbols command %q, expected search or refresh", args[0])
+	}
+}
+
+// refreshCompanies rebuilds the companies table from every stored day, for
+// databases loaded before the table existed
+func refreshCompanies(dbPath string) error {
+	paths, err := databaseFiles(dbPath)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		db, err := openDatabase(path)
+		if err != nil {
+			return err
+		}
+		days, err := db.Query("SELECT DISTINCT date FROM market_data WHERE date IS NOT NULL ORDER BY date")
+		if err != nil {
+			db.Close()
+			return fmt.Errorf("failed to list stored dates: %w", err)
+		}
+		var dates []string
+		for days.Next() {
+			var date string
+			if err := days.Scan(&date); err != nil {
+				days.Close()
+				db.Close()
+				return fmt.Errorf("failed to list stored dates: %w", err)
+			}
+			dates = append(dates, date)
+		}
+		days.Close()
+
+		for _, date := range dates {
+			if err := updateCompanies(db, date); err != nil {
+				db.Close()
+				return err
+			}
+		}
+		db.Close()
+		slog.Info("Refreshed companies", "db", path, "days", len(dates))
+	}
+	return nil
+}
+
+// searchSymbols prints the companies best matching the search text
+func searchSymbols(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("symbols search", flag.ContinueOnError)
+	limit := fs.Int("limit", 10, "Most matches to show")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return errors.New("missing search text")
+	}
+	text := strings.ToLower(strings.Join(positional, " "))
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT symbol, COALESCE(code, ''), COALESCE(company_name, ''), first_seen, last_seen FROM companies")
+	if err != nil {
+		return fmt.Errorf("failed to query companies, run symbols refresh if the database predates the table: %w", err)
+	}
+	defer rows.Close()
+
+	// Shards each hold the companies of their year, keep the latest entry
+	bySymbol := make(map[string]company)
+	for rows.Next() {
+		var c company
+		if err := rows.Scan(&c.symbol, &c.code, &c.name, &c.firstSeen, &c.lastSeen); err != nil {
+			return fmt.Errorf("failed to read company: %w", err)
+		}
+		if prev, ok := bySymbol[c.symbol]; ok {
+			c.firstSeen = min(c.firstSeen, prev.firstSeen)
+			if prev.lastSeen > c.lastSeen {
+				prev.firstSeen = c.firstSeen
+				c = prev
+			}
+		}
+		bySymbol[c.symbol] = c
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read companies: %w", err)
+	}
+	if len(bySymbol) == 0 {
+		return errors.New("the companies table is empty, run symbols refresh first")
+	}
+
+	type match struct {
+		company
+		score int
+	}
+	var matches []match
+	for _, c := range bySymbol {
+		if score := matchScore(text, c); score > 0 {
+			matches = append(matches, match{c, score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].symbol < matches[j].symbol
+	})
+	if len(matches) > *limit {
+		matches = matches[:*limit]
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "symbol\tcompany\tlast_seen")
+	for _, m := range matches {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", m.symbol, m.name, m.lastSeen)
+	}
+	return tw.Flush()
+}
+
+// matchScore ranks how well text matches a company, 0 meaning no match.
+// Exact and prefix matches of the symbol rank first, then words of the
+// company name, substrings, abbreviations and finally near misses of the
+// symbol such as a single typo.
+func matchScore(text string, c company) int {
+	symbol, name := strings.ToLower(c.symbol), strings.ToLower(c.name)
+	switch {
+	case symbol == text:
+		return 1000
+	case strings.HasPrefix(symbol, text):
+		return 800
+	}
+	for _, word := range strings.Fields(name) {
+		if strings.HasPrefix(word, text) {
+			return 600
+		}
+	}
+	switch {
+	case strings.Contains(symbol, text), strings.Contains(name, text):
+		return 400
+	case isSubsequence(text, symbol):
+		return 300
+	}
+	if d := editDistance(text, symbol); d <= max(1, len(text)/3) {
+		return 200 - d
+	}
+	return 0
+}
+
+// isSubsequence reports whether the letters of sub appear in s in order
+func isSubsequence(sub, s string) bool {
+	i := 0
+	for j := 0; i < len(sub) && j < len(s); j++ {
+		if sub[i] == s[j] {
+			i++
+		}
+	}
+	return i == len(sub)
+}
+
+// editDistance is the optimal string alignment distance between a and b,
+// the Levenshtein distance with swapped neighbouring letters counting as one
+// edit
+func editDistance(a, b string) int {
+	rows := make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(a)][len(b)]
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsOptions configures HTTPS for the HTTP server, either from certificate
+// files or from certificates obtained from an ACME CA such as Let's Encrypt
+type tlsOptions struct {
+	certFile, keyFile string
+	acmeDomains       string
+	acmeEmail         string
+	acmeCache         string
+	// acmeHTTPAddr answers HTTP-01 challenges and redirects to HTTPS
+	acmeHTTPAddr string
+}
+
+var tlsConfig tlsOptions
+
+// serverTLSConfig returns the TLS configuration of the HTTP server, nil for
+// plain HTTP, and with ACME the handler for the HTTP-01 challenge listener
+func serverTLSConfig(dbPath string) (*tls.Config, http.Handler, error) {
+	opts := tlsConfig
+	domains := parseList(opts.acmeDomains)
+	switch {
+	case opts.certFile != "" && len(domains) > 0:
+		return nil, nil, errors.New("-tls-cert and -acme-domains cannot be combined")
+	case (opts.certFile == "") != (opts.keyFile == ""):
+		return nil, nil, errors.New("-tls-cert and -tls-key must be given together")
+	case opts.certFile != "":
+		reloader := &certReloader{certFile: opts.certFile, keyFile: opts.keyFile}
+		if _, err := reloader.getCertificate(nil); err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: reloader.getCertificate}, nil, nil
+	case len(domains) > 0:
+		cache := opts.acmeCache
+		if cache == "" {
+			cache = filepath.Join(filepath.Dir(dbPath), "acme")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cache),
+			Email:      opts.acmeEmail,
+		}
+		cfg := m.TLSConfig()
+		cfg.MinVersion = tls.VersionTLS12
+		var challenge http.Handler
+		if opts.acmeHTTPAddr != "" {
+			challenge = m.HTTPHandler(nil)
+		}
+		return cfg, challenge, nil
+	}
+	return nil, nil, nil
+}
+
+// listenAndServe runs server with TLS when configured, blocking like
+// http.Server.ListenAndServe
+func listenAndServe(server *http.Server, dbPath string) error {
+	cfg, challenge, err := serverTLSConfig(dbPath)
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		slog.Info("Starting HTTP server", "addr", server.Addr)
+		return server.ListenAndServe()
+	}
+
+	if challenge != nil {
+		redirect := &http.Server{Addr: tlsConfig.acmeHTTPAddr, Handler: challenge, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := redirect.ListenAndServe(); err != nil {
+				slog.Error("ACME challenge server failed", "error", err, "addr", redirect.Addr)
+			}
+		}()
+	}
+	server.TLSConfig = cfg
+	slog.Info("Starting HTTPS server", "addr", server.Addr)
+	return server.ListenAndServeTLS("", "")
+}
+
+// certReloader serves a certificate from files, loading them again once they
+// change so renewals by certbot and the like apply without a restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var modTime time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			if c.cert != nil {
+				return c.cert, nil
+			}
+			return nil, fmt.Errorf("failed to read certificate: %w", err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if c.cert != nil && modTime.Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		// The pair is briefly mismatched while a renewal replaces the files
+		if c.cert != nil {
+			slog.Warn("Failed to reload certificate, keeping the previous one", "error", err)
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	if c.cert != nil {
+		slog.Info("Reloaded certificate", "file", c.certFile)
+	}
+	c.cert, c.modTime = &cert, modTime
+	return c.cert, nil
+}
+
+// parseList splits a comma separated flag value
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
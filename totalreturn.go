@@ -0,0 +1,243 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// totalReturnPoint is a day of the total return series of a symbol. Dividend
+// and ShareFactor are the actions that went ex since the previous day.
+type totalReturnPoint struct {
+	Date          string  `json:"date"`
+	Symbol        string  `json:"symbol"`
+	Close         float64 `json:"close"`
+	Dividend      float64 `json:"dividend"`
+	ShareFactor   float64 `json:"share_factor"`
+	DividendYield float64 `json:"dividend_yield"`
+	TotalReturn   float64 `json:"total_return_index"`
+}
+
+// trailingYield is the dividend yield in percent at close on date from the
+// dividends that went ex in the year up to it. Dividends paid before a bonus
+// issue or split are scaled to the shares of today.
+func trailingYield(actions []corporateAction, date string, close float64) float64 {
+	if close <= 0 {
+		return 0
+	}
+	start := yearBefore(date)
+	var paid float64
+	for _, a := range actions {
+		if a.Type != actionDividend || a.ExDate <= start || a.ExDate > date {
+			continue
+		}
+		// A dividend going ex with a bonus issue is paid on the old shares
+		amount := a.Value
+		for _, later := range actions {
+			if later.Type != actionDividend && later.ExDate >= a.ExDate && later.ExDate <= date {
+				amount /= later.shareFactor()
+			}
+		}
+		paid += amount
+	}
+	return math.Round(paid/close*100*10000) / 10000
+}
+
+// yearBefore returns the day a year before date, date itself when invalid
+func yearBefore(date string) string {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	return day.AddDate(-1, 0, 0).Format("2006-01-02")
+}
+
+// totalReturnSeries computes the total return index of a symbol from from to
+// to, 100 on the first stored day, reinvesting dividends at the close of
+// their ex-date and following bonus issues and splits
+func totalReturnSeries(db *sql.DB, symbol, from, to string) ([]totalReturnPoint, error) {
+	actions, err := loadActions(db, []string{symbol}, yearBefore(from), to)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT date, close FROM market_data
+		WHERE symbol = ? AND date >= ? AND date <= ? AND close > 0 ORDER BY date`, symbol, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query closes: %w", err)
+	}
+	defer rows.Close()
+
+	series := []totalReturnPoint{}
+	var previous totalReturnPoint
+	for rows.Next() {
+		p := totalReturnPoint{Symbol: symbol, ShareFactor: 1, TotalReturn: 100}
+		if err := rows.Scan(&p.Date, &p.Close); err != nil {
+			return nil, fmt.Errorf("failed to read closes: %w", err)
+		}
+		if len(series) > 0 {
+			// Actions of days without a stored close apply to the next one
+			for _, a := range actions {
+				if a.ExDate > previous.Date && a.ExDate <= p.Date {
+					if a.Type == actionDividend {
+						p.Dividend += a.Value
+					} else {
+						p.ShareFactor *= a.shareFactor()
+					}
+				}
+			}
+			p.TotalReturn = previous.TotalReturn * (p.Close*p.ShareFactor + p.Dividend) / previous.Close
+		}
+		p.DividendYield = trailingYield(actions, p.Date, p.Close)
+		previous = p
+		p.TotalReturn = math.Round(p.TotalReturn*10000) / 10000
+		series = append(series, p)
+	}
+	return series, rows.Err()
+}
+
+// totalReturnReport writes the total return series of a symbol
+func totalReturnReport(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("report total-return", flag.ContinueOnError)
+	symbol := fs.String("symbol", "", "Symbol to report on")
+	from := fs.String("from", "0000-01-01", "First day (YYYY-MM-DD), the index is 100 on it")
+	to := fs.String("to", "9999-12-31", "Last day (YYYY-MM-DD)")
+	format := fs.String("format", "csv", "Output format: table, csv or json")
+	out := fs.String("out", "-", "File to write, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *symbol == "" {
+		return errors.New("missing -symbol")
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	series, err := totalReturnSeries(db, strings.ToUpper(*symbol), *from, *to)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"date", "symbol", "close", "dividend", "share_factor", "dividend_yield", "total_return_index"}
+	cells := func(p totalReturnPoint) []string {
+		return []string{p.Date, p.Symbol, formatFloat(p.Close), formatFloat(p.Dividend), formatFloat(p.ShareFactor),
+			formatFloat(p.DividendYield), formatFloat(p.TotalReturn)}
+	}
+	return writeReport(*out, func(w io.Writer) error {
+		switch *format {
+		case "json":
+			enc := json.NewEncoder(w)
+			for _, p := range series {
+				if err := enc.Encode(p); err != nil {
+					return err
+				}
+			}
+			return nil
+		case "csv":
+			cw := csv.NewWriter(w)
+			cw.Write(header)
+			for _, p := range series {
+				cw.Write(cells(p))
+			}
+			cw.Flush()
+			return cw.Error()
+		case "table":
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+			fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+			for _, p := range series {
+				fmt.Fprintln(tw, strings.Join(cells(p), "\t")+"\t")
+			}
+			return tw.Flush()
+		default:
+			return fmt.Errorf("unknown format %q, expected table, csv or json", *format)
+		}
+	})
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// serveTotalReturn answers GET /total-return?symbol=OGDC&from=2024-01-01
+// &to=2024-12-31 with the total return series of a symbol
+func serveTotalReturn(w http.ResponseWriter, r *http.Request, dbPath string) {
+	q := r.URL.Query()
+	symbol := strings.ToUpper(strings.TrimSpace(q.Get("symbol")))
+	if !isSymbol(symbol) {
+		http.Error(w, "missing or invalid symbol", http.StatusBadRequest)
+		return
+	}
+	from, to, err := queryDateRange(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer db.Close()
+	series, err := totalReturnSeries(db, symbol, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, series)
+}
+
+// queryDateRange reads the optional from and to dates of a request, open
+// ended when left out
+func queryDateRange(q url.Values) (string, string, error) {
+	from, to := q.Get("from"), q.Get("to")
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return "", "", errors.New("invalid from or to date, expected YYYY-MM-DD")
+		}
+	}
+	if from == "" {
+		from = "0000-01-01"
+	}
+	if to == "" {
+		to = "9999-12-31"
+	}
+	return from, to, nil
+}
+
+// serveActions answers GET /actions?symbols=OGDC&from=2024-01-01 with the
+// stored corporate actions
+func serveActions(w http.ResponseWriter, r *http.Request, dbPath string) {
+	q := r.URL.Query()
+	from, to, err := queryDateRange(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer db.Close()
+	actions, err := loadActions(db, parseSymbolList(q.Get("symbols")), from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, actions)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// transformScript is a Starlark file whose transform(row) function is called
+// for every parsed record, transforms are off when it is empty
+var transformScript string
+
+// transformMaxSteps stops a runaway script from stalling the ingest
+const transformMaxSteps = 1_000_000
+
+// rowTransform is a loaded transform script
+type rowTransform struct {
+	path string
+	fn   starlark.Callable
+}
+
+// loadTransform runs the script and looks up its transform function. The
+// script is loaded for every file, edits apply on the next ingest.
+func loadTransform(path string) (*rowTransform, error) {
+	thread := &starlark.Thread{Name: "load " + path, Print: transformPrint}
+	opts := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true}
+	globals, err := starlark.ExecFileOptions(opts, thread, path, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load transform script: %w", err)
+	}
+	globals.Freeze()
+	fn, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("transform script %s does not define a transform(row) function", path)
+	}
+	return &rowTransform{path: path, fn: fn}, nil
+}
+
+func transformPrint(thread *starlark.Thread, msg string) {
+	moduleLogger("transform").Info("Transform script output", "message", msg)
+}
+
+// apply passes every record through the script. It returns the kept records,
+// the number the script dropped and the number it failed on, which are
+// skipped like lines that fail to parse.
+func (t *rowTransform) apply(records []parsedRecord) ([]parsedRecord, int, int) {
+	kept := records[:0]
+	dropped, failed := 0, 0
+	for _, rec := range records {
+		out, keep, err := t.call(rec)
+		if err != nil {
+			slog.Error("Transform script failed on record", "symbol", rec.symbol, "date", rec.date, "error", err)
+			failed++
+			continue
+		}
+		if !keep {
+			dropped++
+			continue
+		}
+		kept = append(kept, out)
+	}
+	return kept, dropped, failed
+}
+
+// call runs transform(row) for one record. The row is a dict of the stored
+// columns, the function returns it changed, a new dict or None to drop the
+// record. Keys besides the stored columns are kept as extra columns.
+func (t *rowTransform) call(rec parsedRecord) (parsedRecord, bool, error) {
+	row := starlark.NewDict(10)
+	set := func(k string, v starlark.Value) { row.SetKey(starlark.String(k), v) }
+	set("date", starlark.String(rec.date))
+	set("symbol", starlark.String(rec.symbol))
+	set("code", starlark.String(rec.row.code))
+	set("company_name", starlark.String(rec.row.companyName))
+	set("open", starlark.Float(rec.row.open))
+	set("high", starlark.Float(rec.row.high))
+	set("low", starlark.Float(rec.row.low))
+	set("close", starlark.Float(rec.row.close))
+	set("volume", starlark.MakeInt(rec.row.volume))
+	set("previous_close", starlark.Float(rec.row.previousClose))
+	for k, v := range rec.extra {
+		value, err := toStarlark(v)
+		if err != nil {
+			return rec, false, err
+		}
+		set(k, value)
+	}
+
+	thread := &starlark.Thread{Name: t.path, Print: transformPrint}
+	thread.SetMaxExecutionSteps(transformMaxSteps)
+	result, err := starlark.Call(thread, t.fn, starlark.Tuple{row}, nil)
+	if err != nil {
+		return rec, false, err
+	}
+	if result == starlark.None {
+		return rec, false, nil
+	}
+	dict, ok := result.(*starlark.Dict)
+	if !ok {
+		return rec, false, fmt.Errorf("transform returned %s, expected a dict or None", result.Type())
+	}
+
+	// Fields outside the column mapping are not exposed to scripts
+	out := parsedRecord{row: marketRow{extraFields: rec.row.extraFields}, extra: map[string]any{}}
+	for _, item := range dict.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok {
+			return rec, false, fmt.Errorf("transform returned a non-string key %s", item[0])
+		}
+		if err := setField(&out, key, item[1]); err != nil {
+			return rec, false, fmt.Errorf("field %s: %w", key, err)
+		}
+	}
+	if out.symbol == "" {
+		return rec, false, fmt.Errorf("transform returned a row without a symbol")
+	}
+	if _, err := time.Parse("2006-01-02", out.date); err != nil {
+		return rec, false, fmt.Errorf("transform returned invalid date %q, expected YYYY-MM-DD", out.date)
+	}
+	if len(out.extra) == 0 {
+		out.extra = nil
+	}
+	return out, true, nil
+}
+
+// setField stores a value returned by the script in the record
+func setField(rec *parsedRecord, key string, v starlark.Value) error {
+	text := func() (string, error) {
+		s, ok := starlark.AsString(v)
+		if !ok {
+			return "", fmt.Errorf("got %s, expected a string", v.Type())
+		}
+		return s, nil
+	}
+	number := func() (float64, error) {
+		f, ok := starlark.AsFloat(v)
+		if !ok {
+			return 0, fmt.Errorf("got %s, expected a number", v.Type())
+		}
+		return f, nil
+	}
+
+	var err error
+	switch key {
+	case "date":
+		rec.date, err = text()
+	case "symbol":
+		rec.symbol, err = text()
+	case "code":
+		rec.row.code, err = text()
+	case "company_name":
+		rec.row.companyName, err = text()
+	case "open":
+		rec.row.open, err = number()
+	case "high":
+		rec.row.high, err = number()
+	case "low":
+		rec.row.low, err = number()
+	case "close":
+		rec.row.close, err = number()
+	case "previous_close":
+		rec.row.previousClose, err = number()
+	case "volume":
+		var n starlark.Int
+		if n, err = starlark.NumberToInt(v); err == nil {
+			volume, ok := n.Int64()
+			if !ok {
+				return fmt.Errorf("volume %s out of range", n)
+			}
+			rec.row.volume = int(volume)
+		}
+	default:
+		switch x := v.(type) {
+		case starlark.NoneType:
+		case starlark.String:
+			rec.extra[key] = string(x)
+		case starlark.Bool:
+			rec.extra[key] = bool(x)
+		case starlark.Float:
+			rec.extra[key] = float64(x)
+		case starlark.Int:
+			n, ok := x.Int64()
+			if !ok {
+				return fmt.Errorf("%s out of range", x)
+			}
+			rec.extra[key] = n
+		default:
+			return fmt.Errorf("got %s, extra columns hold strings, numbers or booleans", v.Type())
+		}
+	}
+	return err
+}
+
+func toStarlark(v any) (starlark.Value, error) {
+	switch x := v.(type) {
+	case string:
+		return starlark.String(x), nil
+	case bool:
+		return starlark.Bool(x), nil
+	case float64:
+		return starlark.Float(x), nil
+	case int64:
+		return starlark.MakeInt64(x), nil
+	}
+	return nil, fmt.Errorf("unsupported extra value %T", v)
+}
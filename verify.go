@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// runVerifyCommand re-reads the source files of a date range, either from
+// PSX or from a directory of archived files, and diffs them against the
+// stored rows. With -source timeseries it spot-checks the closes of a sample
+// of symbols against the PSX timeseries instead.
+func runVerifyCommand(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	from := fs.String("from", "", "First date to verify (YYYY-MM-DD)")
+	to := fs.String("to", "", "Last date to verify (YYYY-MM-DD), defaults to -from")
+	dir := fs.String("dir", "", "Read archived YYYY-MM-DD.Z files from this directory instead of downloading them")
+	source := fs.String("source", "summary", "What to verify against: summary (the market summary files) or timeseries")
+	symbols := fs.String("symbols", "", "Comma separated symbols to check with -source timeseries, a random sample when empty")
+	sample := fs.Int("sample", 20, "Symbols to pick with -source timeseries when -symbols is empty")
+	tolerance := fs.Float64("tolerance", 0.01, "Largest difference of closes in PKR -source timeseries accepts")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return errors.New("missing -from date to verify")
+	}
+	if *to == "" {
+		*to = *from
+	}
+	startDate, err := time.Parse("2006-01-02", *from)
+	if err != nil {
+		return fmt.Errorf("invalid -from date: %w", err)
+	}
+	endDate, err := time.Parse("2006-01-02", *to)
+	if err != nil {
+		return fmt.Errorf("invalid -to date: %w", err)
+	}
+	if startDate.After(endDate) {
+		return errors.New("-from is after -to")
+	}
+
+	db, err := openQueryDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch *source {
+	case "summary":
+	case "timeseries":
+		return verifyTimeseries(db, *from, *to, parseSymbolList(*symbols), *sample, *tolerance)
+	default:
+		return fmt.Errorf("unknown source %q, expected summary or timeseries", *source)
+	}
+
+	if err := loadHolidays(dbPath); err != nil {
+		slog.Warn("Failed to load market holidays, only weekends are skipped", "error", err)
+	}
+
+	var days, unavailable, mismatches int
+	for _, date := range backloadDates(startDate, endDate.AddDate(0, 0, 1)) {
+		day := date.Format("2006-01-02")
+		records, err := readSourceRecords(date, *dir)
+		if err != nil {
+			slog.Warn("Source file unavailable, skipping", "date", day, "error", err)
+			unavailable++
+			continue
+		}
+
+		stored, err := storedRows(db, day)
+		if err != nil {
+			return err
+		}
+
+		days++
+		found := 0
+		for _, line := range diffDay(day, records, stored) {
+			fmt.Println(line)
+			found++
+		}
+		mismatches += found
+		slog.Debug("Verified day", "date", day, "sourceRows", len(records), "storedRows", len(stored), "mismatches", found)
+	}
+
+	slog.Info("Verification completed", "daysChecked", days, "daysUnavailable", unavailable, "mismatches", mismatches)
+	if mismatches > 0 {
+		return fmt.Errorf("found %d differences from the source files", mismatches)
+	}
+	return nil
+}
+
+// readSourceRecords parses the market summary of date, from dir when given
+func readSourceRecords(date time.Time, dir string) ([]parsedRecord, error) {
+	var data []byte
+	var err error
+	if dir != "" {
+		data, err = os.ReadFile(filepath.Join(dir, date.Format("2006-01-02")+".Z"))
+	} else {
+		data, err = downloadFile(marketSummaryURL(date))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, fileData, err := extractArchive(data, date)
+	if err != nil {
+		return nil, err
+	}
+	records, _, err := parseRecords(fileData, date)
+	return records, err
+}
+
+// diffDay compares the source records of a day with the stored rows and
+// describes every difference as a tab separated line
+func diffDay(day string, records []parsedRecord, stored map[string]marketRow) []string {
+	if len(stored) == 0 && len(records) > 0 {
+		return []string{fmt.Sprintf("%s\t*\tmissing\tday not in the database, %d source rows", day, len(records))}
+	}
+
+	var lines []string
+	seen := make(map[string]bool, len(records))
+	for _, rec := range records {
+		seen[rec.symbol] = true
+		row, ok := stored[rec.symbol]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("%s\t%s\tmissing\tnot in the database", day, rec.symbol))
+			continue
+		}
+		for _, field := range rowDiff(row, rec.row) {
+			lines = append(lines, fmt.Sprintf("%s\t%s\tchanged\t%s", day, rec.symbol, field))
+		}
+	}
+
+	var extra []string
+	for symbol := range stored {
+		if !seen[symbol] {
+			extra = append(extra, symbol)
+		}
+	}
+	sort.Strings(extra)
+	for _, symbol := range extra {
+		lines = append(lines, fmt.Sprintf("%s\t%s\textra\tnot in the source file", day, symbol))
+	}
+	return lines
+}
+
+// rowDiff lists the fields that differ as "field: stored -> source"
+func rowDiff(stored, source marketRow) []string {
+	var diffs []string
+	compare := func(field string, a, b any) {
+		if a != b {
+			diffs = append(diffs, fmt.Sprintf("%s: %v -> %v", field, a, b))
+		}
+	}
+	compare("code", stored.code, source.code)
+	compare("company_name", stored.companyName, source.companyName)
+	compare("open", stored.open, source.open)
+	compare("high", stored.high, source.high)
+	compare("low", stored.low, source.low)
+	compare("close", stored.close, source.close)
+	compare("volume", stored.volume, source.volume)
+	compare("previous_close", stored.previousClose, source.previousClose)
+	compare("extra_fields", stored.extraFields, source.extraFields)
+	return diffs
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+func init() {
+	registerIndicator("atr", windowIndicator(averageTrueRange))
+	registerIndicator("stdev", windowIndicator(returnStdev))
+	registerIndicator("bollinger", indicator{
+		params:  []string{"window", "k"},
+		outputs: []string{"upper", "middle", "lower"},
+		check: func(p []float64) error {
+			if err := checkWindow("window", p[0]); err != nil {
+				return err
+			}
+			if p[1] <= 0 {
+				return fmt.Errorf("invalid band width %g, expected a positive number of deviations", p[1])
+			}
+			return nil
+		},
+		lookback: func(p []float64) int { return int(p[0]) },
+		compute:  bollingerBands,
+	})
+}
+
+// averageTrueRange is the simple average of the true ranges of the bars: the
+// day's range widened to the previous close when the price gapped. Untraded
+// days count with the move of their close, usually none.
+func averageTrueRange(bars []indicatorBar, window int) (float64, bool) {
+	var sum float64
+	for _, b := range bars {
+		switch {
+		case b.traded() && b.previousClose > 0:
+			sum += max(b.high-b.low, math.Abs(b.high-b.previousClose), math.Abs(b.low-b.previousClose))
+		case b.traded():
+			sum += b.high - b.low
+		case b.previousClose > 0:
+			sum += math.Abs(b.close - b.previousClose)
+		}
+	}
+	return sum / float64(window), true
+}
+
+// returnStdev is the sample standard deviation of the daily log returns of
+// the bars, in percent. Every bar needs a positive close and previous close.
+func returnStdev(bars []indicatorBar, window int) (float64, bool) {
+	returns := make([]float64, 0, len(bars))
+	var mean float64
+	for _, b := range bars {
+		if b.close <= 0 || b.previousClose <= 0 {
+			return 0, false
+		}
+		r := math.Log(b.close/b.previousClose) * 100
+		returns = append(returns, r)
+		mean += r
+	}
+	mean /= float64(window)
+	var squares float64
+	for _, r := range returns {
+		squares += (r - mean) * (r - mean)
+	}
+	return math.Sqrt(squares / float64(window-1)), true
+}
+
+// bollingerBands are the simple average of the closes over the window and
+// the bands k population standard deviations of the closes above and below
+// it. Every bar needs a positive close.
+func bollingerBands(bars []indicatorBar, p []float64) ([]float64, bool) {
+	window, k := int(p[0]), p[1]
+	var mean float64
+	for _, b := range bars {
+		if b.close <= 0 {
+			return nil, false
+		}
+		mean += b.close
+	}
+	mean /= float64(window)
+	var squares float64
+	for _, b := range bars {
+		squares += (b.close - mean) * (b.close - mean)
+	}
+	width := k * math.Sqrt(squares/float64(window))
+	return []float64{mean + width, mean, mean - width}, true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func init() {
+	registerExporter("xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", writeXLSX)
+}
+
+// writeXLSX writes a workbook with one sheet per symbol or per date. Dates,
+// prices and volumes are typed cells so they can be charted and summed in
+// Excel.
+func writeXLSX(w io.Writer, rows []exportRow, opts exportOptions) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	dateFormat := "yyyy-mm-dd"
+	dateStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		return err
+	}
+
+	// Rows arrive ordered by date, group them keeping first appearance order
+	var keys []string
+	groups := make(map[string][]exportRow)
+	for _, r := range rows {
+		key := r.symbol
+		if opts.by == "date" {
+			key = r.date
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], r)
+	}
+	if opts.by == "symbol" {
+		sort.Strings(keys)
+	}
+
+	header := []any{"Date", "Symbol", "Code", "Company", "Open", "High", "Low", "Close", "Volume", "Previous Close"}
+	used := make(map[string]bool)
+	for i, key := range keys {
+		name := sheetName(key, used)
+		if i == 0 {
+			if err := f.SetSheetName("Sheet1", name); err != nil {
+				return err
+			}
+		} else if _, err := f.NewSheet(name); err != nil {
+			return err
+		}
+
+		sw, err := f.NewStreamWriter(name)
+		if err != nil {
+			return err
+		}
+		if err := sw.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+			return err
+		}
+		if err := sw.SetRow("A1", header, excelize.RowOpts{StyleID: headerStyle}); err != nil {
+			return err
+		}
+		for j, r := range groups[key] {
+			cell, err := excelize.CoordinatesToCellName(1, j+2)
+			if err != nil {
+				return err
+			}
+			// Real date cells sort and filter as dates in Excel
+			var date any = r.date
+			if t, err := time.Parse("2006-01-02", r.date); err == nil {
+				date = excelize.Cell{StyleID: dateStyle, Value: t}
+			}
+			values := []any{date, r.symbol, r.code, r.companyName, r.open, r.high, r.low, r.close, r.volume, r.previousClose}
+			if err := sw.SetRow(cell, values); err != nil {
+				return err
+			}
+		}
+		if err := sw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	_, err = f.WriteTo(w)
+	return err
+}
+
+// sheetName makes key a valid, unique worksheet name: at most 31 characters
+// and none of []:*?/\
+func sheetName(key string, used map[string]bool) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, key)
+	if name == "" {
+		name = "Sheet"
+	}
+	if len(name) > 31 {
+		name = name[:31]
+	}
+	base := name
+	for n := 2; used[strings.ToLower(name)]; n++ {
+		suffix := "_" + strconv.Itoa(n)
+		name = base[:min(len(base), 31-len(suffix))] + suffix
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}